@@ -32,6 +32,20 @@
 // parameters for non-interactive operations (taken from [2]) are time=1 and to
 // use the maximum available memory.
 //
+//
+// Parallelism
+//
+// The threads parameter is the degree of parallelism of Argon2: the
+// memory is split into that many lanes, and each pass over the memory
+// processes all lanes concurrently, in one goroutine per lane. The
+// total amount of memory does not depend on threads; each lane uses
+// memory/threads KiB, after memory is rounded down to a multiple of
+// 4*threads KiB (and raised to at least 8*threads KiB). The threads
+// parameter is part of the output, so the same value must be used to
+// re-derive a key. Lanes only run in parallel up to the number of CPUs
+// available to the Go scheduler; DefaultThreads returns that number,
+// taking container CPU limits into account.
+//
 // [1] https://github.com/P-H-C/phc-winner-argon2/blob/master/argon2-specs.pdf
 // [2] https://tools.ietf.org/html/draft-irtf-cfrg-argon2-03#section-9.3
 package argon2
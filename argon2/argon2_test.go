@@ -6,8 +6,12 @@ package argon2
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"fmt"
 	"testing"
+
+	"golang.org/x/crypto/blake2b"
 )
 
 var (
@@ -91,6 +95,65 @@ func TestVectors(t *testing.T) {
 	}
 }
 
+func TestBlake2bLong(t *testing.T) {
+	in := []byte("input")
+	for _, n := range []int{1, 32, 64, 65, 100, 1024} {
+		out := make([]byte, n)
+		Blake2bLong(out, in)
+
+		// The first bytes are a plain BLAKE2b hash of the length and
+		// the input: all of them for short outputs, and 32 bytes of
+		// a BLAKE2b-512 hash for long ones.
+		var prefix [4]byte
+		binary.LittleEndian.PutUint32(prefix[:], uint32(n))
+		size, keep := n, n
+		if n > blake2b.Size {
+			size, keep = blake2b.Size, 32
+		}
+		h, _ := blake2b.New(size, nil)
+		h.Write(prefix[:])
+		h.Write(in)
+		if want := h.Sum(nil)[:keep]; !bytes.Equal(out[:keep], want) {
+			t.Errorf("Blake2bLong with %d bytes: got prefix %x, want %x", n, out[:keep], want)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Blake2bLong did not panic with an empty output")
+		}
+	}()
+	Blake2bLong(nil, in)
+}
+
+func TestDefaultThreads(t *testing.T) {
+	if n := DefaultThreads(); n < 1 {
+		t.Errorf("DefaultThreads() = %d", n)
+	}
+	for _, tt := range []struct {
+		cpuMax string
+		want   int
+		ok     bool
+	}{
+		{"max 100000\n", 0, false},
+		{"200000 100000\n", 2, true},
+		{"150000 100000", 2, true},
+		{"50000 100000", 1, true},
+		{"garbage", 0, false},
+	} {
+		n, ok := parseCPUMax(tt.cpuMax)
+		if n != tt.want || ok != tt.ok {
+			t.Errorf("parseCPUMax(%q) = %d, %v, want %d, %v", tt.cpuMax, n, ok, tt.want, tt.ok)
+		}
+	}
+	if _, ok := parseCPUQuota("-1\n", "100000\n"); ok {
+		t.Error("a negative cgroup v1 quota is a limit")
+	}
+	if n, ok := parseCPUQuota("400000\n", "100000\n"); n != 4 || !ok {
+		t.Errorf("parseCPUQuota = %d, %v, want 4, true", n, ok)
+	}
+}
+
 func benchmarkArgon2(mode int, time, memory uint32, threads uint8, keyLen uint32, b *testing.B) {
 	password := []byte("password")
 	salt := []byte("choosing random salts is hard")
@@ -127,6 +190,16 @@ func BenchmarkArgon2id(b *testing.B) {
 	b.Run(" Time: 5, Memory: 64 MB, Threads: 4", func(b *testing.B) { benchmarkArgon2(argon2id, 5, 64*1024, 4, 32, b) })
 }
 
+// BenchmarkArgon2idThreads shows how the time to derive a key with a
+// fixed amount of memory scales with the number of threads.
+func BenchmarkArgon2idThreads(b *testing.B) {
+	for _, threads := range []uint8{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("Time: 1, Memory: 64 MB, Threads: %d", threads), func(b *testing.B) {
+			benchmarkArgon2(argon2id, 1, 64*1024, threads, 32, b)
+		})
+	}
+}
+
 // Generated with the CLI of https://github.com/P-H-C/phc-winner-argon2/blob/master/argon2-specs.pdf
 var testVectors = []struct {
 	mode         int
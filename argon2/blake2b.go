@@ -11,6 +11,17 @@ import (
 	"golang.org/x/crypto/blake2b"
 )
 
+// Blake2bLong computes the variable length hash function H' of Argon2,
+// which is based on BLAKE2b, of in, and writes len(out) bytes of it to
+// out. See section 3.3 of the specification. It panics if out is
+// empty or longer than 2^32-1 bytes.
+func Blake2bLong(out []byte, in []byte) {
+	if len(out) == 0 || uint64(len(out)) > 1<<32-1 {
+		panic("argon2: invalid output length for Blake2bLong")
+	}
+	blake2bHash(out, in)
+}
+
 // blake2bHash computes an arbitrary long hash value of in
 // and writes the hash to out.
 func blake2bHash(out []byte, in []byte) {
@@ -0,0 +1,27 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package argon2
+
+import "io/ioutil"
+
+// cgroupCPULimit returns the CPU limit of the cgroup of the process,
+// as seen through the cgroup file system mounted in the usual place,
+// which is where container runtimes expose the limits of a container.
+func cgroupCPULimit() (int, bool) {
+	// cgroup v2.
+	if data, err := ioutil.ReadFile("/sys/fs/cgroup/cpu.max"); err == nil {
+		return parseCPUMax(string(data))
+	}
+	// cgroup v1.
+	quota, err := ioutil.ReadFile("/sys/fs/cgroup/cpu/cpu.cfs_quota_us")
+	if err != nil {
+		return 0, false
+	}
+	period, err := ioutil.ReadFile("/sys/fs/cgroup/cpu/cpu.cfs_period_us")
+	if err != nil {
+		return 0, false
+	}
+	return parseCPUQuota(string(quota), string(period))
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package argon2
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// DefaultThreads returns the number of lanes that can be processed in
+// parallel, for use as the threads parameter of Key and IDKey. It is
+// the smaller of runtime.GOMAXPROCS and, on Linux, the CPU quota of the
+// cgroup of the process, which may be much lower than the number of
+// CPUs in containers with CPU limits. The result is between 1 and 255.
+//
+// The number of threads affects the derived key, so DefaultThreads
+// should only be used to choose the parameters of new keys, which must
+// be stored along with them.
+func DefaultThreads() uint8 {
+	n := runtime.GOMAXPROCS(0)
+	if limit, ok := cgroupCPULimit(); ok && limit < n {
+		n = limit
+	}
+	if n < 1 {
+		n = 1
+	}
+	if n > 255 {
+		n = 255
+	}
+	return uint8(n)
+}
+
+// parseCPUQuota returns the number of CPUs allowed by a CFS quota and
+// period, in microseconds, rounded up. It reports false if there is no
+// limit, which cgroup v1 encodes as a negative quota and cgroup v2 as
+// "max".
+func parseCPUQuota(quota, period string) (int, bool) {
+	quota, period = strings.TrimSpace(quota), strings.TrimSpace(period)
+	if quota == "max" {
+		return 0, false
+	}
+	q, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseInt(period, 10, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	n := (q + p - 1) / p
+	if n > 1<<16 {
+		n = 1 << 16
+	}
+	return int(n), true
+}
+
+// parseCPUMax parses the contents of a cgroup v2 cpu.max file, which
+// holds a quota and a period.
+func parseCPUMax(data string) (int, bool) {
+	fields := strings.Fields(data)
+	if len(fields) != 2 {
+		return 0, false
+	}
+	return parseCPUQuota(fields[0], fields[1])
+}
@@ -256,6 +256,54 @@ func TestSaltedCipher(t *testing.T) {
 	}
 }
 
+func TestMarshalBinary(t *testing.T) {
+	key := []byte("marshaled key")
+	salt := []byte("some salt")
+	for _, salted := range []bool{false, true} {
+		var c *Cipher
+		var err error
+		if salted {
+			c, err = NewSaltedCipher(key, salt)
+		} else {
+			c, err = NewCipher(key)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, err := c.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var restored Cipher
+		if err := restored.UnmarshalBinary(state); err != nil {
+			t.Fatalf("UnmarshalBinary: %v", err)
+		}
+		if restored != *c {
+			t.Errorf("salted %v: restored cipher differs from the original", salted)
+		}
+		src := []byte("8 bytes!")
+		var want, got, dec [BlockSize]byte
+		c.Encrypt(want[:], src)
+		restored.Encrypt(got[:], src)
+		if got != want {
+			t.Errorf("salted %v: restored cipher encrypts to %x, want %x", salted, got, want)
+		}
+		restored.Decrypt(dec[:], got[:])
+		if string(dec[:]) != string(src) {
+			t.Errorf("salted %v: restored cipher decrypts to %q, want %q", salted, dec, src)
+		}
+	}
+
+	var c Cipher
+	state, _ := new(Cipher).MarshalBinary()
+	for _, bad := range [][]byte{nil, state[:len(state)-1], append([]byte("xxxx"), state[4:]...)} {
+		if err := c.UnmarshalBinary(bad); err == nil {
+			t.Errorf("UnmarshalBinary accepted a %d byte state", len(bad))
+		}
+	}
+}
+
 func BenchmarkExpandKeyWithSalt(b *testing.B) {
 	key := make([]byte, 32)
 	salt := make([]byte, 16)
@@ -16,7 +16,11 @@ package blowfish // import "golang.org/x/crypto/blowfish"
 // The code is a port of Bruce Schneier's C implementation.
 // See https://www.schneier.com/blowfish.html.
 
-import "strconv"
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
 
 // The Blowfish block size in bytes.
 const BlockSize = 8
@@ -90,6 +94,55 @@ func (c *Cipher) Decrypt(dst, src []byte) {
 	dst[4], dst[5], dst[6], dst[7] = byte(r>>24), byte(r>>16), byte(r>>8), byte(r)
 }
 
+// stateMagic starts the encoding of a Cipher by MarshalBinary, and
+// identifies its version.
+const stateMagic = "bfs\x01"
+
+// stateSize is the length of the encoding of a Cipher.
+const stateSize = len(stateMagic) + (18+4*256)*4
+
+// MarshalBinary implements encoding.BinaryMarshaler. It returns the
+// expanded key schedule of c, its P-array and S-boxes, so that the
+// Cipher can be restored with UnmarshalBinary without repeating the
+// key setup. The result is as sensitive as the key.
+func (c *Cipher) MarshalBinary() ([]byte, error) {
+	b := make([]byte, len(stateMagic), stateSize)
+	copy(b, stateMagic)
+	for _, v := range c.p {
+		b = appendUint32(b, v)
+	}
+	for _, s := range []*[256]uint32{&c.s0, &c.s1, &c.s2, &c.s3} {
+		for _, v := range s {
+			b = appendUint32(b, v)
+		}
+	}
+	return b, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It restores a
+// key schedule returned by MarshalBinary, replacing that of c.
+func (c *Cipher) UnmarshalBinary(b []byte) error {
+	if len(b) != stateSize || string(b[:len(stateMagic)]) != stateMagic {
+		return errors.New("crypto/blowfish: invalid cipher state")
+	}
+	b = b[len(stateMagic):]
+	for i := range c.p {
+		c.p[i] = binary.BigEndian.Uint32(b)
+		b = b[4:]
+	}
+	for _, s := range []*[256]uint32{&c.s0, &c.s1, &c.s2, &c.s3} {
+		for i := range s {
+			s[i] = binary.BigEndian.Uint32(b)
+			b = b[4:]
+		}
+	}
+	return nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
 func initCipher(c *Cipher) {
 	copy(c.p[0:], p[0:])
 	copy(c.s0[0:], s0[0:])
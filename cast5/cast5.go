@@ -13,26 +13,91 @@
 // golang.org/x/crypto/chacha20poly1305).
 package cast5 // import "golang.org/x/crypto/cast5"
 
-import "errors"
+import (
+	"crypto/cipher"
+	"errors"
+)
 
 const BlockSize = 8
 const KeySize = 16
 
+// MinKeySize is the size of the shortest key accepted by NewCipher.
+const MinKeySize = 5
+
 type Cipher struct {
 	masking [16]uint32
 	rotate  [16]uint8
+	// rounds is 12 for keys of 80 bits or less, and 16 otherwise.
+	rounds int
 }
 
+// NewCipher creates a CAST5 cipher. The key must be between MinKeySize
+// and KeySize bytes long. As described in RFC 2144, section 2.5,
+// shorter keys are padded with zeros, and keys of 10 bytes or less use
+// 12 rounds instead of 16.
 func NewCipher(key []byte) (c *Cipher, err error) {
-	if len(key) != KeySize {
-		return nil, errors.New("CAST5: keys must be 16 bytes")
+	if len(key) < MinKeySize || len(key) > KeySize {
+		return nil, errors.New("CAST5: keys must be between 5 and 16 bytes")
 	}
 
+	var padded [KeySize]byte
+	copy(padded[:], key)
+
 	c = new(Cipher)
-	c.keySchedule(key)
+	c.rounds = 16
+	if len(key) <= 10 {
+		c.rounds = 12
+	}
+	c.keySchedule(padded[:])
 	return
 }
 
+// NewCTR returns a cipher.Stream that encrypts or decrypts with CAST5
+// in counter mode. The key must be between MinKeySize and KeySize
+// bytes long, and the iv must be BlockSize bytes long.
+//
+// Because of the 64-bit block, the counter wraps around, and
+// keystream blocks are likely to repeat, long before they would for
+// AES. Keep the amount of data encrypted with one key well below the
+// birthday bound of 2³² blocks. No authenticated mode is provided for
+// CAST5; a separate MAC is needed to detect tampering.
+func NewCTR(key, iv []byte) (cipher.Stream, error) {
+	c, err := newStreamCipher(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewCTR(c, iv), nil
+}
+
+// NewCFBEncrypter returns a cipher.Stream that encrypts with CAST5 in
+// cipher feedback mode, as used by OpenPGP. The key must be between
+// MinKeySize and KeySize bytes long, and the iv must be BlockSize bytes
+// long. The caveats of NewCTR apply.
+func NewCFBEncrypter(key, iv []byte) (cipher.Stream, error) {
+	c, err := newStreamCipher(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewCFBEncrypter(c, iv), nil
+}
+
+// NewCFBDecrypter returns a cipher.Stream that decrypts with CAST5 in
+// cipher feedback mode. See NewCFBEncrypter.
+func NewCFBDecrypter(key, iv []byte) (cipher.Stream, error) {
+	c, err := newStreamCipher(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewCFBDecrypter(c, iv), nil
+}
+
+func newStreamCipher(key, iv []byte) (*Cipher, error) {
+	if len(iv) != BlockSize {
+		return nil, errors.New("CAST5: IV must be 8 bytes")
+	}
+	return NewCipher(key)
+}
+
 func (c *Cipher) BlockSize() int {
 	return BlockSize
 }
@@ -56,10 +121,12 @@ func (c *Cipher) Encrypt(dst, src []byte) {
 	l, r = r, l^f2(r, c.masking[10], c.rotate[10])
 	l, r = r, l^f3(r, c.masking[11], c.rotate[11])
 
-	l, r = r, l^f1(r, c.masking[12], c.rotate[12])
-	l, r = r, l^f2(r, c.masking[13], c.rotate[13])
-	l, r = r, l^f3(r, c.masking[14], c.rotate[14])
-	l, r = r, l^f1(r, c.masking[15], c.rotate[15])
+	if c.rounds == 16 {
+		l, r = r, l^f1(r, c.masking[12], c.rotate[12])
+		l, r = r, l^f2(r, c.masking[13], c.rotate[13])
+		l, r = r, l^f3(r, c.masking[14], c.rotate[14])
+		l, r = r, l^f1(r, c.masking[15], c.rotate[15])
+	}
 
 	dst[0] = uint8(r >> 24)
 	dst[1] = uint8(r >> 16)
@@ -75,10 +142,12 @@ func (c *Cipher) Decrypt(dst, src []byte) {
 	l := uint32(src[0])<<24 | uint32(src[1])<<16 | uint32(src[2])<<8 | uint32(src[3])
 	r := uint32(src[4])<<24 | uint32(src[5])<<16 | uint32(src[6])<<8 | uint32(src[7])
 
-	l, r = r, l^f1(r, c.masking[15], c.rotate[15])
-	l, r = r, l^f3(r, c.masking[14], c.rotate[14])
-	l, r = r, l^f2(r, c.masking[13], c.rotate[13])
-	l, r = r, l^f1(r, c.masking[12], c.rotate[12])
+	if c.rounds == 16 {
+		l, r = r, l^f1(r, c.masking[15], c.rotate[15])
+		l, r = r, l^f3(r, c.masking[14], c.rotate[14])
+		l, r = r, l^f2(r, c.masking[13], c.rotate[13])
+		l, r = r, l^f1(r, c.masking[12], c.rotate[12])
+	}
 
 	l, r = r, l^f3(r, c.masking[11], c.rotate[11])
 	l, r = r, l^f2(r, c.masking[10], c.rotate[10])
@@ -6,13 +6,13 @@ package cast5
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"encoding/hex"
 	"testing"
 )
 
-// This test vector is taken from RFC 2144, App B.1.
-// Since the other two test vectors are for reduced-round variants, we can't
-// use them.
+// These test vectors are taken from RFC 2144, App B.1. The 80 and 40-bit
+// keys use the reduced-round variant.
 var basicTests = []struct {
 	key, plainText, cipherText string
 }{
@@ -21,6 +21,16 @@ var basicTests = []struct {
 		"0123456789abcdef",
 		"238b4fe5847e44b2",
 	},
+	{
+		"01234567123456782345",
+		"0123456789abcdef",
+		"eb6a711a2c02271b",
+	},
+	{
+		"0123456712",
+		"0123456789abcdef",
+		"7ac816d16e9b302e",
+	},
 }
 
 func TestBasic(t *testing.T) {
@@ -48,6 +58,59 @@ func TestBasic(t *testing.T) {
 	}
 }
 
+func TestKeySizes(t *testing.T) {
+	for n := 0; n <= KeySize+1; n++ {
+		_, err := NewCipher(make([]byte, n))
+		if valid := n >= MinKeySize && n <= KeySize; valid != (err == nil) {
+			t.Errorf("%d byte key: got error %v, want valid=%v", n, err, valid)
+		}
+	}
+}
+
+func TestStreamModes(t *testing.T) {
+	key, _ := hex.DecodeString("0123456712345678234567893456789a")
+	iv, _ := hex.DecodeString("0001020304050607")
+	plainText := []byte("CAST5 is a legacy cipher; please prefer AES or ChaCha20 for new systems.")
+
+	modes := []struct {
+		name     string
+		enc, dec func(key, iv []byte) (cipher.Stream, error)
+	}{
+		{"CTR", NewCTR, NewCTR},
+		{"CFB", NewCFBEncrypter, NewCFBDecrypter},
+	}
+	for _, m := range modes {
+		enc, err := m.enc(key, iv)
+		if err != nil {
+			t.Fatalf("%s: %v", m.name, err)
+		}
+		cipherText := make([]byte, len(plainText))
+		enc.XORKeyStream(cipherText, plainText)
+		if bytes.Equal(cipherText, plainText) {
+			t.Errorf("%s: encryption did not change the plaintext", m.name)
+		}
+
+		dec, err := m.dec(key, iv)
+		if err != nil {
+			t.Fatalf("%s: %v", m.name, err)
+		}
+		// Decrypt in pieces to check that the stream keeps its state.
+		got := make([]byte, len(cipherText))
+		dec.XORKeyStream(got[:5], cipherText[:5])
+		dec.XORKeyStream(got[5:], cipherText[5:])
+		if !bytes.Equal(got, plainText) {
+			t.Errorf("%s: got %q, want %q", m.name, got, plainText)
+		}
+
+		if _, err := m.enc(key, iv[:7]); err == nil {
+			t.Errorf("%s: accepted a 7 byte IV", m.name)
+		}
+		if _, err := m.enc(key[:4], iv); err == nil {
+			t.Errorf("%s: accepted a 4 byte key", m.name)
+		}
+	}
+}
+
 // TestFull performs the test specified in RFC 2144, App B.2.
 // However, due to the length of time taken, it's disabled here and a more
 // limited version is included, below.
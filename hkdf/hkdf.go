@@ -56,15 +56,9 @@ func (f *hkdf) Read(p []byte) (int, error) {
 
 	// Fill the rest of the buffer
 	for len(p) > 0 {
-		f.expander.Reset()
-		f.expander.Write(f.prev)
-		f.expander.Write(f.info)
-		f.expander.Write([]byte{f.counter})
-		f.prev = f.expander.Sum(f.prev[:0])
-		f.counter++
+		f.next()
 
 		// Copy the new batch into p
-		f.buf = f.prev
 		n = copy(p, f.buf)
 		p = p[n:]
 	}
@@ -74,12 +68,60 @@ func (f *hkdf) Read(p []byte) (int, error) {
 	return need, nil
 }
 
+// next computes the next block of output into f.buf.
+func (f *hkdf) next() {
+	f.expander.Reset()
+	f.expander.Write(f.prev)
+	f.expander.Write(f.info)
+	f.expander.Write([]byte{f.counter})
+	f.prev = f.expander.Sum(f.prev[:0])
+	f.counter++
+	f.buf = f.prev
+}
+
+// WriteTo writes all the remaining output of the expansion to w, up to
+// the limit of 255 times the hash size, and returns the number of bytes
+// written. It implements io.WriterTo.
+func (f *hkdf) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for {
+		if len(f.buf) > 0 {
+			n, err := w.Write(f.buf)
+			total += int64(n)
+			f.buf = f.buf[n:]
+			if err != nil {
+				return total, err
+			}
+			if len(f.buf) > 0 {
+				return total, io.ErrShortWrite
+			}
+		}
+		// The counter wraps to zero after the last block.
+		if f.counter == 0 {
+			return total, nil
+		}
+		f.next()
+	}
+}
+
+// Reset rewinds the expansion to its start, so that subsequent reads
+// return the same output as a new Reader created with the same
+// arguments. The HMAC state is reused.
+func (f *hkdf) Reset() {
+	f.counter = 1
+	f.prev = f.prev[:0]
+	f.buf = nil
+}
+
 // Expand returns a Reader, from which keys can be read, using the given
 // pseudorandom key and optional context info, skipping the extraction step.
 //
 // The pseudorandomKey should have been generated by Extract, or be a uniformly
 // random or pseudorandom cryptographically strong key. See RFC 5869, Section
 // 3.3. Most common scenarios will want to use New instead.
+//
+// The returned Reader also implements io.WriterTo, and has a Reset()
+// method that restarts the expansion from the beginning.
 func Expand(hash func() hash.Hash, pseudorandomKey, info []byte) io.Reader {
 	expander := hmac.New(hash, pseudorandomKey)
 	return &hkdf{expander, expander.Size(), info, 1, nil, nil}
@@ -87,6 +129,9 @@ func Expand(hash func() hash.Hash, pseudorandomKey, info []byte) io.Reader {
 
 // New returns a Reader, from which keys can be read, using the given hash,
 // secret, salt and context info. Salt and info can be nil.
+//
+// Like the Reader returned by Expand, it implements io.WriterTo and has a
+// Reset() method.
 func New(hash func() hash.Hash, secret, salt, info []byte) io.Reader {
 	prk := Extract(hash, secret, salt)
 	return Expand(hash, prk, info)
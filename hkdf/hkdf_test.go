@@ -366,6 +366,64 @@ func TestHKDFLimit(t *testing.T) {
 	}
 }
 
+func TestHKDFReset(t *testing.T) {
+	for i, tt := range hkdfTests {
+		hkdf := New(tt.hash, tt.master, tt.salt, tt.info)
+		out := make([]byte, len(tt.out))
+		io.ReadFull(hkdf, out[:len(out)/2+1])
+
+		hkdf.(interface{ Reset() }).Reset()
+		n, err := io.ReadFull(hkdf, out)
+		if n != len(tt.out) || err != nil {
+			t.Errorf("test %d: not enough output bytes after Reset: %d, %v.", i, n, err)
+		}
+		if !bytes.Equal(out, tt.out) {
+			t.Errorf("test %d: incorrect output after Reset: have %v, need %v.", i, out, tt.out)
+		}
+	}
+
+	// Reset also rewinds an exhausted reader.
+	hkdf := New(sha256.New, []byte{0x00, 0x01, 0x02, 0x03}, nil, nil)
+	limit := sha256.Size * 255
+	first := make([]byte, limit)
+	io.ReadFull(hkdf, first)
+	hkdf.(interface{ Reset() }).Reset()
+	second := make([]byte, limit)
+	if n, err := io.ReadFull(hkdf, second); n != limit || err != nil {
+		t.Fatalf("not enough output bytes after Reset: %d, %v.", n, err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Error("output after Reset differs from the first output")
+	}
+}
+
+func TestHKDFWriteTo(t *testing.T) {
+	hash := sha1.New
+	master := []byte{0x00, 0x01, 0x02, 0x03}
+	limit := hash().Size() * 255
+
+	want := make([]byte, limit)
+	io.ReadFull(New(hash, master, nil, nil), want)
+
+	hkdf := New(hash, master, nil, nil)
+	head := make([]byte, 7)
+	io.ReadFull(hkdf, head)
+
+	var buf bytes.Buffer
+	n, err := hkdf.(io.WriterTo).WriteTo(&buf)
+	if n != int64(limit-len(head)) || err != nil {
+		t.Fatalf("WriteTo = %d, %v, want %d, nil", n, err, limit-len(head))
+	}
+	if got := append(head, buf.Bytes()...); !bytes.Equal(got, want) {
+		t.Error("incorrect output from WriteTo")
+	}
+
+	// All output has been consumed.
+	if n, err := hkdf.Read(make([]byte, 1)); n > 0 || err == nil {
+		t.Errorf("key expansion overflowed: n = %d, err = %v", n, err)
+	}
+}
+
 func Benchmark16ByteMD5Single(b *testing.B) {
 	benchmarkHKDFSingle(md5.New, 16, b)
 }
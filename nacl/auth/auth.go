@@ -26,6 +26,7 @@ package auth
 import (
 	"crypto/hmac"
 	"crypto/sha512"
+	"hash"
 )
 
 const (
@@ -56,3 +57,53 @@ func Verify(digest []byte, m []byte, key *[KeySize]byte) bool {
 	expectedMAC := mac.Sum(nil) // first 256 bits of 512-bit sum
 	return hmac.Equal(digest, expectedMAC[:Size])
 }
+
+// MAC computes an authenticator incrementally, like libsodium's
+// crypto_auth_init, crypto_auth_update and crypto_auth_final functions.
+// It implements hash.Hash. The authenticator of the data written to a
+// MAC is the same as the one returned by Sum for the concatenated data:
+// the first 256 bits of HMAC-SHA-512, which is not the same as
+// HMAC-SHA-512/256.
+type MAC struct {
+	h hash.Hash
+}
+
+var _ hash.Hash = (*MAC)(nil)
+
+// New returns a MAC that authenticates data using a secret key.
+func New(key *[KeySize]byte) *MAC {
+	return &MAC{h: hmac.New(sha512.New, key[:])}
+}
+
+// Write adds more data to the running authenticator. It never returns
+// an error.
+func (m *MAC) Write(p []byte) (int, error) {
+	return m.h.Write(p)
+}
+
+// Sum appends the 32-byte authenticator of the data written so far to
+// b and returns the resulting slice. It does not change the state of
+// the MAC.
+func (m *MAC) Sum(b []byte) []byte {
+	var sum [sha512.Size]byte
+	return append(b, m.h.Sum(sum[:0])[:Size]...)
+}
+
+// Verify reports whether digest is the authenticator of the data
+// written so far. Verify does not leak timing information.
+func (m *MAC) Verify(digest []byte) bool {
+	if len(digest) != Size {
+		return false
+	}
+	var sum [Size]byte
+	return hmac.Equal(digest, m.Sum(sum[:0]))
+}
+
+// Reset discards the data written so far, keeping the key.
+func (m *MAC) Reset() { m.h.Reset() }
+
+// Size returns the size of the authenticator, Size.
+func (m *MAC) Size() int { return Size }
+
+// BlockSize returns the block size of SHA-512.
+func (m *MAC) BlockSize() int { return m.h.BlockSize() }
@@ -111,6 +111,62 @@ func TestVerify(t *testing.T) {
 	}
 }
 
+func TestMAC(t *testing.T) {
+	for i, test := range testCases {
+		for _, chunk := range []int{1, 3, 64, 128, len(test.msg) + 1} {
+			mac := New(&test.key)
+			for msg := test.msg; len(msg) > 0; {
+				n := chunk
+				if n > len(msg) {
+					n = len(msg)
+				}
+				mac.Write(msg[:n])
+				msg = msg[n:]
+			}
+			if tag := mac.Sum(nil); !bytes.Equal(tag, test.out[:]) {
+				t.Errorf("#%d: MAC in %d byte chunks: got\n%x\nwant\n%x", i, chunk, tag, test.out)
+			}
+			if !mac.Verify(test.out[:]) {
+				t.Errorf("#%d: MAC.Verify(%x) failed", i, test.out)
+			}
+			if mac.Verify(test.out[:Size-1]) {
+				t.Errorf("#%d: MAC.Verify of a truncated digest passed", i)
+			}
+
+			mac.Reset()
+			mac.Write([]byte("unknown msg"))
+			if mac.Verify(test.out[:]) {
+				t.Errorf("#%d: MAC.Verify after Reset unexpectedly passed", i)
+			}
+		}
+	}
+}
+
+func TestMACMatchesSum(t *testing.T) {
+	var key [32]byte
+	msg := make([]byte, 1000)
+	prng := mrand.New(mrand.NewSource(0))
+	prng.Read(key[:])
+	prng.Read(msg)
+
+	mac := New(&key)
+	if mac.Size() != Size || mac.BlockSize() != 128 {
+		t.Errorf("got Size %d and BlockSize %d, want %d and 128", mac.Size(), mac.BlockSize(), Size)
+	}
+	for i := 0; i < len(msg); {
+		n := prng.Intn(200)
+		if i+n > len(msg) {
+			n = len(msg) - i
+		}
+		mac.Write(msg[i : i+n])
+		i += n
+		want := Sum(msg[:i], &key)
+		if got := mac.Sum([]byte("prefix")); !bytes.Equal(got, append([]byte("prefix"), want[:]...)) {
+			t.Fatalf("after %d bytes: got %x, want prefix and %x", i, got, want)
+		}
+	}
+}
+
 func TestStress(t *testing.T) {
 	if testing.Short() {
 		t.Skip("exhaustiveness test")
@@ -149,6 +205,28 @@ func TestStress(t *testing.T) {
 	}
 }
 
+func BenchmarkMAC(b *testing.B) {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		b.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	if _, err := rand.Read(buf[:]); err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	mac := New(&key)
+	var tag [Size]byte
+	for i := 0; i < b.N; i++ {
+		mac.Write(buf)
+		mac.Sum(tag[:0])
+	}
+}
+
 func BenchmarkAuth(b *testing.B) {
 	var key [32]byte
 	if _, err := rand.Read(key[:]); err != nil {
@@ -28,7 +28,7 @@ might present some implementations with no other choice.
 
 Thus large amounts of data should be chunked so that each message is small.
 (Each message still needs a unique nonce.) If in doubt, 16KB is a reasonable
-chunk size.
+chunk size. SharedKey.SealStream and SharedKey.OpenStream do this chunking.
 
 This package is interoperable with NaCl: https://nacl.cr.yp.to/box.html.
 Anonymous sealing/opening is an extension of NaCl defined by and interoperable
@@ -0,0 +1,165 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package box
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/salsa20/salsa"
+)
+
+// SharedKey is the key shared by a pair of keys, as computed by
+// Precompute. Long-lived channels compute it once with NewSharedKey,
+// and Zero it when they are closed.
+type SharedKey [32]byte
+
+// NewSharedKey returns the key shared by peersPublicKey and privateKey.
+func NewSharedKey(peersPublicKey, privateKey *[32]byte) *SharedKey {
+	k := new(SharedKey)
+	Precompute((*[32]byte)(k), peersPublicKey, privateKey)
+	return k
+}
+
+// Zero overwrites the key with zeros. The key must not be used
+// afterwards.
+func (k *SharedKey) Zero() {
+	for i := range k {
+		k[i] = 0
+	}
+}
+
+// Seal is like SealAfterPrecomputation, with the key k.
+func (k *SharedKey) Seal(out, message []byte, nonce *[24]byte) []byte {
+	return secretbox.Seal(out, message, nonce, (*[32]byte)(k))
+}
+
+// Open is like OpenAfterPrecomputation, with the key k.
+func (k *SharedKey) Open(out, box []byte, nonce *[24]byte) ([]byte, bool) {
+	return secretbox.Open(out, box, nonce, (*[32]byte)(k))
+}
+
+// The flags that start the plaintext of each chunk of a stream.
+const (
+	chunkMore  = 0
+	chunkFinal = 1
+)
+
+var (
+	errChunkSize       = errors.New("box: chunk size must be positive")
+	errChunkOpen       = errors.New("box: chunk failed to open")
+	errStreamTruncated = errors.New("box: stream is truncated")
+	errStreamTrailing  = errors.New("box: data follows the end of the stream")
+)
+
+// SealStream reads r until io.EOF and writes it to w in chunks of up to
+// chunkSize bytes, each of which is a box of its own, 1+Overhead bytes
+// longer than the data it carries. The nonce must be unique for each
+// distinct stream or message sealed with k; the nonces of the chunks
+// are derived from it. If in doubt, 16KB is a reasonable chunk size.
+//
+// The chunks are numbered, and the last one is marked, so OpenStream
+// detects chunks that are reordered, dropped or appended.
+func (k *SharedKey) SealStream(w io.Writer, r io.Reader, nonce *[24]byte, chunkSize int) error {
+	if chunkSize <= 0 {
+		return errChunkSize
+	}
+	s := newStream(k, nonce)
+	defer s.key.Zero()
+
+	buf := make([]byte, 1+chunkSize)
+	out := make([]byte, 0, len(buf)+Overhead)
+	for {
+		n, err := io.ReadFull(r, buf[1:])
+		final := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !final {
+			return err
+		}
+		buf[0] = chunkMore
+		if final {
+			buf[0] = chunkFinal
+		}
+		out = s.key.Seal(out[:0], buf[:1+n], s.next())
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// OpenStream reads a stream produced by SealStream with the same nonce
+// and chunkSize from r, and writes its data to w. Each chunk is
+// authenticated before its data is written, but the data written
+// before an error, such as a truncated stream, must be discarded.
+func (k *SharedKey) OpenStream(w io.Writer, r io.Reader, nonce *[24]byte, chunkSize int) error {
+	if chunkSize <= 0 {
+		return errChunkSize
+	}
+	s := newStream(k, nonce)
+	defer s.key.Zero()
+
+	buf := make([]byte, 1+chunkSize+Overhead)
+	out := make([]byte, 0, 1+chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			return errStreamTruncated
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		var ok bool
+		if out, ok = s.key.Open(out[:0], buf[:n], s.next()); !ok || len(out) == 0 {
+			return errChunkOpen
+		}
+		final := out[0] == chunkFinal
+		if !final && n < len(buf) {
+			return errStreamTruncated
+		}
+		if _, err := w.Write(out[1:]); err != nil {
+			return err
+		}
+		if final {
+			if _, err := io.ReadFull(r, buf[:1]); err != io.EOF {
+				if err != nil {
+					return err
+				}
+				return errStreamTrailing
+			}
+			return nil
+		}
+	}
+}
+
+// stream derives the keys and nonces of the chunks of a stream. Its key
+// is specific to the first 16 bytes of the nonce of the stream, and the
+// nonces of its chunks are the last 8 bytes of that nonce followed by
+// the number of the chunk, so that no two chunks of distinct streams
+// share both.
+type stream struct {
+	key     SharedKey
+	nonce   [24]byte
+	counter uint64
+}
+
+func newStream(k *SharedKey, nonce *[24]byte) *stream {
+	s := new(stream)
+	var in [16]byte
+	copy(in[:], nonce[:16])
+	salsa.HSalsa20((*[32]byte)(&s.key), &in, (*[32]byte)(k), &salsa.Sigma)
+	copy(s.nonce[:8], nonce[16:])
+	return s
+}
+
+// next returns the nonce of the next chunk.
+func (s *stream) next() *[24]byte {
+	binary.BigEndian.PutUint64(s.nonce[16:], s.counter)
+	s.counter++
+	return &s.nonce
+}
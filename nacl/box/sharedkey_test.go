@@ -0,0 +1,117 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package box
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSharedKey(t *testing.T) {
+	publicKey1, privateKey1, _ := GenerateKey(rand.Reader)
+	publicKey2, privateKey2, _ := GenerateKey(rand.Reader)
+	k1 := NewSharedKey(publicKey2, privateKey1)
+	k2 := NewSharedKey(publicKey1, privateKey2)
+	if *k1 != *k2 {
+		t.Fatalf("the shared keys of both sides differ")
+	}
+	var precomputed [32]byte
+	Precompute(&precomputed, publicKey2, privateKey1)
+	if [32]byte(*k1) != precomputed {
+		t.Fatalf("NewSharedKey differs from Precompute")
+	}
+
+	message := []byte("test message")
+	var nonce [24]byte
+	box := k1.Seal(nil, message, &nonce)
+	if opened, ok := Open(nil, box, &nonce, publicKey1, privateKey2); !ok || !bytes.Equal(opened, message) {
+		t.Fatalf("Open of SharedKey.Seal = %q, %v, want %q", opened, ok, message)
+	}
+	box = Seal(nil, message, &nonce, publicKey2, privateKey1)
+	if opened, ok := k2.Open(nil, box, &nonce); !ok || !bytes.Equal(opened, message) {
+		t.Fatalf("SharedKey.Open of Seal = %q, %v, want %q", opened, ok, message)
+	}
+
+	k1.Zero()
+	if *k1 != (SharedKey{}) {
+		t.Fatalf("Zero left %x", *k1)
+	}
+}
+
+func sealStream(t *testing.T, k *SharedKey, message []byte, nonce *[24]byte, chunkSize int) []byte {
+	var sealed bytes.Buffer
+	if err := k.SealStream(&sealed, iotest.HalfReader(bytes.NewReader(message)), nonce, chunkSize); err != nil {
+		t.Fatalf("SealStream: %v", err)
+	}
+	return sealed.Bytes()
+}
+
+func TestSharedKeyStream(t *testing.T) {
+	var k SharedKey
+	rand.Read(k[:])
+	var nonce [24]byte
+	rand.Read(nonce[:])
+	const chunkSize = 64
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize, 3*chunkSize + 5} {
+		message := make([]byte, size)
+		rand.Read(message)
+		sealed := sealStream(t, &k, message, &nonce, chunkSize)
+		chunks := size/chunkSize + 1
+		if want := size + chunks*(1+Overhead); len(sealed) != want {
+			t.Errorf("%d bytes: sealed stream has %d bytes, want %d", size, len(sealed), want)
+		}
+
+		var opened bytes.Buffer
+		if err := k.OpenStream(&opened, iotest.OneByteReader(bytes.NewReader(sealed)), &nonce, chunkSize); err != nil {
+			t.Errorf("%d bytes: OpenStream: %v", size, err)
+		} else if !bytes.Equal(opened.Bytes(), message) {
+			t.Errorf("%d bytes: OpenStream = %x, want %x", size, opened.Bytes(), message)
+		}
+	}
+}
+
+func TestSharedKeyStreamTampered(t *testing.T) {
+	var k SharedKey
+	rand.Read(k[:])
+	var nonce [24]byte
+	const chunkSize = 16
+	message := bytes.Repeat([]byte("0123456789abcdef"), 3)
+	sealed := sealStream(t, &k, message, &nonce, chunkSize)
+	sealedChunk := 1 + chunkSize + Overhead
+	chunk := func(i int) []byte { return sealed[i*sealedChunk : (i+1)*sealedChunk] }
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	otherNonce := nonce
+	otherNonce[23] = 1
+	otherStream := sealStream(t, &k, message, &otherNonce, chunkSize)
+
+	flipped := append([]byte(nil), sealed...)
+	flipped[len(flipped)-1] ^= 1
+
+	for _, tt := range []struct {
+		name   string
+		sealed []byte
+	}{
+		{"empty", nil},
+		{"flipped bit", flipped},
+		{"last chunk dropped", sealed[:3*sealedChunk]},
+		{"chunk dropped", join(chunk(0), chunk(2), sealed[3*sealedChunk:])},
+		{"chunks swapped", join(chunk(1), chunk(0), sealed[2*sealedChunk:])},
+		{"partial chunk", sealed[:len(sealed)-1]},
+		{"trailing data", join(sealed, []byte{0})},
+		{"chunk of another stream", join(otherStream[:sealedChunk], sealed[sealedChunk:])},
+	} {
+		var opened bytes.Buffer
+		if err := k.OpenStream(&opened, bytes.NewReader(tt.sealed), &nonce, chunkSize); err == nil {
+			t.Errorf("%s: OpenStream succeeded", tt.name)
+		}
+	}
+
+	if err := k.SealStream(new(bytes.Buffer), bytes.NewReader(message), &nonce, 0); err == nil {
+		t.Errorf("SealStream with a chunk size of 0 succeeded")
+	}
+}
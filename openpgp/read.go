@@ -84,6 +84,14 @@ type keyEnvelopePair struct {
 // verification) and, possibly encrypted, private keys for decrypting.
 // If config is nil, sensible defaults will be used.
 func ReadMessage(r io.Reader, keyring KeyRing, prompt PromptFunction, config *packet.Config) (md *MessageDetails, err error) {
+	return ReadMessageWithOptions(r, keyring, prompt, config, nil)
+}
+
+// ReadMessageWithOptions is like ReadMessage, but the signature of the
+// message, if any, must also satisfy opts. If it does not,
+// MessageDetails.SignatureError is set once UnverifiedBody has been read
+// to EOF. If opts is nil, any signature is accepted, as with ReadMessage.
+func ReadMessageWithOptions(r io.Reader, keyring KeyRing, prompt PromptFunction, config *packet.Config, opts *VerifyOptions) (md *MessageDetails, err error) {
 	var p packet.Packet
 
 	var symKeys []*packet.SymmetricKeyEncrypted
@@ -136,7 +144,7 @@ ParsePackets:
 				return nil, errors.StructuralError("key material not followed by encrypted message")
 			}
 			packets.Unread(p)
-			return readSignedMessage(packets, nil, keyring)
+			return readSignedMessage(packets, nil, keyring, opts)
 		}
 	}
 
@@ -216,13 +224,13 @@ FindKey:
 	if err := packets.Push(decrypted); err != nil {
 		return nil, err
 	}
-	return readSignedMessage(packets, md, keyring)
+	return readSignedMessage(packets, md, keyring, opts)
 }
 
 // readSignedMessage reads a possibly signed message if mdin is non-zero then
 // that structure is updated and returned. Otherwise a fresh MessageDetails is
 // used.
-func readSignedMessage(packets *packet.Reader, mdin *MessageDetails, keyring KeyRing) (md *MessageDetails, err error) {
+func readSignedMessage(packets *packet.Reader, mdin *MessageDetails, keyring KeyRing, opts *VerifyOptions) (md *MessageDetails, err error) {
 	if mdin == nil {
 		mdin = new(MessageDetails)
 	}
@@ -266,7 +274,7 @@ FindLiteralData:
 	}
 
 	if md.SignedBy != nil {
-		md.UnverifiedBody = &signatureCheckReader{packets, h, wrappedHash, md}
+		md.UnverifiedBody = &signatureCheckReader{packets, h, wrappedHash, md, opts}
 	} else if md.decrypted != nil {
 		md.UnverifiedBody = checkReader{md}
 	} else {
@@ -322,6 +330,7 @@ type signatureCheckReader struct {
 	packets        *packet.Reader
 	h, wrappedHash hash.Hash
 	md             *MessageDetails
+	opts           *VerifyOptions
 }
 
 func (scr *signatureCheckReader) Read(buf []byte) (n int, err error) {
@@ -336,9 +345,13 @@ func (scr *signatureCheckReader) Read(buf []byte) (n int, err error) {
 
 		var ok bool
 		if scr.md.Signature, ok = p.(*packet.Signature); ok {
-			scr.md.SignatureError = scr.md.SignedBy.PublicKey.VerifySignature(scr.h, scr.md.Signature)
+			if scr.md.SignatureError = scr.opts.checkHash(scr.md.Signature.Hash); scr.md.SignatureError == nil {
+				scr.md.SignatureError = scr.md.SignedBy.PublicKey.VerifySignature(scr.h, scr.md.Signature)
+			}
 		} else if scr.md.SignatureV3, ok = p.(*packet.SignatureV3); ok {
-			scr.md.SignatureError = scr.md.SignedBy.PublicKey.VerifySignatureV3(scr.h, scr.md.SignatureV3)
+			if scr.md.SignatureError = scr.opts.checkHash(scr.md.SignatureV3.Hash); scr.md.SignatureError == nil {
+				scr.md.SignatureError = scr.md.SignedBy.PublicKey.VerifySignatureV3(scr.h, scr.md.SignatureV3)
+			}
 		} else {
 			scr.md.SignatureError = errors.StructuralError("LiteralData not followed by Signature")
 			return
@@ -357,10 +370,68 @@ func (scr *signatureCheckReader) Read(buf []byte) (n int, err error) {
 	return
 }
 
+// VerifyOptions is a policy that signatures must satisfy, on top of being
+// valid, to be accepted by CheckDetachedSignatureWithOptions and
+// ReadMessageWithOptions. A nil *VerifyOptions accepts any signature.
+type VerifyOptions struct {
+	// MinHash, if non-zero, is the weakest hash function that a
+	// signature may use. Hash functions are ranked MD5, then SHA-1 and
+	// RIPEMD-160, then SHA-224, SHA-256, SHA-384 and SHA-512. Set it to
+	// crypto.SHA256 to reject the MD5 and SHA-1 signatures that older
+	// software makes, which are not safe against forgery.
+	MinHash crypto.Hash
+}
+
+// hashStrength ranks the hash functions that may be used in signatures.
+// Unknown hash functions are ranked zero.
+func hashStrength(h crypto.Hash) int {
+	switch h {
+	case crypto.MD5:
+		return 1
+	case crypto.SHA1, crypto.RIPEMD160:
+		return 2
+	case crypto.SHA224:
+		return 3
+	case crypto.SHA256:
+		return 4
+	case crypto.SHA384:
+		return 5
+	case crypto.SHA512:
+		return 6
+	}
+	return 0
+}
+
+// checkHash returns a SignatureError if a signature made with h is not
+// allowed by opts.
+func (opts *VerifyOptions) checkHash(h crypto.Hash) error {
+	if opts == nil || opts.MinHash == 0 {
+		return nil
+	}
+	if hashStrength(h) < hashStrength(opts.MinHash) {
+		return errors.SignatureError("hash function " + hashName(h) + " is weaker than the minimum of " + hashName(opts.MinHash))
+	}
+	return nil
+}
+
+func hashName(h crypto.Hash) string {
+	if hashStrength(h) == 0 {
+		return "#" + strconv.Itoa(int(h))
+	}
+	return h.String()
+}
+
 // CheckDetachedSignature takes a signed file and a detached signature and
 // returns the signer if the signature is valid. If the signer isn't known,
 // ErrUnknownIssuer is returned.
 func CheckDetachedSignature(keyring KeyRing, signed, signature io.Reader) (signer *Entity, err error) {
+	return CheckDetachedSignatureWithOptions(keyring, signed, signature, nil)
+}
+
+// CheckDetachedSignatureWithOptions is like CheckDetachedSignature, but
+// the signature must also satisfy opts. If it does not, an
+// errors.SignatureError is returned without hashing signed.
+func CheckDetachedSignatureWithOptions(keyring KeyRing, signed, signature io.Reader, opts *VerifyOptions) (signer *Entity, err error) {
 	var issuerKeyId uint64
 	var hashFunc crypto.Hash
 	var sigType packet.SignatureType
@@ -403,6 +474,10 @@ func CheckDetachedSignature(keyring KeyRing, signed, signature io.Reader) (signe
 		panic("unreachable")
 	}
 
+	if err := opts.checkHash(hashFunc); err != nil {
+		return nil, err
+	}
+
 	h, wrappedHash, err := hashForSignature(hashFunc, sigType)
 	if err != nil {
 		return nil, err
@@ -433,10 +508,17 @@ func CheckDetachedSignature(keyring KeyRing, signed, signature io.Reader) (signe
 // CheckArmoredDetachedSignature performs the same actions as
 // CheckDetachedSignature but expects the signature to be armored.
 func CheckArmoredDetachedSignature(keyring KeyRing, signed, signature io.Reader) (signer *Entity, err error) {
+	return CheckArmoredDetachedSignatureWithOptions(keyring, signed, signature, nil)
+}
+
+// CheckArmoredDetachedSignatureWithOptions performs the same actions as
+// CheckDetachedSignatureWithOptions but expects the signature to be
+// armored.
+func CheckArmoredDetachedSignatureWithOptions(keyring KeyRing, signed, signature io.Reader, opts *VerifyOptions) (signer *Entity, err error) {
 	body, err := readArmored(signature, SignatureType)
 	if err != nil {
 		return
 	}
 
-	return CheckDetachedSignature(keyring, signed, body)
+	return CheckDetachedSignatureWithOptions(keyring, signed, body, opts)
 }
@@ -6,6 +6,7 @@ package openpgp
 
 import (
 	"bytes"
+	"crypto"
 	_ "crypto/sha512"
 	"encoding/hex"
 	"io"
@@ -15,6 +16,7 @@ import (
 
 	"golang.org/x/crypto/openpgp/armor"
 	"golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
 )
 
 func readerFromHex(s string) io.Reader {
@@ -343,6 +345,87 @@ func TestDetachedSignatureP256(t *testing.T) {
 	testDetachedSignature(t, kring, readerFromHex(detachedSignatureP256Hex), signedInput, "binary", testKeyP256KeyId)
 }
 
+func TestDetachedSignatureMinHash(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	policy := &VerifyOptions{MinHash: crypto.SHA256}
+
+	for _, test := range []struct {
+		hash crypto.Hash
+		ok   bool
+	}{
+		{crypto.SHA1, false},
+		{crypto.SHA256, true},
+		{crypto.SHA512, true},
+	} {
+		sig := new(bytes.Buffer)
+		if err := DetachSign(sig, kring[0], bytes.NewBufferString(signedInput), &packet.Config{DefaultHash: test.hash}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := CheckDetachedSignatureWithOptions(kring, bytes.NewBufferString(signedInput), bytes.NewReader(sig.Bytes()), nil); err != nil {
+			t.Errorf("%s: without a policy: %s", test.hash, err)
+		}
+
+		signer, err := CheckDetachedSignatureWithOptions(kring, bytes.NewBufferString(signedInput), bytes.NewReader(sig.Bytes()), policy)
+		if test.ok {
+			if err != nil {
+				t.Errorf("%s: signature error: %s", test.hash, err)
+			} else if signer.PrimaryKey.KeyId != testKey1KeyId {
+				t.Errorf("%s: wrong signer got:%x want:%x", test.hash, signer.PrimaryKey.KeyId, uint64(testKey1KeyId))
+			}
+			continue
+		}
+		if _, ok := err.(errors.SignatureError); !ok {
+			t.Errorf("%s: got error %v, want a SignatureError", test.hash, err)
+		} else if !strings.Contains(err.Error(), "SHA-1") {
+			t.Errorf("%s: error %q does not name the hash function", test.hash, err)
+		}
+	}
+}
+
+func TestSignedMessageMinHash(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	policy := &VerifyOptions{MinHash: crypto.SHA256}
+
+	for _, test := range []struct {
+		hash crypto.Hash
+		ok   bool
+	}{
+		{crypto.SHA1, false},
+		{crypto.SHA256, true},
+	} {
+		msg := new(bytes.Buffer)
+		w, err := Sign(msg, kring[0], nil, &packet.Config{DefaultHash: test.hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(signedInput))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		md, err := ReadMessageWithOptions(msg, kring, nil, nil, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(md.UnverifiedBody); err != nil {
+			t.Fatal(err)
+		}
+		if md.Signature == nil || md.Signature.Hash != test.hash {
+			t.Fatalf("%s: message was not signed with the expected hash", test.hash)
+		}
+		if test.ok {
+			if md.SignatureError != nil {
+				t.Errorf("%s: signature error: %s", test.hash, md.SignatureError)
+			}
+			continue
+		}
+		if _, ok := md.SignatureError.(errors.SignatureError); !ok {
+			t.Errorf("%s: got SignatureError %v, want a SignatureError", test.hash, md.SignatureError)
+		}
+	}
+}
+
 func testHashFunctionError(t *testing.T, signatureHex string) {
 	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2Hex))
 	_, err := CheckDetachedSignature(kring, nil, readerFromHex(signatureHex))
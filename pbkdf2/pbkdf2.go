@@ -20,7 +20,9 @@ package pbkdf2 // import "golang.org/x/crypto/pbkdf2"
 
 import (
 	"crypto/hmac"
+	"errors"
 	"hash"
+	"io"
 )
 
 // Key derives a key from the password, salt and iteration count, returning a
@@ -44,34 +46,89 @@ func Key(password, salt []byte, iter, keyLen int, h func() hash.Hash) []byte {
 	hashLen := prf.Size()
 	numBlocks := (keyLen + hashLen - 1) / hashLen
 
-	var buf [4]byte
 	dk := make([]byte, 0, numBlocks*hashLen)
 	U := make([]byte, hashLen)
 	for block := 1; block <= numBlocks; block++ {
-		// N.B.: || means concatenation, ^ means XOR
-		// for each block T_i = U_1 ^ U_2 ^ ... ^ U_iter
-		// U_1 = PRF(password, salt || uint(i))
+		dk = appendBlock(dk, prf, salt, iter, uint32(block), U)
+	}
+	return dk[:keyLen]
+}
+
+// appendBlock appends the block T_i of the derived key to dk, using U
+// as scratch space of the size of the hash.
+func appendBlock(dk []byte, prf hash.Hash, salt []byte, iter int, block uint32, U []byte) []byte {
+	// N.B.: || means concatenation, ^ means XOR
+	// for each block T_i = U_1 ^ U_2 ^ ... ^ U_iter
+	// U_1 = PRF(password, salt || uint(i))
+	var buf [4]byte
+	prf.Reset()
+	prf.Write(salt)
+	buf[0] = byte(block >> 24)
+	buf[1] = byte(block >> 16)
+	buf[2] = byte(block >> 8)
+	buf[3] = byte(block)
+	prf.Write(buf[:4])
+	dk = prf.Sum(dk)
+	T := dk[len(dk)-len(U):]
+	copy(U, T)
+
+	// U_n = PRF(password, U_(n-1))
+	for n := 2; n <= iter; n++ {
 		prf.Reset()
-		prf.Write(salt)
-		buf[0] = byte(block >> 24)
-		buf[1] = byte(block >> 16)
-		buf[2] = byte(block >> 8)
-		buf[3] = byte(block)
-		prf.Write(buf[:4])
-		dk = prf.Sum(dk)
-		T := dk[len(dk)-hashLen:]
-		copy(U, T)
+		prf.Write(U)
+		U = U[:0]
+		U = prf.Sum(U)
+		for x := range U {
+			T[x] ^= U[x]
+		}
+	}
+	return dk
+}
+
+type reader struct {
+	prf  hash.Hash
+	salt []byte
+	iter int
 
-		// U_n = PRF(password, U_(n-1))
-		for n := 2; n <= iter; n++ {
-			prf.Reset()
-			prf.Write(U)
-			U = U[:0]
-			U = prf.Sum(U)
-			for x := range U {
-				T[x] ^= U[x]
+	// block is the index of the last computed block, stored in out,
+	// and buf holds its unread part.
+	block uint32
+	out   []byte
+	buf   []byte
+	U     []byte
+}
+
+// NewReader returns a reader from which the key derived from the
+// password, salt and iteration count, as by Key, can be read. The key
+// material is computed one block of the size of the hash at a time, as
+// it is read, so that reading keyLen bytes returns the same bytes as
+// Key with that keyLen, without computing or allocating all of them up
+// front. The reader returns an error once 2^32 - 1 blocks, the maximum
+// of RFC 8018, have been read.
+func NewReader(password, salt []byte, iter int, h func() hash.Hash) io.Reader {
+	prf := hmac.New(h, password)
+	return &reader{
+		prf:  prf,
+		salt: append([]byte(nil), salt...),
+		iter: iter,
+		out:  make([]byte, 0, prf.Size()),
+		U:    make([]byte, prf.Size()),
+	}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			if r.block == 1<<32-1 {
+				return n, errors.New("pbkdf2: key length limit reached")
 			}
+			r.block++
+			r.buf = appendBlock(r.out[:0], r.prf, r.salt, r.iter, r.block, r.U)
 		}
+		c := copy(p[n:], r.buf)
+		r.buf = r.buf[c:]
+		n += c
 	}
-	return dk[:keyLen]
+	return n, nil
 }
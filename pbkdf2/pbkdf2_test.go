@@ -9,6 +9,7 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"hash"
+	"io"
 	"testing"
 )
 
@@ -145,6 +146,12 @@ func testHash(t *testing.T, h func() hash.Hash, hashName string, vectors []testV
 		if !bytes.Equal(o, v.output) {
 			t.Errorf("%s %d: expected %x, got %x", hashName, i, v.output, o)
 		}
+		o = make([]byte, len(v.output))
+		if _, err := io.ReadFull(NewReader([]byte(v.password), []byte(v.salt), v.iter, h), o); err != nil {
+			t.Errorf("%s %d: reader failed: %v", hashName, i, err)
+		} else if !bytes.Equal(o, v.output) {
+			t.Errorf("%s %d: expected %x from the reader, got %x", hashName, i, v.output, o)
+		}
 	}
 }
 
@@ -156,6 +163,38 @@ func TestWithHMACSHA256(t *testing.T) {
 	testHash(t, sha256.New, "SHA256", sha256TestVectors)
 }
 
+func TestReader(t *testing.T) {
+	password, salt := []byte("password"), []byte("salt")
+	const keyLen = 1000
+	want := Key(password, salt, 10, keyLen, sha256.New)
+
+	// Reads of varying sizes, not aligned to the blocks, concatenate
+	// into the output of Key.
+	r := NewReader(password, salt, 10, sha256.New)
+	var got []byte
+	for n := 1; len(got) < keyLen; n = n*2 + 1 {
+		if n > keyLen-len(got) {
+			n = keyLen - len(got)
+		}
+		p := make([]byte, n)
+		if m, err := r.Read(p); m != n || err != nil {
+			t.Fatalf("Read(%d bytes) = %d, %v", n, m, err)
+		}
+		got = append(got, p...)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	// The block index does not wrap around.
+	lr := NewReader(password, salt, 1, sha1.New).(*reader)
+	lr.block = 1<<32 - 2
+	p := make([]byte, sha1.Size+1)
+	if n, err := lr.Read(p); n != sha1.Size || err == nil {
+		t.Errorf("Read past the last block = %d, %v, want %d and an error", n, err, sha1.Size)
+	}
+}
+
 var sink uint8
 
 func benchmark(b *testing.B, h func() hash.Hash) {
@@ -12,6 +12,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"math/bits"
+	"time"
 
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -210,3 +211,82 @@ func Key(password, salt []byte, N, r, p, keyLen int) ([]byte, error) {
 
 	return pbkdf2.Key(password, b, 1, keyLen, sha256.New), nil
 }
+
+// paramsR is the block size used by Params.
+const paramsR = 8
+
+// Params picks scrypt parameters for Key that make deriving a key take
+// about targetDuration on the current machine, while using at most
+// maxMemBytes of memory (which is 128 * N * r bytes).
+//
+// Params uses r = 8 and first raises N, which must be a power of two,
+// as far as the time target and the memory limit allow. If the largest
+// N that fits in maxMemBytes is still faster than targetDuration, p is
+// raised instead. The target is an estimate based on a short
+// measurement of Key, so the resulting cost will vary from run to run
+// and should be treated as approximate.
+func Params(targetDuration time.Duration, maxMemBytes int) (N, r, p int, err error) {
+	return params(targetDuration, maxMemBytes, measureKey)
+}
+
+// measureKey returns the shortest of a few runs of Key with the given
+// parameters.
+func measureKey(N, r, p int) time.Duration {
+	var best time.Duration
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		Key([]byte("password"), []byte("salt"), N, r, p, 32)
+		if d := time.Since(start); i == 0 || d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// params implements Params, with measure reporting how long Key takes
+// for the given parameters.
+func params(targetDuration time.Duration, maxMemBytes int, measure func(N, r, p int) time.Duration) (N, r, p int, err error) {
+	if targetDuration <= 0 {
+		return 0, 0, 0, errors.New("scrypt: target duration must be positive")
+	}
+	r = paramsR
+	if maxMemBytes < 128*2*r {
+		return 0, 0, 0, errors.New("scrypt: memory limit is too small")
+	}
+
+	maxN := 2
+	for maxN <= maxInt/2 && 128*2*maxN*r <= maxMemBytes && 2*maxN <= maxInt/128/r {
+		maxN *= 2
+	}
+
+	// The cost of Key is close to linear in N * p, so measure a small
+	// N and extrapolate.
+	calN := 1 << 12
+	if calN > maxN {
+		calN = maxN
+	}
+	cal := measure(calN, r, 1)
+	if cal <= 0 {
+		cal = 1
+	}
+	// perN is the estimated cost of one unit of N, in nanoseconds.
+	perN := float64(cal) / float64(calN)
+	target := float64(targetDuration)
+
+	N = 2
+	for N < maxN && perN*float64(2*N) <= target {
+		N *= 2
+	}
+
+	p = 1
+	if N == maxN {
+		if n := int(target / (perN * float64(N))); n > 1 {
+			p = n
+		}
+		maxP := (1<<30 - 1) / r
+		if p > maxP {
+			p = maxP
+		}
+	}
+	return N, r, p, nil
+}
@@ -7,6 +7,7 @@ package scrypt
 import (
 	"bytes"
 	"testing"
+	"time"
 )
 
 type testVector struct {
@@ -153,6 +154,66 @@ func TestKey(t *testing.T) {
 	}
 }
 
+// linearCost models Key as taking perN for each unit of N * p.
+func linearCost(perN time.Duration) func(N, r, p int) time.Duration {
+	return func(N, r, p int) time.Duration {
+		return perN * time.Duration(N*p)
+	}
+}
+
+func TestParams(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		target  time.Duration
+		maxMem  int
+		perN    time.Duration
+		N, r, p int
+	}{
+		{"time bound", 100 * time.Millisecond, 1 << 30, 3 * time.Microsecond, 1 << 15, 8, 1},
+		{"memory bound", 100 * time.Millisecond, 16 << 20, time.Microsecond, 1 << 14, 8, 6},
+		{"tiny target", time.Nanosecond, 1 << 30, time.Microsecond, 2, 8, 1},
+		{"exact fit", 4096 * time.Microsecond, 1 << 30, time.Microsecond, 1 << 12, 8, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			N, r, p, err := params(tt.target, tt.maxMem, linearCost(tt.perN))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if N != tt.N || r != tt.r || p != tt.p {
+				t.Errorf("got N=%d r=%d p=%d, want N=%d r=%d p=%d", N, r, p, tt.N, tt.r, tt.p)
+			}
+			if mem := 128 * N * r; mem > tt.maxMem {
+				t.Errorf("parameters use %d bytes, more than the limit of %d", mem, tt.maxMem)
+			}
+		})
+	}
+
+	if _, _, _, err := params(time.Second, 1024, linearCost(time.Microsecond)); err == nil {
+		t.Error("expected an error for a memory limit that is too small")
+	}
+	if _, _, _, err := params(0, 1<<30, linearCost(time.Microsecond)); err == nil {
+		t.Error("expected an error for a zero target duration")
+	}
+}
+
+func TestParamsRuntime(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping calibration in short mode")
+	}
+	const target = 50 * time.Millisecond
+	N, r, p, err := Params(target, 64<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Key([]byte("password"), []byte("salt"), N, r, p, 32); err != nil {
+		t.Fatalf("Key rejected parameters N=%d r=%d p=%d: %v", N, r, p, err)
+	}
+	// Timing on shared machines is noisy, so only catch gross errors.
+	if d := measureKey(N, r, p); d > 10*target {
+		t.Errorf("Key with N=%d r=%d p=%d took %v, want about %v", N, r, p, d, target)
+	}
+}
+
 var sink []byte
 
 func BenchmarkKey(b *testing.B) {
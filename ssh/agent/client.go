@@ -272,6 +272,42 @@ func (k *Key) Verify(data []byte, sig *ssh.Signature) error {
 	return pubKey.Verify(data, sig)
 }
 
+// FilteredSigners returns signers for the keys held by agent for which
+// allow returns true. Offering only the keys that are useful for a
+// server avoids running into its limit on authentication attempts when
+// the agent holds many keys. For example, allow can match the key
+// comment, or ssh.FingerprintSHA256(key).
+//
+// The signers are in the order the agent lists the keys, which is
+// usually the order they were added in, whatever their algorithms. To
+// offer them in another order, sort the result before passing it to
+// ssh.PublicKeys.
+func FilteredSigners(agent Agent, allow func(key *Key) bool) ([]ssh.Signer, error) {
+	keys, err := agent.List()
+	if err != nil {
+		return nil, err
+	}
+	signers, err := agent.Signers()
+	if err != nil {
+		return nil, err
+	}
+	byBlob := make(map[string]ssh.Signer, len(signers))
+	for _, s := range signers {
+		byBlob[string(s.PublicKey().Marshal())] = s
+	}
+
+	var result []ssh.Signer
+	for _, k := range keys {
+		if !allow(k) {
+			continue
+		}
+		if s, ok := byBlob[string(k.Blob)]; ok {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
 type wireKey struct {
 	Format string
 	Rest   []byte `ssh:"rest"`
@@ -528,11 +564,27 @@ type ed25519KeyMsg struct {
 
 // Insert adds a private key to the agent.
 func (c *client) insertKey(s interface{}, comment string, constraints []byte) error {
+	req, err := keyRequest(s, comment, constraints)
+	if err != nil {
+		return err
+	}
+	resp, err := c.call(req)
+	if err != nil {
+		return err
+	}
+	if _, ok := resp.(*successAgentMsg); ok {
+		return nil
+	}
+	return errors.New("agent: failure")
+}
+
+// keyRequest returns the request adding the private key s to an agent.
+func keyRequest(s interface{}, comment string, constraints []byte) ([]byte, error) {
 	var req []byte
 	switch k := s.(type) {
 	case *rsa.PrivateKey:
 		if len(k.Primes) != 2 {
-			return fmt.Errorf("agent: unsupported RSA key with %d primes", len(k.Primes))
+			return nil, fmt.Errorf("agent: unsupported RSA key with %d primes", len(k.Primes))
 		}
 		k.Precompute()
 		req = ssh.Marshal(rsaKeyMsg{
@@ -587,22 +639,14 @@ func (c *client) insertKey(s interface{}, comment string, constraints []byte) er
 			Constraints: constraints,
 		})
 	default:
-		return fmt.Errorf("agent: unsupported key type %T", s)
+		return nil, fmt.Errorf("agent: unsupported key type %T", s)
 	}
 
 	// if constraints are present then the message type needs to be changed.
 	if len(constraints) != 0 {
 		req[0] = agentAddIDConstrained
 	}
-
-	resp, err := c.call(req)
-	if err != nil {
-		return err
-	}
-	if _, ok := resp.(*successAgentMsg); ok {
-		return nil
-	}
-	return errors.New("agent: failure")
+	return req, nil
 }
 
 type rsaCertMsg struct {
@@ -644,6 +688,17 @@ type ed25519CertMsg struct {
 // Add adds a private key to the agent. If a certificate is given,
 // that certificate is added instead as public key.
 func (c *client) Add(key AddedKey) error {
+	constraints := marshalConstraints(key)
+	cert := key.Certificate
+	if cert == nil {
+		return c.insertKey(key.PrivateKey, key.Comment, constraints)
+	}
+	return c.insertCert(key.PrivateKey, cert, key.Comment, constraints)
+}
+
+// marshalConstraints returns the lifetime and confirmation constraints
+// of key.
+func marshalConstraints(key AddedKey) []byte {
 	var constraints []byte
 
 	if secs := key.LifetimeSecs; secs != 0 {
@@ -653,20 +708,41 @@ func (c *client) Add(key AddedKey) error {
 	if key.ConfirmBeforeUse {
 		constraints = append(constraints, agentConstrainConfirm)
 	}
+	return constraints
+}
 
-	cert := key.Certificate
-	if cert == nil {
-		return c.insertKey(key.PrivateKey, key.Comment, constraints)
+// addedKeyRequest returns the request adding key to an agent, with
+// the given constraints.
+func addedKeyRequest(key AddedKey, constraints []byte) ([]byte, error) {
+	if key.Certificate == nil {
+		return keyRequest(key.PrivateKey, key.Comment, constraints)
 	}
-	return c.insertCert(key.PrivateKey, cert, key.Comment, constraints)
+	return certRequest(key.PrivateKey, key.Certificate, key.Comment, constraints)
 }
 
 func (c *client) insertCert(s interface{}, cert *ssh.Certificate, comment string, constraints []byte) error {
+	req, err := certRequest(s, cert, comment, constraints)
+	if err != nil {
+		return err
+	}
+	resp, err := c.call(req)
+	if err != nil {
+		return err
+	}
+	if _, ok := resp.(*successAgentMsg); ok {
+		return nil
+	}
+	return errors.New("agent: failure")
+}
+
+// certRequest returns the request adding the private key s to an
+// agent, with the certificate cert of its public key.
+func certRequest(s interface{}, cert *ssh.Certificate, comment string, constraints []byte) ([]byte, error) {
 	var req []byte
 	switch k := s.(type) {
 	case *rsa.PrivateKey:
 		if len(k.Primes) != 2 {
-			return fmt.Errorf("agent: unsupported RSA key with %d primes", len(k.Primes))
+			return nil, fmt.Errorf("agent: unsupported RSA key with %d primes", len(k.Primes))
 		}
 		k.Precompute()
 		req = ssh.Marshal(rsaCertMsg{
@@ -717,7 +793,7 @@ func (c *client) insertCert(s interface{}, cert *ssh.Certificate, comment string
 			Constraints: constraints,
 		})
 	default:
-		return fmt.Errorf("agent: unsupported key type %T", s)
+		return nil, fmt.Errorf("agent: unsupported key type %T", s)
 	}
 
 	// if constraints are present then the message type needs to be changed.
@@ -727,20 +803,12 @@ func (c *client) insertCert(s interface{}, cert *ssh.Certificate, comment string
 
 	signer, err := ssh.NewSignerFromKey(s)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if bytes.Compare(cert.Key.Marshal(), signer.PublicKey().Marshal()) != 0 {
-		return errors.New("agent: signer and cert have different public key")
+		return nil, errors.New("agent: signer and cert have different public key")
 	}
-
-	resp, err := c.call(req)
-	if err != nil {
-		return err
-	}
-	if _, ok := resp.(*successAgentMsg); ok {
-		return nil
-	}
-	return errors.New("agent: failure")
+	return req, nil
 }
 
 // Signers provides a callback for client authentication.
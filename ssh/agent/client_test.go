@@ -13,6 +13,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
@@ -266,6 +267,70 @@ func TestAgent(t *testing.T) {
 	}
 }
 
+func TestFilteredSigners(t *testing.T) {
+	keyring := NewKeyring()
+	for _, keyType := range []string{"rsa", "dsa", "ecdsa", "ed25519"} {
+		if err := keyring.Add(AddedKey{PrivateKey: testPrivateKeys[keyType], Comment: keyType}); err != nil {
+			t.Fatalf("Add(%s): %v", keyType, err)
+		}
+	}
+	agent, cleanup := startAgent(t, keyring)
+	defer cleanup()
+
+	signers, err := FilteredSigners(agent, func(k *Key) bool {
+		return k.Comment == "ecdsa"
+	})
+	if err != nil {
+		t.Fatalf("FilteredSigners: %v", err)
+	}
+	if len(signers) != 1 {
+		t.Fatalf("got %d signers, want 1", len(signers))
+	}
+	if !bytes.Equal(signers[0].PublicKey().Marshal(), testPublicKeys["ecdsa"].Marshal()) {
+		t.Fatalf("got signer for %s key, want the ecdsa key", signers[0].PublicKey().Type())
+	}
+	data := []byte("hello")
+	sig, err := signers[0].Sign(rand.Reader, data)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if err := testPublicKeys["ecdsa"].Verify(data, sig); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	fp := ssh.FingerprintSHA256(testPublicKeys["ed25519"])
+	signers, err = FilteredSigners(agent, func(k *Key) bool {
+		return ssh.FingerprintSHA256(k) == fp
+	})
+	if err != nil {
+		t.Fatalf("FilteredSigners: %v", err)
+	}
+	if len(signers) != 1 || signers[0].PublicKey().Type() != ssh.KeyAlgoED25519 {
+		t.Fatalf("got %d signers, want the ed25519 key", len(signers))
+	}
+
+	// The order of the agent is kept.
+	signers, err = FilteredSigners(agent, func(k *Key) bool {
+		return k.Comment != "dsa"
+	})
+	if err != nil {
+		t.Fatalf("FilteredSigners: %v", err)
+	}
+	var got []string
+	for _, s := range signers {
+		got = append(got, s.PublicKey().Type())
+	}
+	want := []string{ssh.KeyAlgoRSA, ssh.KeyAlgoECDSA256, ssh.KeyAlgoED25519}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got signers for %q, want %q", got, want)
+	}
+
+	signers, err = FilteredSigners(agent, func(k *Key) bool { return false })
+	if err != nil || len(signers) != 0 {
+		t.Fatalf("got %d signers, %v, want none", len(signers), err)
+	}
+}
+
 func TestCert(t *testing.T) {
 	cert := &ssh.Certificate{
 		Key:         testPublicKeys["rsa"],
@@ -39,3 +39,62 @@ func ExampleNewClient() {
 	// Use sshc...
 	sshc.Close()
 }
+
+func ExampleOpenForwardedAgent() {
+	config := &ssh.ServerConfig{
+		NoClientAuth: true,
+	}
+	// Add a host key to config...
+
+	listener, err := net.Listen("tcp", "0.0.0.0:2022")
+	if err != nil {
+		log.Fatal("failed to listen for connection: ", err)
+	}
+	nConn, err := listener.Accept()
+	if err != nil {
+		log.Fatal("failed to accept incoming connection: ", err)
+	}
+	conn, chans, reqs, err := ssh.NewServerConn(nConn, config)
+	if err != nil {
+		log.Fatal("failed to handshake: ", err)
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			log.Fatalf("Could not accept channel: %v", err)
+		}
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				if !agent.IsAgentForwardingRequest(req) {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+
+				// The session may now use the client's agent, for
+				// example to authenticate to another server.
+				ch, err := agent.OpenForwardedAgent(conn)
+				if err != nil {
+					log.Printf("failed to reach forwarded agent: %v", err)
+					continue
+				}
+				keys, err := agent.NewClient(ch).List()
+				ch.Close()
+				if err != nil {
+					log.Printf("failed to list keys: %v", err)
+					continue
+				}
+				log.Printf("client agent has %d keys", len(keys))
+			}
+		}(requests)
+
+		// Serve the session on channel...
+		_ = channel
+	}
+}
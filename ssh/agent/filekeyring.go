@@ -0,0 +1,356 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package agent
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/ssh"
+)
+
+// A keyring file holds a keyringFileHeader, which is authenticated but
+// not encrypted, followed by a string with the keyringRecords of the
+// keys, encrypted with XChaCha20-Poly1305. The encryption key is
+// derived from the passphrase with Argon2id, whose parameters are in
+// the header. The header begins with keyringFileMagic and the version
+// of the format, so that other versions can be told apart.
+const (
+	keyringFileMagic   = "golang.org/x/crypto/ssh/agent keyring"
+	keyringFileVersion = 1
+	keyringKDFArgon2id = "argon2id"
+)
+
+// ErrIncorrectPassphrase is returned by NewFileKeyring if the
+// passphrase does not decrypt the keyring file, or if the file was
+// modified.
+var ErrIncorrectPassphrase = errors.New("agent: incorrect keyring passphrase")
+
+type keyringFileHeader struct {
+	Magic   string
+	Version uint32
+	KDF     string
+	Time    uint32
+	Memory  uint32
+	Threads uint32
+	Salt    []byte
+	Nonce   []byte
+}
+
+type keyringFile struct {
+	Magic      string
+	Version    uint32
+	KDF        string
+	Time       uint32
+	Memory     uint32
+	Threads    uint32
+	Salt       []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// keyringRecord holds a key of a keyring file, as the request that
+// adds it to an agent, without its lifetime constraint.
+type keyringRecord struct {
+	// Expire is the Unix time at which the key expires, or 0.
+	Expire  uint64
+	Request []byte
+	Rest    []byte `ssh:"rest"`
+}
+
+// keyringKDFParams are the Argon2id parameters of new keyring files,
+// the second recommended option of RFC 9106.
+var keyringKDFParams = struct {
+	time, memory uint32
+	threads      uint8
+}{3, 64 * 1024, 4}
+
+// The limits of the Argon2id parameters of keyring files, which are
+// read before the passphrase can be checked: the memory, in KiB, and
+// the memory times the passes, which bounds the time taken.
+const (
+	maxKeyringKDFMemory = 1 << 20
+	maxKeyringKDFWork   = 1 << 23
+)
+
+type fileKeyring struct {
+	keyring
+
+	path   string
+	header keyringFileHeader
+	key    []byte
+}
+
+// NewFileKeyring returns an Agent that holds keys in memory, like
+// NewKeyring, and stores them in the file at path, encrypted with a
+// key derived from passphrase. If the file exists, its keys are loaded,
+// except for those whose lifetime has elapsed; otherwise it is created.
+//
+// The file is rewritten whenever keys are added or removed, so the
+// keys survive restarts of the program, along with the time at which
+// they expire and their comments and confirmation constraints. Locking
+// the agent is not persistent. The file should not be used by several
+// agents at the same time.
+func NewFileKeyring(path string, passphrase []byte) (Agent, error) {
+	r := &fileKeyring{path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		salt := make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return nil, err
+		}
+		r.header = keyringFileHeader{
+			Magic:   keyringFileMagic,
+			Version: keyringFileVersion,
+			KDF:     keyringKDFArgon2id,
+			Time:    keyringKDFParams.time,
+			Memory:  keyringKDFParams.memory,
+			Threads: uint32(keyringKDFParams.threads),
+			Salt:    salt,
+		}
+		r.key = r.deriveKey(passphrase)
+		if err := r.saveLocked(); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(data, passphrase); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *fileKeyring) deriveKey(passphrase []byte) []byte {
+	h := &r.header
+	return argon2.IDKey(passphrase, h.Salt, h.Time, h.Memory, uint8(h.Threads), chacha20poly1305.KeySize)
+}
+
+func (r *fileKeyring) load(data, passphrase []byte) error {
+	var prefix struct {
+		Magic   string
+		Version uint32
+		Rest    []byte `ssh:"rest"`
+	}
+	if err := ssh.Unmarshal(data, &prefix); err != nil || prefix.Magic != keyringFileMagic {
+		return fmt.Errorf("agent: %s is not a keyring file", r.path)
+	}
+	if prefix.Version != keyringFileVersion {
+		return fmt.Errorf("agent: unsupported keyring file version %d", prefix.Version)
+	}
+	var f keyringFile
+	if err := ssh.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("agent: invalid keyring file %s: %v", r.path, err)
+	}
+	if f.KDF != keyringKDFArgon2id {
+		return fmt.Errorf("agent: unsupported keyring key derivation %q", f.KDF)
+	}
+	if f.Time < 1 || f.Threads < 1 || f.Threads > 255 || len(f.Nonce) != chacha20poly1305.NonceSizeX {
+		return errors.New("agent: invalid keyring file parameters")
+	}
+	if f.Memory > maxKeyringKDFMemory || uint64(f.Memory)*uint64(f.Time) > maxKeyringKDFWork {
+		return errors.New("agent: keyring key derivation parameters are too costly")
+	}
+	r.header = keyringFileHeader{
+		Magic:   f.Magic,
+		Version: f.Version,
+		KDF:     f.KDF,
+		Time:    f.Time,
+		Memory:  f.Memory,
+		Threads: f.Threads,
+		Salt:    f.Salt,
+	}
+	r.key = r.deriveKey(passphrase)
+
+	aead, err := chacha20poly1305.NewX(r.key)
+	if err != nil {
+		return err
+	}
+	// The header is authenticated as additional data.
+	header := data[:len(data)-4-len(f.Ciphertext)]
+	plaintext, err := aead.Open(nil, f.Nonce, f.Ciphertext, header)
+	if err != nil {
+		return ErrIncorrectPassphrase
+	}
+
+	now := time.Now()
+	for len(plaintext) > 0 {
+		var rec keyringRecord
+		if err := ssh.Unmarshal(plaintext, &rec); err != nil {
+			return fmt.Errorf("agent: invalid keyring file %s: %v", r.path, err)
+		}
+		plaintext = rec.Rest
+
+		key, err := parseAddedKey(rec.Request)
+		if err != nil {
+			return err
+		}
+		p, err := newPrivKey(*key)
+		if err != nil {
+			return err
+		}
+		p.request = rec.Request
+		if rec.Expire != 0 {
+			t := time.Unix(int64(rec.Expire), 0)
+			if now.After(t) {
+				continue
+			}
+			p.expire = &t
+		}
+		r.keys = append(r.keys, p)
+	}
+	return nil
+}
+
+// saveLocked writes the keys that have not expired to the file. The
+// caller must be holding the keyring mutex.
+func (r *fileKeyring) saveLocked() error {
+	now := time.Now()
+	var plaintext []byte
+	for _, k := range r.keys {
+		rec := keyringRecord{Request: k.request}
+		if k.expire != nil {
+			if now.After(*k.expire) {
+				continue
+			}
+			rec.Expire = uint64(k.expire.Unix())
+		}
+		plaintext = append(plaintext, ssh.Marshal(&rec)...)
+	}
+
+	aead, err := chacha20poly1305.NewX(r.key)
+	if err != nil {
+		return err
+	}
+	h := r.header
+	h.Nonce = make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := io.ReadFull(rand.Reader, h.Nonce); err != nil {
+		return err
+	}
+	data := ssh.Marshal(&h)
+	data = append(data, ssh.Marshal(struct {
+		Ciphertext []byte
+	}{aead.Seal(nil, h.Nonce, plaintext, data)})...)
+
+	// Replace the file atomically, so that a crash does not lose the
+	// keys: the new file is synced before it is renamed, and the
+	// directory after. TempFile creates the file with mode 0600.
+	dir := filepath.Dir(r.path)
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(r.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), r.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir commits the entries of the directory to stable storage.
+// Windows does not support syncing directories, and does not need it
+// for renames to be durable.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// Add adds a private key to the keyring and stores it. Its lifetime
+// is stored as the time at which it expires.
+func (r *fileKeyring) Add(key AddedKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.locked {
+		return errLocked
+	}
+	p, err := newPrivKey(key)
+	if err != nil {
+		return err
+	}
+	stored := key
+	stored.LifetimeSecs = 0
+	if p.request, err = addedKeyRequest(stored, marshalConstraints(stored)); err != nil {
+		return err
+	}
+
+	r.keys = append(r.keys, p)
+	if err := r.saveLocked(); err != nil {
+		r.keys = r.keys[:len(r.keys)-1]
+		return err
+	}
+	return nil
+}
+
+// Remove removes all identities with the given public key from the
+// keyring and the file.
+func (r *fileKeyring) Remove(key ssh.PublicKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.locked {
+		return errLocked
+	}
+
+	keys := append([]privKey(nil), r.keys...)
+	if err := r.removeLocked(key.Marshal()); err != nil {
+		return err
+	}
+	if err := r.saveLocked(); err != nil {
+		r.keys = keys
+		return err
+	}
+	return nil
+}
+
+// RemoveAll removes all identities from the keyring and the file.
+func (r *fileKeyring) RemoveAll() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.locked {
+		return errLocked
+	}
+
+	keys := r.keys
+	r.keys = nil
+	if err := r.saveLocked(); err != nil {
+		r.keys = keys
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package agent
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestFileKeyring(t *testing.T, path string, passphrase []byte) Agent {
+	t.Helper()
+	params := keyringKDFParams
+	keyringKDFParams.time, keyringKDFParams.memory, keyringKDFParams.threads = 1, 64, 1
+	defer func() { keyringKDFParams = params }()
+
+	k, err := NewFileKeyring(path, passphrase)
+	if err != nil {
+		t.Fatalf("NewFileKeyring: %v", err)
+	}
+	return k
+}
+
+func TestFileKeyringPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring")
+	passphrase := []byte("correct horse")
+
+	k := newTestFileKeyring(t, path, passphrase)
+	keyNames := []string{"dsa", "ecdsa", "rsa", "ed25519"}
+	for _, keyName := range keyNames {
+		addTestKey(t, k, keyName)
+	}
+	if err := k.Add(AddedKey{
+		PrivateKey:       testPrivateKeys["user"],
+		Comment:          "user",
+		ConfirmBeforeUse: true,
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("keyring file has mode %v, want 0600", fi.Mode().Perm())
+	}
+
+	k = newTestFileKeyring(t, path, passphrase)
+	validateListedKeys(t, k, append(keyNames, "user"))
+	data := []byte("data")
+	for _, keyName := range keyNames {
+		sig, err := k.Sign(testPublicKeys[keyName], data)
+		if err != nil {
+			t.Fatalf("Sign(%s): %v", keyName, err)
+		}
+		if err := testPublicKeys[keyName].Verify(data, sig); err != nil {
+			t.Errorf("Verify(%s): %v", keyName, err)
+		}
+	}
+	fk := k.(*fileKeyring)
+	stored, err := parseAddedKey(fk.keys[len(fk.keys)-1].request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.ConfirmBeforeUse {
+		t.Error("the confirmation constraint was not stored")
+	}
+
+	removeTestKey(t, k, "ecdsa")
+	k = newTestFileKeyring(t, path, passphrase)
+	validateListedKeys(t, k, []string{"dsa", "rsa", "ed25519", "user"})
+
+	if err := k.RemoveAll(); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	k = newTestFileKeyring(t, path, passphrase)
+	validateListedKeys(t, k, []string{})
+
+	if err := k.Lock(passphrase); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if err := k.Add(AddedKey{PrivateKey: testPrivateKeys["rsa"]}); err != errLocked {
+		t.Errorf("Add on a locked keyring: got %v, want %v", err, errLocked)
+	}
+}
+
+func TestFileKeyringExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring")
+	passphrase := []byte("passphrase")
+
+	k := newTestFileKeyring(t, path, passphrase)
+	addTestKey(t, k, "rsa")
+	if err := k.Add(AddedKey{
+		PrivateKey:   testPrivateKeys["ecdsa"],
+		Comment:      "ecdsa",
+		LifetimeSecs: 2,
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	// The lifetime runs from the time the key was added, not from
+	// the time the file is loaded.
+	k = newTestFileKeyring(t, path, passphrase)
+	validateListedKeys(t, k, []string{"rsa", "ecdsa"})
+	time.Sleep(2100 * time.Millisecond)
+	k = newTestFileKeyring(t, path, passphrase)
+	if n := len(k.(*fileKeyring).keys); n != 1 {
+		t.Errorf("got %d keys after loading, want the expired key dropped", n)
+	}
+	validateListedKeys(t, k, []string{"rsa"})
+}
+
+func TestFileKeyringPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keyring")
+	k := newTestFileKeyring(t, path, []byte("right"))
+	addTestKey(t, k, "rsa")
+
+	if _, err := NewFileKeyring(path, []byte("wrong")); err != ErrIncorrectPassphrase {
+		t.Errorf("NewFileKeyring with the wrong passphrase: got %v, want %v", err, ErrIncorrectPassphrase)
+	}
+
+	// The header is authenticated too.
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{len(data) - 1, len(data) / 2, 45} {
+		tampered := append([]byte(nil), data...)
+		tampered[i] ^= 1
+		tamperedPath := filepath.Join(dir, "tampered")
+		if err := ioutil.WriteFile(tamperedPath, tampered, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewFileKeyring(tamperedPath, []byte("right")); err == nil {
+			t.Errorf("NewFileKeyring accepted a file modified at byte %d", i)
+		}
+	}
+
+	junk := make([]byte, 100)
+	rand.Read(junk)
+	junkPath := filepath.Join(dir, "junk")
+	if err := ioutil.WriteFile(junkPath, junk, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileKeyring(junkPath, []byte("right")); err == nil {
+		t.Error("NewFileKeyring accepted a file that is not a keyring")
+	}
+
+	k = newTestFileKeyring(t, path, []byte("right"))
+	validateListedKeys(t, k, []string{"rsa"})
+}
+
+func TestFileKeyringCostlyParameters(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []keyringFile{
+		{Time: 1, Memory: 1<<32 - 1},
+		{Time: 1<<32 - 1, Memory: 64},
+		{Time: 16, Memory: maxKeyringKDFMemory},
+	} {
+		f.Magic, f.Version, f.KDF, f.Threads = keyringFileMagic, keyringFileVersion, keyringKDFArgon2id, 1
+		f.Salt, f.Nonce = make([]byte, 16), make([]byte, chacha20poly1305.NonceSizeX)
+		path := filepath.Join(dir, "costly")
+		if err := ioutil.WriteFile(path, ssh.Marshal(&f), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewFileKeyring(path, []byte("right")); err == nil || err == ErrIncorrectPassphrase {
+			t.Errorf("time %d and memory %d: got %v, want the parameters to be refused", f.Time, f.Memory, err)
+		}
+	}
+}
@@ -17,7 +17,7 @@ import (
 // ForwardToAgent or ForwardToRemote should be called to route
 // the authentication requests.
 func RequestAgentForwarding(session *ssh.Session) error {
-	ok, err := session.SendRequest("auth-agent-req@openssh.com", true, nil)
+	ok, err := session.SendRequest(requestType, true, nil)
 	if err != nil {
 		return err
 	}
@@ -50,7 +50,32 @@ func ForwardToAgent(client *ssh.Client, keyring Agent) error {
 	return nil
 }
 
-const channelType = "auth-agent@openssh.com"
+const (
+	channelType = "auth-agent@openssh.com"
+	requestType = "auth-agent-req@openssh.com"
+)
+
+// IsAgentForwardingRequest reports whether req is the session request
+// that a client sends with RequestAgentForwarding. A server that wants
+// to offer agent forwarding to the session should reply true to it,
+// and can then reach the client's agent with OpenForwardedAgent.
+func IsAgentForwardingRequest(req *ssh.Request) bool {
+	return req.Type == requestType
+}
+
+// OpenForwardedAgent opens a channel to the agent that the client on
+// conn forwards with ForwardToAgent or ForwardToRemote. It is meant
+// for servers, once a session on conn requested agent forwarding (see
+// IsAgentForwardingRequest). Use NewClient on the returned channel to
+// talk to the agent, and close the channel when done.
+func OpenForwardedAgent(conn ssh.Conn) (ssh.Channel, error) {
+	ch, reqs, err := conn.OpenChannel(channelType, nil)
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+	return ch, nil
+}
 
 // ForwardToRemote routes authentication requests to the ssh-agent
 // process serving on the given unix socket.
@@ -20,6 +20,10 @@ type privKey struct {
 	signer  ssh.Signer
 	comment string
 	expire  *time.Time
+
+	// request is the request that adds the key, without its lifetime,
+	// for keyrings that store their keys.
+	request []byte
 }
 
 type keyring struct {
@@ -152,16 +156,28 @@ func (r *keyring) Add(key AddedKey) error {
 	if r.locked {
 		return errLocked
 	}
+	p, err := newPrivKey(key)
+	if err != nil {
+		return err
+	}
+
+	r.keys = append(r.keys, p)
+
+	return nil
+}
+
+// newPrivKey returns the keyring entry of key.
+func newPrivKey(key AddedKey) (privKey, error) {
 	signer, err := ssh.NewSignerFromKey(key.PrivateKey)
 
 	if err != nil {
-		return err
+		return privKey{}, err
 	}
 
 	if cert := key.Certificate; cert != nil {
 		signer, err = ssh.NewCertSigner(cert, signer)
 		if err != nil {
-			return err
+			return privKey{}, err
 		}
 	}
 
@@ -174,10 +190,7 @@ func (r *keyring) Add(key AddedKey) error {
 		t := time.Now().Add(time.Duration(key.LifetimeSecs) * time.Second)
 		p.expire = &t
 	}
-
-	r.keys = append(r.keys, p)
-
-	return nil
+	return p, nil
 }
 
 // Sign returns a signature for the data.
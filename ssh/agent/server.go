@@ -491,13 +491,22 @@ func parseECDSACert(req []byte) (*AddedKey, error) {
 }
 
 func (s *server) insertIdentity(req []byte) error {
+	addedKey, err := parseAddedKey(req)
+	if err != nil {
+		return err
+	}
+	return s.agent.Add(*addedKey)
+}
+
+// parseAddedKey parses a request adding a key to the agent.
+func parseAddedKey(req []byte) (*AddedKey, error) {
 	var record struct {
 		Type string `sshtype:"17|25"`
 		Rest []byte `ssh:"rest"`
 	}
 
 	if err := ssh.Unmarshal(req, &record); err != nil {
-		return err
+		return nil, err
 	}
 
 	var addedKey *AddedKey
@@ -521,13 +530,9 @@ func (s *server) insertIdentity(req []byte) error {
 	case ssh.CertAlgoED25519v01:
 		addedKey, err = parseEd25519Cert(req)
 	default:
-		return fmt.Errorf("agent: not implemented: %q", record.Type)
-	}
-
-	if err != nil {
-		return err
+		return nil, fmt.Errorf("agent: not implemented: %q", record.Type)
 	}
-	return s.agent.Add(*addedKey)
+	return addedKey, err
 }
 
 // ServeAgent serves the agent protocol on the given connection. It
@@ -5,6 +5,7 @@
 package agent
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/rand"
 	"fmt"
@@ -84,6 +85,100 @@ func TestSetupForwardAgent(t *testing.T) {
 	conn.Close()
 }
 
+func TestServerSideAgentForwarding(t *testing.T) {
+	a, b, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer a.Close()
+	defer b.Close()
+
+	keyring := NewKeyring()
+	if err := keyring.Add(AddedKey{PrivateKey: testPrivateKeys["rsa"]}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	serverConf := ssh.ServerConfig{
+		NoClientAuth: true,
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+
+	// The server accepts one session, honors its agent forwarding
+	// request, and reports the keys of the forwarded agent.
+	keys := make(chan []*Key, 1)
+	go func() {
+		defer close(keys)
+		conn, chans, reqs, err := ssh.NewServerConn(a, &serverConf)
+		if err != nil {
+			t.Errorf("NewServerConn: %v", err)
+			return
+		}
+		defer conn.Close()
+		go ssh.DiscardRequests(reqs)
+
+		newCh := <-chans
+		if newCh == nil {
+			t.Error("connection closed before a session was opened")
+			return
+		}
+		session, sessionReqs, err := newCh.Accept()
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			return
+		}
+		defer session.Close()
+
+		for req := range sessionReqs {
+			if !IsAgentForwardingRequest(req) {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+
+			ch, err := OpenForwardedAgent(conn)
+			if err != nil {
+				t.Errorf("OpenForwardedAgent: %v", err)
+				return
+			}
+			defer ch.Close()
+			list, err := NewClient(ch).List()
+			if err != nil {
+				t.Errorf("List: %v", err)
+				return
+			}
+			keys <- list
+			return
+		}
+	}()
+
+	conf := ssh.ClientConfig{
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	conn, chans, reqs, err := ssh.NewClientConn(b, "", &conf)
+	if err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	client := ssh.NewClient(conn, chans, reqs)
+	defer client.Close()
+
+	if err := ForwardToAgent(client, keyring); err != nil {
+		t.Fatalf("ForwardToAgent: %v", err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+	if err := RequestAgentForwarding(session); err != nil {
+		t.Fatalf("RequestAgentForwarding: %v", err)
+	}
+
+	list := <-keys
+	if len(list) != 1 || !bytes.Equal(list[0].Marshal(), testPublicKeys["rsa"].Marshal()) {
+		t.Fatalf("forwarded agent lists %v, want the rsa test key", list)
+	}
+}
+
 func TestV1ProtocolMessages(t *testing.T) {
 	c1, c2, err := netPipe()
 	if err != nil {
@@ -0,0 +1,134 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseAlgorithmSpec applies spec, an algorithm list as written for the
+// Ciphers, KexAlgorithms, MACs and HostKeyAlgorithms options of
+// ssh_config(5), to defaults and returns the resulting list, for
+// example to set Config.Ciphers:
+//
+//	aes128-ctr,aes256-ctr  the listed algorithms replace defaults
+//	+aes128-cbc            the listed algorithms are appended to defaults
+//	-*-cbc,hmac-sha1       the matching algorithms are removed from defaults
+//	^chacha20-*            the listed algorithms are placed before defaults
+//
+// The names may be patterns, in which '*' matches any run of
+// characters and '?' any single character. A pattern that is added
+// stands for the matching algorithms of defaults and of supported, in
+// that order, and for none if there is no match. Like OpenSSH, which
+// matches the algorithms of the option's own kind, supported should
+// only hold algorithms of the kind of defaults, such as all the ciphers
+// the caller allows. Names without wildcards are kept as given, even
+// if this package does not implement them; the Config fields ignore
+// those. Duplicates are dropped. An empty spec returns a copy of
+// defaults.
+func ParseAlgorithmSpec(defaults, supported []string, spec string) ([]string, error) {
+	if spec == "" {
+		return append([]string(nil), defaults...), nil
+	}
+	op, list := byte(0), spec
+	switch spec[0] {
+	case '+', '-', '^':
+		op, list = spec[0], spec[1:]
+	}
+	patterns := strings.Split(list, ",")
+	for _, p := range patterns {
+		if p == "" {
+			return nil, fmt.Errorf("ssh: empty algorithm name in %q", spec)
+		}
+		if strings.HasPrefix(p, "!") {
+			return nil, fmt.Errorf("ssh: negated algorithm pattern %q is not supported", p)
+		}
+	}
+
+	var result []string
+	switch op {
+	case '-':
+		for _, name := range defaults {
+			if !matchAlgorithmPatterns(patterns, name) {
+				result = append(result, name)
+			}
+		}
+	case '+':
+		result = expandAlgorithmPatterns(defaults, supported, append(append([]string(nil), defaults...), patterns...))
+	case '^':
+		result = expandAlgorithmPatterns(defaults, supported, append(patterns, defaults...))
+	default:
+		result = expandAlgorithmPatterns(defaults, supported, patterns)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("ssh: algorithm spec %q leaves no algorithms", spec)
+	}
+	return result, nil
+}
+
+// expandAlgorithmPatterns replaces the patterns of names by the
+// algorithms of defaults, then of supported, that they match, and
+// drops the duplicates.
+func expandAlgorithmPatterns(defaults, supported, names []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	for _, name := range names {
+		if !strings.ContainsAny(name, "*?") {
+			add(name)
+			continue
+		}
+		for _, candidates := range [][]string{defaults, supported} {
+			for _, c := range candidates {
+				if matchAlgorithmPattern(name, c) {
+					add(c)
+				}
+			}
+		}
+	}
+	return result
+}
+
+func matchAlgorithmPatterns(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if matchAlgorithmPattern(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAlgorithmPattern reports whether name matches pattern, where
+// '*' matches any run of characters and '?' any single one, as in
+// match_pattern of OpenSSH.
+func matchAlgorithmPattern(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(name); i >= 0; i-- {
+				if matchAlgorithmPattern(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(name) == 0 {
+				return false
+			}
+		default:
+			if len(name) == 0 || name[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
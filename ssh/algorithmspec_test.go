@@ -0,0 +1,85 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAlgorithmSpec(t *testing.T) {
+	defaults := []string{chacha20Poly1305ID, "aes128-ctr", "aes256-ctr", gcmCipherID}
+	for _, tt := range []struct {
+		spec string
+		want []string
+	}{
+		{"", defaults},
+		// Replacing.
+		{"aes256-ctr,aes128-ctr", []string{"aes256-ctr", "aes128-ctr"}},
+		{"aes*-ctr,aes128-ctr", []string{"aes128-ctr", "aes256-ctr", "aes192-ctr"}},
+		{"unknown@example.com", []string{"unknown@example.com"}},
+		// Appending.
+		{"+aes128-cbc", []string{chacha20Poly1305ID, "aes128-ctr", "aes256-ctr", gcmCipherID, "aes128-cbc"}},
+		{"+aes128-ctr,3des-cbc", []string{chacha20Poly1305ID, "aes128-ctr", "aes256-ctr", gcmCipherID, "3des-cbc"}},
+		{"+*-cbc", []string{chacha20Poly1305ID, "aes128-ctr", "aes256-ctr", gcmCipherID, "aes128-cbc", "3des-cbc"}},
+		// Removing.
+		{"-aes128-ctr", []string{chacha20Poly1305ID, "aes256-ctr", gcmCipherID}},
+		{"-aes*,3des-cbc", []string{chacha20Poly1305ID}},
+		{"-aes???-ctr", []string{chacha20Poly1305ID, gcmCipherID}},
+		{"-*@openssh.com", []string{"aes128-ctr", "aes256-ctr"}},
+		// Prepending.
+		{"^aes256-ctr", []string{"aes256-ctr", chacha20Poly1305ID, "aes128-ctr", gcmCipherID}},
+		{"^aes128-cbc,aes*-gcm*", []string{"aes128-cbc", gcmCipherID, chacha20Poly1305ID, "aes128-ctr", "aes256-ctr"}},
+	} {
+		got, err := ParseAlgorithmSpec(defaults, supportedCiphers, tt.spec)
+		if err != nil {
+			t.Errorf("ParseAlgorithmSpec(%q): %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseAlgorithmSpec(%q) = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"+", "aes128-ctr,,aes256-ctr", "-aes128-ctr,", "-*", "-!aes128-ctr", "nothing-matches-*"} {
+		if got, err := ParseAlgorithmSpec(defaults, supportedCiphers, spec); err == nil {
+			t.Errorf("ParseAlgorithmSpec(%q) = %q, want an error", spec, got)
+		}
+	}
+
+	// Wildcards only match the supported algorithms, of one kind, and
+	// not the MACs, host key algorithms or compressions that share
+	// the suffix.
+	got, err := ParseAlgorithmSpec([]string{"aes128-ctr"}, supportedCiphers, "+*@openssh.com")
+	if want := []string{"aes128-ctr", gcmCipherID, chacha20Poly1305ID}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseAlgorithmSpec(+*@openssh.com) = %q, %v, want %q", got, err, want)
+	}
+	got, err = ParseAlgorithmSpec([]string{"aes128-ctr"}, nil, "hmac-*")
+	if err == nil {
+		t.Errorf("ParseAlgorithmSpec(hmac-*) without supported algorithms = %q, want an error", got)
+	}
+}
+
+func TestMatchAlgorithmPattern(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"aes128-ctr", "aes128-ctr", true},
+		{"aes128-ctr", "aes128-ctrx", false},
+		{"*", "", true},
+		{"aes*", "aes", true},
+		{"*-etm@openssh.com", "hmac-sha2-256-etm@openssh.com", true},
+		{"*-etm@openssh.com", "hmac-sha2-256", false},
+		{"a*b*c", "axxbyybzzc", true},
+		{"a*b*c", "axxbyybzz", false},
+		{"aes???-ctr", "aes128-ctr", true},
+		{"?", "", false},
+	} {
+		if got := matchAlgorithmPattern(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchAlgorithmPattern(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
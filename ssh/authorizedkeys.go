@@ -0,0 +1,299 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AuthorizedKeyOptions holds the options of an entry in an OpenSSH
+// authorized_keys file, as described in the sshd(8) manual page. Marshal
+// formats them for the beginning of an entry, and
+// ParseAuthorizedKeyOptions reads back the options returned by
+// ParseAuthorizedKey.
+type AuthorizedKeyOptions struct {
+	// Restrict is the restrict option, which disables all
+	// forwarding, the allocation of a pty and ~/.ssh/rc.
+	Restrict bool
+
+	// CertAuthority marks the key as a certificate authority.
+	CertAuthority bool
+
+	NoAgentForwarding bool
+	NoPortForwarding  bool
+	NoPTY             bool
+	NoUserRC          bool
+	NoX11Forwarding   bool
+
+	// Command, if non-empty, is run instead of the command requested
+	// by the client.
+	Command string
+
+	// From, if non-empty, holds the patterns that the client's host
+	// name or address must match.
+	From []string
+
+	// Environment holds variables, in the form "NAME=value", that are
+	// set for the session.
+	Environment []string
+
+	// PermitOpen holds the "host:port" destinations that local port
+	// forwarding is limited to.
+	PermitOpen []string
+
+	// PermitListen holds the "[host:]port" addresses that remote port
+	// forwarding is limited to.
+	PermitListen []string
+
+	// Principals, for a certificate authority, holds the principals
+	// that certificates must be issued for.
+	Principals []string
+
+	// ExpiryTime, if non-empty, is the time, in the
+	// YYYYMMDD[HHMM[SS]][Z] format of sshd, after which the key is
+	// not accepted. See AuthorizedKeyExpired.
+	ExpiryTime string
+
+	// Extra holds the other options, verbatim, such as
+	// "no-touch-required" or `tunnel="1"`.
+	Extra []string
+}
+
+// flags returns the names of the options without a value, as written
+// by Marshal, with their fields.
+func (o *AuthorizedKeyOptions) flags() []struct {
+	name string
+	set  *bool
+} {
+	return []struct {
+		name string
+		set  *bool
+	}{
+		{"restrict", &o.Restrict},
+		{"cert-authority", &o.CertAuthority},
+		{"no-agent-forwarding", &o.NoAgentForwarding},
+		{"no-port-forwarding", &o.NoPortForwarding},
+		{"no-pty", &o.NoPTY},
+		{"no-user-rc", &o.NoUserRC},
+		{"no-X11-forwarding", &o.NoX11Forwarding},
+	}
+}
+
+// quoteAuthorizedKeyOption returns the option name with value as a
+// quoted string. sshd only unescapes \", so that is the only escape
+// written. Values with a line break, which would start another entry,
+// or ending in a backslash, which would escape the closing quote,
+// cannot be represented.
+func quoteAuthorizedKeyOption(name, value string) (string, error) {
+	if strings.ContainsAny(value, "\r\n") {
+		return "", fmt.Errorf("ssh: authorized_keys option %q has a line break", name)
+	}
+	if strings.HasSuffix(value, `\`) {
+		return "", fmt.Errorf("ssh: authorized_keys option %q ends in a backslash", name)
+	}
+	return name + `="` + strings.Replace(value, `"`, `\"`, -1) + `"`, nil
+}
+
+// Marshal returns the options in the format of authorized_keys, as a
+// comma separated list without spaces outside of quotes, to be followed
+// by a space and the output of MarshalAuthorizedKey. It returns an empty
+// string if no option is set, and an error if a value cannot be
+// represented, or an Extra option is not a single well-formed option.
+func (o *AuthorizedKeyOptions) Marshal() (string, error) {
+	var opts []string
+	for _, f := range o.flags() {
+		if *f.set {
+			opts = append(opts, f.name)
+		}
+	}
+	var values [][2]string
+	if o.Command != "" {
+		values = append(values, [2]string{"command", o.Command})
+	}
+	if len(o.From) > 0 {
+		values = append(values, [2]string{"from", strings.Join(o.From, ",")})
+	}
+	for _, env := range o.Environment {
+		values = append(values, [2]string{"environment", env})
+	}
+	for _, dest := range o.PermitOpen {
+		values = append(values, [2]string{"permitopen", dest})
+	}
+	for _, addr := range o.PermitListen {
+		values = append(values, [2]string{"permitlisten", addr})
+	}
+	if len(o.Principals) > 0 {
+		values = append(values, [2]string{"principals", strings.Join(o.Principals, ",")})
+	}
+	if o.ExpiryTime != "" {
+		values = append(values, [2]string{"expiry-time", o.ExpiryTime})
+	}
+	for _, v := range values {
+		opt, err := quoteAuthorizedKeyOption(v[0], v[1])
+		if err != nil {
+			return "", err
+		}
+		opts = append(opts, opt)
+	}
+	for _, extra := range o.Extra {
+		if strings.ContainsAny(extra, "\r\n") {
+			return "", fmt.Errorf("ssh: authorized_keys option %q has a line break", extra)
+		}
+		if split, err := parseAuthorizedOptions(extra); err != nil || len(split) != 1 {
+			return "", fmt.Errorf("ssh: malformed authorized_keys option %q", extra)
+		}
+		opts = append(opts, extra)
+	}
+	return strings.Join(opts, ","), nil
+}
+
+// ParseAuthorizedKeyOptions interprets the options returned by
+// ParseAuthorizedKey. Option names are matched without regard to case,
+// as sshd does, and options that are not fields of AuthorizedKeyOptions
+// are kept in Extra. An error is returned if a known option has a
+// missing, extra or badly quoted value.
+func ParseAuthorizedKeyOptions(options []string) (*AuthorizedKeyOptions, error) {
+	o := new(AuthorizedKeyOptions)
+	flags := o.flags()
+Options:
+	for _, opt := range options {
+		name, value, hasValue := opt, "", false
+		if i := strings.IndexByte(opt, '='); i >= 0 {
+			name, value, hasValue = opt[:i], opt[i+1:], true
+		}
+		for _, f := range flags {
+			if strings.EqualFold(name, f.name) {
+				if hasValue {
+					return nil, fmt.Errorf("ssh: authorized_keys option %q does not take a value", name)
+				}
+				*f.set = true
+				continue Options
+			}
+		}
+
+		var list *[]string
+		lower := strings.ToLower(name)
+		switch lower {
+		case "command", "from", "expiry-time", "principals":
+		case "environment":
+			list = &o.Environment
+		case "permitopen":
+			list = &o.PermitOpen
+		case "permitlisten":
+			list = &o.PermitListen
+		default:
+			o.Extra = append(o.Extra, opt)
+			continue
+		}
+
+		if !hasValue {
+			return nil, fmt.Errorf("ssh: authorized_keys option %q requires a value", name)
+		}
+		value, err := unquoteAuthorizedKeyOption(value)
+		if err != nil {
+			return nil, fmt.Errorf("ssh: authorized_keys option %q: %v", name, err)
+		}
+		switch lower {
+		case "command":
+			o.Command = value
+		case "from":
+			o.From = strings.Split(value, ",")
+		case "expiry-time":
+			o.ExpiryTime = value
+		case "principals":
+			o.Principals = strings.Split(value, ",")
+		default:
+			*list = append(*list, value)
+		}
+	}
+	return o, nil
+}
+
+// unquoteAuthorizedKeyOption returns the contents of the quoted string
+// s, with \" unescaped.
+func unquoteAuthorizedKeyOption(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", errors.New("value is not quoted")
+	}
+	s = s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && s[i+1] == '"' {
+			i++
+		} else if s[i] == '"' {
+			return "", errors.New("unescaped quote in value")
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), nil
+}
+
+// AuthorizedKeyExpired reports whether the key of an authorized_keys
+// entry with the given options, as returned by ParseAuthorizedKey, has
+// expired at now according to its expiry-time option. Like sshd, it
+// accepts a date, YYYYMMDD, or a time, YYYYMMDDHHMM[SS], in the local
+// time zone, or in UTC if followed by "Z". A key without the option
+// never expires, and of several options, the earliest time applies. An
+// error is returned if an expiry-time option is malformed.
+func AuthorizedKeyExpired(options []string, now time.Time) (bool, error) {
+	expired := false
+	for _, opt := range options {
+		i := strings.IndexByte(opt, '=')
+		if i < 0 {
+			if strings.EqualFold(opt, "expiry-time") {
+				return false, errors.New(`ssh: authorized_keys option "expiry-time" requires a value`)
+			}
+			continue
+		}
+		if !strings.EqualFold(opt[:i], "expiry-time") {
+			continue
+		}
+		value, err := unquoteAuthorizedKeyOption(opt[i+1:])
+		if err != nil {
+			return false, fmt.Errorf("ssh: authorized_keys option %q: %v", opt[:i], err)
+		}
+		expiry, err := parseExpiryTime(value)
+		if err != nil {
+			return false, err
+		}
+		if now.After(expiry) {
+			expired = true
+		}
+	}
+	return expired, nil
+}
+
+// parseExpiryTime parses the time of an expiry-time option.
+func parseExpiryTime(s string) (time.Time, error) {
+	loc := time.Local
+	value := s
+	if strings.HasSuffix(value, "Z") {
+		loc, value = time.UTC, value[:len(value)-1]
+	}
+	var layout string
+	switch len(value) {
+	case len("YYYYMMDD"):
+		layout = "20060102"
+	case len("YYYYMMDDHHMM"):
+		layout = "200601021504"
+	case len("YYYYMMDDHHMMSS"):
+		layout = "20060102150405"
+	default:
+		return time.Time{}, fmt.Errorf("ssh: invalid expiry-time %q", s)
+	}
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return time.Time{}, fmt.Errorf("ssh: invalid expiry-time %q", s)
+		}
+	}
+	t, err := time.ParseInLocation(layout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ssh: invalid expiry-time %q: %v", s, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthorizedKeyOptionsRoundTrip(t *testing.T) {
+	pub := testPublicKeys["ed25519"]
+	for _, opts := range []AuthorizedKeyOptions{
+		{},
+		{NoPTY: true, NoPortForwarding: true},
+		{Command: "echo hello world"},
+		{Command: `echo "it's quoted" && printf '%s\n' "a b"`},
+		{Command: `sh -c "echo \"nested\""`},
+		{
+			Restrict:     true,
+			Command:      "/usr/bin/rsync --server -e.LsfxC . /backup",
+			From:         []string{"10.0.0.0/8", "!10.1.2.3", "*.example.com"},
+			Environment:  []string{"LANG=C", `GREETING=say "hi" there`},
+			PermitOpen:   []string{"localhost:8080", "db.internal:5432"},
+			PermitListen: []string{"localhost:2222"},
+			ExpiryTime:   "20301231",
+			Extra:        []string{"no-touch-required", `tunnel="1"`},
+		},
+		{CertAuthority: true, Principals: []string{"alice", "bob"}, NoX11Forwarding: true, NoAgentForwarding: true, NoUserRC: true},
+	} {
+		line := string(MarshalAuthorizedKey(pub))
+		marshaled, err := opts.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal(%#v): %v", opts, err)
+		}
+		if marshaled != "" {
+			line = marshaled + " " + line
+		}
+
+		out, _, options, _, err := ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			t.Fatalf("ParseAuthorizedKey(%q): %v", line, err)
+		}
+		if !reflect.DeepEqual(out, pub) {
+			t.Errorf("%q: got key %v, want %v", line, out, pub)
+		}
+		got, err := ParseAuthorizedKeyOptions(options)
+		if err != nil {
+			t.Fatalf("ParseAuthorizedKeyOptions(%q): %v", options, err)
+		}
+		if !reflect.DeepEqual(*got, opts) {
+			t.Errorf("%q: got options %#v, want %#v", line, *got, opts)
+		}
+	}
+}
+
+func TestAuthorizedKeyOptionsMarshal(t *testing.T) {
+	opts := AuthorizedKeyOptions{
+		NoPortForwarding: true,
+		Command:          `echo "hi there"`,
+		From:             []string{"192.0.2.1", "*.example.com"},
+	}
+	want := `no-port-forwarding,command="echo \"hi there\"",from="192.0.2.1,*.example.com"`
+	if got, err := opts.Marshal(); err != nil || got != want {
+		t.Errorf("got %s, %v, want %s", got, err, want)
+	}
+}
+
+func TestAuthorizedKeyOptionsMarshalInvalid(t *testing.T) {
+	for _, opts := range []AuthorizedKeyOptions{
+		{Command: "true\nssh-ed25519 AAAA attacker"},
+		{Command: "true\r"},
+		{Environment: []string{`PATH=C:\`}},
+		{From: []string{"192.0.2.1\n"}},
+		{Extra: []string{"no-pty\nssh-ed25519"}},
+		{Extra: []string{"no-pty,no-X11-forwarding"}},
+		{Extra: []string{`tunnel="1`}},
+		{Extra: []string{"no-pty ssh-ed25519"}},
+		{Extra: []string{""}},
+	} {
+		if got, err := opts.Marshal(); err == nil {
+			t.Errorf("Marshal(%#v) = %q, want an error", opts, got)
+		}
+	}
+}
+
+func TestParseAuthorizedKeyOptions(t *testing.T) {
+	got, err := ParseAuthorizedKeyOptions([]string{"No-Pty", `COMMAND="ls -l"`, "no-X11-forwarding", "agent-forwarding"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &AuthorizedKeyOptions{NoPTY: true, Command: "ls -l", NoX11Forwarding: true, Extra: []string{"agent-forwarding"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	for _, bad := range []string{"command", `command=ls`, `command="ls`, `command="a"b"`, `no-pty="yes"`} {
+		if _, err := ParseAuthorizedKeyOptions([]string{bad}); err == nil || !strings.Contains(err.Error(), "authorized_keys option") {
+			t.Errorf("%s: got error %v, want an authorized_keys option error", bad, err)
+		}
+	}
+}
+
+func TestAuthorizedKeyExpired(t *testing.T) {
+	now := time.Date(2030, 6, 15, 12, 30, 45, 0, time.UTC)
+	for _, tt := range []struct {
+		options []string
+		want    bool
+	}{
+		{nil, false},
+		{[]string{"no-pty", `command="ls"`}, false},
+		{[]string{`expiry-time="20300615Z"`}, true},
+		{[]string{`expiry-time="20300616Z"`}, false},
+		{[]string{`expiry-time="203006151230Z"`}, true},
+		{[]string{`expiry-time="203006151231Z"`}, false},
+		{[]string{`expiry-time="20300615123045Z"`}, false},
+		{[]string{`expiry-time="20300615123044Z"`}, true},
+		{[]string{`EXPIRY-TIME="20290101Z"`}, true},
+		{[]string{`expiry-time="20400101Z"`, `expiry-time="20200101Z"`}, true},
+		{[]string{"no-pty", `expiry-time="20400101Z"`}, false},
+	} {
+		got, err := AuthorizedKeyExpired(tt.options, now)
+		if err != nil {
+			t.Errorf("AuthorizedKeyExpired(%q): %v", tt.options, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("AuthorizedKeyExpired(%q) = %t, want %t", tt.options, got, tt.want)
+		}
+	}
+
+	// Without a "Z", the time is in the local time zone.
+	local := now.In(time.Local)
+	options := []string{`expiry-time="` + local.Format("200601021504") + `"`}
+	if expired, err := AuthorizedKeyExpired(options, local.Add(-time.Minute)); err != nil || expired {
+		t.Errorf("AuthorizedKeyExpired(%q) before expiry = %t, %v, want false", options, expired, err)
+	}
+	if expired, err := AuthorizedKeyExpired(options, local.Add(time.Minute)); err != nil || !expired {
+		t.Errorf("AuthorizedKeyExpired(%q) after expiry = %t, %v, want true", options, expired, err)
+	}
+
+	for _, bad := range []string{
+		"expiry-time",
+		"expiry-time=20300101",
+		`expiry-time=""`,
+		`expiry-time="2030"`,
+		`expiry-time="2030010"`,
+		`expiry-time="203001011"`,
+		`expiry-time="20301301"`,
+		`expiry-time="20300101 1200"`,
+		`expiry-time="+0300101"`,
+		`expiry-time="20300101ZZ"`,
+		`expiry-time="203001012500Z"`,
+	} {
+		if _, err := AuthorizedKeyExpired([]string{bad}, now); err == nil {
+			t.Errorf("AuthorizedKeyExpired(%q) succeeded", bad)
+		}
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"errors"
 	"io"
 	"net"
+	"sync"
 	"testing"
+	"time"
 )
 
 type server struct {
@@ -72,6 +74,7 @@ func sshPipe() (Conn, *server, error) {
 
 func BenchmarkEndToEnd(b *testing.B) {
 	b.StopTimer()
+	b.ReportAllocs()
 
 	client, server, err := sshPipe()
 	if err != nil {
@@ -124,3 +127,131 @@ func BenchmarkEndToEnd(b *testing.B) {
 
 	<-done
 }
+
+// BenchmarkHandshake measures complete handshakes, including key
+// exchange and authentication, over an in-memory connection. Run it
+// with -cpu 1 to get the handshake rate of a single core.
+func BenchmarkHandshake(b *testing.B) {
+	for _, kex := range []string{kexAlgoCurve25519SHA256, kexAlgoECDH256} {
+		b.Run(kex, func(b *testing.B) {
+			serverConf := &ServerConfig{NoClientAuth: true}
+			serverConf.AddHostKey(testSigners["ed25519"])
+			serverConf.KeyExchanges = []string{kex}
+			clientConf := &ClientConfig{
+				User:            "user",
+				HostKeyCallback: InsecureIgnoreHostKey(),
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				client, server, _, _, err := Pipe(serverConf, clientConf)
+				if err != nil {
+					b.Fatalf("Pipe: %v", err)
+				}
+				client.Close()
+				server.Close()
+			}
+			b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "handshakes/s")
+		})
+	}
+}
+
+// latencyConn delays the data written to a net.Conn by a fixed latency.
+type latencyConn struct {
+	net.Conn
+	latency   time.Duration
+	writes    chan latencyWrite
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type latencyWrite struct {
+	due  time.Time
+	data []byte
+}
+
+func newLatencyConn(c net.Conn, latency time.Duration) *latencyConn {
+	l := &latencyConn{
+		Conn:    c,
+		latency: latency,
+		writes:  make(chan latencyWrite, 1024),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case w := <-l.writes:
+				time.Sleep(time.Until(w.due))
+				if _, err := c.Write(w.data); err != nil {
+					return
+				}
+			case <-l.done:
+				return
+			}
+		}
+	}()
+	return l
+}
+
+func (l *latencyConn) Write(p []byte) (int, error) {
+	select {
+	case l.writes <- latencyWrite{time.Now().Add(l.latency), append([]byte(nil), p...)}:
+		return len(p), nil
+	case <-l.done:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (l *latencyConn) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Conn.Close()
+}
+
+// BenchmarkPipelineAuth reports the duration of a connection setup,
+// including a password authentication, in round trips of a link with a
+// one way latency of 5ms.
+func BenchmarkPipelineAuth(b *testing.B) {
+	const latency = 5 * time.Millisecond
+	serverConf := &ServerConfig{
+		PasswordCallback: func(conn ConnMetadata, password []byte) (*Permissions, error) {
+			return nil, nil
+		},
+	}
+	serverConf.AddHostKey(testSigners["ed25519"])
+	for _, pipeline := range []bool{false, true} {
+		name := "Serial"
+		if pipeline {
+			name = "Pipelined"
+		}
+		b.Run(name, func(b *testing.B) {
+			clientConf := &ClientConfig{
+				User:            "user",
+				Auth:            []AuthMethod{Password("password")},
+				SkipNoneAuth:    true,
+				HostKeyCallback: InsecureIgnoreHostKey(),
+				PipelineAuth:    pipeline,
+			}
+			var elapsed time.Duration
+			for i := 0; i < b.N; i++ {
+				c1, c2, err := netPipe()
+				if err != nil {
+					b.Fatalf("netPipe: %v", err)
+				}
+				go func() {
+					if conn, _, _, err := NewServerConn(newLatencyConn(c1, latency), serverConf); err == nil {
+						conn.Wait()
+					}
+				}()
+				start := time.Now()
+				conn, _, _, err := NewClientConn(newLatencyConn(c2, latency), "", clientConf)
+				if err != nil {
+					b.Fatalf("NewClientConn: %v", err)
+				}
+				elapsed += time.Since(start)
+				conn.Close()
+			}
+			b.ReportMetric(float64(elapsed)/float64(b.N)/float64(2*latency), "round-trips/op")
+		})
+	}
+}
@@ -6,7 +6,9 @@ package ssh
 
 import (
 	"io"
+	"os"
 	"sync"
+	"time"
 )
 
 // buffer provides a linked list buffer for data exchange
@@ -20,12 +22,19 @@ type buffer struct {
 	tail *element // the buffer that will be read last
 
 	closed bool
+
+	// deadline makes Read fail instead of waiting once it passed.
+	deadline condDeadline
 }
 
 // An element represents a single link in a linked list.
 type element struct {
 	buf  []byte
 	next *element
+
+	// packet, if set, is the packet buffer that buf is part of. It
+	// is returned to the packet buffer pools once buf is read.
+	packet []byte
 }
 
 // newBuffer returns an empty buffer that is not closed.
@@ -50,6 +59,18 @@ func (b *buffer) write(buf []byte) {
 	b.Cond.L.Unlock()
 }
 
+// writePacket is like write, for buf that is part of packet, a buffer
+// from getPacketBuffer. The packet is returned to the pools after buf
+// has been read.
+func (b *buffer) writePacket(buf, packet []byte) {
+	b.Cond.L.Lock()
+	e := &element{buf: buf, packet: packet}
+	b.tail.next = e
+	b.tail = e
+	b.Cond.Signal()
+	b.Cond.L.Unlock()
+}
+
 // eof closes the buffer. Reads from the buffer once all
 // the data has been consumed will receive io.EOF.
 func (b *buffer) eof() {
@@ -59,6 +80,14 @@ func (b *buffer) eof() {
 	b.Cond.L.Unlock()
 }
 
+// setDeadline makes Read fail with os.ErrDeadlineExceeded instead of
+// blocking once t has passed. The zero t means no deadline.
+func (b *buffer) setDeadline(t time.Time) {
+	b.Cond.L.Lock()
+	b.deadline.set(b.Cond, t)
+	b.Cond.L.Unlock()
+}
+
 // Read reads data from the internal buffer in buf.  Reads will block
 // if no data is available, or until the buffer is closed.
 func (b *buffer) Read(buf []byte) (n int, err error) {
@@ -71,6 +100,10 @@ func (b *buffer) Read(buf []byte) (n int, err error) {
 			r := copy(buf, b.head.buf)
 			buf, b.head.buf = buf[r:], b.head.buf[r:]
 			n += r
+			if len(b.head.buf) == 0 && b.head.packet != nil {
+				putPacketBuffer(b.head.packet)
+				b.head.packet = nil
+			}
 			continue
 		}
 		// if there is a next buffer, make it the head
@@ -90,6 +123,10 @@ func (b *buffer) Read(buf []byte) (n int, err error) {
 			err = io.EOF
 			break
 		}
+		if b.deadline.expired() {
+			err = os.ErrDeadlineExceeded
+			break
+		}
 		// out of buffers, wait for producer
 		b.Cond.Wait()
 	}
@@ -85,3 +85,39 @@ func TestBufferClose(t *testing.T) {
 		t.Fatal("Expected written == read == 15", r, r2, r3, r4)
 	}
 }
+
+func TestBufferWritePacket(t *testing.T) {
+	b := newBuffer()
+	packet := getPacketBuffer(9 + len(alphabet))
+	if cap(packet) != packetBufferSizes[0] {
+		t.Fatalf("got packet buffer with capacity %d, want %d", cap(packet), packetBufferSizes[0])
+	}
+	copy(packet[9:], alphabet)
+	b.writePacket(packet[9:], packet)
+
+	buf := make([]byte, 10)
+	if n, _ := b.Read(buf); n != 10 || string(buf) != string(alphabet[:10]) {
+		t.Fatalf("got %q, want %q", buf[:n], alphabet[:10])
+	}
+	if packet[9] != 'a' {
+		t.Fatal("packet released before it was read completely")
+	}
+	rest := make([]byte, 100)
+	n, _ := b.Read(rest)
+	if string(rest[:n]) != string(alphabet[10:]) {
+		t.Fatalf("got %q, want %q", rest[:n], alphabet[10:])
+	}
+	if b.head.packet != nil {
+		t.Fatal("packet not released after it was read")
+	}
+}
+
+func TestPacketBufferSizes(t *testing.T) {
+	for _, n := range []int{1, 512, 513, 9 + channelMaxPacket, 1 << 18} {
+		buf := getPacketBuffer(n)
+		if len(buf) != n {
+			t.Errorf("getPacketBuffer(%d) has length %d", n, len(buf))
+		}
+		putPacketBuffer(buf)
+	}
+}
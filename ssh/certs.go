@@ -6,11 +6,14 @@ package ssh
 
 import (
 	"bytes"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -162,6 +165,10 @@ func parseTuples(in []byte) (map[string]string, error) {
 	return tups, nil
 }
 
+// maxCertPrincipals is the maximum number of principals of a
+// certificate, as in OpenSSH.
+const maxCertPrincipals = 256
+
 func parseCert(in []byte, privAlgo string) (*Certificate, error) {
 	nonce, rest, ok := parseString(in)
 	if !ok {
@@ -193,6 +200,9 @@ func parseCert(in []byte, privAlgo string) (*Certificate, error) {
 		if !ok {
 			return nil, errShortRead
 		}
+		if len(c.ValidPrincipals) == maxCertPrincipals {
+			return nil, fmt.Errorf("ssh: certificate has more than %d principals", maxCertPrincipals)
+		}
 		c.ValidPrincipals = append(c.ValidPrincipals, string(principal))
 		principals = rest
 	}
@@ -210,6 +220,11 @@ func parseCert(in []byte, privAlgo string) (*Certificate, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Certificates cannot be signed by certificates, which also
+	// keeps the nesting of parseCert bounded.
+	if _, ok := k.(*Certificate); ok {
+		return nil, errors.New("ssh: certificate signature key is a certificate")
+	}
 
 	c.SignatureKey = k
 	c.Signature, rest, ok = parseSignatureBody(g.Signature)
@@ -283,7 +298,9 @@ type CertChecker struct {
 	// CertChecker will be checking host certificates.
 	IsHostAuthority func(auth PublicKey, address string) bool
 
-	// Clock is used for verifying time stamps. If nil, time.Now
+	// Clock is used for verifying time stamps, that is the
+	// ValidAfter and ValidBefore of certificates checked by
+	// CheckCert, Authenticate and CheckHostKey. If nil, time.Now
 	// is used.
 	Clock func() time.Time
 
@@ -330,6 +347,79 @@ func (c *CertChecker) CheckHostKey(addr string, remote net.Addr, key PublicKey)
 	return c.CheckCert(hostname, cert)
 }
 
+// NewHostCertChecker returns a CertChecker that accepts the host
+// certificates signed by one of caKeys, for any host, unless the
+// certificate, its key or its signing authority is one of revoked.
+// Host keys that are not certificates are rejected unless
+// HostKeyFallback is set. Its CheckHostKey method can be used as
+// ClientConfig.HostKeyCallback.
+func NewHostCertChecker(caKeys, revoked []PublicKey) *CertChecker {
+	cas := make(map[string]bool)
+	for _, k := range caKeys {
+		cas[string(k.Marshal())] = true
+	}
+	revokedKeys := make(map[string]bool)
+	for _, k := range revoked {
+		revokedKeys[string(k.Marshal())] = true
+	}
+	return &CertChecker{
+		IsHostAuthority: func(auth PublicKey, address string) bool {
+			return cas[string(auth.Marshal())] && !revokedKeys[string(auth.Marshal())]
+		},
+		IsRevoked: func(cert *Certificate) bool {
+			return revokedKeys[string(cert.Marshal())] ||
+				revokedKeys[string(cert.Key.Marshal())] ||
+				revokedKeys[string(cert.SignatureKey.Marshal())]
+		},
+	}
+}
+
+// ParseHostCertAuthorities returns the keys of the @cert-authority
+// lines of in, which is in the known_hosts format, and those of its
+// @revoked lines:
+//
+//	@cert-authority * ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAA... corp-ca
+//	@revoked * ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAA... compromised host
+//
+// The keys are meant for NewHostCertChecker. A revoked key is revoked
+// for all hosts, whatever the host patterns of its line. The other
+// lines are ignored. Host patterns other than "*", which restrict the
+// hosts a CA is trusted for, are not supported: package
+// golang.org/x/crypto/ssh/knownhosts implements them.
+func ParseHostCertAuthorities(in []byte) (caKeys, revoked []PublicKey, err error) {
+	for len(in) > 0 {
+		marker, hosts, key, _, rest, err := ParseKnownHosts(in)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		in = rest
+		switch marker {
+		case "revoked":
+			revoked = append(revoked, key)
+		case "cert-authority":
+			if len(hosts) != 1 || hosts[0] != "*" {
+				return nil, nil, fmt.Errorf("ssh: unsupported host patterns %q for certificate authority %s", strings.Join(hosts, ","), FingerprintSHA256(key))
+			}
+			caKeys = append(caKeys, key)
+		}
+	}
+	return caKeys, revoked, nil
+}
+
+// LoadHostCertAuthorities reads the file named filename and returns the
+// keys of its @cert-authority and @revoked lines, see
+// ParseHostCertAuthorities.
+func LoadHostCertAuthorities(filename string) (caKeys, revoked []PublicKey, err error) {
+	in, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ParseHostCertAuthorities(in)
+}
+
 // Authenticate checks a user certificate. Authenticate can be used as
 // a value for ServerConfig.PublicKeyCallback.
 func (c *CertChecker) Authenticate(conn ConnMetadata, pubKey PublicKey) (*Permissions, error) {
@@ -431,6 +521,172 @@ func (c *Certificate) SignCert(rand io.Reader, authority Signer) error {
 	return nil
 }
 
+// CertOptions are the contents of a certificate issued with
+// IssueUserCert or IssueHostCert. At least one principal and a
+// Validity are required, unless AnyPrincipal and NoExpiry are set.
+// With those two set and nothing else, the certificate is like
+// ssh-keygen issues without options: valid forever, for any principal,
+// with serial 0 and, for a user certificate, the permit-X11-forwarding,
+// permit-agent-forwarding, permit-port-forwarding, permit-pty and
+// permit-user-rc extensions.
+type CertOptions struct {
+	// KeyId identifies the certificate in the logs of servers.
+	KeyId  string
+	Serial uint64
+
+	// Principals are the user names or host names for which the
+	// certificate is valid. AnyPrincipal must be set to issue a
+	// certificate without principals, which is valid for any.
+	Principals   []string
+	AnyPrincipal bool
+
+	// ValidAfter is when the certificate becomes valid, and
+	// Validity how long it is valid from then. NoExpiry must be set
+	// to issue a certificate that does not expire, with a zero
+	// Validity. If ValidAfter is zero, the certificate is valid from
+	// the start of time if it does not expire, and otherwise from
+	// the start of the previous minute to allow for clock skew, as
+	// with ssh-keygen, until Validity from now.
+	ValidAfter time.Time
+	Validity   time.Duration
+	NoExpiry   bool
+
+	// The No fields leave out the default extensions of user
+	// certificates, like the no-* options of ssh-keygen. They are
+	// ignored for host certificates, which have no extensions by
+	// default.
+	NoX11Forwarding   bool
+	NoAgentForwarding bool
+	NoPortForwarding  bool
+	NoPty             bool
+	NoUserRC          bool
+
+	// ForceCommand and SourceAddress, if set, are the values of
+	// the force-command and source-address critical options.
+	ForceCommand  string
+	SourceAddress string
+
+	// CriticalOptions and Extensions are added to those above.
+	CriticalOptions map[string]string
+	Extensions      map[string]string
+
+	// Algorithm is the signature algorithm passed to the
+	// SignWithAlgorithm method of the authority. If empty,
+	// SigAlgoRSASHA2512 is used for RSA authorities, as by
+	// ssh-keygen, and the default algorithm of the authority
+	// otherwise.
+	Algorithm string
+
+	// Rand is the source of the nonce and of the randomness of the
+	// signature. If nil, crypto/rand.Reader is used.
+	Rand io.Reader
+}
+
+// IssueUserCert returns a user certificate for pub, signed by the
+// authority ca.
+func IssueUserCert(ca AlgorithmSigner, pub PublicKey, opts CertOptions) (*Certificate, error) {
+	return issueCert(ca, pub, UserCert, &opts)
+}
+
+// IssueHostCert returns a host certificate for pub, signed by the
+// authority ca.
+func IssueHostCert(ca AlgorithmSigner, pub PublicKey, opts CertOptions) (*Certificate, error) {
+	return issueCert(ca, pub, HostCert, &opts)
+}
+
+func issueCert(ca AlgorithmSigner, pub PublicKey, certType uint32, opts *CertOptions) (*Certificate, error) {
+	if _, ok := pub.(*Certificate); ok {
+		return nil, errors.New("ssh: cannot certify a certificate")
+	}
+	cert := &Certificate{
+		Key:             pub,
+		Serial:          opts.Serial,
+		CertType:        certType,
+		KeyId:           opts.KeyId,
+		ValidPrincipals: append([]string(nil), opts.Principals...),
+		ValidBefore:     CertTimeInfinity,
+		Permissions: Permissions{
+			CriticalOptions: map[string]string{},
+			Extensions:      map[string]string{},
+		},
+	}
+
+	if len(opts.Principals) == 0 && !opts.AnyPrincipal {
+		return nil, errors.New("ssh: certificate without principals; set AnyPrincipal to issue it")
+	}
+	if opts.Validity < 0 {
+		return nil, fmt.Errorf("ssh: negative certificate validity %v", opts.Validity)
+	}
+	if opts.Validity == 0 && !opts.NoExpiry {
+		return nil, errors.New("ssh: certificate without validity period; set NoExpiry to issue it")
+	}
+	if opts.Validity != 0 && opts.NoExpiry {
+		return nil, errors.New("ssh: certificate with both a validity period and NoExpiry")
+	}
+	if after := opts.ValidAfter; !after.IsZero() {
+		if after.Unix() < 0 {
+			return nil, fmt.Errorf("ssh: invalid certificate start %v", after)
+		}
+		cert.ValidAfter = uint64(after.Unix())
+		if opts.Validity > 0 {
+			cert.ValidBefore = uint64(after.Add(opts.Validity).Unix())
+		}
+	} else if opts.Validity > 0 {
+		now := time.Now()
+		cert.ValidAfter = uint64(now.Truncate(time.Minute).Add(-time.Minute).Unix())
+		cert.ValidBefore = uint64(now.Add(opts.Validity).Unix())
+	}
+
+	if certType == UserCert {
+		for _, ext := range []struct {
+			name string
+			off  bool
+		}{
+			{"permit-X11-forwarding", opts.NoX11Forwarding},
+			{"permit-agent-forwarding", opts.NoAgentForwarding},
+			{"permit-port-forwarding", opts.NoPortForwarding},
+			{"permit-pty", opts.NoPty},
+			{"permit-user-rc", opts.NoUserRC},
+		} {
+			if !ext.off {
+				cert.Extensions[ext.name] = ""
+			}
+		}
+	}
+	if opts.ForceCommand != "" {
+		cert.CriticalOptions["force-command"] = opts.ForceCommand
+	}
+	if opts.SourceAddress != "" {
+		cert.CriticalOptions[sourceAddressCriticalOption] = opts.SourceAddress
+	}
+	for k, v := range opts.CriticalOptions {
+		cert.CriticalOptions[k] = v
+	}
+	for k, v := range opts.Extensions {
+		cert.Extensions[k] = v
+	}
+
+	algorithm := opts.Algorithm
+	if algorithm == "" && ca.PublicKey().Type() == KeyAlgoRSA {
+		algorithm = SigAlgoRSASHA2512
+	}
+	r := opts.Rand
+	if r == nil {
+		r = rand.Reader
+	}
+	cert.Nonce = make([]byte, 32)
+	if _, err := io.ReadFull(r, cert.Nonce); err != nil {
+		return nil, err
+	}
+	cert.SignatureKey = ca.PublicKey()
+	sig, err := ca.SignWithAlgorithm(r, cert.bytesForSigning(), algorithm)
+	if err != nil {
+		return nil, err
+	}
+	cert.Signature = sig
+	return cert, nil
+}
+
 var certAlgoNames = map[string]string{
 	KeyAlgoRSA:        CertAlgoRSAv01,
 	KeyAlgoDSA:        CertAlgoDSAv01,
@@ -9,8 +9,12 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -99,6 +103,28 @@ func TestParseCertWithOptions(t *testing.T) {
 	}
 }
 
+func TestParseCertBounds(t *testing.T) {
+	newCert := func(principals int, authority Signer) []byte {
+		c := &Certificate{Key: testPublicKeys["ed25519"], CertType: UserCert}
+		for i := 0; i < principals; i++ {
+			c.ValidPrincipals = append(c.ValidPrincipals, fmt.Sprint("user", i))
+		}
+		if err := c.SignCert(rand.Reader, authority); err != nil {
+			t.Fatalf("SignCert: %v", err)
+		}
+		return c.Marshal()
+	}
+	if _, err := ParsePublicKey(newCert(maxCertPrincipals, testSigners["ecdsa"])); err != nil {
+		t.Errorf("ParsePublicKey of a certificate with %d principals: %v", maxCertPrincipals, err)
+	}
+	if _, err := ParsePublicKey(newCert(maxCertPrincipals+1, testSigners["ecdsa"])); err == nil {
+		t.Errorf("ParsePublicKey accepted a certificate with %d principals", maxCertPrincipals+1)
+	}
+	if _, err := ParsePublicKey(newCert(1, testSigners["cert"])); err == nil {
+		t.Error("ParsePublicKey accepted a certificate signed by a certificate")
+	}
+}
+
 func TestValidateCert(t *testing.T) {
 	key, _, _, _, err := ParseAuthorizedKey([]byte(exampleSSHCert))
 	if err != nil {
@@ -158,6 +184,59 @@ func TestValidateCertTime(t *testing.T) {
 	}
 }
 
+func TestCertCheckerClock(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	end := start.Add(time.Hour)
+	cert := &Certificate{
+		ValidPrincipals: []string{"user"},
+		Key:             testPublicKeys["rsa"],
+		ValidAfter:      uint64(start.Unix()),
+		ValidBefore:     uint64(end.Unix()),
+		CertType:        UserCert,
+	}
+	cert.SignCert(rand.Reader, testSigners["ecdsa"])
+	forever := *cert
+	forever.ValidBefore = CertTimeInfinity
+	forever.SignCert(rand.Reader, testSigners["ecdsa"])
+
+	var now time.Time
+	checker := &CertChecker{
+		IsUserAuthority: func(k PublicKey) bool {
+			return bytes.Equal(k.Marshal(), testPublicKeys["ecdsa"].Marshal())
+		},
+		Clock: func() time.Time { return now },
+	}
+	for _, tt := range []struct {
+		cert    *Certificate
+		now     time.Time
+		wantErr string
+	}{
+		{cert, start.Add(-time.Second), "not yet valid"},
+		{cert, start, ""},
+		{cert, end.Add(-time.Second), ""},
+		{cert, end, "expired"},
+		{cert, end.Add(time.Second), "expired"},
+		{&forever, start.Add(-time.Second), "not yet valid"},
+		{&forever, start.AddDate(1000, 0, 0), ""},
+	} {
+		now = tt.now
+		err := checker.CheckCert("user", tt.cert)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("CheckCert at %v until %d: got %v, want %q", tt.now.Unix(), tt.cert.ValidBefore, err, tt.wantErr)
+		}
+		// Authenticate checks the validity with the same clock.
+		if _, err2 := checker.Authenticate(&sshConn{user: "user"}, tt.cert); (err2 == nil) != (err == nil) {
+			t.Errorf("Authenticate at %v until %d: got %v, CheckCert got %v", tt.now.Unix(), tt.cert.ValidBefore, err2, err)
+		}
+	}
+
+	// Without Clock, the certificate is checked against the current time.
+	checker.Clock = nil
+	if err := checker.CheckCert("user", cert); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("CheckCert without Clock: got %v, want it to have expired", err)
+	}
+}
+
 // TODO(hanwen): tests for
 //
 // host keys:
@@ -333,3 +412,180 @@ func TestCertTypes(t *testing.T) {
 		})
 	}
 }
+
+func TestIssueUserCert(t *testing.T) {
+	// exampleSSHCert has the defaults of ssh-keygen.
+	key, _, _, _, err := ParseAuthorizedKey([]byte(exampleSSHCert))
+	if err != nil {
+		t.Fatalf("ParseAuthorizedKey: %v", err)
+	}
+	keygen := key.(*Certificate)
+
+	for _, name := range []string{"rsa", "ecdsa", "ed25519"} {
+		ca := testSigners[name].(AlgorithmSigner)
+		cert, err := IssueUserCert(ca, testPublicKeys["ecdsa"], CertOptions{KeyId: "test", AnyPrincipal: true, NoExpiry: true})
+		if err != nil {
+			t.Fatalf("IssueUserCert(%s): %v", name, err)
+		}
+		if cert.CertType != UserCert || cert.KeyId != "test" || cert.Serial != keygen.Serial ||
+			cert.ValidAfter != keygen.ValidAfter || cert.ValidBefore != keygen.ValidBefore ||
+			len(cert.ValidPrincipals) != 0 || !reflect.DeepEqual(cert.Permissions, keygen.Permissions) {
+			t.Errorf("IssueUserCert(%s) = %+v, want the ssh-keygen defaults of %+v", name, cert, keygen)
+		}
+		wantAlgo := ca.PublicKey().Type()
+		if name == "rsa" {
+			wantAlgo = SigAlgoRSASHA2512
+		}
+		if cert.Signature.Format != wantAlgo {
+			t.Errorf("IssueUserCert(%s) signed with %s, want %s", name, cert.Signature.Format, wantAlgo)
+		}
+
+		checker := &CertChecker{}
+		if err := checker.CheckCert("user", cert); err != nil {
+			t.Errorf("CheckCert of the certificate of %s: %v", name, err)
+		}
+	}
+
+	before := time.Now()
+	cert, err := IssueUserCert(testSigners["ed25519"].(AlgorithmSigner), testPublicKeys["ecdsa"], CertOptions{
+		KeyId:           "alice@example.com",
+		Serial:          42,
+		Principals:      []string{"alice"},
+		Validity:        time.Hour,
+		NoPty:           true,
+		NoUserRC:        true,
+		ForceCommand:    "/usr/bin/backup",
+		SourceAddress:   "192.0.2.0/24",
+		Extensions:      map[string]string{"login@example.com": "alice"},
+		NoX11Forwarding: true,
+	})
+	if err != nil {
+		t.Fatalf("IssueUserCert: %v", err)
+	}
+	wantPerms := Permissions{
+		CriticalOptions: map[string]string{"force-command": "/usr/bin/backup", "source-address": "192.0.2.0/24"},
+		Extensions: map[string]string{
+			"permit-agent-forwarding": "",
+			"permit-port-forwarding":  "",
+			"login@example.com":       "alice",
+		},
+	}
+	if !reflect.DeepEqual(cert.Permissions, wantPerms) {
+		t.Errorf("got permissions %+v, want %+v", cert.Permissions, wantPerms)
+	}
+	if cert.Serial != 42 || !reflect.DeepEqual(cert.ValidPrincipals, []string{"alice"}) {
+		t.Errorf("got serial %d and principals %q", cert.Serial, cert.ValidPrincipals)
+	}
+	after, until := time.Unix(int64(cert.ValidAfter), 0), time.Unix(int64(cert.ValidBefore), 0)
+	if after.After(before) || before.Sub(after) > 2*time.Minute || until.Before(before.Add(time.Hour-time.Second)) || until.After(time.Now().Add(time.Hour)) {
+		t.Errorf("valid from %v until %v, want from about a minute before %v for an hour", after, until, before)
+	}
+
+	if _, err := IssueUserCert(testSigners["ed25519"].(AlgorithmSigner), cert, CertOptions{AnyPrincipal: true, NoExpiry: true}); err == nil {
+		t.Error("IssueUserCert succeeded for a certificate")
+	}
+
+	for _, opts := range []CertOptions{
+		{},
+		{Validity: time.Hour},
+		{Principals: []string{"alice"}},
+		{Principals: []string{"alice"}, Validity: time.Hour, NoExpiry: true},
+		{AnyPrincipal: true, Validity: -time.Hour},
+	} {
+		if _, err := IssueUserCert(testSigners["ed25519"].(AlgorithmSigner), testPublicKeys["ecdsa"], opts); err == nil {
+			t.Errorf("IssueUserCert succeeded with %+v", opts)
+		}
+	}
+}
+
+func TestIssueHostCert(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	ca := testSigners["ecdsa"].(AlgorithmSigner)
+	cert, err := IssueHostCert(ca, testPublicKeys["ed25519"], CertOptions{
+		KeyId:      "host",
+		Principals: []string{"host.example.com"},
+		ValidAfter: start,
+		Validity:   24 * time.Hour,
+		// Ignored for host certificates.
+		NoPty: true,
+	})
+	if err != nil {
+		t.Fatalf("IssueHostCert: %v", err)
+	}
+	if cert.CertType != HostCert || len(cert.CriticalOptions) != 0 || len(cert.Extensions) != 0 {
+		t.Errorf("got a certificate of type %d with options %+v, want a host certificate without options", cert.CertType, cert.Permissions)
+	}
+	if cert.ValidAfter != 1600000000 || cert.ValidBefore != 1600000000+24*3600 {
+		t.Errorf("valid from %d until %d", cert.ValidAfter, cert.ValidBefore)
+	}
+
+	checker := &CertChecker{
+		IsHostAuthority: func(auth PublicKey, address string) bool {
+			return bytes.Equal(auth.Marshal(), ca.PublicKey().Marshal())
+		},
+		Clock: func() time.Time { return start.Add(time.Hour) },
+	}
+	if err := checker.CheckHostKey("host.example.com:22", &net.TCPAddr{}, cert); err != nil {
+		t.Errorf("CheckHostKey: %v", err)
+	}
+	checker.Clock = func() time.Time { return start.Add(25 * time.Hour) }
+	if err := checker.CheckHostKey("host.example.com:22", &net.TCPAddr{}, cert); err == nil {
+		t.Error("CheckHostKey succeeded for an expired certificate")
+	}
+}
+
+func TestLoadHostCertAuthorities(t *testing.T) {
+	ca := testSigners["ecdsa"].(AlgorithmSigner)
+	revokedCA := testSigners["rsa"].(AlgorithmSigner)
+	file := "# Trusted CAs.\n" +
+		"@cert-authority * " + string(MarshalAuthorizedKey(ca.PublicKey())) +
+		"@cert-authority * " + string(MarshalAuthorizedKey(revokedCA.PublicKey())) +
+		"host.example.com " + string(MarshalAuthorizedKey(testPublicKeys["rsa"])) +
+		"@revoked * " + string(MarshalAuthorizedKey(testPublicKeys["dsa"])) +
+		"@revoked old.example.com " + string(MarshalAuthorizedKey(revokedCA.PublicKey()))
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := ioutil.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cas, revoked, err := LoadHostCertAuthorities(path)
+	if err != nil {
+		t.Fatalf("LoadHostCertAuthorities: %v", err)
+	}
+	if len(cas) != 2 || !bytes.Equal(cas[0].Marshal(), ca.PublicKey().Marshal()) || !bytes.Equal(cas[1].Marshal(), revokedCA.PublicKey().Marshal()) {
+		t.Fatalf("got authorities %v, want the ecdsa and rsa keys", cas)
+	}
+	if len(revoked) != 2 || !bytes.Equal(revoked[0].Marshal(), testPublicKeys["dsa"].Marshal()) || !bytes.Equal(revoked[1].Marshal(), revokedCA.PublicKey().Marshal()) {
+		t.Fatalf("got revoked keys %v, want the dsa and rsa keys", revoked)
+	}
+	checker := NewHostCertChecker(cas, revoked)
+
+	issue := func(ca Signer, key PublicKey) PublicKey {
+		cert, err := IssueHostCert(ca.(AlgorithmSigner), key, CertOptions{Principals: []string{"host.example.com"}, Validity: time.Hour})
+		if err != nil {
+			t.Fatalf("IssueHostCert: %v", err)
+		}
+		return cert
+	}
+	if err := checker.CheckHostKey("host.example.com:22", &net.TCPAddr{}, issue(ca, testPublicKeys["ed25519"])); err != nil {
+		t.Errorf("CheckHostKey of a certificate of a loaded authority: %v", err)
+	}
+	if err := checker.CheckHostKey("host.example.com:22", &net.TCPAddr{}, issue(testSigners["ed25519"], testPublicKeys["ed25519"])); err == nil {
+		t.Error("CheckHostKey succeeded for a certificate of an unknown authority")
+	}
+	if err := checker.CheckHostKey("host.example.com:22", &net.TCPAddr{}, issue(revokedCA, testPublicKeys["ed25519"])); err == nil {
+		t.Error("CheckHostKey succeeded for a certificate of a revoked authority")
+	}
+	if err := checker.CheckHostKey("host.example.com:22", &net.TCPAddr{}, issue(ca, testPublicKeys["dsa"])); err == nil {
+		t.Error("CheckHostKey succeeded for a certificate of a revoked key")
+	}
+	if err := checker.CheckHostKey("host.example.com:22", &net.TCPAddr{}, testPublicKeys["ed25519"]); err == nil {
+		t.Error("CheckHostKey succeeded for a plain host key")
+	}
+
+	if _, _, err := ParseHostCertAuthorities([]byte("@cert-authority *.example.com " + string(MarshalAuthorizedKey(ca.PublicKey())))); err == nil {
+		t.Error("ParseHostCertAuthorities succeeded for a host pattern")
+	}
+	if _, _, err := LoadHostCertAuthorities(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("LoadHostCertAuthorities succeeded for a missing file")
+	}
+}
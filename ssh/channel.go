@@ -10,7 +10,10 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -78,6 +81,70 @@ type Channel interface {
 	Stderr() io.ReadWriter
 }
 
+// A ManualWindowChannel is a Channel whose flow control window is
+// managed by the application instead of by Read. Reading data from it
+// does not grant the peer more window; the application must call
+// AdjustWindow to allow the peer to send more data, which makes it
+// possible to implement credit based flow control. If the window is
+// not adjusted, the peer stops sending, and a read waiting for data
+// will block forever; the peer may also block on writes to the
+// channel, possibly deadlocking both sides.
+//
+// Channels are created in this mode with the OpenChannelManualWindow
+// method of ManualWindowConn, and the AcceptManualWindow method of
+// ManualWindowNewChannel.
+type ManualWindowChannel interface {
+	Channel
+
+	// AdjustWindow grants the peer the right to send bytes more
+	// bytes of data, in addition to the current window. It fails
+	// if the window would exceed 2^32-1 bytes.
+	AdjustWindow(bytes uint32) error
+}
+
+// ManualWindowNewChannel is implemented by the NewChannel values of
+// this package, and accepts a channel in manual window mode.
+type ManualWindowNewChannel interface {
+	NewChannel
+
+	// AcceptManualWindow accepts the channel creation request,
+	// like Accept, granting the peer an initial window of window
+	// bytes. Further window is only granted by AdjustWindow.
+	AcceptManualWindow(window uint32) (ManualWindowChannel, <-chan *Request, error)
+}
+
+// TCPIPNewChannel is implemented by the NewChannel values of this
+// package, and decodes the extra data of the channels of TCP/IP
+// forwarding, for instance so that a server can check the destination
+// of a "direct-tcpip" channel before accepting it.
+type TCPIPNewChannel interface {
+	NewChannel
+
+	// DirectTCPIP returns the extra data of a "direct-tcpip"
+	// channel. It fails for channels of other types.
+	DirectTCPIP() (*DirectTCPIPPayload, error)
+
+	// ForwardedTCPIP returns the extra data of a "forwarded-tcpip"
+	// channel. It fails for channels of other types.
+	ForwardedTCPIP() (*ForwardedTCPIPPayload, error)
+}
+
+// A PaddedWritesChannel is a Channel that can hide the size of small
+// writes, such as the keystrokes of an interactive session, from an
+// observer of the connection.
+type PaddedWritesChannel interface {
+	Channel
+
+	// PadSmallWrites makes every data packet carrying fewer than
+	// blockSize bytes take as much space on the wire as one
+	// carrying blockSize bytes. The padding is the random packet
+	// padding of the SSH transport (RFC 4253, section 6), so the
+	// peer discards it and the data it reads is unchanged. Each such
+	// packet costs up to blockSize extra bytes of bandwidth. A
+	// blockSize of 0 disables padding; the maximum is 224.
+	PadSmallWrites(blockSize int) error
+}
+
 // Request is a request sent outside of the normal stream of
 // data. Requests can either be specific to an SSH channel, or they
 // can be global.
@@ -88,6 +155,9 @@ type Request struct {
 
 	ch  *channel
 	mux *mux
+	// reply is the pending reply of a global request, see
+	// mux.incomingPending.
+	reply *globalReply
 }
 
 // Reply sends a response to a request. It must be called for all requests
@@ -99,6 +169,9 @@ func (r *Request) Reply(ok bool, payload []byte) error {
 	}
 
 	if r.ch == nil {
+		if r.reply != nil {
+			return r.mux.sendReply(r.reply, ok, payload)
+		}
 		return r.mux.ackRequest(ok, payload)
 	}
 
@@ -148,10 +221,22 @@ const (
 // channel is an implementation of the Channel interface that works
 // with the mux class.
 type channel struct {
+	// bytesReceived and bytesSent count the channel data, including
+	// extended data, in each direction. They are accessed atomically,
+	// and come first to keep them 64-bit aligned.
+	bytesReceived uint64
+	bytesSent     uint64
+
 	// R/O after creation
 	chanType          string
 	extraData         []byte
 	localId, remoteId uint32
+	created           time.Time
+
+	// open is set once the channel is confirmed. It is protected
+	// by the mutex of mux.chanList, and remoteId may only be read
+	// under that lock if open is set.
+	open bool
 
 	// maxIncomingPayload and maxRemotePayload are the maximum
 	// payload sizes of normal and extended data packets for
@@ -191,32 +276,75 @@ type channel struct {
 	windowMu sync.Mutex
 	myWindow uint32
 
+	// manualWindow is set, before the channel is confirmed, for
+	// channels whose window is only adjusted by AdjustWindow.
+	manualWindow bool
+
 	// writeMu serializes calls to mux.conn.writePacket() and
-	// protects sentClose and packetPool. This mutex must be
+	// protects sentClose, packetPool and padTo. This mutex must be
 	// different from windowMu, as writePacket can block if there
 	// is a key exchange pending.
 	writeMu   sync.Mutex
 	sentClose bool
 
+	// padTo is the data size that shorter data packets are padded
+	// to on the wire, see PadSmallWrites.
+	padTo int
+
 	// packetPool has a buffer for each extended channel ID to
 	// save allocations during writes.
 	packetPool map[uint32][]byte
+
+	// envMu protects env, the environment variables accepted by
+	// mux.acceptEnv, in "NAME=value" form.
+	envMu sync.Mutex
+	env   []string
+
+	// eowReceived is set, atomically, when the peer sends an
+	// eow@openssh.com request.
+	eowReceived uint32
+
+	// readClosed is set, atomically, by CloseRead. The data received
+	// afterwards is discarded.
+	readClosed uint32
 }
 
 // writePacket sends a packet. If the packet is a channel close, it updates
 // sentClose. This method takes the lock c.writeMu.
 func (ch *channel) writePacket(packet []byte) error {
+	return ch.writePaddedPacket(packet, 0)
+}
+
+// writePaddedPacket is like writePacket, but pads packets shorter
+// than padTo bytes on the wire if the transport supports it.
+func (ch *channel) writePaddedPacket(packet []byte, padTo int) error {
 	ch.writeMu.Lock()
 	if ch.sentClose {
 		ch.writeMu.Unlock()
 		return io.EOF
 	}
 	ch.sentClose = (packet[0] == msgChannelClose)
-	err := ch.mux.conn.writePacket(packet)
+	var err error
+	if w, ok := ch.mux.conn.(paddedPacketWriter); ok && padTo > 0 {
+		err = w.writePaddedPacket(packet, padTo)
+	} else {
+		err = ch.mux.conn.writePacket(packet)
+	}
 	ch.writeMu.Unlock()
 	return err
 }
 
+// PadSmallWrites implements PaddedWritesChannel.
+func (ch *channel) PadSmallWrites(blockSize int) error {
+	if blockSize < 0 || blockSize > maxExtraPadding {
+		return fmt.Errorf("ssh: padding block size %d out of range [0, %d]", blockSize, maxExtraPadding)
+	}
+	ch.writeMu.Lock()
+	ch.padTo = blockSize
+	ch.writeMu.Unlock()
+	return nil
+}
+
 func (ch *channel) sendMessage(msg interface{}) error {
 	if debugMux {
 		log.Printf("send(%d): %#v", ch.mux.chanList.offset, msg)
@@ -243,6 +371,10 @@ func (ch *channel) WriteExtended(data []byte, extendedCode uint32) (n int, err e
 
 	ch.writeMu.Lock()
 	packet := ch.packetPool[extendedCode]
+	padTo := 0
+	if ch.padTo > 0 {
+		padTo = int(headerLength) + ch.padTo
+	}
 	// We don't remove the buffer from packetPool, so
 	// WriteExtended calls from different goroutines will be
 	// flagged as errors by the race detector.
@@ -268,12 +400,13 @@ func (ch *channel) WriteExtended(data []byte, extendedCode uint32) (n int, err e
 		}
 		binary.BigEndian.PutUint32(packet[headerLength-4:], uint32(len(todo)))
 		copy(packet[headerLength:], todo)
-		if err = ch.writePacket(packet); err != nil {
+		if err = ch.writePaddedPacket(packet, padTo); err != nil {
 			return n, err
 		}
 
 		n += len(todo)
 		data = data[len(todo):]
+		atomic.AddUint64(&ch.bytesSent, uint64(len(todo)))
 	}
 
 	ch.writeMu.Lock()
@@ -321,13 +454,20 @@ func (ch *channel) handleData(packet []byte) error {
 	}
 	ch.myWindow -= length
 	ch.windowMu.Unlock()
+	atomic.AddUint64(&ch.bytesReceived, uint64(length))
 
 	if extended == 1 {
-		ch.extPending.write(data)
+		ch.extPending.writePacket(data, packet)
 	} else if extended > 0 {
 		// discard other extended data.
+		putPacketBuffer(packet)
+	} else if atomic.LoadUint32(&ch.readClosed) != 0 {
+		// Read no longer consumes the data, so open the window
+		// for it here.
+		putPacketBuffer(packet)
+		return ch.adjustWindow(length)
 	} else {
-		ch.pending.write(data)
+		ch.pending.writePacket(data, packet)
 	}
 	return nil
 }
@@ -343,6 +483,23 @@ func (c *channel) adjustWindow(n uint32) error {
 	})
 }
 
+// AdjustWindow implements ManualWindowChannel.
+func (c *channel) AdjustWindow(bytes uint32) error {
+	if bytes == 0 {
+		return nil
+	}
+	c.windowMu.Lock()
+	if c.myWindow+bytes < c.myWindow {
+		c.windowMu.Unlock()
+		return errors.New("ssh: window adjustment overflows the window")
+	}
+	c.myWindow += bytes
+	c.windowMu.Unlock()
+	return c.sendMessage(windowAdjustMsg{
+		AdditionalBytes: bytes,
+	})
+}
+
 func (c *channel) ReadExtended(data []byte, extended uint32) (n int, err error) {
 	switch extended {
 	case 1:
@@ -353,7 +510,7 @@ func (c *channel) ReadExtended(data []byte, extended uint32) (n int, err error)
 		return 0, fmt.Errorf("ssh: extended code %d unimplemented", extended)
 	}
 
-	if n > 0 {
+	if n > 0 && !c.manualWindow {
 		err = c.adjustWindow(uint32(n))
 		// sendWindowAdjust can return io.EOF if the remote
 		// peer has closed the connection, however we want to
@@ -434,12 +591,29 @@ func (ch *channel) handlePacket(packet []byte) error {
 		ch.remoteId = msg.MyID
 		ch.maxRemotePayload = msg.MaxPacketSize
 		ch.remoteWin.add(msg.MyWindow)
+		ch.mux.chanList.setOpen(ch)
 		ch.msg <- msg
 	case *windowAdjustMsg:
 		if !ch.remoteWin.add(msg.AdditionalBytes) {
 			return fmt.Errorf("ssh: invalid window update for %d bytes", msg.AdditionalBytes)
 		}
 	case *channelRequestMsg:
+		if msg.Request == "env" && ch.mux.acceptEnv != nil && ch.direction == channelInbound && ch.chanType == "session" {
+			return ch.handleEnvRequest(msg)
+		}
+		if msg.Request == eowRequestType && ch.chanType == "session" {
+			atomic.StoreUint32(&ch.eowReceived, 1)
+			if msg.WantReply {
+				return ch.ackRequest(false)
+			}
+			return nil
+		}
+		if msg.Request == agentRequestType && ch.mux.noAgentForwarding {
+			if msg.WantReply {
+				return ch.ackRequest(false)
+			}
+			return nil
+		}
 		req := Request{
 			Type:      msg.Request,
 			WantReply: msg.WantReply,
@@ -454,6 +628,185 @@ func (ch *channel) handlePacket(packet []byte) error {
 	return nil
 }
 
+// handleEnvRequest stores the variable of an "env" request if
+// mux.acceptEnv accepts it. Other variables are dropped.
+func (ch *channel) handleEnvRequest(msg *channelRequestMsg) error {
+	var req setenvRequest
+	ok := Unmarshal(msg.RequestSpecificData, &req) == nil &&
+		!strings.ContainsRune(req.Name, '=') && ch.mux.acceptEnv(req.Name, req.Value)
+	if ok {
+		ch.envMu.Lock()
+		ch.setEnvLocked(req.Name, req.Value)
+		ch.envMu.Unlock()
+	}
+	if !msg.WantReply {
+		return nil
+	}
+	return ch.ackRequest(ok)
+}
+
+// setEnvLocked sets the variable name in ch.env, replacing an earlier
+// value.
+func (ch *channel) setEnvLocked(name, value string) {
+	kv := name + "=" + value
+	for i, e := range ch.env {
+		if strings.HasPrefix(e, name+"=") {
+			ch.env[i] = kv
+			return
+		}
+	}
+	ch.env = append(ch.env, kv)
+}
+
+// EnvChannel is implemented by the channels of this package. On
+// servers whose ServerConfig has an AcceptEnv callback, it gives the
+// environment variables of session channels.
+type EnvChannel interface {
+	Channel
+
+	// Env returns the environment variables sent by the client
+	// with "env" requests and accepted by ServerConfig.AcceptEnv, in
+	// "NAME=value" form, as used by os/exec.Cmd.Env. A variable
+	// sent several times has its last value.
+	Env() []string
+}
+
+// Env implements EnvChannel.
+func (ch *channel) Env() []string {
+	ch.envMu.Lock()
+	defer ch.envMu.Unlock()
+	return append([]string(nil), ch.env...)
+}
+
+// EOWChannel is implemented by the channels of this package. It
+// supports the eow@openssh.com request of OpenSSH, with which a side
+// of a session channel tells the other that it stopped reading the
+// channel data, for example because its output was closed, so the
+// other side should stop writing it. Unlike the EOF message of
+// CloseWrite, it says nothing about the data of its sender. Such
+// requests are handled by the channel and not passed to its request
+// channel.
+type EOWChannel interface {
+	Channel
+
+	// CloseRead sends an eow@openssh.com request on a session
+	// channel, and makes Read return io.EOF once the data received
+	// so far has been read. The data that arrives afterwards is
+	// discarded. Writes and the extended data are not affected.
+	CloseRead() error
+
+	// EOWReceived reports whether an eow@openssh.com request was
+	// received, that is whether the peer stopped reading the data
+	// written to the channel.
+	EOWReceived() bool
+}
+
+// CloseRead implements EOWChannel.
+func (ch *channel) CloseRead() error {
+	if !ch.decided {
+		return errUndecided
+	}
+	if !atomic.CompareAndSwapUint32(&ch.readClosed, 0, 1) {
+		return nil
+	}
+	ch.pending.eof()
+	if ch.chanType != "session" {
+		return nil
+	}
+	return ch.sendMessage(channelRequestMsg{
+		PeersID: ch.remoteId,
+		Request: eowRequestType,
+	})
+}
+
+// EOWReceived implements EOWChannel.
+func (ch *channel) EOWReceived() bool {
+	return atomic.LoadUint32(&ch.eowReceived) != 0
+}
+
+// DeadlineChannel is implemented by the channels of this package. Its
+// deadlines have the semantics of those of net.Conn: once a deadline
+// has passed, the reads or writes that would block fail with an error
+// that implements net.Error with Timeout() == true, until the deadline
+// is moved. The read deadline applies to the normal and the extended
+// data, such as stderr, and so does the write deadline.
+//
+// A Read that times out consumes no data. A Write that times out while
+// it waits for the peer to grant window space returns the number of
+// bytes sent so far; the packets are not written partially. A Write
+// that is blocked by the underlying connection, for example during a
+// key exchange, is not interrupted.
+type DeadlineChannel interface {
+	Channel
+
+	// SetDeadline sets both the read and the write deadline.
+	SetDeadline(t time.Time) error
+
+	// SetReadDeadline sets the deadline of Read. The zero t
+	// means Read does not time out.
+	SetReadDeadline(t time.Time) error
+
+	// SetWriteDeadline sets the deadline of Write. The zero t
+	// means Write does not time out.
+	SetWriteDeadline(t time.Time) error
+}
+
+// SetDeadline implements DeadlineChannel.
+func (ch *channel) SetDeadline(t time.Time) error {
+	ch.SetReadDeadline(t)
+	return ch.SetWriteDeadline(t)
+}
+
+// SetReadDeadline implements DeadlineChannel.
+func (ch *channel) SetReadDeadline(t time.Time) error {
+	ch.pending.setDeadline(t)
+	ch.extPending.setDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline implements DeadlineChannel.
+func (ch *channel) SetWriteDeadline(t time.Time) error {
+	ch.remoteWin.setDeadline(t)
+	return nil
+}
+
+// ExitChannel is implemented by the channels of this package. It lets
+// servers report how the command of a session channel ended, so that
+// they control which of the "exit-status" and "exit-signal" requests,
+// the EOF message and the close message they send, and in which order.
+// The requests are sent without asking for a reply, as RFC 4254,
+// section 6.10 requires.
+type ExitChannel interface {
+	Channel
+
+	// SendExitStatus sends an "exit-status" request with the exit
+	// status of the command.
+	SendExitStatus(code uint32) error
+
+	// SendExitSignal sends an "exit-signal" request, which reports
+	// that the command was terminated by sig, such as SIGKILL. msg
+	// describes the error, and lang is the language tag of msg, as
+	// defined in RFC 3066.
+	SendExitSignal(sig Signal, coreDumped bool, msg, lang string) error
+}
+
+// SendExitStatus implements ExitChannel.
+func (ch *channel) SendExitStatus(code uint32) error {
+	_, err := ch.SendRequest("exit-status", false, Marshal(&ExitStatusRequest{Status: code}))
+	return err
+}
+
+// SendExitSignal implements ExitChannel.
+func (ch *channel) SendExitSignal(sig Signal, coreDumped bool, msg, lang string) error {
+	_, err := ch.SendRequest("exit-signal", false, Marshal(&ExitSignalRequest{
+		Signal:     string(sig),
+		CoreDumped: coreDumped,
+		Error:      msg,
+		Lang:       lang,
+	}))
+	return err
+}
+
 func (m *mux) newChannel(chanType string, direction channelDirection, extraData []byte) *channel {
 	ch := &channel{
 		remoteWin:        window{Cond: newCond()},
@@ -465,6 +818,7 @@ func (m *mux) newChannel(chanType string, direction channelDirection, extraData
 		msg:              make(chan interface{}, chanSize),
 		chanType:         chanType,
 		extraData:        extraData,
+		created:          time.Now(),
 		mux:              m,
 		packetPool:       make(map[uint32][]byte),
 	}
@@ -489,6 +843,22 @@ func (e *extChannel) Read(data []byte) (n int, err error) {
 }
 
 func (ch *channel) Accept() (Channel, <-chan *Request, error) {
+	return ch.accept()
+}
+
+// AcceptManualWindow implements ManualWindowNewChannel.
+func (ch *channel) AcceptManualWindow(window uint32) (ManualWindowChannel, <-chan *Request, error) {
+	if ch.decided {
+		return nil, nil, errDecidedAlready
+	}
+	ch.windowMu.Lock()
+	ch.myWindow = window
+	ch.windowMu.Unlock()
+	ch.manualWindow = true
+	return ch.accept()
+}
+
+func (ch *channel) accept() (*channel, <-chan *Request, error) {
 	if ch.decided {
 		return nil, nil, errDecidedAlready
 	}
@@ -503,6 +873,7 @@ func (ch *channel) Accept() (Channel, <-chan *Request, error) {
 	if err := ch.sendMessage(confirm); err != nil {
 		return nil, nil, err
 	}
+	ch.mux.chanList.setOpen(ch)
 
 	return ch, ch.incomingRequests, nil
 }
@@ -570,6 +941,9 @@ func (ch *channel) SendRequest(name string, wantReply bool, payload []byte) (boo
 	if !ch.decided {
 		return false, errUndecided
 	}
+	if name == agentRequestType && ch.mux.noAgentForwarding {
+		return false, errAgentForwardingDisabled
+	}
 
 	if wantReply {
 		ch.sentRequestMu.Lock()
@@ -631,3 +1005,27 @@ func (ch *channel) ChannelType() string {
 func (ch *channel) ExtraData() []byte {
 	return ch.extraData
 }
+
+// DirectTCPIP implements TCPIPNewChannel.
+func (ch *channel) DirectTCPIP() (*DirectTCPIPPayload, error) {
+	if ch.chanType != "direct-tcpip" {
+		return nil, fmt.Errorf("ssh: channel type %q is not direct-tcpip", ch.chanType)
+	}
+	var payload DirectTCPIPPayload
+	if err := Unmarshal(ch.extraData, &payload); err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// ForwardedTCPIP implements TCPIPNewChannel.
+func (ch *channel) ForwardedTCPIP() (*ForwardedTCPIPPayload, error) {
+	if ch.chanType != "forwarded-tcpip" {
+		return nil, fmt.Errorf("ssh: channel type %q is not forwarded-tcpip", ch.chanType)
+	}
+	var payload ForwardedTCPIPPayload
+	if err := Unmarshal(ch.extraData, &payload); err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
@@ -0,0 +1,237 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ChannelConn adapts ch to the net.Conn interface, so that it can be
+// used where a network connection is expected, for example with
+// http.Serve. The laddr and raddr are returned by LocalAddr and
+// RemoteAddr; either may be nil.
+//
+// The returned net.Conn supports read and write deadlines. If ch is a
+// DeadlineChannel, as the channels of this package are, they are the
+// deadlines of ch. Otherwise, they are emulated: a Write that times
+// out may still complete in the background, and later Writes wait for
+// it, so the data written to the channel is not reordered; reads are
+// served from a goroutine that reads ahead from ch, so the caller
+// should not read from ch directly once it is wrapped.
+func ChannelConn(ch Channel, laddr, raddr net.Addr) net.Conn {
+	if _, ok := ch.(DeadlineChannel); ok {
+		return &chanConn{Channel: ch, laddr: laddr, raddr: raddr}
+	}
+	c := &channelConn{
+		Channel: ch,
+		laddr:   laddr,
+		raddr:   raddr,
+		reads:   make(chan channelConnRead),
+		closed:  make(chan struct{}),
+	}
+	c.readDeadline.init()
+	c.writeDeadline.init()
+	go c.readLoop()
+	return c
+}
+
+type channelConnRead struct {
+	data []byte
+	err  error
+}
+
+// channelConn emulates deadlines for a Channel that has none.
+type channelConn struct {
+	Channel
+	laddr, raddr net.Addr
+
+	// reads carries data from readLoop to Read.
+	reads chan channelConnRead
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	// readMu serializes Read calls, and protects leftover and
+	// readErr.
+	readMu   sync.Mutex
+	leftover []byte
+	readErr  error
+
+	// writeMu serializes Write calls, and protects pendingWrite,
+	// which is set if a Write timed out before the channel
+	// accepted its data.
+	writeMu      sync.Mutex
+	pendingWrite chan error
+
+	readDeadline, writeDeadline connDeadline
+}
+
+func (c *channelConn) readLoop() {
+	for {
+		buf := make([]byte, channelMaxPacket)
+		n, err := c.Channel.Read(buf)
+		select {
+		case c.reads <- channelConnRead{buf[:n], err}:
+		case <-c.closed:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *channelConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for len(c.leftover) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		expired, changed, stop := c.readDeadline.timer()
+		select {
+		case r := <-c.reads:
+			c.leftover, c.readErr = r.data, r.err
+		case <-c.closed:
+			stop()
+			return 0, net.ErrClosed
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		case <-changed:
+		}
+		stop()
+	}
+	n := copy(b, c.leftover)
+	c.leftover = c.leftover[n:]
+	return n, nil
+}
+
+func (c *channelConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.pendingWrite != nil {
+		if err := c.waitWrite(c.pendingWrite); err != nil {
+			return 0, err
+		}
+		c.pendingWrite = nil
+	}
+
+	// The channel may still be writing after we return, so it
+	// must not hold on to b.
+	data := append([]byte(nil), b...)
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Channel.Write(data)
+		done <- err
+	}()
+	if err := c.waitWrite(done); err != nil {
+		if err == os.ErrDeadlineExceeded {
+			c.pendingWrite = done
+		}
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// waitWrite waits for the result of a Write on done, or for the write
+// deadline to pass.
+func (c *channelConn) waitWrite(done chan error) error {
+	for {
+		expired, changed, stop := c.writeDeadline.timer()
+		select {
+		case err := <-done:
+			stop()
+			return err
+		case <-c.closed:
+			stop()
+			return net.ErrClosed
+		case <-expired:
+			return os.ErrDeadlineExceeded
+		case <-changed:
+			stop()
+		}
+	}
+}
+
+func (c *channelConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Channel.Close()
+}
+
+// LocalAddr returns the local network address.
+func (c *channelConn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+// RemoteAddr returns the remote network address.
+func (c *channelConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+// SetDeadline sets the read and write deadlines associated
+// with the connection.
+func (c *channelConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the read deadline.
+// A zero value for t means Read will not time out.
+// After the deadline, the error from Read will implement net.Error
+// with Timeout() == true.
+func (c *channelConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline.
+// A zero value for t means Write will not time out.
+// After the deadline, the error from Write will implement net.Error
+// with Timeout() == true.
+func (c *channelConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// connDeadline is a deadline that blocked operations can wait for.
+type connDeadline struct {
+	mu      sync.Mutex
+	t       time.Time
+	changed chan struct{} // closed and replaced on every set
+}
+
+func (d *connDeadline) init() {
+	d.changed = make(chan struct{})
+}
+
+func (d *connDeadline) set(t time.Time) {
+	d.mu.Lock()
+	d.t = t
+	close(d.changed)
+	d.changed = make(chan struct{})
+	d.mu.Unlock()
+}
+
+// timer returns a channel that receives when the deadline passes,
+// which is nil if there is no deadline, and a channel that is closed
+// when the deadline is changed. The stop function releases the timer.
+func (d *connDeadline) timer() (expired <-chan time.Time, changed <-chan struct{}, stop func()) {
+	d.mu.Lock()
+	t := d.t
+	changed = d.changed
+	d.mu.Unlock()
+
+	if t.IsZero() {
+		return nil, changed, func() {}
+	}
+	timer := time.NewTimer(time.Until(t))
+	return timer.C, changed, func() { timer.Stop() }
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// channelListener is a net.Listener that returns the accepted
+// channels of a connection, wrapped with ChannelConn.
+type channelListener struct {
+	chans     <-chan NewChannel
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (l *channelListener) Accept() (net.Conn, error) {
+	select {
+	case newCh, ok := <-l.chans:
+		if !ok {
+			return nil, io.EOF
+		}
+		ch, reqs, err := newCh.Accept()
+		if err != nil {
+			return nil, err
+		}
+		go DiscardRequests(reqs)
+		return ChannelConn(ch, memAddr{}, memAddr{}), nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *channelListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *channelListener) Addr() net.Addr {
+	return memAddr{}
+}
+
+func channelConnPair(t *testing.T) (*Client, <-chan NewChannel, func()) {
+	serverConf := &ServerConfig{NoClientAuth: true}
+	serverConf.AddHostKey(testSigners["rsa"])
+	client, server, chans, reqs := pipePair(t, serverConf, testClientConfig())
+	go DiscardRequests(reqs)
+	return client, chans, func() {
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestChannelConnHTTP(t *testing.T) {
+	client, chans, cleanup := channelConnPair(t)
+	defer cleanup()
+
+	l := &channelListener{chans: chans, closed: make(chan struct{})}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello from "+r.URL.Path)
+	}))
+
+	ch, reqs, err := client.OpenChannel("http", nil)
+	if err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+	go DiscardRequests(reqs)
+	conn := ChannelConn(ch, nil, memAddr{})
+	defer conn.Close()
+
+	req, _ := http.NewRequest("GET", "http://pipe/channel", nil)
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatalf("ReadResponse: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if got, want := string(body), "hello from /channel"; got != want {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+	if conn.RemoteAddr() != (memAddr{}) || conn.LocalAddr() != nil {
+		t.Errorf("got addresses %v and %v", conn.LocalAddr(), conn.RemoteAddr())
+	}
+}
+
+// plainChannel hides the DeadlineChannel methods of a Channel.
+type plainChannel struct {
+	Channel
+}
+
+func TestChannelConnDeadline(t *testing.T) {
+	t.Run("native", func(t *testing.T) {
+		testChannelConnDeadline(t, func(ch Channel) Channel { return ch })
+	})
+	t.Run("emulated", func(t *testing.T) {
+		testChannelConnDeadline(t, func(ch Channel) Channel { return plainChannel{ch} })
+	})
+}
+
+func testChannelConnDeadline(t *testing.T, wrap func(Channel) Channel) {
+	client, chans, cleanup := channelConnPair(t)
+	defer cleanup()
+
+	serverConns := make(chan net.Conn, 1)
+	go func() {
+		newCh := <-chans
+		ch, reqs, err := newCh.Accept()
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			return
+		}
+		go DiscardRequests(reqs)
+		serverConns <- ChannelConn(wrap(ch), nil, nil)
+	}()
+
+	ch, reqs, err := client.OpenChannel("test", nil)
+	if err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+	go DiscardRequests(reqs)
+	conn := ChannelConn(wrap(ch), nil, nil)
+	defer conn.Close()
+	serverConn := <-serverConns
+	defer serverConn.Close()
+
+	// Nothing is sent, so the read times out.
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	buf := make([]byte, 10)
+	_, err = conn.Read(buf)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("got error %v, want a timeout", err)
+	}
+
+	// Extending the deadline of a pending read lets it complete.
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		conn.SetReadDeadline(time.Time{})
+		time.Sleep(100 * time.Millisecond)
+		serverConn.Write([]byte("late"))
+	}()
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "late" {
+		t.Fatalf("got %q, %v, want %q", buf[:n], err, "late")
+	}
+
+	// Data is not lost by a timed out read.
+	conn.SetReadDeadline(time.Now().Add(-time.Second))
+	if _, err := conn.Read(buf); err == nil {
+		t.Fatal("read with a past deadline succeeded")
+	}
+	serverConn.Write([]byte("kept"))
+	conn.SetReadDeadline(time.Time{})
+	n, err = conn.Read(buf)
+	if err != nil || string(buf[:n]) != "kept" {
+		t.Fatalf("got %q, %v, want %q", buf[:n], err, "kept")
+	}
+
+	// The peer does not read, so a write exceeding the window
+	// times out.
+	conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	big := make([]byte, 4*channelWindowSize)
+	_, err = conn.Write(big)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("got error %v, want a timeout", err)
+	}
+}
@@ -34,8 +34,26 @@ const (
 	// length fields do not overflow, so it should remain well
 	// below 4G.
 	maxPacket = 256 * 1024
+
+	// maxExtraPadding is the largest number of bytes of random padding
+	// that writeCipherPacket adds beyond what the cipher requires, so
+	// that the padding length still fits in a single byte.
+	maxExtraPadding = 224
 )
 
+// extraPadding returns the number of padding bytes, beyond those the
+// cipher requires, needed for packet to occupy as much space on the
+// wire as a packet of padTo bytes.
+func extraPadding(packet []byte, padTo int) int {
+	if padTo <= len(packet) {
+		return 0
+	}
+	if extra := padTo - len(packet); extra < maxExtraPadding {
+		return extra
+	}
+	return maxExtraPadding
+}
+
 // noneCipher implements cipher.Stream and provides no encryption. It is used
 // by the transport before the first key-exchange.
 type noneCipher struct{}
@@ -143,7 +161,7 @@ type streamPacketCipher struct {
 	// The following members are to avoid per-packet allocations.
 	prefix      [prefixLen]byte
 	seqNumBytes [4]byte
-	padding     [2 * packetSizeMultiple]byte
+	padding     [2*packetSizeMultiple + maxExtraPadding]byte
 	packetData  []byte
 	macResult   []byte
 }
@@ -221,7 +239,7 @@ func (s *streamPacketCipher) readCipherPacket(seqNum uint32, r io.Reader) ([]byt
 }
 
 // writeCipherPacket encrypts and sends a packet of data to the writer argument
-func (s *streamPacketCipher) writeCipherPacket(seqNum uint32, w io.Writer, rand io.Reader, packet []byte) error {
+func (s *streamPacketCipher) writeCipherPacket(seqNum uint32, w io.Writer, rand io.Reader, packet []byte, padTo int) error {
 	if len(packet) > maxPacket {
 		return errors.New("ssh: packet too large")
 	}
@@ -232,10 +250,12 @@ func (s *streamPacketCipher) writeCipherPacket(seqNum uint32, w io.Writer, rand
 		aadlen = 4
 	}
 
-	paddingLength := packetSizeMultiple - (prefixLen+len(packet)-aadlen)%packetSizeMultiple
+	extra := extraPadding(packet, padTo)
+	paddingLength := packetSizeMultiple - (prefixLen+len(packet)+extra-aadlen)%packetSizeMultiple
 	if paddingLength < 4 {
 		paddingLength += packetSizeMultiple
 	}
+	paddingLength += extra
 
 	length := len(packet) + 1 + paddingLength
 	binary.BigEndian.PutUint32(s.prefix[:], uint32(length))
@@ -326,13 +346,15 @@ func newGCMCipher(key, iv, unusedMacKey []byte, unusedAlgs directionAlgorithms)
 
 const gcmTagSize = 16
 
-func (c *gcmCipher) writeCipherPacket(seqNum uint32, w io.Writer, rand io.Reader, packet []byte) error {
+func (c *gcmCipher) writeCipherPacket(seqNum uint32, w io.Writer, rand io.Reader, packet []byte, padTo int) error {
 	// Pad out to multiple of 16 bytes. This is different from the
 	// stream cipher because that encrypts the length too.
-	padding := byte(packetSizeMultiple - (1+len(packet))%packetSizeMultiple)
+	extra := extraPadding(packet, padTo)
+	padding := byte(packetSizeMultiple - (1+len(packet)+extra)%packetSizeMultiple)
 	if padding < 4 {
 		padding += packetSizeMultiple
 	}
+	padding += byte(extra)
 
 	length := uint32(len(packet) + int(padding) + 1)
 	binary.BigEndian.PutUint32(c.prefix[:], length)
@@ -575,12 +597,12 @@ func (c *cbcCipher) readCipherPacketLeaky(seqNum uint32, r io.Reader) ([]byte, e
 	return c.packetData[prefixLen:paddingStart], nil
 }
 
-func (c *cbcCipher) writeCipherPacket(seqNum uint32, w io.Writer, rand io.Reader, packet []byte) error {
+func (c *cbcCipher) writeCipherPacket(seqNum uint32, w io.Writer, rand io.Reader, packet []byte, padTo int) error {
 	effectiveBlockSize := maxUInt32(cbcMinPacketSizeMultiple, c.encrypter.BlockSize())
 
 	// Length of encrypted portion of the packet (header, payload, padding).
 	// Enforce minimum padding and packet size.
-	encLength := maxUInt32(prefixLen+len(packet)+cbcMinPaddingSize, cbcMinPaddingSize)
+	encLength := maxUInt32(prefixLen+len(packet)+extraPadding(packet, padTo)+cbcMinPaddingSize, cbcMinPaddingSize)
 	// Enforce block size.
 	encLength = (encLength + effectiveBlockSize - 1) / effectiveBlockSize * effectiveBlockSize
 
@@ -726,7 +748,7 @@ func (c *chacha20Poly1305Cipher) readCipherPacket(seqNum uint32, r io.Reader) ([
 	return plain, nil
 }
 
-func (c *chacha20Poly1305Cipher) writeCipherPacket(seqNum uint32, w io.Writer, rand io.Reader, payload []byte) error {
+func (c *chacha20Poly1305Cipher) writeCipherPacket(seqNum uint32, w io.Writer, rand io.Reader, payload []byte, padTo int) error {
 	nonce := make([]byte, 12)
 	binary.BigEndian.PutUint32(nonce[8:], seqNum)
 	s, err := chacha20.NewUnauthenticatedCipher(c.contentKey[:], nonce)
@@ -741,10 +763,12 @@ func (c *chacha20Poly1305Cipher) writeCipherPacket(seqNum uint32, w io.Writer, r
 	// padding, as described in RFC 4253, Sec 6.
 	const packetSizeMultiple = 8
 
-	padding := packetSizeMultiple - (1+len(payload))%packetSizeMultiple
+	extra := extraPadding(payload, padTo)
+	padding := packetSizeMultiple - (1+len(payload)+extra)%packetSizeMultiple
 	if padding < 4 {
 		padding += packetSizeMultiple
 	}
+	padding += extra
 
 	// size (4 bytes), padding (1), payload, padding, tag.
 	totalLength := 4 + 1 + len(payload) + padding + poly1305.TagSize
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/rand"
+	"strings"
 	"testing"
 )
 
@@ -24,6 +25,100 @@ func TestDefaultCiphersExist(t *testing.T) {
 	}
 }
 
+func TestPreferredCipherOrder(t *testing.T) {
+	if got := preferredCipherOrder(true)[0]; got != gcmCipherID {
+		t.Errorf("with AES-GCM hardware, got %q first, want %q", got, gcmCipherID)
+	}
+	if got := preferredCipherOrder(false)[0]; got != chacha20Poly1305ID {
+		t.Errorf("without AES-GCM hardware, got %q first, want %q", got, chacha20Poly1305ID)
+	}
+}
+
+func TestCipherNegotiation(t *testing.T) {
+	all := []string{gcmCipherID, chacha20Poly1305ID, "aes128-ctr"}
+	for _, tt := range []struct {
+		client, server []string
+		want           string
+	}{
+		{[]string{chacha20Poly1305ID, gcmCipherID}, all, chacha20Poly1305ID},
+		{[]string{gcmCipherID, chacha20Poly1305ID}, all, gcmCipherID},
+		// The client's order wins over the server's.
+		{[]string{"aes128-ctr", gcmCipherID}, []string{gcmCipherID, "aes128-ctr"}, "aes128-ctr"},
+		// Ciphers the server does not support are skipped.
+		{[]string{"aes256-ctr", chacha20Poly1305ID}, all, chacha20Poly1305ID},
+	} {
+		serverConf := testServerConfig()
+		serverConf.Ciphers = tt.server
+		clientConf := testClientConfig()
+		clientConf.Ciphers = tt.client
+
+		client, server, chans, reqs := pipePair(t, serverConf, clientConf)
+		go DiscardRequests(reqs)
+		go func() {
+			for newCh := range chans {
+				newCh.Reject(Prohibited, "")
+			}
+		}()
+		c := client.Conn.(AlgorithmsConn).Algorithms()
+		s := server.Conn.(AlgorithmsConn).Algorithms()
+		if c.Write.Cipher != tt.want || c.Read.Cipher != tt.want {
+			t.Errorf("client %v, server %v: client got ciphers %q and %q, want %q", tt.client, tt.server, c.Write.Cipher, c.Read.Cipher, tt.want)
+		}
+		if s.Read != c.Write || s.Write != c.Read || s.KeyExchange != c.KeyExchange || s.HostKey != c.HostKey {
+			t.Errorf("client algorithms %+v do not match server algorithms %+v", c, s)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestMACNegotiation(t *testing.T) {
+	for _, tt := range []struct {
+		client, server []string
+		want           string
+		etm            bool
+	}{
+		// Both peers prefer an encrypt-then-MAC variant by default.
+		{nil, nil, "hmac-sha2-256-etm@openssh.com", true},
+		{nil, []string{"hmac-sha2-256", "hmac-sha2-512-etm@openssh.com"}, "hmac-sha2-512-etm@openssh.com", true},
+		{[]string{"hmac-sha2-512", "hmac-sha2-256-etm@openssh.com"}, nil, "hmac-sha2-512", false},
+		{nil, []string{"hmac-sha1"}, "hmac-sha1", false},
+	} {
+		serverConf := testServerConfig()
+		serverConf.Ciphers = []string{"aes128-ctr"}
+		serverConf.MACs = tt.server
+		clientConf := testClientConfig()
+		clientConf.Ciphers = []string{"aes128-ctr"}
+		clientConf.MACs = tt.client
+
+		client, server, chans, reqs := pipePair(t, serverConf, clientConf)
+		go DiscardRequests(reqs)
+		go func() {
+			for newCh := range chans {
+				newCh.Reject(Prohibited, "")
+			}
+		}()
+		for _, algs := range []NegotiatedAlgorithms{
+			client.Conn.(AlgorithmsConn).Algorithms(),
+			server.Conn.(AlgorithmsConn).Algorithms(),
+		} {
+			for _, dir := range []DirectionAlgorithms{algs.Read, algs.Write} {
+				if dir.MAC != tt.want || dir.EncryptThenMAC() != tt.etm {
+					t.Errorf("client %v, server %v: got MAC %q, encrypt-then-MAC %v, want %q, %v", tt.client, tt.server, dir.MAC, dir.EncryptThenMAC(), tt.want, tt.etm)
+				}
+			}
+		}
+		client.Close()
+		server.Close()
+	}
+
+	for _, cipher := range []string{gcmCipherID, chacha20Poly1305ID} {
+		if algs := (DirectionAlgorithms{Cipher: cipher, MAC: "hmac-sha1"}); !algs.EncryptThenMAC() {
+			t.Errorf("%+v: EncryptThenMAC = false for an AEAD cipher", algs)
+		}
+	}
+}
+
 func TestPacketCiphers(t *testing.T) {
 	defaultMac := "hmac-sha2-256"
 	defaultCipher := "aes128-ctr"
@@ -56,7 +151,7 @@ func testPacketCipher(t *testing.T, cipher, mac string) {
 	want := "bla bla"
 	input := []byte(want)
 	buf := &bytes.Buffer{}
-	if err := client.writeCipherPacket(0, buf, rand.Reader, input); err != nil {
+	if err := client.writeCipherPacket(0, buf, rand.Reader, input, 0); err != nil {
 		t.Fatalf("writeCipherPacket(%q, %q): %v", cipher, mac, err)
 	}
 
@@ -70,6 +165,47 @@ func testPacketCipher(t *testing.T, cipher, mac string) {
 	}
 }
 
+func TestPacketCipherPadding(t *testing.T) {
+	kr := &kexResult{Hash: crypto.SHA1}
+	for cipher := range cipherModes {
+		algs := directionAlgorithms{
+			Cipher:      cipher,
+			MAC:         "hmac-sha2-256",
+			Compression: "none",
+		}
+		client, err := newPacketCipher(clientKeys, algs, kr)
+		if err != nil {
+			t.Fatalf("newPacketCipher(client, %q): %v", cipher, err)
+		}
+		server, err := newPacketCipher(clientKeys, algs, kr)
+		if err != nil {
+			t.Fatalf("newPacketCipher(server, %q): %v", cipher, err)
+		}
+
+		const padTo = 9 + 64
+		wantLen := -1
+		for i, want := range []string{"a", "ab", "abcdefgh", strings.Repeat("x", 63)} {
+			buf := &bytes.Buffer{}
+			input := append(make([]byte, 9), want...)
+			if err := client.writeCipherPacket(uint32(i), buf, rand.Reader, input, padTo); err != nil {
+				t.Fatalf("writeCipherPacket(%q): %v", cipher, err)
+			}
+			if wantLen < 0 {
+				wantLen = buf.Len()
+			} else if buf.Len() != wantLen {
+				t.Errorf("%s: packet with %d bytes of data is %d bytes on the wire, want %d", cipher, len(want), buf.Len(), wantLen)
+			}
+			packet, err := server.readCipherPacket(uint32(i), buf)
+			if err != nil {
+				t.Fatalf("readCipherPacket(%q): %v", cipher, err)
+			}
+			if string(packet[9:]) != want {
+				t.Errorf("roundtrip(%q): got %q, want %q", cipher, packet[9:], want)
+			}
+		}
+	}
+}
+
 func TestCBCOracleCounterMeasure(t *testing.T) {
 	kr := &kexResult{Hash: crypto.SHA1}
 	algs := directionAlgorithms{
@@ -85,7 +221,7 @@ func TestCBCOracleCounterMeasure(t *testing.T) {
 	want := "bla bla"
 	input := []byte(want)
 	buf := &bytes.Buffer{}
-	if err := client.writeCipherPacket(0, buf, rand.Reader, input); err != nil {
+	if err := client.writeCipherPacket(0, buf, rand.Reader, input, 0); err != nil {
 		t.Errorf("writeCipherPacket: %v", err)
 	}
 
@@ -129,3 +265,40 @@ func TestCBCOracleCounterMeasure(t *testing.T) {
 		lastRead = bytesRead
 	}
 }
+
+// BenchmarkPacketCipher compares the AEAD ciphers. AES-GCM is faster on
+// CPUs with AES and carry-less multiplication instructions, and
+// ChaCha20-Poly1305 on others; see preferredCipherOrder.
+func BenchmarkPacketCipher(b *testing.B) {
+	kr := &kexResult{Hash: crypto.SHA256}
+	payload := make([]byte, 32*1024)
+	for _, cipher := range []string{gcmCipherID, chacha20Poly1305ID, "aes128-ctr"} {
+		b.Run(cipher, func(b *testing.B) {
+			algs := directionAlgorithms{
+				Cipher:      cipher,
+				MAC:         "hmac-sha2-256-etm@openssh.com",
+				Compression: compressionNone,
+			}
+			w, err := newPacketCipher(clientKeys, algs, kr)
+			if err != nil {
+				b.Fatalf("newPacketCipher: %v", err)
+			}
+			r, err := newPacketCipher(clientKeys, algs, kr)
+			if err != nil {
+				b.Fatalf("newPacketCipher: %v", err)
+			}
+			var buf bytes.Buffer
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				if err := w.writeCipherPacket(uint32(i), &buf, rand.Reader, payload, 0); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := r.readCipherPacket(uint32(i), &buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -6,10 +6,12 @@ package ssh
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -49,6 +51,31 @@ func (c *Client) HandleChannelOpen(channelType string) <-chan NewChannel {
 	return ch
 }
 
+// HandleChannelType calls handler, in a new goroutine, with each
+// channel of the given type that the remote side opens. It returns an
+// error if the type already is being handled, by HandleChannelType or
+// HandleChannelOpen, and net.ErrClosed if the connection is closed.
+// Channels of the types that are not handled are rejected with
+// UnknownChannelType.
+func (c *Client) HandleChannelType(channelType string, handler func(NewChannel)) error {
+	c.mu.Lock()
+	closed := c.channelHandlers == nil
+	c.mu.Unlock()
+	if closed {
+		return net.ErrClosed
+	}
+	in := c.HandleChannelOpen(channelType)
+	if in == nil {
+		return fmt.Errorf("ssh: channel type %q is already being handled", channelType)
+	}
+	go func() {
+		for ch := range in {
+			go handler(ch)
+		}
+	}()
+	return nil
+}
+
 // NewClient creates a Client on top of the given connection.
 func NewClient(c Conn, chans <-chan NewChannel, reqs <-chan *Request) *Client {
 	conn := &Client{
@@ -80,11 +107,23 @@ func NewClientConn(c net.Conn, addr string, config *ClientConfig) (Conn, <-chan
 		sshConn: sshConn{conn: c, user: fullConf.User},
 	}
 
+	timedOut := startHandshakeTimer(c, fullConf.HandshakeTimeout)
 	if err := conn.clientHandshake(addr, &fullConf); err != nil {
 		c.Close()
+		if timedOut() {
+			return nil, nil, nil, errHandshakeTimeout
+		}
 		return nil, nil, nil, fmt.Errorf("ssh: handshake failed: %v", err)
 	}
-	conn.mux = newMux(conn.transport)
+	if timedOut() {
+		c.Close()
+		return nil, nil, nil, errHandshakeTimeout
+	}
+	conn.mux = newMuxWithOptions(conn.transport, muxOptions{
+		maxPendingGlobalRequests: fullConf.MaxPendingGlobalRequests,
+		noAgentForwarding:        fullConf.DisableAgentForwarding,
+	})
+	conn.limitLifetime(fullConf.MaxLifetime)
 	return conn, conn.mux.incomingChannels, conn.mux.incomingRequests, nil
 }
 
@@ -96,14 +135,19 @@ func (c *connection) clientHandshake(dialAddress string, config *ClientConfig) e
 	} else {
 		c.clientVersion = []byte(packageVersion)
 	}
+	var preamble []string
 	var err error
-	c.serverVersion, err = exchangeVersions(c.sshConn.conn, c.clientVersion)
+	c.serverVersion, preamble, err = exchangeVersionsPreamble(c.sshConn.conn, c.clientVersion, config.MaxBannerLines)
 	if err != nil {
 		return err
 	}
+	if err := config.displayPreamble(preamble); err != nil {
+		return err
+	}
 
-	c.transport = newClientTransport(
-		newTransport(c.sshConn.conn, config.Rand, true /* is client */),
+	tr := newTransport(c.sshConn.conn, config.Rand, true /* is client */)
+	c.traffic = tr.traffic
+	c.transport = newClientTransport(tr,
 		c.clientVersion, c.serverVersion, config, dialAddress, c.sshConn.RemoteAddr())
 	if err := c.transport.waitSession(); err != nil {
 		return err
@@ -113,6 +157,22 @@ func (c *connection) clientHandshake(dialAddress string, config *ClientConfig) e
 	return c.clientAuthenticate(config)
 }
 
+// displayPreamble passes the lines the server sent before its version
+// string to the banner callback.
+func (c *ClientConfig) displayPreamble(preamble []string) error {
+	if len(preamble) == 0 {
+		return nil
+	}
+	message := strings.Join(preamble, "\n") + "\n"
+	switch {
+	case c.BannerLanguageCallback != nil:
+		return c.BannerLanguageCallback(message, "")
+	case c.BannerCallback != nil:
+		return c.BannerCallback(message)
+	}
+	return nil
+}
+
 // verifyHostKeySignature verifies the host key obtained in the key
 // exchange.
 func verifyHostKeySignature(hostKey PublicKey, result *kexResult) error {
@@ -134,6 +194,62 @@ func (c *Client) NewSession() (*Session, error) {
 	return newSession(ch, in)
 }
 
+// runGracePeriod is how long Run waits for the command to exit after
+// each step of the escalation of Session.RunContext.
+const runGracePeriod = time.Second
+
+// Run runs cmd on the remote host in a new session, and returns its
+// combined standard output and standard error. The session requests
+// no pseudo-terminal, and is closed before Run returns. The error is
+// as for Session.Run. If ctx is done before the command exits, Run
+// terminates it as Session.RunContext does, with a grace period of a
+// second; it then returns the output received so far and the error
+// of ctx.
+func (c *Client) Run(ctx context.Context, cmd string) ([]byte, error) {
+	s, err := c.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	var b singleWriter
+	s.Stdout = &b
+	s.Stderr = &b
+	err = s.RunContext(ctx, cmd, runGracePeriod)
+	return b.bytes(), err
+}
+
+// Ping sends a keepalive@openssh.com global request to the server and
+// returns the time until its reply. Servers reply to it with a failure,
+// as they do not implement it, which is not an error. Ping can be
+// called concurrently; the replies to concurrent calls arrive in order.
+// If ctx is done before the reply arrives, Ping returns the error of
+// ctx.
+func (c *Client) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	if sender, ok := c.Conn.(ContextRequestSender); ok {
+		if _, _, err := sender.SendRequestContext(ctx, "keepalive@openssh.com", true, nil); err != nil {
+			return 0, err
+		}
+		return time.Since(start), nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := c.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return 0, err
+		}
+		return time.Since(start), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
 func (c *Client) handleGlobalRequests(incoming <-chan *Request) {
 	for r := range incoming {
 		// This handles keepalive messages and matches
@@ -170,7 +286,8 @@ func (c *Client) handleChannelOpens(in <-chan NewChannel) {
 // to incoming channels and requests, use net.Dial with NewClientConn
 // instead.
 func Dial(network, addr string, config *ClientConfig) (*Client, error) {
-	conn, err := net.DialTimeout(network, addr, config.Timeout)
+	dialer := net.Dialer{Timeout: config.Timeout, KeepAlive: config.TCPKeepAlive}
+	conn, err := dialer.Dial(network, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -192,6 +309,11 @@ type HostKeyCallback func(hostname string, remote net.Addr, key PublicKey) error
 // the server. A BannerCallback receives the message sent by the remote server.
 type BannerCallback func(message string) error
 
+// BannerLanguageCallback is like BannerCallback, but also receives the
+// language tag of the banner, as defined in RFC 3066. The tag is often
+// empty.
+type BannerLanguageCallback func(message, language string) error
+
 // A ClientConfig structure is used to configure a Client. It must not be
 // modified after having been passed to an SSH function.
 type ClientConfig struct {
@@ -207,6 +329,23 @@ type ClientConfig struct {
 	// be used during authentication.
 	Auth []AuthMethod
 
+	// SkipNoneAuth, if true, makes the client start authentication
+	// with the first method in Auth, instead of first sending a
+	// "none" request to learn which methods the server accepts. The
+	// "none" request is still sent if the server's methods are
+	// needed but unknown. Some servers log the "none" request as a
+	// failed login attempt.
+	SkipNoneAuth bool
+
+	// RequireSingleMethod, if true, makes the client try at most one
+	// of the methods in Auth, the first that the server accepts:
+	// if it fails, or the server asks for another method after it,
+	// authentication fails instead of going on with the next
+	// method. This ensures, for instance, that a password is never
+	// sent when authentication with a certificate fails. The "none"
+	// request that learns the methods of the server is not counted.
+	RequireSingleMethod bool
+
 	// HostKeyCallback is called during the cryptographic
 	// handshake to validate the server's host key. The client
 	// configuration must supply this callback for the connection
@@ -214,12 +353,40 @@ type ClientConfig struct {
 	// FixedHostKey can be used for simplistic host key checks.
 	HostKeyCallback HostKeyCallback
 
+	// MinRSAKeySize, if positive, is the minimum size in bits of an
+	// RSA host key, or of the key of an RSA host certificate.
+	// Servers with smaller keys are rejected before
+	// HostKeyCallback is called. Unlike ServerConfig.MinRSAKeySize,
+	// there is no minimum by default, so that clients can still
+	// reach servers that have not replaced their old host keys.
+	MinRSAKeySize int
+
 	// BannerCallback is called during the SSH dance to display a custom
 	// server's message. The client configuration can supply this callback to
 	// handle it as wished. The function BannerDisplayStderr can be used for
-	// simplistic display on Stderr.
+	// simplistic display on Stderr. It is called for each banner, in
+	// the order they are received; a server may send several.
 	BannerCallback BannerCallback
 
+	// BannerLanguageCallback, if non-nil, is called instead of
+	// BannerCallback, with the language tag of each banner.
+	BannerLanguageCallback BannerLanguageCallback
+
+	// MaxBannerLines is the number of lines the server may send
+	// before its version string, which some servers and middleboxes
+	// use for messages. If zero, 32 lines are accepted; if negative,
+	// none are. Such lines are not authenticated: they are passed to
+	// BannerCallback or BannerLanguageCallback, with an empty
+	// language tag, as a single message, before the key exchange.
+	MaxBannerLines int
+
+	// OnExtInfo, if non-nil, is called with the extensions of RFC
+	// 8308 that the server advertises with their values, including
+	// server-sig-algs, if it sends an extension info message after
+	// the first key exchange. It is called from the goroutine that
+	// reads packets, which it must not block.
+	OnExtInfo func(exts map[string][]byte)
+
 	// ClientVersion contains the version identification string that will
 	// be used for the connection. If empty, a reasonable default is used.
 	ClientVersion string
@@ -235,6 +402,42 @@ type ClientConfig struct {
 	//
 	// A Timeout of zero means no timeout.
 	Timeout time.Duration
+
+	// TCPKeepAlive is the interval between the TCP keep-alive
+	// probes of the connection established by Dial, and the time it
+	// must be idle before the first, as for net.Dialer.KeepAlive:
+	// if zero, the default of package net is used, and if
+	// negative, keep-alives are disabled. The channels of the
+	// connection, including those of forwarded connections, have no
+	// socket of their own, so this also detects dead peers for
+	// them.
+	TCPKeepAlive time.Duration
+
+	// HandshakeTimeout is the maximum amount of time for the version
+	// exchange, key exchange and authentication to complete once
+	// NewClientConn is called. If it is exceeded, the connection is
+	// closed and an error whose Timeout method returns true is
+	// returned. It does not apply to the established connection.
+	//
+	// A HandshakeTimeout of zero means no timeout.
+	HandshakeTimeout time.Duration
+
+	// DisableAgentForwarding, if true, guarantees that the
+	// connection never forwards the agent, whatever the code using
+	// it does: sending an "auth-agent-req@openssh.com" request
+	// fails, and the "auth-agent@openssh.com" channels the server
+	// opens are rejected.
+	DisableAgentForwarding bool
+
+	// PipelineAuth, if true, makes the client send its first
+	// authentication request right after requesting the
+	// authentication service, without waiting for the server to
+	// accept it, which saves a round trip. Servers process the
+	// packets in order, so this is compatible with the protocol,
+	// but a server that rejects the service still receives the
+	// first request, which may hold a public key or, if SkipNoneAuth
+	// is set, a password.
+	PipelineAuth bool
 }
 
 // InsecureIgnoreHostKey returns a function that can be used for
@@ -267,6 +470,75 @@ func FixedHostKey(key PublicKey) HostKeyCallback {
 	return hk.check
 }
 
+// HostKeyCallbacksError is returned by the callback of
+// CombineHostKeyCallbacks when all the combined callbacks reject a
+// host key.
+type HostKeyCallbacksError struct {
+	// Errors are the errors of the callbacks, in order. Their
+	// types tell why each rejected the key, for example a
+	// *knownhosts.KeyError.
+	Errors []error
+}
+
+func (e *HostKeyCallbacksError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return "ssh: host key rejected: " + strings.Join(msgs, "; ")
+}
+
+// Is reports whether one of the errors of the callbacks matches target,
+// for errors.Is.
+func (e *HostKeyCallbacksError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors of the callbacks that matches
+// target, for errors.As.
+func (e *HostKeyCallbacksError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unwrap returns the errors of the callbacks.
+func (e *HostKeyCallbacksError) Unwrap() []error {
+	return e.Errors
+}
+
+// CombineHostKeyCallbacks returns a HostKeyCallback that accepts a host
+// key if any of callbacks does, such as one for a known_hosts file and
+// the CheckHostKey method of a CertChecker. The callbacks are called in
+// order until one accepts the key. If all of them reject it, the error
+// is a *HostKeyCallbacksError. Since one acceptance is enough, a key
+// revoked in only one of the sources, such as by a @revoked line of a
+// known_hosts file, is still accepted by the others.
+func CombineHostKeyCallbacks(callbacks ...HostKeyCallback) HostKeyCallback {
+	return func(hostname string, remote net.Addr, key PublicKey) error {
+		if len(callbacks) == 0 {
+			return errors.New("ssh: no host key callbacks to combine")
+		}
+		var errs []error
+		for _, cb := range callbacks {
+			err := cb(hostname, remote, key)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err)
+		}
+		return &HostKeyCallbacksError{Errors: errs}
+	}
+}
+
 // BannerDisplayStderr returns a function that can be used for
 // ClientConfig.BannerCallback to display banners on os.Stderr.
 func BannerDisplayStderr() BannerCallback {
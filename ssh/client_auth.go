@@ -19,18 +19,28 @@ const (
 	authSuccess
 )
 
+func (r authResult) String() string {
+	switch r {
+	case authFailure:
+		return "failure"
+	case authPartialSuccess:
+		return "partial success"
+	case authSuccess:
+		return "success"
+	}
+	return fmt.Sprintf("authResult(%d)", int(r))
+}
+
 // clientAuthenticate authenticates with the remote server. See RFC 4252.
 func (c *connection) clientAuthenticate(config *ClientConfig) error {
 	// initiate user auth session
 	if err := c.transport.writePacket(Marshal(&serviceRequestMsg{serviceUserAuth})); err != nil {
 		return err
 	}
-	packet, err := c.transport.readPacket()
-	if err != nil {
-		return err
-	}
-	var serviceAccept serviceAcceptMsg
-	if err := Unmarshal(packet, &serviceAccept); err != nil {
+	var transport packetConn = c.transport
+	if config.PipelineAuth {
+		transport = &pipelinedAuthConn{packetConn: c.transport}
+	} else if err := readServiceAccept(c.transport); err != nil {
 		return err
 	}
 
@@ -39,15 +49,26 @@ func (c *connection) clientAuthenticate(config *ClientConfig) error {
 	var tried []string
 	var lastMethods []string
 
+	first := AuthMethod(new(noneAuth))
+	probed := true
+	if config.SkipNoneAuth && len(config.Auth) > 0 {
+		first = config.Auth[0]
+		probed = false
+	}
+
 	sessionID := c.transport.getSessionID()
-	for auth := AuthMethod(new(noneAuth)); auth != nil; {
-		ok, methods, err := auth.auth(sessionID, config.User, c.transport, config.Rand)
+	for auth := first; auth != nil; {
+		ok, methods, err := auth.auth(sessionID, config.User, transport, config.Rand)
 		if err != nil {
+			config.log(LogLevelWarn, "ssh: authentication error", "user", config.User, "method", auth.method(), "error", err)
 			return err
 		}
+		config.log(LogLevelInfo, "ssh: authentication attempt", "user", config.User, "method", auth.method(), "result", ok.String())
 		if ok == authSuccess {
 			// success
 			return nil
+		} else if config.RequireSingleMethod && auth.method() != "none" {
+			return fmt.Errorf("ssh: authentication with method %s resulted in %s, and RequireSingleMethod forbids trying another", auth.method(), ok)
 		} else if ok == authFailure {
 			if m := auth.method(); !contains(tried, m) {
 				tried = append(tried, m)
@@ -59,6 +80,13 @@ func (c *connection) clientAuthenticate(config *ClientConfig) error {
 		lastMethods = methods
 
 		auth = nil
+		if methods == nil && !probed {
+			// The server has not told us which methods it
+			// accepts yet, so ask it.
+			auth = new(noneAuth)
+			probed = true
+			continue
+		}
 
 	findNext:
 		for _, a := range config.Auth {
@@ -77,6 +105,42 @@ func (c *connection) clientAuthenticate(config *ClientConfig) error {
 	return fmt.Errorf("ssh: unable to authenticate, attempted methods %v, no supported methods remain", tried)
 }
 
+func readServiceAccept(c packetConn) error {
+	packet, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	var serviceAccept serviceAcceptMsg
+	return Unmarshal(packet, &serviceAccept)
+}
+
+// pipelinedAuthConn reads the answer to the service request before the
+// first packet, for ClientConfig.PipelineAuth.
+type pipelinedAuthConn struct {
+	packetConn
+	accepted bool
+}
+
+func (c *pipelinedAuthConn) readPacket() ([]byte, error) {
+	if !c.accepted {
+		if err := readServiceAccept(c.packetConn); err != nil {
+			return nil, err
+		}
+		c.accepted = true
+	}
+	return c.packetConn.readPacket()
+}
+
+// authTransport returns the handshakeTransport under the packetConn
+// passed to an AuthMethod, if any.
+func authTransport(c packetConn) (*handshakeTransport, bool) {
+	if p, ok := c.(*pipelinedAuthConn); ok {
+		c = p.packetConn
+	}
+	t, ok := c.(*handshakeTransport)
+	return t, ok
+}
+
 func contains(list []string, e string) bool {
 	for _, s := range list {
 		if s == e {
@@ -201,7 +265,9 @@ func (cb publicKeyCallback) auth(session []byte, user string, c packetConn, rand
 	}
 	var methods []string
 	for _, signer := range signers {
-		ok, err := validateKey(signer.PublicKey(), user, c)
+		pub := signer.PublicKey()
+		algo := pickSignatureAlgorithm(signer, c)
+		ok, err := validateKey(pub, algo, user, c)
 		if err != nil {
 			return authFailure, nil, err
 		}
@@ -209,13 +275,18 @@ func (cb publicKeyCallback) auth(session []byte, user string, c packetConn, rand
 			continue
 		}
 
-		pub := signer.PublicKey()
 		pubKey := pub.Marshal()
-		sign, err := signer.Sign(rand, buildDataSignedForAuth(session, userAuthRequestMsg{
+		data := buildDataSignedForAuth(session, userAuthRequestMsg{
 			User:    user,
 			Service: serviceSSH,
 			Method:  cb.method(),
-		}, []byte(pub.Type()), pubKey))
+		}, []byte(algo), pubKey)
+		var sign *Signature
+		if algo != pub.Type() {
+			sign, err = signer.(AlgorithmSigner).SignWithAlgorithm(rand, data, algo)
+		} else {
+			sign, err = signer.Sign(rand, data)
+		}
 		if err != nil {
 			return authFailure, nil, err
 		}
@@ -229,7 +300,7 @@ func (cb publicKeyCallback) auth(session []byte, user string, c packetConn, rand
 			Service:  serviceSSH,
 			Method:   cb.method(),
 			HasSig:   true,
-			Algoname: pub.Type(),
+			Algoname: algo,
 			PubKey:   pubKey,
 			Sig:      sig,
 		}
@@ -265,38 +336,34 @@ func containsMethod(methods []string, method string) bool {
 	return false
 }
 
-// validateKey validates the key provided is acceptable to the server.
-func validateKey(key PublicKey, user string, c packetConn) (bool, error) {
+// validateKey validates the key provided is acceptable to the server,
+// with the signature algorithm algo.
+func validateKey(key PublicKey, algo, user string, c packetConn) (bool, error) {
 	pubKey := key.Marshal()
 	msg := publickeyAuthMsg{
 		User:     user,
 		Service:  serviceSSH,
 		Method:   "publickey",
 		HasSig:   false,
-		Algoname: key.Type(),
+		Algoname: algo,
 		PubKey:   pubKey,
 	}
 	if err := c.writePacket(Marshal(&msg)); err != nil {
 		return false, err
 	}
 
-	return confirmKeyAck(key, c)
+	return confirmKeyAck(key, algo, c)
 }
 
-func confirmKeyAck(key PublicKey, c packetConn) (bool, error) {
+func confirmKeyAck(key PublicKey, algoname string, c packetConn) (bool, error) {
 	pubKey := key.Marshal()
-	algoname := key.Type()
 
 	for {
-		packet, err := c.readPacket()
+		packet, err := readAuthPacket(c)
 		if err != nil {
 			return false, err
 		}
 		switch packet[0] {
-		case msgUserAuthBanner:
-			if err := handleBannerResponse(c, packet); err != nil {
-				return false, err
-			}
 		case msgUserAuthPubKeyOk:
 			var msg userAuthPubKeyOkMsg
 			if err := Unmarshal(packet, &msg); err != nil {
@@ -331,16 +398,12 @@ func PublicKeysCallback(getSigners func() (signers []Signer, err error)) AuthMet
 // an error if an unexpected response was received.
 func handleAuthResponse(c packetConn) (authResult, []string, error) {
 	for {
-		packet, err := c.readPacket()
+		packet, err := readAuthPacket(c)
 		if err != nil {
 			return authFailure, nil, err
 		}
 
 		switch packet[0] {
-		case msgUserAuthBanner:
-			if err := handleBannerResponse(c, packet); err != nil {
-				return authFailure, nil, err
-			}
 		case msgUserAuthFailure:
 			var msg userAuthFailureMsg
 			if err := Unmarshal(packet, &msg); err != nil {
@@ -358,19 +421,38 @@ func handleAuthResponse(c packetConn) (authResult, []string, error) {
 	}
 }
 
+// readAuthPacket reads the next packet of the user authentication
+// dance. The server may send banners at any point before the
+// authentication succeeds; they are passed to the banner callback, in
+// order, and skipped.
+func readAuthPacket(c packetConn) ([]byte, error) {
+	for {
+		packet, err := c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		if packet[0] != msgUserAuthBanner {
+			return packet, nil
+		}
+		if err := handleBannerResponse(c, packet); err != nil {
+			return nil, err
+		}
+	}
+}
+
 func handleBannerResponse(c packetConn, packet []byte) error {
 	var msg userAuthBannerMsg
 	if err := Unmarshal(packet, &msg); err != nil {
 		return err
 	}
 
-	transport, ok := c.(*handshakeTransport)
+	transport, ok := authTransport(c)
 	if !ok {
 		return nil
 	}
 
 	if transport.bannerCallback != nil {
-		return transport.bannerCallback(msg.Message)
+		return transport.bannerCallback(msg.Message, msg.Language)
 	}
 
 	return nil
@@ -413,18 +495,13 @@ func (cb KeyboardInteractiveChallenge) auth(session []byte, user string, c packe
 	}
 
 	for {
-		packet, err := c.readPacket()
+		packet, err := readAuthPacket(c)
 		if err != nil {
 			return authFailure, nil, err
 		}
 
 		// like handleAuthResponse, but with less options.
 		switch packet[0] {
-		case msgUserAuthBanner:
-			if err := handleBannerResponse(c, packet); err != nil {
-				return authFailure, nil, err
-			}
-			continue
 		case msgUserAuthInfoRequest:
 			// OK
 		case msgUserAuthFailure:
@@ -561,7 +638,7 @@ func (g *gssAPIWithMICCallback) auth(session []byte, user string, c packetConn,
 	// See RFC 4462 section 3.3.
 	// OpenSSH supports Kerberos V5 mechanism only for GSS-API authentication,so I don't want to check
 	// selected mech if it is valid.
-	packet, err := c.readPacket()
+	packet, err := readAuthPacket(c)
 	if err != nil {
 		return authFailure, nil, err
 	}
@@ -589,7 +666,7 @@ func (g *gssAPIWithMICCallback) auth(session []byte, user string, c packetConn,
 		if !needContinue {
 			break
 		}
-		packet, err = c.readPacket()
+		packet, err = readAuthPacket(c)
 		if err != nil {
 			return authFailure, nil, err
 		}
@@ -7,13 +7,16 @@ package ssh
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/rsa"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -117,6 +120,28 @@ func TestClientAuthPublicKey(t *testing.T) {
 	}
 }
 
+func TestClientAuthPipelined(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		config  ClientConfig
+		wantErr bool
+	}{
+		{"publickey", ClientConfig{Auth: []AuthMethod{PublicKeys(testSigners["rsa"])}}, false},
+		{"password", ClientConfig{Auth: []AuthMethod{Password(clientPassword)}, SkipNoneAuth: true}, false},
+		{"wrong password", ClientConfig{Auth: []AuthMethod{Password("wrong")}, SkipNoneAuth: true}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.User = "testuser"
+			config.HostKeyCallback = InsecureIgnoreHostKey()
+			config.PipelineAuth = true
+			if err := tryAuth(t, &config); (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestAuthMethodPassword(t *testing.T) {
 	config := &ClientConfig{
 		User: "testuser",
@@ -170,6 +195,43 @@ func TestAuthMethodWrongPassword(t *testing.T) {
 	}
 }
 
+func TestAuthRequireSingleMethod(t *testing.T) {
+	for _, single := range []bool{false, true} {
+		var passwordCalled bool
+		config := &ClientConfig{
+			User: "testuser",
+			Auth: []AuthMethod{
+				// The server does not accept this key.
+				PublicKeys(testSigners["ed25519"]),
+				PasswordCallback(func() (string, error) {
+					passwordCalled = true
+					return clientPassword, nil
+				}),
+			},
+			HostKeyCallback:     InsecureIgnoreHostKey(),
+			RequireSingleMethod: single,
+		}
+		err, serverErrors := tryAuthBothSides(t, config, nil)
+		if !single {
+			if err != nil || !passwordCalled {
+				t.Errorf("without RequireSingleMethod: got %v, password tried %v, want success with the password", err, passwordCalled)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "RequireSingleMethod") {
+			t.Errorf("got error %v, want one for RequireSingleMethod", err)
+		}
+		if passwordCalled {
+			t.Error("the password was asked for after public key authentication failed")
+		}
+		for _, err := range serverErrors {
+			if strings.Contains(err.Error(), "password") {
+				t.Errorf("server got a password attempt: %v", err)
+			}
+		}
+	}
+}
+
 func TestAuthMethodKeyboardInteractive(t *testing.T) {
 	answers := keyboardInteractive(map[string]string{
 		"question1": "answer1",
@@ -547,6 +609,68 @@ func TestClientAuthNone(t *testing.T) {
 	}
 }
 
+func TestClientAuthSkipNoneAuth(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		auth        []AuthMethod
+		wantMethods []string
+	}{
+		{
+			name:        "password",
+			auth:        []AuthMethod{Password("tiger")},
+			wantMethods: []string{"password"},
+		},
+		{
+			name:        "fallback after wrong password",
+			auth:        []AuthMethod{Password("wrong"), PublicKeys(testSigners["rsa"])},
+			wantMethods: []string{"password", "publickey"},
+		},
+		{
+			// The rejected key does not reveal the methods, so
+			// the client has to ask for them.
+			name:        "probe after rejected key",
+			auth:        []AuthMethod{PublicKeys(testSigners["ecdsa"]), Password("tiger")},
+			wantMethods: []string{"publickey", "none", "password"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			methods := make(chan string, 10)
+			serverConfig := &ServerConfig{
+				PasswordCallback: func(conn ConnMetadata, pass []byte) (*Permissions, error) {
+					if string(pass) == "tiger" {
+						return nil, nil
+					}
+					return nil, errors.New("password auth failed")
+				},
+				PublicKeyCallback: func(conn ConnMetadata, key PublicKey) (*Permissions, error) {
+					if bytes.Equal(key.Marshal(), testPublicKeys["rsa"].Marshal()) {
+						return nil, nil
+					}
+					return nil, errors.New("pubkey auth failed")
+				},
+				AuthLogCallback: func(conn ConnMetadata, method string, err error) {
+					methods <- method
+				},
+			}
+			serverConfig.AddHostKey(testSigners["rsa"])
+			clientConfig := &ClientConfig{
+				User:            "testuser",
+				Auth:            tt.auth,
+				SkipNoneAuth:    true,
+				HostKeyCallback: InsecureIgnoreHostKey(),
+			}
+			pipePair(t, serverConfig, clientConfig)
+			var got []string
+			for len(methods) > 0 {
+				got = append(got, <-methods)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantMethods, ",") {
+				t.Errorf("server saw methods %q, want %q", got, tt.wantMethods)
+			}
+		})
+	}
+}
+
 // Test if authentication attempts are limited on server when MaxAuthTries is set
 func TestClientAuthMaxAuthTries(t *testing.T) {
 	user := "testuser"
@@ -643,6 +767,46 @@ func TestClientAuthMaxAuthTriesPublicKey(t *testing.T) {
 	}
 }
 
+func TestServerDisconnectCallback(t *testing.T) {
+	serverConfig := &ServerConfig{
+		MaxAuthTries: 1,
+		PasswordCallback: func(conn ConnMetadata, pass []byte) (*Permissions, error) {
+			return nil, errors.New("password auth failed")
+		},
+		DisconnectCallback: func(conn ConnMetadata, reason DisconnectReason, message string) (DisconnectReason, string) {
+			if reason != DisconnectProtocolError || message != "too many authentication failures" {
+				t.Errorf("got disconnect %v %q, want the default", reason, message)
+			}
+			return DisconnectNoMoreAuthMethodsAvailable, "authorized use only"
+		},
+	}
+	serverConfig.AddHostKey(testSigners["rsa"])
+	clientConfig := &ClientConfig{
+		User: "testuser",
+		Auth: []AuthMethod{
+			RetryableAuthMethod(Password("wrong"), 3),
+		},
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	go newServer(c1, serverConfig)
+	_, _, _, err = NewClientConn(c2, "", clientConfig)
+	want := &disconnectMsg{
+		Reason:  DisconnectNoMoreAuthMethodsAvailable,
+		Message: "authorized use only",
+	}
+	if err == nil || !strings.Contains(err.Error(), want.Error()) {
+		t.Fatalf("client: got %v, want %v", err, want)
+	}
+}
+
 // Test whether authentication errors are being properly logged if all
 // authentication methods have been exhausted
 func TestClientAuthErrorList(t *testing.T) {
@@ -896,3 +1060,392 @@ func TestAuthMethodGSSAPIWithMIC(t *testing.T) {
 		}
 	}
 }
+
+// rsaSHA2PublicKey is a publickey AuthMethod that names algo in its
+// request, and signs using sigAlgo.
+type rsaSHA2PublicKey struct {
+	signer  AlgorithmSigner
+	algo    string
+	sigAlgo string
+}
+
+func (k rsaSHA2PublicKey) method() string {
+	return "publickey"
+}
+
+func (k rsaSHA2PublicKey) auth(session []byte, user string, c packetConn, rand io.Reader) (authResult, []string, error) {
+	pubKey := k.signer.PublicKey().Marshal()
+	sign, err := k.signer.SignWithAlgorithm(rand, buildDataSignedForAuth(session, userAuthRequestMsg{
+		User:    user,
+		Service: serviceSSH,
+		Method:  k.method(),
+	}, []byte(k.algo), pubKey), k.sigAlgo)
+	if err != nil {
+		return authFailure, nil, err
+	}
+	s := Marshal(sign)
+	sig := make([]byte, stringLength(len(s)))
+	marshalString(sig, s)
+	msg := publickeyAuthMsg{
+		User:     user,
+		Service:  serviceSSH,
+		Method:   k.method(),
+		HasSig:   true,
+		Algoname: k.algo,
+		PubKey:   pubKey,
+		Sig:      sig,
+	}
+	if err := c.writePacket(Marshal(&msg)); err != nil {
+		return authFailure, nil, err
+	}
+	return handleAuthResponse(c)
+}
+
+// legacySigner hides the SignWithAlgorithm method of an RSA signer, so
+// that the client authenticates with ssh-rsa signatures.
+type legacySigner struct {
+	Signer
+}
+
+func TestPublicKeyAlgorithmCallback(t *testing.T) {
+	signer := testSigners["rsa"].(AlgorithmSigner)
+	for _, tt := range []struct {
+		name     string
+		auth     AuthMethod
+		wantAlgo string
+		wantErr  bool
+	}{
+		{
+			name:     "ssh-rsa",
+			auth:     PublicKeys(legacySigner{signer}),
+			wantAlgo: SigAlgoRSA,
+			wantErr:  true,
+		},
+		{
+			name:     "rsa-sha2-256",
+			auth:     rsaSHA2PublicKey{signer, SigAlgoRSASHA2256, SigAlgoRSASHA2256},
+			wantAlgo: SigAlgoRSASHA2256,
+		},
+		{
+			name:     "rsa-sha2-512",
+			auth:     rsaSHA2PublicKey{signer, SigAlgoRSASHA2512, SigAlgoRSASHA2512},
+			wantAlgo: SigAlgoRSASHA2512,
+		},
+		{
+			name:     "signature does not match algorithm",
+			auth:     rsaSHA2PublicKey{signer, SigAlgoRSASHA2512, SigAlgoRSA},
+			wantAlgo: SigAlgoRSASHA2512,
+			wantErr:  true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c1, c2, err := netPipe()
+			if err != nil {
+				t.Fatalf("netPipe: %v", err)
+			}
+			defer c1.Close()
+			defer c2.Close()
+
+			gotAlgos := make(chan string, 4)
+			serverConfig := &ServerConfig{
+				PublicKeyAlgorithmCallback: func(conn ConnMetadata, key PublicKey, algorithm string) (*Permissions, error) {
+					gotAlgos <- algorithm
+					if algorithm == SigAlgoRSA {
+						return nil, errors.New("SHA-1 signatures not allowed")
+					}
+					return nil, nil
+				},
+			}
+			serverConfig.AddHostKey(testSigners["ecdsa"])
+			go newServer(c1, serverConfig)
+
+			clientConfig := &ClientConfig{
+				User:            "testuser",
+				Auth:            []AuthMethod{tt.auth},
+				HostKeyCallback: InsecureIgnoreHostKey(),
+			}
+			_, _, _, err = NewClientConn(c2, "", clientConfig)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got := <-gotAlgos; got != tt.wantAlgo {
+				t.Errorf("callback saw algorithm %q, want %q", got, tt.wantAlgo)
+			}
+		})
+	}
+}
+
+func TestRSACertSHA2Signature(t *testing.T) {
+	cert := &Certificate{
+		Key:             testPublicKeys["rsa"],
+		ValidPrincipals: []string{"testuser"},
+		ValidBefore:     CertTimeInfinity,
+		CertType:        UserCert,
+	}
+	cert.SignCert(rand.Reader, testSigners["ecdsa"])
+	certSigner, err := NewCertSigner(cert, testSigners["rsa"])
+	if err != nil {
+		t.Fatalf("NewCertSigner: %v", err)
+	}
+	signer := certSigner.(AlgorithmSigner)
+	for _, tt := range []struct {
+		algo, sigAlgo string
+		wantErr       bool
+	}{
+		// OpenSSH 7.2 to 7.7 sign with SHA-2 while naming the
+		// ssh-rsa certificate algorithm.
+		{CertAlgoRSAv01, SigAlgoRSASHA2256, false},
+		{CertAlgoRSAv01, SigAlgoRSASHA2512, false},
+		{CertAlgoRSAv01, SigAlgoRSA, false},
+		{CertAlgoRSAv01, KeyAlgoECDSA256, true},
+	} {
+		serverConfig := &ServerConfig{
+			PublicKeyCallback: func(conn ConnMetadata, key PublicKey) (*Permissions, error) {
+				return nil, nil
+			},
+		}
+		serverConfig.AddHostKey(testSigners["ecdsa"])
+		clientConfig := &ClientConfig{
+			User:            "testuser",
+			Auth:            []AuthMethod{rsaSHA2PublicKey{signer, tt.algo, tt.sigAlgo}},
+			HostKeyCallback: InsecureIgnoreHostKey(),
+		}
+		client, server, _, _, err := Pipe(serverConfig, clientConfig)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s signed with %s: got error %v, want error %v", tt.algo, tt.sigAlgo, err, tt.wantErr)
+		}
+		if err == nil {
+			client.Close()
+			server.Close()
+		}
+	}
+}
+
+func TestPublicKeyAlgorithmCallbackPerUser(t *testing.T) {
+	signer := testSigners["rsa"].(AlgorithmSigner)
+	for _, tt := range []struct {
+		user    string
+		auth    AuthMethod
+		wantErr bool
+	}{
+		{user: "legacy", auth: PublicKeys(legacySigner{signer})},
+		{user: "legacy", auth: rsaSHA2PublicKey{signer, SigAlgoRSASHA2256, SigAlgoRSASHA2256}},
+		{user: "modern", auth: PublicKeys(legacySigner{signer}), wantErr: true},
+		{user: "modern", auth: rsaSHA2PublicKey{signer, SigAlgoRSASHA2512, SigAlgoRSASHA2512}},
+		// The rejection of ssh-rsa doesn't stop the same key from
+		// being accepted with SHA-2 on the same connection.
+		{user: "modern", auth: PublicKeysCallback(func() ([]Signer, error) {
+			return []Signer{legacySigner{signer}, signer}, nil
+		})},
+	} {
+		c1, c2, err := netPipe()
+		if err != nil {
+			t.Fatalf("netPipe: %v", err)
+		}
+
+		serverConfig := &ServerConfig{
+			PublicKeyAlgorithmCallback: func(conn ConnMetadata, key PublicKey, algorithm string) (*Permissions, error) {
+				if algorithm == SigAlgoRSA && conn.User() != "legacy" {
+					return nil, errors.New("SHA-1 signatures not allowed")
+				}
+				return nil, nil
+			},
+		}
+		serverConfig.AddHostKey(testSigners["ecdsa"])
+		go newServer(c1, serverConfig)
+
+		clientConfig := &ClientConfig{
+			User:            tt.user,
+			Auth:            []AuthMethod{tt.auth},
+			HostKeyCallback: InsecureIgnoreHostKey(),
+		}
+		_, _, _, err = NewClientConn(c2, "", clientConfig)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("user %q with %T: got error %v, want error %v", tt.user, tt.auth, err, tt.wantErr)
+		}
+		c1.Close()
+		c2.Close()
+	}
+}
+
+func TestPublicKeyAuthServerSigAlgs(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		signer   Signer
+		pipeline bool
+		wantAlgo string
+	}{
+		{"rsa", testSigners["rsa"], false, SigAlgoRSASHA2512},
+		{"rsa pipelined", testSigners["rsa"], true, SigAlgoRSASHA2512},
+		{"ed25519", testSigners["ed25519"], false, KeyAlgoED25519},
+		{"legacy rsa", legacySigner{testSigners["rsa"]}, false, SigAlgoRSA},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var algos, attempts []string
+			serverConfig := &ServerConfig{
+				PublicKeyAlgorithmCallback: func(conn ConnMetadata, key PublicKey, algorithm string) (*Permissions, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					algos = append(algos, algorithm)
+					if algorithm == SigAlgoRSA && tt.wantAlgo != SigAlgoRSA {
+						return nil, errors.New("SHA-1 signatures not allowed")
+					}
+					return nil, nil
+				},
+				AuthLogCallback: func(conn ConnMetadata, method string, err error) {
+					mu.Lock()
+					defer mu.Unlock()
+					attempts = append(attempts, fmt.Sprintf("%s %v", method, err == nil))
+				},
+			}
+			serverConfig.AddHostKey(testSigners["ecdsa"])
+			clientConfig := &ClientConfig{
+				User:            "testuser",
+				Auth:            []AuthMethod{PublicKeys(tt.signer)},
+				HostKeyCallback: InsecureIgnoreHostKey(),
+				PipelineAuth:    tt.pipeline,
+			}
+			client, _, _, _ := pipePair(t, serverConfig, clientConfig)
+
+			if got := client.Conn.(*connection).transport.serverSignatureAlgorithms(); !contains(got, SigAlgoRSASHA2512) || !contains(got, KeyAlgoED25519) {
+				t.Errorf("client received server-sig-algs %q", got)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			// The key is offered once, and signed with the first
+			// algorithm, which the server accepts.
+			if len(algos) == 0 {
+				t.Error("the server saw no public key")
+			}
+			for _, algo := range algos {
+				if algo != tt.wantAlgo {
+					t.Errorf("server saw algorithms %q, want only %q", algos, tt.wantAlgo)
+					break
+				}
+			}
+			if want := []string{"none false", "publickey true"}; !reflect.DeepEqual(attempts, want) {
+				t.Errorf("got authentication attempts %q, want %q", attempts, want)
+			}
+		})
+	}
+}
+
+func TestMinRSAKeySize(t *testing.T) {
+	newSigner := func(bits int) Signer {
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			t.Fatalf("GenerateKey(%d): %v", bits, err)
+		}
+		signer, err := NewSignerFromKey(key)
+		if err != nil {
+			t.Fatalf("NewSignerFromKey: %v", err)
+		}
+		return signer
+	}
+	small, large := newSigner(1024), newSigner(3072)
+
+	for _, tt := range []struct {
+		name    string
+		min     int
+		signer  Signer
+		wantErr bool
+	}{
+		{"1024 bits with the default", 0, small, true},
+		{"3072 bits with the default", 0, large, false},
+		{"3072 bits with a minimum of 4096", 4096, large, true},
+		{"1024 bits without minimum", -1, small, false},
+		// Other key types are not affected.
+		{"ecdsa with a minimum of 4096", 4096, testSigners["ecdsa"], false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var callbackCalled bool
+			serverConfig := &ServerConfig{
+				MinRSAKeySize: tt.min,
+				PublicKeyCallback: func(conn ConnMetadata, key PublicKey) (*Permissions, error) {
+					callbackCalled = true
+					return nil, nil
+				},
+			}
+			serverConfig.AddHostKey(testSigners["ecdsa"])
+			clientConfig := &ClientConfig{
+				User:            "testuser",
+				Auth:            []AuthMethod{PublicKeys(tt.signer)},
+				HostKeyCallback: InsecureIgnoreHostKey(),
+			}
+
+			c1, c2, err := netPipe()
+			if err != nil {
+				t.Fatalf("netPipe: %v", err)
+			}
+			defer c1.Close()
+			defer c2.Close()
+			serverErr := make(chan error, 1)
+			go func() {
+				_, _, _, err := NewServerConn(c1, serverConfig)
+				serverErr <- err
+			}()
+			_, _, _, err = NewClientConn(c2, "", clientConfig)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("client authenticated with a key that is too small")
+				}
+				if callbackCalled {
+					t.Error("PublicKeyCallback called for a key that is too small")
+				}
+				serr := <-serverErr
+				if serr == nil || !strings.Contains(serr.Error(), "smaller than the minimum") {
+					t.Errorf("server error %v does not mention the key size", serr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewClientConn: %v", err)
+			}
+			if err := <-serverErr; err != nil {
+				t.Fatalf("NewServerConn: %v", err)
+			}
+		})
+	}
+}
+
+func TestClientMinRSAKeySize(t *testing.T) {
+	for _, tt := range []struct {
+		min     int
+		wantErr bool
+	}{
+		{0, false},
+		{2048, false},
+		{3072, true},
+	} {
+		c1, c2, err := netPipe()
+		if err != nil {
+			t.Fatalf("netPipe: %v", err)
+		}
+		serverConfig := &ServerConfig{NoClientAuth: true}
+		serverConfig.AddHostKey(testSigners["rsa"])
+		go NewServerConn(c1, serverConfig)
+
+		var callbackCalled bool
+		clientConfig := &ClientConfig{
+			MinRSAKeySize: tt.min,
+			HostKeyCallback: func(hostname string, remote net.Addr, key PublicKey) error {
+				callbackCalled = true
+				return nil
+			},
+		}
+		_, _, _, err = NewClientConn(c2, "", clientConfig)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "smaller than the minimum") {
+				t.Errorf("MinRSAKeySize %d: got error %v, want a key size error", tt.min, err)
+			}
+			if callbackCalled {
+				t.Errorf("MinRSAKeySize %d: HostKeyCallback called for a key that is too small", tt.min)
+			}
+		} else if err != nil {
+			t.Errorf("MinRSAKeySize %d: NewClientConn: %v", tt.min, err)
+		}
+		c1.Close()
+		c2.Close()
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// keepAliveOptions returns the SO_KEEPALIVE and TCP_KEEPIDLE options
+// of the socket of c.
+func keepAliveOptions(t *testing.T, c net.Conn) (enabled bool, idle time.Duration) {
+	raw, err := c.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn: %v", err)
+	}
+	var on, secs int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		if on, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); sockErr != nil {
+			return
+		}
+		secs, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE)
+	}); err != nil {
+		t.Fatalf("Control: %v", err)
+	}
+	if sockErr != nil {
+		t.Fatalf("getsockopt: %v", sockErr)
+	}
+	return on != 0, time.Duration(secs) * time.Second
+}
+
+func TestDialTCPKeepAlive(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	serverConf := testServerConfig()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn, chans, reqs, err := NewServerConn(c, serverConf)
+				if err != nil {
+					return
+				}
+				go DiscardRequests(reqs)
+				go func() {
+					for newCh := range chans {
+						newCh.Reject(Prohibited, "")
+					}
+				}()
+				conn.Wait()
+			}()
+		}
+	}()
+
+	for _, tt := range []struct {
+		keepAlive time.Duration
+		enabled   bool
+		idle      time.Duration
+	}{
+		{42 * time.Second, true, 42 * time.Second},
+		{-1, false, 0},
+	} {
+		client, err := Dial("tcp", l.Addr().String(), &ClientConfig{
+			HostKeyCallback: InsecureIgnoreHostKey(),
+			TCPKeepAlive:    tt.keepAlive,
+		})
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		enabled, idle := keepAliveOptions(t, client.Conn.(NetConnGetter).NetConn())
+		if enabled != tt.enabled || (enabled && idle != tt.idle) {
+			t.Errorf("TCPKeepAlive %v: got keep-alive %v after %v, want %v after %v", tt.keepAlive, enabled, idle, tt.enabled, tt.idle)
+		}
+		client.Close()
+	}
+}
@@ -5,8 +5,15 @@
 package ssh
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestClientVersion(t *testing.T) {
@@ -116,6 +123,31 @@ func TestHostKeyCheck(t *testing.T) {
 	}
 }
 
+func TestBannerCallbackPipelined(t *testing.T) {
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+		BannerCallback: func(conn ConnMetadata) string {
+			return "Hello World"
+		},
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+	var banners []string
+	clientConf := &ClientConfig{
+		HostKeyCallback: InsecureIgnoreHostKey(),
+		PipelineAuth:    true,
+		BannerCallback: func(message string) error {
+			banners = append(banners, message)
+			return nil
+		},
+	}
+	client, server, _, _ := pipePair(t, serverConf, clientConf)
+	client.Close()
+	server.Close()
+	if len(banners) != 1 || banners[0] != "Hello World" {
+		t.Errorf("got banners %q, want %q", banners, "Hello World")
+	}
+}
+
 func TestBannerCallback(t *testing.T) {
 	c1, c2, err := netPipe()
 	if err != nil {
@@ -165,6 +197,115 @@ func TestBannerCallback(t *testing.T) {
 	}
 }
 
+func TestBannerCallbackPreamble(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		preamble string
+		maxLines int
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "preamble",
+			preamble: "hello\r\nworld\n",
+			want:     "hello\nworld\n",
+		},
+		{
+			name:     "rejected preamble",
+			preamble: "hello\r\n",
+			maxLines: -1,
+			wantErr:  true,
+		},
+		{
+			name:     "endless preamble",
+			preamble: strings.Repeat("hello\r\n", 1000),
+			wantErr:  true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c1, c2, err := netPipe()
+			if err != nil {
+				t.Fatalf("netPipe: %v", err)
+			}
+			defer c1.Close()
+			defer c2.Close()
+
+			serverConf := &ServerConfig{NoClientAuth: true}
+			serverConf.AddHostKey(testSigners["rsa"])
+			go func() {
+				if _, err := c1.Write([]byte(tt.preamble)); err != nil {
+					return
+				}
+				NewServerConn(c1, serverConf)
+				c1.Close()
+			}()
+
+			var banners []string
+			clientConf := &ClientConfig{
+				User:            "user",
+				HostKeyCallback: InsecureIgnoreHostKey(),
+				MaxBannerLines:  tt.maxLines,
+				BannerCallback: func(message string) error {
+					banners = append(banners, message)
+					return nil
+				},
+			}
+			_, _, _, err = NewClientConn(c2, "", clientConf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v; wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(banners) != 1 || banners[0] != tt.want {
+				t.Errorf("got banners %q; want [%q]", banners, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultipleBanners(t *testing.T) {
+	var banners []string
+	clientConf := &ClientConfig{
+		User:            "user",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+		BannerLanguageCallback: func(message, language string) error {
+			banners = append(banners, language+": "+message)
+			return nil
+		},
+	}
+	trC, trS, err := handshakePair(clientConf, "addr", false)
+	if err != nil {
+		t.Fatalf("handshakePair: %v", err)
+	}
+	defer trC.Close()
+	defer trS.Close()
+
+	go func() {
+		// Accept the service request, then send two banners before
+		// accepting the "none" authentication.
+		if _, err := trS.readPacket(); err != nil {
+			return
+		}
+		trS.writePacket(Marshal(&serviceAcceptMsg{serviceUserAuth}))
+		if _, err := trS.readPacket(); err != nil {
+			return
+		}
+		trS.writePacket(Marshal(&userAuthBannerMsg{Message: "first", Language: "en"}))
+		trS.writePacket(Marshal(&userAuthBannerMsg{Message: "second", Language: "fr"}))
+		trS.writePacket([]byte{msgUserAuthSuccess})
+	}()
+
+	conn := &connection{transport: trC}
+	if err := conn.clientAuthenticate(clientConf); err != nil {
+		t.Fatalf("clientAuthenticate: %v", err)
+	}
+	want := []string{"en: first", "fr: second"}
+	if !reflect.DeepEqual(banners, want) {
+		t.Errorf("got banners %q, want %q", banners, want)
+	}
+}
+
 func TestNewClientConn(t *testing.T) {
 	for _, tt := range []struct {
 		name string
@@ -213,3 +354,373 @@ func TestNewClientConn(t *testing.T) {
 		})
 	}
 }
+
+func TestHandshakeTimeout(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	// The peer sends its version string and then stalls.
+	go func() {
+		c1.Write([]byte(packageVersion + "\r\n"))
+	}()
+
+	clientConf := &ClientConfig{
+		HostKeyCallback:  InsecureIgnoreHostKey(),
+		HandshakeTimeout: 100 * time.Millisecond,
+	}
+	start := time.Now()
+	_, _, _, err = NewClientConn(c2, "", clientConf)
+	if err == nil {
+		t.Fatal("NewClientConn succeeded against a stalled server")
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("got error %v, want a timeout error", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatalf("handshake took %v to time out", d)
+	}
+}
+
+func TestServerHandshakeTimeout(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	serverConf := &ServerConfig{
+		NoClientAuth:     true,
+		HandshakeTimeout: 100 * time.Millisecond,
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+
+	// The client never sends anything.
+	_, _, _, err = NewServerConn(c1, serverConf)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("got error %v, want a timeout error", err)
+	}
+}
+
+func TestClientPing(t *testing.T) {
+	client, _, chans, reqs := pipePair(t, testServerConfig(), testClientConfig())
+	go func() {
+		for newCh := range chans {
+			newCh.Reject(Prohibited, "")
+		}
+	}()
+	stall := make(chan struct{})
+	go func() {
+		for req := range reqs {
+			if req.Type != "keepalive@openssh.com" || !req.WantReply {
+				t.Errorf("got request %q with want reply %v, want keepalive@openssh.com with a reply", req.Type, req.WantReply)
+			}
+			select {
+			case <-stall:
+				// Never reply to this request.
+				continue
+			default:
+			}
+			time.Sleep(time.Millisecond)
+			req.Reply(false, nil)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtt, err := client.Ping(context.Background())
+			if err != nil {
+				t.Errorf("Ping: %v", err)
+			} else if rtt < time.Millisecond {
+				t.Errorf("Ping: got %v, want at least 1ms", rtt)
+			}
+		}()
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.Ping(ctx); err != context.Canceled {
+		t.Errorf("Ping with a canceled context: got %v, want %v", err, context.Canceled)
+	}
+
+	close(stall)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Ping(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Ping without a reply: got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestDialAddresses(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	type accepted struct {
+		c    net.Conn
+		conn *ServerConn
+	}
+	done := make(chan accepted, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			done <- accepted{}
+			return
+		}
+		serverConf := testServerConfig()
+		conn, chans, reqs, err := NewServerConn(c, serverConf)
+		if err != nil {
+			t.Errorf("NewServerConn: %v", err)
+			c.Close()
+			done <- accepted{}
+			return
+		}
+		go DiscardRequests(reqs)
+		go func() {
+			for newCh := range chans {
+				newCh.Reject(Prohibited, "")
+			}
+		}()
+		done <- accepted{c, conn}
+	}()
+
+	client, err := Dial("tcp", l.Addr().String(), testClientConfig())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	a := <-done
+	if a.conn == nil {
+		t.FailNow()
+	}
+	defer a.conn.Close()
+
+	if got, want := client.RemoteAddr().String(), l.Addr().String(); got != want {
+		t.Errorf("got client remote address %s, want %s", got, want)
+	}
+	if got, want := client.LocalAddr().String(), a.c.RemoteAddr().String(); got != want {
+		t.Errorf("got client local address %s, want %s", got, want)
+	}
+	if got, want := a.conn.RemoteAddr().String(), client.LocalAddr().String(); got != want {
+		t.Errorf("got server remote address %s, want %s", got, want)
+	}
+
+	c := client.Conn.(NetConnGetter).NetConn()
+	if c.LocalAddr() != client.LocalAddr() || c.RemoteAddr() != client.RemoteAddr() {
+		t.Errorf("got net.Conn addresses %v %v, want %v %v", c.LocalAddr(), c.RemoteAddr(), client.LocalAddr(), client.RemoteAddr())
+	}
+	tcpConn, ok := c.(*net.TCPConn)
+	if !ok {
+		t.Fatalf("got net.Conn of type %T, want *net.TCPConn", c)
+	}
+	if err := tcpConn.SetNoDelay(false); err != nil {
+		t.Errorf("SetNoDelay: %v", err)
+	}
+	if a.conn.Conn.(NetConnGetter).NetConn() != a.c {
+		t.Error("the server NetConn is not the accepted net.Conn")
+	}
+	if _, err := client.Ping(context.Background()); err != nil {
+		t.Errorf("Ping after setting socket options: %v", err)
+	}
+}
+
+func TestMaxLifetime(t *testing.T) {
+	const lifetime = 200 * time.Millisecond
+	serverConf := testServerConfig()
+	serverConf.MaxLifetime = lifetime
+	clientConf := testClientConfig()
+
+	start := time.Now()
+	client, server, chans, reqs := pipePair(t, serverConf, clientConf)
+	defer client.Close()
+	go func() {
+		for req := range reqs {
+			req.Reply(true, nil)
+		}
+	}()
+	go func() {
+		for newCh := range chans {
+			newCh.Reject(Prohibited, "")
+		}
+	}()
+
+	// Keep the connection busy until it is closed.
+	for {
+		if _, _, err := client.SendRequest("busy", true, nil); err != nil {
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("connection still open")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < lifetime {
+		t.Errorf("connection closed after %v, before its lifetime of %v", elapsed, lifetime)
+	}
+	err := client.Wait()
+	if d, ok := err.(*disconnectMsg); !ok || d.Reason != DisconnectByApplication || !strings.Contains(d.Message, "lifetime") {
+		t.Errorf("client.Wait = %v, want a disconnect for the lifetime", err)
+	}
+	if err := server.Wait(); err == nil {
+		t.Error("server.Wait = nil, want an error")
+	}
+}
+
+// stallingConn is a net.Conn whose writes block, as if the peer
+// stopped reading, once stall is closed, until the conn is closed.
+type stallingConn struct {
+	net.Conn
+	stall  chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *stallingConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.stall:
+		<-c.closed
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *stallingConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func TestMaxLifetimeStalledPeer(t *testing.T) {
+	const lifetime = 100 * time.Millisecond
+	serverConf := testServerConfig()
+	serverConf.MaxLifetime = lifetime
+	clientConf := testClientConfig()
+
+	c1, c2 := newMemConnPair()
+	sc := &stallingConn{Conn: c1, stall: make(chan struct{}), closed: make(chan struct{})}
+	defer c2.Close()
+	go NewClientConn(c2, "stalled", clientConf)
+	server, _, reqs, err := NewServerConn(sc, serverConf)
+	if err != nil {
+		t.Fatalf("NewServerConn: %v", err)
+	}
+	go DiscardRequests(reqs)
+	// From now on, the writes of the server block, including the
+	// disconnect message at the end of the lifetime.
+	close(sc.stall)
+
+	done := make(chan error, 1)
+	go func() { done <- server.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(lifetime + disconnectTimeout + 5*time.Second):
+		t.Fatal("the connection of a peer that stopped reading outlived its lifetime")
+	}
+}
+
+func TestHandleChannelType(t *testing.T) {
+	client, server, _, _ := pipePair(t, testServerConfig(), testClientConfig())
+
+	const channelType = "custom@example.com"
+	extraData := make(chan string, 1)
+	if err := client.HandleChannelType(channelType, func(newCh NewChannel) {
+		extraData <- string(newCh.ExtraData())
+		ch, reqs, err := newCh.Accept()
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			return
+		}
+		go DiscardRequests(reqs)
+		ch.Write([]byte("pong"))
+		ch.Close()
+	}); err != nil {
+		t.Fatalf("HandleChannelType: %v", err)
+	}
+	if err := client.HandleChannelType(channelType, func(NewChannel) {}); err == nil {
+		t.Errorf("HandleChannelType succeeded for a type that is already handled")
+	}
+
+	ch, reqs, err := server.OpenChannel(channelType, []byte("ping"))
+	if err != nil {
+		t.Fatalf("OpenChannel(%q): %v", channelType, err)
+	}
+	go DiscardRequests(reqs)
+	if got := <-extraData; got != "ping" {
+		t.Errorf("handler got extra data %q, want %q", got, "ping")
+	}
+	buf := make([]byte, 4)
+	if _, err := ch.Read(buf); err != nil || string(buf) != "pong" {
+		t.Errorf("Read = %q, %v, want %q", buf, err, "pong")
+	}
+	ch.Close()
+
+	_, _, err = server.OpenChannel("unregistered@example.com", nil)
+	if openErr, ok := err.(*OpenChannelError); !ok || openErr.Reason != UnknownChannelType {
+		t.Errorf("OpenChannel of an unregistered type: got error %v, want UnknownChannelType", err)
+	}
+
+	// The handled channels are closed once the connection is.
+	other := client.HandleChannelOpen("other@example.com")
+	client.Close()
+	for range other {
+	}
+	if err := client.HandleChannelType("late@example.com", func(NewChannel) {}); err != net.ErrClosed {
+		t.Errorf("HandleChannelType on a closed connection: got %v, want %v", err, net.ErrClosed)
+	}
+}
+
+func TestCombineHostKeyCallbacks(t *testing.T) {
+	var called []int
+	callback := func(i int, err error) HostKeyCallback {
+		return func(hostname string, remote net.Addr, key PublicKey) error {
+			called = append(called, i)
+			return err
+		}
+	}
+	errReject := errors.New("rejected")
+	key := testPublicKeys["ecdsa"]
+
+	if err := CombineHostKeyCallbacks(callback(0, errReject), callback(1, nil), callback(2, nil))("host:22", nil, key); err != nil {
+		t.Errorf("got %v, want the key accepted by the second callback", err)
+	}
+	if !reflect.DeepEqual(called, []int{0, 1}) {
+		t.Errorf("called callbacks %v, want [0 1]", called)
+	}
+
+	err := CombineHostKeyCallbacks(callback(0, errReject), FixedHostKey(testPublicKeys["rsa"]))("host:22", nil, key)
+	if combined, ok := err.(*HostKeyCallbacksError); !ok || len(combined.Errors) != 2 || combined.Errors[0] != errReject {
+		t.Errorf("got %#v, want a HostKeyCallbacksError with both errors", err)
+	}
+	if !errors.Is(err, errReject) {
+		t.Errorf("errors.Is(%v, errReject) = false", err)
+	}
+	// The methods themselves, which errors.Is and errors.As use
+	// before Go 1.20, find the errors too.
+	combined := err.(*HostKeyCallbacksError)
+	if !combined.Is(errReject) || combined.Is(errors.New("other")) {
+		t.Errorf("HostKeyCallbacksError.Is does not find only errReject")
+	}
+	typed := &RequestRejectedError{Request: "test"}
+	err = CombineHostKeyCallbacks(callback(0, errReject), callback(1, typed))("host:22", nil, key)
+	var target *RequestRejectedError
+	if !err.(*HostKeyCallbacksError).As(&target) || target != typed {
+		t.Errorf("HostKeyCallbacksError.As did not find the typed error")
+	}
+	if !errors.As(err, &target) || target != typed {
+		t.Errorf("errors.As did not find the typed error")
+	}
+
+	if err := CombineHostKeyCallbacks()("host:22", nil, key); err == nil {
+		t.Errorf("no callbacks accepted the key")
+	}
+}
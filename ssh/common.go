@@ -10,11 +10,15 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"os"
 	"sync"
+	"time"
 
 	_ "crypto/sha1"
 	_ "crypto/sha256"
 	_ "crypto/sha512"
+
+	"golang.org/x/sys/cpu"
 )
 
 // These are string constants in the SSH protocol.
@@ -35,10 +39,33 @@ var supportedCiphers = []string{
 }
 
 // preferredCiphers specifies the default preference for ciphers.
-var preferredCiphers = []string{
-	"aes128-gcm@openssh.com",
-	chacha20Poly1305ID,
-	"aes128-ctr", "aes192-ctr", "aes256-ctr",
+var preferredCiphers = preferredCipherOrder(hasAESGCMHardwareSupport)
+
+// hasAESGCMHardwareSupport reports whether the CPU has instructions for
+// both AES and the GHASH of GCM, which crypto/aes uses. The condition
+// is the one crypto/tls uses to order its cipher suites.
+var hasAESGCMHardwareSupport = cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ ||
+	cpu.ARM64.HasAES && cpu.ARM64.HasPMULL ||
+	cpu.S390X.HasAES && cpu.S390X.HasAESCBC && cpu.S390X.HasAESCTR &&
+		(cpu.S390X.HasGHASH || cpu.S390X.HasAESGCM)
+
+// preferredCipherOrder returns the default preference for ciphers. With
+// hardware support, AES-GCM is the fastest cipher. Without it,
+// ChaCha20-Poly1305 is faster, and unlike the software AES it is
+// constant time, so it comes first.
+func preferredCipherOrder(aesGCMHardware bool) []string {
+	if aesGCMHardware {
+		return []string{
+			gcmCipherID,
+			chacha20Poly1305ID,
+			"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		}
+	}
+	return []string{
+		chacha20Poly1305ID,
+		gcmCipherID,
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+	}
 }
 
 // supportedKexAlgos specifies the supported key-exchange algorithms in
@@ -48,6 +75,7 @@ var supportedKexAlgos = []string{
 	// P384 and P521 are not constant-time yet, but since we don't
 	// reuse ephemeral keys, using them for ECDH should be OK.
 	kexAlgoECDH256, kexAlgoECDH384, kexAlgoECDH521,
+	kexAlgoDH16SHA512, kexAlgoDH18SHA512,
 	kexAlgoDH14SHA1, kexAlgoDH1SHA1,
 }
 
@@ -63,6 +91,7 @@ var serverForbiddenKexAlgos = map[string]struct{}{
 var preferredKexAlgos = []string{
 	kexAlgoCurve25519SHA256,
 	kexAlgoECDH256, kexAlgoECDH384, kexAlgoECDH521,
+	kexAlgoDH16SHA512,
 	kexAlgoDH14SHA1,
 }
 
@@ -80,12 +109,17 @@ var supportedHostKeyAlgos = []string{
 
 // supportedMACs specifies a default set of MAC algorithms in preference order.
 // This is based on RFC 4253, section 6.4, but with hmac-md5 variants removed
-// because they have reached the end of their useful life.
+// because they have reached the end of their useful life. The
+// encrypt-then-MAC variants come first, as they authenticate the
+// encrypted packet rather than the plaintext.
 var supportedMACs = []string{
-	"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256", "hmac-sha1", "hmac-sha1-96",
+	"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+	"hmac-sha2-256", "hmac-sha2-512", "hmac-sha1", "hmac-sha1-96",
 }
 
-var supportedCompressions = []string{compressionNone}
+// supportedCompressions lists the compression algorithms we support.
+// Compression is off unless Config.Compressions enables it.
+var supportedCompressions = []string{compressionNone, compressionZlibDelayed, compressionZlib}
 
 // hashFuncs keeps the mapping of supported algorithms to their respective
 // hashes needed for signature verification.
@@ -208,6 +242,12 @@ func findAgreedAlgorithms(isClient bool, clientKexInit, serverKexInit *kexInitMs
 // stuff.
 const minRekeyThreshold uint64 = 256
 
+// The reasons for key renewals passed to Config.OnRekey.
+const (
+	RekeyReasonRemote    = "remote"
+	RekeyReasonThreshold = "threshold"
+)
+
 // Config contains configuration data common to both ServerConfig and
 // ClientConfig.
 type Config struct {
@@ -221,17 +261,77 @@ type Config struct {
 	// unspecified, a size suitable for the chosen cipher is used.
 	RekeyThreshold uint64
 
+	// OnRekey, if non-nil, is called after each key exchange that
+	// renews the keys of the connection, with RekeyReasonRemote if
+	// the peer started it and RekeyReasonThreshold if it was
+	// started because the RekeyThreshold was reached. It is not
+	// called for the initial key exchange. It is called from the
+	// goroutine that writes packets, which it must not block.
+	OnRekey func(reason string)
+
 	// The allowed key exchanges algorithms. If unspecified then a
 	// default set of algorithms is used.
 	KeyExchanges []string
 
-	// The allowed cipher algorithms. If unspecified then a sensible
-	// default is used.
+	// The allowed cipher algorithms, in order of preference. If
+	// unspecified then a sensible default is used, which puts
+	// aes128-gcm@openssh.com first if the CPU accelerates AES-GCM,
+	// and chacha20-poly1305@openssh.com first otherwise. The
+	// cipher used in each direction is the first one of the
+	// client's list that the server supports; use
+	// AlgorithmsConn to learn which was chosen.
 	Ciphers []string
 
 	// The allowed MAC algorithms. If unspecified then a sensible default
-	// is used.
+	// is used, which prefers the encrypt-then-MAC variants; use
+	// DirectionAlgorithms.EncryptThenMAC to check the negotiated one.
 	MACs []string
+
+	// The allowed compression algorithms, in order of preference:
+	// "none", "zlib" and "zlib@openssh.com". The latter starts
+	// compressing only once the user is authenticated, so no
+	// packet is compressed before. If unspecified, only "none" is
+	// allowed.
+	Compressions []string
+
+	// Logger, if non-nil, receives events about the connection: the
+	// negotiated algorithms of each key exchange, authentication
+	// attempts and their outcome, and disconnect reasons.
+	Logger Logger
+
+	// MaxPendingGlobalRequests is the maximum number of global
+	// requests sent with SendRequest or SendRequestContext that may
+	// await a reply at the same time. Further requests fail until
+	// replies arrive. It also bounds the received global requests
+	// that want a reply and are not replied to yet; the peer gets a
+	// failure for requests beyond it. If zero, 64 is used.
+	MaxPendingGlobalRequests int
+
+	// MaxLifetime, if positive, is the maximum time a connection
+	// stays open once it is established, however busy it is. When
+	// it elapses, a disconnect message is sent and the connection
+	// is closed. Unlike RekeyThreshold, it does not depend on the
+	// traffic.
+	MaxLifetime time.Duration
+}
+
+// defaultMinRSAKeySize is the default for ServerConfig.MinRSAKeySize.
+const defaultMinRSAKeySize = 2048
+
+// checkRSAKeySize returns an error if key is an RSA key, or a
+// certificate of one, of fewer than min bits.
+func checkRSAKeySize(key PublicKey, min int) error {
+	if cert, ok := key.(*Certificate); ok {
+		key = cert.Key
+	}
+	rsaKey, ok := key.(*rsaPublicKey)
+	if !ok || min <= 0 {
+		return nil
+	}
+	if bits := rsaKey.N.BitLen(); bits < min {
+		return fmt.Errorf("ssh: RSA key of %d bits is smaller than the minimum of %d bits", bits, min)
+	}
+	return nil
 }
 
 // SetDefaults sets sensible values for unset fields in config. This is
@@ -261,6 +361,17 @@ func (c *Config) SetDefaults() {
 		c.MACs = supportedMACs
 	}
 
+	if c.Compressions == nil {
+		c.Compressions = []string{compressionNone}
+	}
+	var compressions []string
+	for _, algo := range c.Compressions {
+		if contains(supportedCompressions, algo) {
+			compressions = append(compressions, algo)
+		}
+	}
+	c.Compressions = compressions
+
 	if c.RekeyThreshold == 0 {
 		// cipher specific default
 	} else if c.RekeyThreshold < minRekeyThreshold {
@@ -338,6 +449,7 @@ type window struct {
 	win          uint32 // RFC 4254 5.2 says the window size can grow to 2^32-1
 	writeWaiters int
 	closed       bool
+	deadline     condDeadline
 }
 
 // add adds win to the amount of window available
@@ -379,6 +491,11 @@ func (w *window) reserve(win uint32) (uint32, error) {
 	w.writeWaiters++
 	w.Broadcast()
 	for w.win == 0 && !w.closed {
+		if w.deadline.expired() {
+			w.writeWaiters--
+			w.L.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
 		w.Wait()
 	}
 	w.writeWaiters--
@@ -393,6 +510,44 @@ func (w *window) reserve(win uint32) (uint32, error) {
 	return win, err
 }
 
+// setDeadline makes reserve fail with os.ErrDeadlineExceeded instead
+// of blocking once t has passed. The zero t means no deadline.
+func (w *window) setDeadline(t time.Time) {
+	w.L.Lock()
+	w.deadline.set(w.Cond, t)
+	w.L.Unlock()
+}
+
+// condDeadline is a deadline for the operations that wait on a
+// sync.Cond, which is broadcast when the deadline passes. The lock of
+// the sync.Cond protects it.
+type condDeadline struct {
+	t     time.Time
+	timer *time.Timer
+}
+
+func (d *condDeadline) set(c *sync.Cond, t time.Time) {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.t = t
+	if wait := time.Until(t); !t.IsZero() && wait > 0 {
+		d.timer = time.AfterFunc(wait, func() {
+			c.L.Lock()
+			c.Broadcast()
+			c.L.Unlock()
+		})
+	}
+	// Let the waiting operations check the new deadline.
+	c.Broadcast()
+}
+
+// expired reports whether the deadline has passed.
+func (d *condDeadline) expired() bool {
+	return !d.t.IsZero() && !time.Now().Before(d.t)
+}
+
 // waitWriterBlocked waits until some goroutine is blocked for further
 // writes. It is used in tests only.
 func (w *window) waitWriterBlocked() {
@@ -0,0 +1,57 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"compress/zlib"
+)
+
+const (
+	compressionZlib = "zlib"
+
+	// compressionZlibDelayed is zlib compression that only starts
+	// once the user is authenticated, so that the unauthenticated
+	// peer cannot attack the decompressor.
+	compressionZlibDelayed = "zlib@openssh.com"
+)
+
+// compressionActive reports whether packets are compressed with
+// algorithm, given whether the user is authenticated.
+func compressionActive(algorithm string, authenticated bool) bool {
+	switch algorithm {
+	case compressionZlib:
+		return true
+	case compressionZlibDelayed:
+		return authenticated
+	}
+	return false
+}
+
+// compressor compresses the packets of one direction. The packets are
+// parts of a single zlib stream, each ending with a flush.
+type compressor struct {
+	buf bytes.Buffer
+	w   *zlib.Writer
+}
+
+func newCompressor() *compressor {
+	c := &compressor{}
+	c.w = zlib.NewWriter(&c.buf)
+	return c
+}
+
+// compress returns the compressed packet. It is valid until the next
+// call of compress.
+func (c *compressor) compress(packet []byte) ([]byte, error) {
+	c.buf.Reset()
+	if _, err := c.w.Write(packet); err != nil {
+		return nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return c.buf.Bytes(), nil
+}
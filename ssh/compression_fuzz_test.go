@@ -0,0 +1,65 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package ssh
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// checkDecompress checks the decompressor against compress/flate, both
+// on what the compressor of this package makes of data and on data
+// taken as arbitrary deflate input.
+func checkDecompress(t *testing.T, data []byte) {
+	c := newCompressor()
+	packet, err := c.compress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDecompressor()
+	got, err := d.decompress(packet)
+	d.close()
+	if len(data) > maxDecompressedPacket {
+		if err != errDecompressedTooLarge {
+			t.Fatalf("decompress of %d bytes = %v, want %v", len(data), err, errDecompressedTooLarge)
+		}
+	} else if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("round trip of %q = %q, %v", data, got, err)
+	}
+
+	// Taken as deflate data that continues in the next packet, data
+	// must inflate to at least what compress/flate reads up to the
+	// unexpected end of its input. compress/flate may stop a symbol
+	// short, as it reads ahead. The decompressor may also reject
+	// data that compress/flate reads, as zlib does when a dynamic
+	// block has no end-of-block code.
+	want, ferr := ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	d = newDecompressor()
+	got, err = d.decompress(append([]byte{0x78, 0x9c}, data...))
+	d.close()
+	switch {
+	case err == errDecompressedTooLarge:
+		if len(want) <= maxDecompressedPacket && ferr != io.ErrUnexpectedEOF {
+			t.Fatalf("decompress = %v, but compress/flate read %d bytes, %v", err, len(want), ferr)
+		}
+	case err == nil:
+		if ferr != io.ErrUnexpectedEOF || !bytes.HasPrefix(got, want) {
+			t.Fatalf("decompress = %q, compress/flate read %q, %v", got, want, ferr)
+		}
+	}
+}
+
+func FuzzDecompress(f *testing.F) {
+	f.Add([]byte("ssh"))
+	f.Add(bytes.Repeat([]byte("channel data "), 1000))
+	f.Add([]byte{0x01, 0x05, 0x00, 0xfa, 0xff, 'h', 'e', 'l', 'l', 'o', 0x02, 0x00})
+	f.Fuzz(checkDecompress)
+}
@@ -0,0 +1,208 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	mrand "math/rand"
+	"testing"
+)
+
+// switchKeys makes both transports move to unencrypted keys using
+// compression from w to r.
+func switchKeys(t *testing.T, w, r *transport, compression string) {
+	w.writer.pendingKeyChange <- keyChange{&streamPacketCipher{cipher: noneCipher{}}, compression}
+	r.reader.pendingKeyChange <- keyChange{&streamPacketCipher{cipher: noneCipher{}}, compression}
+	if err := w.writePacket([]byte{msgNewKeys}); err != nil {
+		t.Fatalf("writePacket(msgNewKeys): %v", err)
+	}
+	if p, err := r.readPacket(); err != nil || p[0] != msgNewKeys {
+		t.Fatalf("readPacket = %v, %v, want msgNewKeys", p, err)
+	}
+}
+
+func TestTransportCompression(t *testing.T) {
+	payload := append([]byte{msgChannelData}, bytes.Repeat([]byte("compress me "), 100)...)
+	for _, tt := range []struct {
+		compression    string
+		compressBefore bool
+	}{
+		{compressionZlib, true},
+		{compressionZlibDelayed, false},
+	} {
+		// The traffic of both directions goes through buf, but
+		// only one of them is written at a time.
+		buf := &closerBuffer{}
+		server := newTransport(buf, rand.Reader, false)
+		client := newTransport(buf, rand.Reader, true)
+		switchKeys(t, server, client, tt.compression)
+		switchKeys(t, client, server, tt.compression)
+
+		send := func(w, r *transport, p []byte, compressed bool) {
+			if err := w.writePacket(p); err != nil {
+				t.Fatalf("%s: writePacket: %v", tt.compression, err)
+			}
+			if got := buf.Len() < len(p); got != compressed {
+				t.Errorf("%s: packet of type %d took %d bytes on the wire, compressed %v, want %v", tt.compression, p[0], buf.Len(), got, compressed)
+			}
+			got, err := r.readPacket()
+			if err != nil {
+				t.Fatalf("%s: readPacket: %v", tt.compression, err)
+			}
+			if !bytes.Equal(got, p) {
+				t.Fatalf("%s: read packet %q, want %q", tt.compression, got, p)
+			}
+		}
+		send(server, client, payload, tt.compressBefore)
+		send(client, server, payload, tt.compressBefore)
+		send(server, client, []byte{msgUserAuthSuccess}, false)
+		send(server, client, payload, true)
+		send(client, server, payload, true)
+
+		// Compression goes on across key changes.
+		switchKeys(t, client, server, tt.compression)
+		send(client, server, payload, true)
+	}
+}
+
+// TestDecompressPartialFlush checks packets that end with a partial
+// flush, as sent by OpenSSH, which are not byte aligned.
+func TestDecompressPartialFlush(t *testing.T) {
+	want := [][]byte{
+		append([]byte{msgChannelData}, bytes.Repeat([]byte("hello, world "), 20)...),
+		{msgChannelData, 'x'},
+		append([]byte{msgChannelData}, bytes.Repeat(make([]byte, 256), 2)...),
+	}
+	for i := 0; i < 256; i++ {
+		want[2][1+i] = byte(i)
+		want[2][1+256+i] = byte(i)
+	}
+	// Generated with zlib: compress(p) + flush(Z_PARTIAL_FLUSH).
+	packets := []string{
+		"789c8acb48cdc9c9d75128cf2fca495118a11c8000",
+		"8aab0008",
+		"a03806462666165636760e4e2e6e1e5e3e7e0141216111513171094929691959397905452565155535750d4d2d6d1d5d3d7d03432363135333730b4b2b6b1b5b3b7b07472767175737770f4f2f6f1f5f3fff80c0a0e090d0b0f088c8a8e898d8b8f884c4a4e494d4b4f48cccacec9cdcbcfc82c2a2e292d2b2f28acaaaea9adabafa86c6a6e696d6b6f68eceaeee9edebefe0913274d9e3275daf4193367cd9e3377defc050b172d5eb274d9f2152b57ad5eb376ddfa0d1b376ddeb275dbf61d3b77eddeb377dffe03070f1d3e72f4d8f113274f9d3e73f6dcf90b172f5dbe72f5daf51b376fddbe73f7defd070f1f3d7ef2f4d9f3172f5fbd7ef3f6ddfb0f1f3f7dfef2f5dbf71f3f7ffdfef3f7dfff91ee7f8000",
+	}
+	d := newDecompressor()
+	defer d.close()
+	for i, h := range packets {
+		packet, err := hex.DecodeString(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := d.decompress(packet)
+		if err != nil {
+			t.Fatalf("decompress packet %d: %v", i, err)
+		}
+		if !bytes.Equal(got, want[i]) {
+			t.Fatalf("packet %d decompressed to %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	rnd := mrand.New(mrand.NewSource(1))
+	words := []string{"ssh", "channel", "data", "window", "adjust", "\x00\x00\x01", "\xff"}
+	c := newCompressor()
+	d := newDecompressor()
+	defer d.close()
+	for i := 0; i < 200; i++ {
+		var want []byte
+		for n := rnd.Intn(3 * inflateChunk); len(want) < n; {
+			if rnd.Intn(10) == 0 {
+				want = append(want, byte(rnd.Intn(256)))
+			} else {
+				want = append(want, words[rnd.Intn(len(words))]...)
+			}
+		}
+		want = append(want, 'x')
+		packet, err := c.compress(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := d.decompress(packet)
+		if err != nil {
+			t.Fatalf("decompress packet %d: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("packet %d decompressed to %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestDecompressInvalid(t *testing.T) {
+	for _, packet := range []string{
+		"7800",               // Bad header checksum.
+		"78bb0000",           // Preset dictionary.
+		"789c07",             // Reserved block type.
+		"789c01050000000000", // Bad stored block length.
+		// Incomplete literal/length code.
+		"789c7451410ec3300883a746dba41ca65ed089d74f0102a6d9da4a38d418cb5125567d",
+	} {
+		data, _ := hex.DecodeString(packet)
+		d := newDecompressor()
+		if got, err := d.decompress(data); err == nil {
+			t.Errorf("decompress(%s) = %q, want an error", packet, got)
+		}
+		d.close()
+	}
+}
+
+func TestDecompressTooLarge(t *testing.T) {
+	c := newCompressor()
+	packet, err := c.compress(make([]byte, maxDecompressedPacket+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDecompressor()
+	defer d.close()
+	if _, err := d.decompress(packet); err != errDecompressedTooLarge {
+		t.Errorf("decompress = %v, want %v", err, errDecompressedTooLarge)
+	}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	payload := bytes.Repeat([]byte("compress me "), 1000)
+	for _, tt := range []struct {
+		client, server []string
+		want           string
+	}{
+		{nil, nil, compressionNone},
+		{nil, []string{compressionZlibDelayed, compressionNone}, compressionNone},
+		{[]string{compressionZlibDelayed, compressionNone}, []string{compressionNone, compressionZlibDelayed}, compressionZlibDelayed},
+		{[]string{compressionZlib, compressionZlibDelayed}, []string{compressionZlibDelayed, compressionZlib}, compressionZlib},
+		// Unsupported algorithms are skipped.
+		{[]string{"zstd@example.com", compressionZlibDelayed}, []string{compressionZlibDelayed}, compressionZlibDelayed},
+	} {
+		serverConf := testServerConfig()
+		serverConf.Compressions = tt.server
+		clientConf := testClientConfig()
+		clientConf.Compressions = tt.client
+
+		client, server, chans, reqs := pipePair(t, serverConf, clientConf)
+		go func() {
+			for req := range reqs {
+				req.Reply(true, req.Payload)
+			}
+		}()
+		go func() {
+			for newCh := range chans {
+				newCh.Reject(Prohibited, "")
+			}
+		}()
+		algs := client.Conn.(AlgorithmsConn).Algorithms()
+		if algs.Read.Compression != tt.want || algs.Write.Compression != tt.want {
+			t.Errorf("client %v, server %v: got compression %q and %q, want %q", tt.client, tt.server, algs.Write.Compression, algs.Read.Compression, tt.want)
+		}
+		ok, reply, err := client.SendRequest("echo", true, payload)
+		if err != nil || !ok || !bytes.Equal(reply, payload) {
+			t.Errorf("%s: SendRequest = %v, %d bytes, %v, want the payload echoed", tt.want, ok, len(reply), err)
+		}
+		client.Close()
+		server.Close()
+	}
+}
@@ -7,6 +7,8 @@ package ssh
 import (
 	"fmt"
 	"net"
+	"strings"
+	"time"
 )
 
 // OpenChannelError is returned if the other side rejects an
@@ -74,6 +76,131 @@ type Conn interface {
 	//   Disconnect
 }
 
+// KexInitConn is implemented by the Conn values returned from
+// NewClientConn and NewServerConn. It gives access to the raw
+// SSH_MSG_KEXINIT payloads that were exchanged at the start of the
+// connection.
+type KexInitConn interface {
+	Conn
+
+	// KexInitPayloads returns copies of the client and server
+	// SSH_MSG_KEXINIT payloads of the first key exchange, exactly
+	// as they were sent on the wire and hashed into the session
+	// ID. Later key exchanges do not change the returned values.
+	KexInitPayloads() (client, server []byte)
+}
+
+// DirectionAlgorithms are the algorithms used for the packets sent in
+// one direction of a connection.
+type DirectionAlgorithms struct {
+	Cipher      string
+	MAC         string
+	Compression string
+}
+
+// EncryptThenMAC reports whether the packets are authenticated after
+// they are encrypted, as they are with the encrypt-then-MAC variants
+// such as hmac-sha2-256-etm@openssh.com, rather than before. It is
+// also true for the AEAD ciphers, such as aes128-gcm@openssh.com,
+// which authenticate the packets themselves and do not use the MAC.
+func (a DirectionAlgorithms) EncryptThenMAC() bool {
+	switch a.Cipher {
+	case gcmCipherID, chacha20Poly1305ID:
+		return true
+	}
+	mode := macModes[a.MAC]
+	return mode != nil && mode.etm
+}
+
+// NegotiatedAlgorithms are the algorithms agreed upon in the last key
+// exchange of a connection.
+type NegotiatedAlgorithms struct {
+	KeyExchange string
+	HostKey     string
+
+	// Read applies to the packets received by the local side,
+	// and Write to those it sends.
+	Read, Write DirectionAlgorithms
+}
+
+// AlgorithmsConn is implemented by the Conn values returned from
+// NewClientConn and NewServerConn.
+type AlgorithmsConn interface {
+	Conn
+
+	// Algorithms returns the algorithms negotiated in the last key
+	// exchange. They may change when the keys are renewed.
+	Algorithms() NegotiatedAlgorithms
+}
+
+// CapabilityConn is implemented by the Conn values returned from
+// NewClientConn and NewServerConn.
+type CapabilityConn interface {
+	Conn
+
+	// PeerSupports reports whether the remote side advertised
+	// capability, which is a namespace and a name separated by a
+	// colon:
+	//
+	//	kex:NAME          key exchange method, or a marker such as
+	//	                  ext-info-c, listed in its SSH_MSG_KEXINIT
+	//	hostkey:NAME      host key algorithm listed in its SSH_MSG_KEXINIT
+	//	cipher:NAME       cipher listed for both directions in its SSH_MSG_KEXINIT
+	//	mac:NAME          MAC listed for both directions in its SSH_MSG_KEXINIT
+	//	compression:NAME  compression listed for both directions in its SSH_MSG_KEXINIT
+	//	ext:NAME          extension sent in its SSH_MSG_EXT_INFO (RFC 8308)
+	//
+	// The algorithm lists are those of the first key exchange. Only
+	// a server sends SSH_MSG_EXT_INFO, so ext: capabilities are
+	// always false on the server side. The protocol does not
+	// advertise channel or request types; those can only be tried.
+	// Unknown namespaces are reported as unsupported.
+	PeerSupports(capability string) bool
+}
+
+// ChannelInfo describes an open channel of a connection.
+type ChannelInfo struct {
+	// Type is the channel type, such as "session".
+	Type string
+
+	// LocalID and RemoteID are the channel numbers used by the
+	// local and the remote side.
+	LocalID, RemoteID uint32
+
+	// Inbound is true for channels that were opened by the remote
+	// side.
+	Inbound bool
+
+	// Created is the time the local side learned of the channel.
+	Created time.Time
+
+	// BytesReceived and BytesSent count the data, including
+	// extended data such as stderr, transferred on the channel.
+	BytesReceived, BytesSent uint64
+}
+
+// ChannelLister is implemented by the Conn values returned from
+// NewClientConn and NewServerConn.
+type ChannelLister interface {
+	Conn
+	// Channels returns a snapshot of the channels that are
+	// currently open on the connection, ordered by LocalID.
+	Channels() []ChannelInfo
+}
+
+// NetConnGetter is implemented by the Conn values returned from
+// NewClientConn and NewServerConn. Their LocalAddr and RemoteAddr are
+// those of the underlying net.Conn.
+type NetConnGetter interface {
+	Conn
+
+	// NetConn returns the net.Conn that the connection runs over,
+	// for example to set socket options after connecting. Reading
+	// from it, writing to it or setting its deadlines corrupts or
+	// stalls the SSH connection.
+	NetConn() net.Conn
+}
+
 // DiscardRequests consumes and rejects all requests from the
 // passed-in channel.
 func DiscardRequests(in <-chan *Request) {
@@ -87,6 +214,7 @@ func DiscardRequests(in <-chan *Request) {
 // A connection represents an incoming connection.
 type connection struct {
 	transport *handshakeTransport
+	traffic   *trafficCounters
 	sshConn
 
 	// The connection protocol.
@@ -97,6 +225,100 @@ func (c *connection) Close() error {
 	return c.sshConn.conn.Close()
 }
 
+// disconnectTimeout bounds the time spent sending a disconnect
+// message, which blocks if the peer stops reading.
+const disconnectTimeout = time.Second
+
+// disconnect sends a disconnect message to the peer, waiting at most
+// disconnectTimeout for it to go out, and closes c.
+func (c *connection) disconnect(reason DisconnectReason, message string) error {
+	sent := make(chan struct{})
+	go func() {
+		c.transport.writePacket(Marshal(&disconnectMsg{
+			Reason:  reason,
+			Message: message,
+		}))
+		close(sent)
+	}()
+	timer := time.NewTimer(disconnectTimeout)
+	select {
+	case <-sent:
+	case <-timer.C:
+	}
+	timer.Stop()
+	return c.Close()
+}
+
+// limitLifetime disconnects c once d elapsed, if d is positive.
+func (c *connection) limitLifetime(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	timer := time.AfterFunc(d, func() {
+		c.transport.config.log(LogLevelInfo, "ssh: maximum connection lifetime exceeded", "lifetime", d)
+		c.disconnect(DisconnectByApplication, fmt.Sprintf("maximum connection lifetime of %v exceeded", d))
+	})
+	go func() {
+		c.Wait()
+		timer.Stop()
+	}()
+}
+
+func (c *connection) KexInitPayloads() (client, server []byte) {
+	return dup(c.transport.clientKexInit), dup(c.transport.serverKexInit)
+}
+
+func (c *connection) PeerSupports(capability string) bool {
+	i := strings.IndexByte(capability, ':')
+	if i < 0 {
+		return false
+	}
+	namespace, name := capability[:i], capability[i+1:]
+	if namespace == "ext" {
+		_, ok := c.transport.serverExtensions()[name]
+		return ok
+	}
+
+	peerKexInit := c.transport.serverKexInit
+	if len(c.transport.hostKeys) > 0 {
+		peerKexInit = c.transport.clientKexInit
+	}
+	var msg kexInitMsg
+	if err := Unmarshal(peerKexInit, &msg); err != nil {
+		return false
+	}
+	switch namespace {
+	case "kex":
+		return contains(msg.KexAlgos, name)
+	case "hostkey":
+		return contains(msg.ServerHostKeyAlgos, name)
+	case "cipher":
+		return contains(msg.CiphersClientServer, name) && contains(msg.CiphersServerClient, name)
+	case "mac":
+		return contains(msg.MACsClientServer, name) && contains(msg.MACsServerClient, name)
+	case "compression":
+		return contains(msg.CompressionClientServer, name) && contains(msg.CompressionServerClient, name)
+	}
+	return false
+}
+
+func (c *connection) NetConn() net.Conn {
+	return c.sshConn.conn
+}
+
+func (c *connection) Algorithms() NegotiatedAlgorithms {
+	a := c.transport.getAlgorithms()
+	if a == nil {
+		return NegotiatedAlgorithms{}
+	}
+	return NegotiatedAlgorithms{
+		KeyExchange: a.kex,
+		HostKey:     a.hostKey,
+		Read:        DirectionAlgorithms(a.r),
+		Write:       DirectionAlgorithms(a.w),
+	}
+}
+
 // sshconn provides net.Conn metadata, but disallows direct reads and
 // writes.
 type sshConn struct {
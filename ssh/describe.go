@@ -0,0 +1,225 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A ConfigSummary describes the effective policy of a ClientConfig or
+// ServerConfig, with the defaults of this package filled in, so that it
+// can be logged for audits or compared across releases. It holds no
+// keys, passwords or other secrets: callbacks are only listed by the
+// name of their field.
+type ConfigSummary struct {
+	// Role is "client" or "server".
+	Role string
+
+	// Version is the version identification string that is sent.
+	Version string
+
+	KeyExchanges []string
+	Ciphers      []string
+	MACs         []string
+	Compressions []string
+
+	// HostKeyAlgorithms are, for a client, the host key algorithms
+	// it accepts, and for a server, the types of its host keys.
+	HostKeyAlgorithms []string
+
+	// AuthMethods are the names of the authentication methods, such
+	// as "publickey", that a client tries, in order, or that a
+	// server accepts. "none" is listed for a server with
+	// NoClientAuth.
+	AuthMethods []string
+
+	// Callbacks are the names of the fields holding callbacks, and
+	// of the other hooks such as Logger, that are set, in
+	// alphabetical order.
+	Callbacks []string
+
+	HandshakeTimeout time.Duration
+
+	// RekeyThreshold is zero if the default for the cipher is used.
+	RekeyThreshold uint64
+
+	// MaxLifetime is zero if connections may stay open forever.
+	MaxLifetime time.Duration
+
+	// MinRSAKeySize is zero if RSA keys of any size are accepted.
+	MinRSAKeySize int
+
+	// MaxAuthTries is only set for a server. It is negative if
+	// the number of attempts is unlimited.
+	MaxAuthTries int
+
+	// ExtInfo are the names of the extensions of RFC 8308 set in
+	// ServerConfig.ExtInfo, sorted. It is only set for a server.
+	ExtInfo []string
+
+	// NoAgentForwarding reports ClientConfig.DisableAgentForwarding
+	// or ServerConfig.RejectAgentForwarding.
+	NoAgentForwarding bool
+}
+
+// String returns the summary as one "name: value" line per field, in
+// a fixed order. Lists are separated by commas.
+func (s ConfigSummary) String() string {
+	var b strings.Builder
+	line := func(name string, value interface{}) {
+		fmt.Fprintf(&b, "%s: %v\n", name, value)
+	}
+	list := func(name string, values []string) {
+		line(name, strings.Join(values, ","))
+	}
+	line("role", s.Role)
+	line("version", s.Version)
+	list("kex", s.KeyExchanges)
+	list("ciphers", s.Ciphers)
+	list("macs", s.MACs)
+	list("compressions", s.Compressions)
+	list("hostkeys", s.HostKeyAlgorithms)
+	list("auth", s.AuthMethods)
+	list("callbacks", s.Callbacks)
+	line("handshake-timeout", s.HandshakeTimeout)
+	line("rekey-threshold", s.RekeyThreshold)
+	line("max-lifetime", s.MaxLifetime)
+	line("min-rsa-key-size", s.MinRSAKeySize)
+	line("no-agent-forwarding", s.NoAgentForwarding)
+	if s.Role == "server" {
+		line("max-auth-tries", s.MaxAuthTries)
+		list("ext-info", s.ExtInfo)
+	}
+	return b.String()
+}
+
+func (s *ConfigSummary) describeConfig(c *Config) {
+	full := *c
+	full.SetDefaults()
+	s.KeyExchanges = append([]string(nil), full.KeyExchanges...)
+	s.Ciphers = append([]string(nil), full.Ciphers...)
+	s.MACs = append([]string(nil), full.MACs...)
+	s.Compressions = append([]string(nil), full.Compressions...)
+	s.RekeyThreshold = full.RekeyThreshold
+	s.MaxLifetime = full.MaxLifetime
+}
+
+// nonNil returns the names of the callbacks that are set, sorted.
+func nonNil(callbacks map[string]bool) []string {
+	var names []string
+	for name, set := range callbacks {
+		if set {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Describe returns a summary of the policy of c.
+func (c *ClientConfig) Describe() ConfigSummary {
+	s := ConfigSummary{
+		Role:              "client",
+		Version:           packageVersion,
+		HostKeyAlgorithms: supportedHostKeyAlgos,
+		HandshakeTimeout:  c.HandshakeTimeout,
+		NoAgentForwarding: c.DisableAgentForwarding,
+	}
+	if c.ClientVersion != "" {
+		s.Version = c.ClientVersion
+	}
+	if c.HostKeyAlgorithms != nil {
+		s.HostKeyAlgorithms = c.HostKeyAlgorithms
+	}
+	s.HostKeyAlgorithms = append([]string(nil), s.HostKeyAlgorithms...)
+	if c.MinRSAKeySize > 0 {
+		s.MinRSAKeySize = c.MinRSAKeySize
+	}
+	if !c.SkipNoneAuth || len(c.Auth) == 0 {
+		s.AuthMethods = append(s.AuthMethods, "none")
+	}
+	for _, a := range c.Auth {
+		if m := a.method(); !contains(s.AuthMethods, m) {
+			s.AuthMethods = append(s.AuthMethods, m)
+		}
+	}
+	s.describeConfig(&c.Config)
+	s.Callbacks = nonNil(map[string]bool{
+		"BannerCallback":         c.BannerCallback != nil,
+		"BannerLanguageCallback": c.BannerLanguageCallback != nil,
+		"HostKeyCallback":        c.HostKeyCallback != nil,
+		"Logger":                 c.Logger != nil,
+		"OnExtInfo":              c.OnExtInfo != nil,
+		"OnRekey":                c.OnRekey != nil,
+	})
+	return s
+}
+
+// Describe returns a summary of the policy of c.
+func (c *ServerConfig) Describe() ConfigSummary {
+	s := ConfigSummary{
+		Role:              "server",
+		Version:           packageVersion,
+		HandshakeTimeout:  c.HandshakeTimeout,
+		MaxAuthTries:      c.MaxAuthTries,
+		MinRSAKeySize:     c.MinRSAKeySize,
+		NoAgentForwarding: c.RejectAgentForwarding,
+	}
+	if c.ServerVersion != "" {
+		s.Version = c.ServerVersion
+	}
+	hostKeys := c.hostKeys
+	if c.HostKeySet != nil {
+		hostKeys = kexHostKeys(c.HostKeySet.Keys())
+	}
+	for _, k := range hostKeys {
+		s.HostKeyAlgorithms = append(s.HostKeyAlgorithms, k.PublicKey().Type())
+	}
+	for name := range c.ExtInfo {
+		s.ExtInfo = append(s.ExtInfo, name)
+	}
+	sort.Strings(s.ExtInfo)
+	if s.MaxAuthTries == 0 {
+		s.MaxAuthTries = 6
+	}
+	if s.MinRSAKeySize == 0 {
+		s.MinRSAKeySize = defaultMinRSAKeySize
+	} else if s.MinRSAKeySize < 0 {
+		s.MinRSAKeySize = 0
+	}
+	for _, m := range []struct {
+		name string
+		set  bool
+	}{
+		{"none", c.NoClientAuth},
+		{"password", c.PasswordCallback != nil},
+		{"publickey", c.PublicKeyCallback != nil || c.PublicKeyAlgorithmCallback != nil},
+		{"keyboard-interactive", c.KeyboardInteractiveCallback != nil},
+		{"gssapi-with-mic", c.GSSAPIWithMICConfig != nil},
+	} {
+		if m.set {
+			s.AuthMethods = append(s.AuthMethods, m.name)
+		}
+	}
+	s.describeConfig(&c.Config)
+	s.Callbacks = nonNil(map[string]bool{
+		"AcceptEnv":                   c.AcceptEnv != nil,
+		"AuthLogCallback":             c.AuthLogCallback != nil,
+		"BannerCallback":              c.BannerCallback != nil,
+		"ClientVersionCallback":       c.ClientVersionCallback != nil,
+		"DisconnectCallback":          c.DisconnectCallback != nil,
+		"KeyboardInteractiveCallback": c.KeyboardInteractiveCallback != nil,
+		"Logger":                      c.Logger != nil,
+		"OnChannelOpen":               c.OnChannelOpen != nil,
+		"OnRekey":                     c.OnRekey != nil,
+		"PasswordCallback":            c.PasswordCallback != nil,
+		"PublicKeyAlgorithmCallback":  c.PublicKeyAlgorithmCallback != nil,
+		"PublicKeyCallback":           c.PublicKeyCallback != nil,
+	})
+	return s
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientConfigDescribe(t *testing.T) {
+	config := &ClientConfig{
+		User:              "gopher",
+		Auth:              []AuthMethod{Password("secret"), PublicKeys(testSigners["ecdsa"]), Password("other")},
+		HostKeyCallback:   InsecureIgnoreHostKey(),
+		HostKeyAlgorithms: []string{KeyAlgoED25519},
+		HandshakeTimeout:  time.Minute,
+		OnExtInfo:         func(map[string][]byte) {},
+	}
+	config.Ciphers = []string{chacha20Poly1305ID, "unknown-cipher"}
+	s := config.Describe()
+
+	want := ConfigSummary{
+		Role:              "client",
+		Version:           packageVersion,
+		KeyExchanges:      preferredKexAlgos,
+		Ciphers:           []string{chacha20Poly1305ID},
+		MACs:              supportedMACs,
+		Compressions:      []string{compressionNone},
+		HostKeyAlgorithms: []string{KeyAlgoED25519},
+		AuthMethods:       []string{"none", "password", "publickey"},
+		Callbacks:         []string{"HostKeyCallback", "OnExtInfo"},
+		HandshakeTimeout:  time.Minute,
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+	if str := s.String(); strings.Contains(str, "secret") || !strings.Contains(str, "ciphers: "+chacha20Poly1305ID+"\n") {
+		t.Errorf("unexpected String:\n%s", str)
+	}
+	if s.String() != config.Describe().String() {
+		t.Error("String is not stable")
+	}
+}
+
+func TestServerConfigDescribe(t *testing.T) {
+	config := &ServerConfig{
+		PasswordCallback: func(conn ConnMetadata, password []byte) (*Permissions, error) {
+			return nil, nil
+		},
+		PublicKeyCallback: func(conn ConnMetadata, key PublicKey) (*Permissions, error) {
+			return nil, nil
+		},
+		ServerVersion:         "SSH-2.0-Audit",
+		MaxAuthTries:          -1,
+		RejectAgentForwarding: true,
+		ExtInfo:               map[string][]byte{"other@example.com": nil},
+	}
+	config.KeyExchanges = []string{kexAlgoCurve25519SHA256}
+	config.Compressions = []string{compressionZlib, compressionNone}
+	config.OnRekey = func(string) {}
+	config.MACs = []string{"hmac-sha2-256-etm@openssh.com"}
+	config.AddHostKey(testSigners["ed25519"])
+	config.AddHostKey(testSigners["rsa"])
+	s := config.Describe()
+
+	want := ConfigSummary{
+		Role:              "server",
+		Version:           "SSH-2.0-Audit",
+		KeyExchanges:      []string{kexAlgoCurve25519SHA256},
+		Ciphers:           preferredCiphers,
+		MACs:              []string{"hmac-sha2-256-etm@openssh.com"},
+		Compressions:      []string{compressionZlib, compressionNone},
+		HostKeyAlgorithms: []string{KeyAlgoED25519, KeyAlgoRSA},
+		AuthMethods:       []string{"password", "publickey"},
+		Callbacks:         []string{"OnRekey", "PasswordCallback", "PublicKeyCallback"},
+		ExtInfo:           []string{"other@example.com"},
+		MinRSAKeySize:     defaultMinRSAKeySize,
+		MaxAuthTries:      -1,
+		NoAgentForwarding: true,
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+	for _, line := range []string{
+		"role: server\n",
+		"hostkeys: ssh-ed25519,ssh-rsa\n",
+		"auth: password,publickey\n",
+		"max-auth-tries: -1\n",
+		"compressions: zlib,none\n",
+		"ext-info: other@example.com\n",
+		"no-agent-forwarding: true\n",
+	} {
+		if !strings.Contains(s.String(), line) {
+			t.Errorf("String does not contain %q:\n%s", line, s)
+		}
+	}
+}
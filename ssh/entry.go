@@ -0,0 +1,192 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// EntryKind identifies what an Entry of an authorized_keys or
+// known_hosts file describes.
+type EntryKind int
+
+const (
+	// EntryPublicKey is a plain public key.
+	EntryPublicKey EntryKind = iota
+
+	// EntryCertificate is a certificate; the Key of the Entry is a
+	// *Certificate.
+	EntryCertificate
+
+	// EntryCertAuthority is the key of a certificate authority,
+	// marked with "@cert-authority" in known_hosts files or with
+	// the "cert-authority" option in authorized_keys files.
+	EntryCertAuthority
+
+	// EntryRevoked is a revoked key, marked with "@revoked" in
+	// known_hosts files.
+	EntryRevoked
+)
+
+func (k EntryKind) String() string {
+	switch k {
+	case EntryPublicKey:
+		return "public key"
+	case EntryCertificate:
+		return "certificate"
+	case EntryCertAuthority:
+		return "cert-authority"
+	case EntryRevoked:
+		return "revoked"
+	}
+	return fmt.Sprintf("EntryKind(%d)", int(k))
+}
+
+// An Entry is a line of an authorized_keys or known_hosts file.
+type Entry struct {
+	Kind EntryKind
+
+	// Key is the key of the entry.
+	Key PublicKey
+
+	// Hosts are the host patterns of a known_hosts entry, which may
+	// be hashed. It is nil for authorized_keys entries.
+	Hosts []string
+
+	// Options are the options of an authorized_keys entry. It is
+	// nil for known_hosts entries.
+	Options []string
+
+	// Comment is the comment after the key, if any.
+	Comment string
+}
+
+// ParseEntry parses a single line of either an authorized_keys or a
+// known_hosts file, as described in the sshd(8) manual page, and
+// returns it as an Entry. Lines with a "@cert-authority" or "@revoked"
+// marker, and lines whose first field is not an authorized_keys option
+// list, are parsed as known_hosts entries; other lines are parsed as
+// authorized_keys entries. Empty and comment lines are an error.
+func ParseEntry(line []byte) (*Entry, error) {
+	if i := bytes.IndexAny(line, "\r\n"); i != -1 {
+		line = line[:i]
+	}
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] == '#' {
+		return nil, errors.New("ssh: no entry in line")
+	}
+
+	fields := bytes.Fields(line)
+	if line[0] == '@' || (len(fields) >= 3 && !isAuthorizedKeysOptions(fields[0]) && isKnownKeyType(fields[1])) {
+		return parseKnownHostsEntry(line)
+	}
+
+	key, comment, options, _, err := ParseAuthorizedKey(line)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{
+		Kind:    entryKindOf(key),
+		Key:     key,
+		Options: options,
+		Comment: comment,
+	}
+	for _, o := range options {
+		if strings.EqualFold(o, "cert-authority") {
+			e.Kind = EntryCertAuthority
+		}
+	}
+	return e, nil
+}
+
+func parseKnownHostsEntry(line []byte) (*Entry, error) {
+	marker, hosts, key, comment, _, err := ParseKnownHosts(line)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{
+		Kind:    entryKindOf(key),
+		Key:     key,
+		Hosts:   hosts,
+		Comment: comment,
+	}
+	switch marker {
+	case "":
+	case "cert-authority":
+		e.Kind = EntryCertAuthority
+	case "revoked":
+		e.Kind = EntryRevoked
+	default:
+		return nil, fmt.Errorf("ssh: unknown marker %q in known_hosts entry", marker)
+	}
+	return e, nil
+}
+
+func entryKindOf(key PublicKey) EntryKind {
+	if _, ok := key.(*Certificate); ok {
+		return EntryCertificate
+	}
+	return EntryPublicKey
+}
+
+// isKnownKeyType reports whether name is a key or certificate type.
+func isKnownKeyType(name []byte) bool {
+	switch string(name) {
+	case KeyAlgoRSA, KeyAlgoDSA, KeyAlgoECDSA256, KeyAlgoECDSA384, KeyAlgoECDSA521, KeyAlgoSKECDSA256, KeyAlgoED25519, KeyAlgoSKED25519,
+		CertAlgoRSAv01, CertAlgoDSAv01, CertAlgoECDSA256v01, CertAlgoECDSA384v01, CertAlgoECDSA521v01, CertAlgoSKECDSA256v01, CertAlgoED25519v01, CertAlgoSKED25519v01:
+		return true
+	}
+	return false
+}
+
+// authorizedKeysOptions are the option names of authorized_keys files
+// known to OpenSSH.
+var authorizedKeysOptions = map[string]bool{
+	"agent-forwarding":    true,
+	"cert-authority":      true,
+	"command":             true,
+	"environment":         true,
+	"expiry-time":         true,
+	"from":                true,
+	"no-agent-forwarding": true,
+	"no-port-forwarding":  true,
+	"no-pty":              true,
+	"no-touch-required":   true,
+	"no-user-rc":          true,
+	"no-x11-forwarding":   true,
+	"permitlisten":        true,
+	"permitopen":          true,
+	"port-forwarding":     true,
+	"principals":          true,
+	"pty":                 true,
+	"restrict":            true,
+	"tunnel":              true,
+	"user-rc":             true,
+	"verify-required":     true,
+	"x11-forwarding":      true,
+}
+
+// isAuthorizedKeysOptions reports whether field, the first field of a
+// line, is a list of authorized_keys options rather than known_hosts
+// host patterns.
+func isAuthorizedKeysOptions(field []byte) bool {
+	if bytes.IndexByte(field, '"') != -1 {
+		// Host patterns are never quoted.
+		return true
+	}
+	for _, opt := range strings.Split(string(field), ",") {
+		name := opt
+		if i := strings.IndexByte(opt, '='); i != -1 {
+			name = opt[:i]
+		}
+		if !authorizedKeysOptions[strings.ToLower(name)] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEntry(t *testing.T) {
+	key := strings.TrimSpace(string(MarshalAuthorizedKey(testPublicKeys["rsa"])))
+	cert := strings.TrimSpace(string(MarshalAuthorizedKey(testSigners["cert"].PublicKey())))
+
+	for _, tt := range []struct {
+		line string
+		want Entry
+	}{
+		{
+			line: key + " user@host",
+			want: Entry{Kind: EntryPublicKey, Comment: "user@host"},
+		},
+		{
+			line: `no-pty,command="echo hi" ` + key + " user@host\n",
+			want: Entry{Kind: EntryPublicKey, Options: []string{"no-pty", `command="echo hi"`}, Comment: "user@host"},
+		},
+		{
+			line: `cert-authority,principals="alice" ` + key,
+			want: Entry{Kind: EntryCertAuthority, Options: []string{"cert-authority", `principals="alice"`}},
+		},
+		{
+			line: cert + " a cert",
+			want: Entry{Kind: EntryCertificate, Comment: "a cert"},
+		},
+		{
+			line: "example.com,192.0.2.1 " + key + "\r\n",
+			want: Entry{Kind: EntryPublicKey, Hosts: []string{"example.com", "192.0.2.1"}},
+		},
+		{
+			line: "|1|ZmFrZXNhbHQ=|ZmFrZWhhc2g= " + key + " hashed",
+			want: Entry{Kind: EntryPublicKey, Hosts: []string{"|1|ZmFrZXNhbHQ=|ZmFrZWhhc2g="}, Comment: "hashed"},
+		},
+		{
+			line: "@cert-authority *.example.com " + key,
+			want: Entry{Kind: EntryCertAuthority, Hosts: []string{"*.example.com"}},
+		},
+		{
+			line: "@revoked * " + key + " old-key",
+			want: Entry{Kind: EntryRevoked, Hosts: []string{"*"}, Comment: "old-key"},
+		},
+	} {
+		got, err := ParseEntry([]byte(tt.line))
+		if err != nil {
+			t.Errorf("ParseEntry(%q): %v", tt.line, err)
+			continue
+		}
+		if got.Key == nil {
+			t.Errorf("ParseEntry(%q) returned no key", tt.line)
+			continue
+		}
+		wantKey := testPublicKeys["rsa"]
+		if tt.want.Kind == EntryCertificate {
+			wantKey = testSigners["cert"].PublicKey()
+		}
+		if !bytes.Equal(got.Key.Marshal(), wantKey.Marshal()) {
+			t.Errorf("ParseEntry(%q) returned the wrong key", tt.line)
+		}
+		got.Key = nil
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("ParseEntry(%q) = %+v, want %+v", tt.line, *got, tt.want)
+		}
+	}
+
+	for _, line := range []string{
+		"",
+		"# comment",
+		"@unknown host " + key,
+		"example.com ssh-rsa bm90IGEga2V5",
+	} {
+		if e, err := ParseEntry([]byte(line)); err == nil {
+			t.Errorf("ParseEntry(%q) = %+v, want error", line, e)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// KeyingMaterialExporter is implemented by the Conn values returned
+// from NewClientConn and NewServerConn.
+type KeyingMaterialExporter interface {
+	Conn
+
+	// ExportKeyingMaterial returns length bytes of keying material
+	// derived from the secrets of the session, in the manner of the
+	// TLS exporters of RFC 5705, so that applications can bind
+	// their own protocols to the SSH session. Both sides of a
+	// connection derive the same material for the same label and
+	// context, and the material does not change when the keys are
+	// renewed.
+	//
+	// SSH does not standardize exporters. The material is
+	//
+	//	HKDF-Expand(PRK, info, length)
+	//	PRK  = HKDF-Extract(salt = session ID, IKM = K)
+	//	info = string("EXPORTER-SSH") || string(label) || string(context)
+	//
+	// as specified in RFC 5869, where K is the shared secret of the
+	// first key exchange, encoded as in its exchange hash, HKDF uses
+	// the hash of that key exchange, and string is the encoding of
+	// RFC 4251 Section 5. The label must not be empty, and length is at most
+	// 255 times the size of the hash.
+	ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error)
+}
+
+// exporterLabel separates the exporter secret from the other secrets
+// derived from K.
+const exporterLabel = "EXPORTER-SSH"
+
+// exporterSecret returns the PRK of ExportKeyingMaterial for the first
+// key exchange of a connection.
+func exporterSecret(r *kexResult) []byte {
+	return hkdf.Extract(r.Hash.New, r.K, r.H)
+}
+
+func (c *connection) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	if label == "" {
+		return nil, errors.New("ssh: empty exporter label")
+	}
+	h := c.transport.exporterHash
+	if length < 0 || length > 255*h.Size() {
+		return nil, errors.New("ssh: invalid exporter length")
+	}
+	info := appendString(nil, exporterLabel)
+	info = appendString(info, label)
+	info = appendString(info, string(context))
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(h.New, c.transport.exporterSecret, info), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExportKeyingMaterial(t *testing.T) {
+	for _, kex := range []string{kexAlgoCurve25519SHA256, kexAlgoECDH384, kexAlgoDH14SHA1} {
+		t.Run(kex, func(t *testing.T) {
+			serverConf := testServerConfig()
+			clientConf := testClientConfig()
+			clientConf.KeyExchanges = []string{kex}
+			client, server, _, reqs := pipePair(t, serverConf, clientConf)
+			go DiscardRequests(reqs)
+
+			clientExporter := client.Conn.(KeyingMaterialExporter)
+			serverExporter := server.Conn.(KeyingMaterialExporter)
+			export := func(e KeyingMaterialExporter, label string, context []byte, length int) []byte {
+				t.Helper()
+				b, err := e.ExportKeyingMaterial(label, context, length)
+				if err != nil {
+					t.Fatalf("ExportKeyingMaterial(%q, %q, %d): %v", label, context, length, err)
+				}
+				if len(b) != length {
+					t.Fatalf("ExportKeyingMaterial(%q, %q, %d) returned %d bytes", label, context, length, len(b))
+				}
+				return b
+			}
+
+			want := export(clientExporter, "test", []byte("context"), 64)
+			if got := export(serverExporter, "test", []byte("context"), 64); !bytes.Equal(got, want) {
+				t.Errorf("server exported %x, client exported %x", got, want)
+			}
+			if got := export(clientExporter, "test", []byte("context"), 32); !bytes.Equal(got, want[:32]) {
+				t.Errorf("shorter material %x is not a prefix of %x", got, want)
+			}
+			for _, other := range [][]byte{
+				export(clientExporter, "test2", []byte("context"), 64),
+				export(clientExporter, "test", []byte("context2"), 64),
+				export(clientExporter, "test", nil, 64),
+				export(clientExporter, "testcontext", nil, 64),
+			} {
+				if bytes.Equal(other, want) {
+					t.Errorf("material %x does not depend on the label and context", other)
+				}
+			}
+			if bytes.Contains(want, client.SessionID()) {
+				t.Error("material contains the session ID")
+			}
+
+			// The material survives key renewal.
+			client.Conn.(*connection).transport.requestKeyExchange()
+			if _, _, err := client.SendRequest("ping", true, nil); err != nil {
+				t.Fatalf("SendRequest: %v", err)
+			}
+			if got := export(serverExporter, "test", []byte("context"), 64); !bytes.Equal(got, want) {
+				t.Errorf("material changed after key renewal: %x, want %x", got, want)
+			}
+
+			if _, err := clientExporter.ExportKeyingMaterial("", nil, 32); err == nil {
+				t.Error("ExportKeyingMaterial succeeded with an empty label")
+			}
+			if _, err := clientExporter.ExportKeyingMaterial("test", nil, 255*64+1); err == nil {
+				t.Error("ExportKeyingMaterial succeeded with an excessive length")
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The extension negotiation of RFC 8308. A client that lists
+// extInfoClient among its key exchange algorithms receives an
+// extension info message from the server after the first key exchange.
+// The server-sig-algs extension lists the signature algorithms that the
+// server accepts for public key authentication, so that clients can use
+// rsa-sha2-256 or rsa-sha2-512 instead of the SHA-1 based ssh-rsa.
+const (
+	extInfoClient    = "ext-info-c"
+	extServerSigAlgs = "server-sig-algs"
+)
+
+// serverSigAlgs are the signature algorithms a server advertises in
+// server-sig-algs, in addition to those of ServerConfig.SignatureAlgorithms.
+var serverSigAlgs = []string{
+	KeyAlgoED25519, KeyAlgoSKED25519, KeyAlgoSKECDSA256,
+	KeyAlgoECDSA256, KeyAlgoECDSA384, KeyAlgoECDSA521,
+	SigAlgoRSASHA2512, SigAlgoRSASHA2256, SigAlgoRSA, KeyAlgoDSA,
+}
+
+// protocolExtensions are the extensions of OpenSSH with names of the
+// form name@domain that change the protocol, which a server of this
+// package must not advertise.
+var protocolExtensions = []string{
+	"publickey-hostbound@openssh.com",
+	"ping@openssh.com",
+}
+
+// checkExtInfo returns an error if exts, a ServerConfig.ExtInfo,
+// holds an extension that changes the protocol. Those of RFC 8308,
+// such as delay-compression, and the others registered with IANA have
+// names without a domain.
+func checkExtInfo(exts map[string][]byte) error {
+	for name := range exts {
+		if name == extServerSigAlgs {
+			continue
+		}
+		if !strings.Contains(name, "@") || contains(protocolExtensions, name) {
+			return fmt.Errorf("ssh: extension %q changes the protocol and can't be advertised", name)
+		}
+	}
+	return nil
+}
+
+// serverExtInfo returns the extension info message of a server,
+// with the extensions of extra after server-sig-algs, sorted by name.
+// The names of sigAlgs are advertised after serverSigAlgs, sorted.
+func serverExtInfo(extra map[string][]byte, sigAlgs map[string]SignatureAlgorithm) []byte {
+	var added []string
+	for name := range sigAlgs {
+		added = append(added, name)
+	}
+	sort.Strings(added)
+	algos := append(append([]string(nil), serverSigAlgs...), added...)
+
+	payload := appendString(nil, extServerSigAlgs)
+	payload = appendString(payload, strings.Join(algos, ","))
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		if name != extServerSigAlgs {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		payload = appendString(payload, name)
+		payload = appendString(payload, string(extra[name]))
+	}
+	return Marshal(&extInfoMsg{NumExtensions: uint32(1 + len(names)), Payload: payload})
+}
+
+// parseExtInfo returns the extensions of an extension info message.
+func parseExtInfo(packet []byte) (map[string][]byte, error) {
+	var msg extInfoMsg
+	if err := Unmarshal(packet, &msg); err != nil {
+		return nil, err
+	}
+	exts := make(map[string][]byte)
+	in := msg.Payload
+	for i := uint32(0); i < msg.NumExtensions; i++ {
+		name, rest, ok := parseString(in)
+		if !ok {
+			return nil, errShortRead
+		}
+		value, rest, ok := parseString(rest)
+		if !ok {
+			return nil, errShortRead
+		}
+		exts[string(name)] = append([]byte(nil), value...)
+		in = rest
+	}
+	return exts, nil
+}
+
+// pickSignatureAlgorithm returns the algorithm with which a client
+// authenticates with signer over c: rsa-sha2-512 or rsa-sha2-256
+// for RSA keys if the server accepts them, which it tells with the
+// server-sig-algs extension, and the type of the key otherwise.
+func pickSignatureAlgorithm(signer Signer, c packetConn) string {
+	pub := signer.PublicKey()
+	if _, ok := signer.(AlgorithmSigner); !ok || pub.Type() != KeyAlgoRSA {
+		return pub.Type()
+	}
+	t, ok := authTransport(c)
+	if !ok {
+		return pub.Type()
+	}
+	accepted := t.serverSignatureAlgorithms()
+	for _, algo := range []string{SigAlgoRSASHA2512, SigAlgoRSASHA2256} {
+		if contains(accepted, algo) {
+			return algo
+		}
+	}
+	return pub.Type()
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestCustomExtInfo(t *testing.T) {
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+		ExtInfo: map[string][]byte{
+			"app@example.com":   {0, 1, 2, 0xff},
+			"other@example.com": []byte("\x00\x00\x00\x04none"),
+			"empty@example.com": {},
+			extServerSigAlgs:    []byte("ignored"),
+		},
+	}
+	serverConf.AddHostKey(testSigners["ecdsa"])
+
+	got := make(chan map[string][]byte, 1)
+	clientConf := &ClientConfig{
+		HostKeyCallback: InsecureIgnoreHostKey(),
+		OnExtInfo: func(exts map[string][]byte) {
+			got <- exts
+		},
+	}
+	client, _, _, _ := pipePair(t, serverConf, clientConf)
+
+	var exts map[string][]byte
+	select {
+	case exts = <-got:
+	default:
+		t.Fatal("OnExtInfo was not called during the handshake")
+	}
+	if len(exts) != 4 {
+		t.Errorf("got extensions %q, want 4", exts)
+	}
+	for name, value := range serverConf.ExtInfo {
+		if name == extServerSigAlgs {
+			continue
+		}
+		if v, ok := exts[name]; !ok || !bytes.Equal(v, value) {
+			t.Errorf("got extension %s = %q, %v, want %q", name, v, ok, value)
+		}
+	}
+	if algos := string(exts[extServerSigAlgs]); !strings.Contains(algos, SigAlgoRSASHA2512) {
+		t.Errorf("got server-sig-algs %q", algos)
+	}
+	if algos := client.Conn.(*connection).transport.serverSignatureAlgorithms(); !contains(algos, SigAlgoRSASHA2512) {
+		t.Errorf("client recorded server-sig-algs %q", algos)
+	}
+}
+
+func TestProtocolExtInfo(t *testing.T) {
+	for _, name := range []string{"delay-compression", "no-flow-control", "elevation", "ping@openssh.com", "publickey-hostbound@openssh.com"} {
+		serverConf := &ServerConfig{
+			NoClientAuth: true,
+			ExtInfo:      map[string][]byte{name: nil},
+		}
+		serverConf.AddHostKey(testSigners["ecdsa"])
+		c1, c2 := net.Pipe()
+		if _, _, _, err := NewServerConn(c1, serverConf); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("NewServerConn with extension %s: got %v, want an error", name, err)
+		}
+		c1.Close()
+		c2.Close()
+	}
+}
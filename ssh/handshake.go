@@ -5,13 +5,16 @@
 package ssh
 
 import (
+	"crypto"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"strings"
 	"sync"
+	"time"
 )
 
 // debugHandshake, if set, prints messages sent and received.  Key
@@ -62,7 +65,7 @@ type handshakeTransport struct {
 	writeError     error
 	sentInitPacket []byte
 	sentInitMsg    *kexInitMsg
-	pendingPackets [][]byte // Used when a key exchange is in progress.
+	pendingPackets []pendingPacket // Used when a key exchange is in progress.
 
 	// If the read loop wants to schedule a kex, it pings this
 	// channel, and the write loop will send out a kex
@@ -75,17 +78,23 @@ type handshakeTransport struct {
 
 	// data for host key checking
 	hostKeyCallback HostKeyCallback
+	minRSAKeySize   int
 	dialAddress     string
 	remoteAddr      net.Addr
 
 	// bannerCallback is non-empty if we are the client and it has been set in
 	// ClientConfig. In that case it is called during the user authentication
 	// dance to handle a custom server's message.
-	bannerCallback BannerCallback
+	bannerCallback BannerLanguageCallback
 
 	// Algorithms agreed in the last key exchange.
 	algorithms *algorithms
 
+	// negotiated is a copy of algorithms after a key exchange
+	// completed, for reading from other goroutines. It is
+	// protected by mu.
+	negotiated *algorithms
+
 	readPacketsLeft uint32
 	readBytesLeft   int64
 
@@ -94,6 +103,55 @@ type handshakeTransport struct {
 
 	// The session ID or nil if first kex did not complete yet.
 	sessionID []byte
+
+	// The KEXINIT payloads of the first key exchange, as they were
+	// hashed into the session ID.
+	clientKexInit, serverKexInit []byte
+
+	// The secret behind ExportKeyingMaterial, derived in the first
+	// key exchange, and its hash.
+	exporterSecret []byte
+	exporterHash   crypto.Hash
+
+	// extInfoReady is closed once the client read the first packet
+	// after the first key exchange, which is the extension info
+	// message if the server sent one. serverSigAlgs holds its
+	// server-sig-algs extension, and serverExts all of them.
+	extInfoReady  chan struct{}
+	serverSigAlgs []string
+	serverExts    map[string][]byte
+
+	// extInfo holds the extensions a server advertises along with
+	// server-sig-algs, and onExtInfo is called by a client with
+	// those of the server.
+	extInfo   map[string][]byte
+	onExtInfo func(exts map[string][]byte)
+
+	// signatureAlgorithms are the additional signature algorithms a
+	// server advertises in server-sig-algs.
+	signatureAlgorithms map[string]SignatureAlgorithm
+}
+
+// errHandshakeTimeout is returned by NewClientConn and NewServerConn if
+// the handshake did not complete within the configured
+// HandshakeTimeout.
+var errHandshakeTimeout error = handshakeTimeoutError{}
+
+type handshakeTimeoutError struct{}
+
+func (handshakeTimeoutError) Error() string   { return "ssh: handshake timed out" }
+func (handshakeTimeoutError) Timeout() bool   { return true }
+func (handshakeTimeoutError) Temporary() bool { return false }
+
+// startHandshakeTimer closes c if the handshake did not complete
+// within d. A zero or negative d disables the timer. The returned
+// function stops the timer, and reports whether it had already fired.
+func startHandshakeTimer(c net.Conn, d time.Duration) (stop func() bool) {
+	if d <= 0 {
+		return func() bool { return false }
+	}
+	timer := time.AfterFunc(d, func() { c.Close() })
+	return func() bool { return !timer.Stop() }
 }
 
 type pendingKex struct {
@@ -125,7 +183,17 @@ func newClientTransport(conn keyingTransport, clientVersion, serverVersion []byt
 	t.dialAddress = dialAddr
 	t.remoteAddr = addr
 	t.hostKeyCallback = config.HostKeyCallback
-	t.bannerCallback = config.BannerCallback
+	t.minRSAKeySize = config.MinRSAKeySize
+	t.extInfoReady = make(chan struct{})
+	t.onExtInfo = config.OnExtInfo
+	if config.BannerLanguageCallback != nil {
+		t.bannerCallback = config.BannerLanguageCallback
+	} else if config.BannerCallback != nil {
+		cb := config.BannerCallback
+		t.bannerCallback = func(message, language string) error {
+			return cb(message)
+		}
+	}
 	if config.HostKeyAlgorithms != nil {
 		t.hostKeyAlgorithms = config.HostKeyAlgorithms
 	} else {
@@ -139,11 +207,21 @@ func newClientTransport(conn keyingTransport, clientVersion, serverVersion []byt
 func newServerTransport(conn keyingTransport, clientVersion, serverVersion []byte, config *ServerConfig) *handshakeTransport {
 	t := newHandshakeTransport(conn, &config.Config, clientVersion, serverVersion)
 	t.hostKeys = config.hostKeys
+	t.extInfo = config.ExtInfo
+	t.signatureAlgorithms = config.SignatureAlgorithms
 	go t.readLoop()
 	go t.kexLoop()
 	return t
 }
 
+// getAlgorithms returns the algorithms of the last completed key
+// exchange, or nil.
+func (t *handshakeTransport) getAlgorithms() *algorithms {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.negotiated
+}
+
 func (t *handshakeTransport) getSessionID() []byte {
 	return t.sessionID
 }
@@ -193,10 +271,22 @@ func (t *handshakeTransport) readPacket() ([]byte, error) {
 
 func (t *handshakeTransport) readLoop() {
 	first := true
+	// Clients wait for the extension info of the server until
+	// extInfoReady is closed; extInfoExpected is set from the end of
+	// the first key exchange until the packet that may be it.
+	extInfoPending := t.extInfoReady != nil
+	extInfoExpected := false
+	extInfoDone := func() {
+		extInfoPending, extInfoExpected = false, false
+		close(t.extInfoReady)
+	}
 	for {
 		p, err := t.readOnePacket(first)
 		first = false
 		if err != nil {
+			if d, ok := err.(*disconnectMsg); ok {
+				t.config.log(LogLevelInfo, "ssh: received disconnect", "reason", d.Reason, "message", d.Message)
+			}
 			t.readError = err
 			close(t.incoming)
 			break
@@ -204,8 +294,27 @@ func (t *handshakeTransport) readLoop() {
 		if p[0] == msgIgnore || p[0] == msgDebug {
 			continue
 		}
+		if p[0] == msgExtInfo {
+			// Extension info that does not follow the first
+			// key exchange, such as the one a server may
+			// send after the authentication, is ignored.
+			if extInfoExpected {
+				t.handleExtInfo(p)
+				extInfoDone()
+			}
+			continue
+		}
+		if extInfoExpected {
+			extInfoDone()
+		}
+		if p[0] == msgNewKeys && extInfoPending {
+			extInfoExpected = true
+		}
 		t.incoming <- p
 	}
+	if extInfoPending {
+		extInfoDone()
+	}
 
 	// Stop writers too.
 	t.recordWriteError(t.readError)
@@ -216,10 +325,57 @@ func (t *handshakeTransport) readLoop() {
 	// Don't close t.requestKex; it's also written to from writePacket.
 }
 
-func (t *handshakeTransport) pushPacket(p []byte) error {
+// handleExtInfo records the extensions of the server.
+func (t *handshakeTransport) handleExtInfo(p []byte) {
+	exts, err := parseExtInfo(p)
+	if err != nil {
+		t.config.log(LogLevelWarn, "ssh: invalid extension info", "error", err)
+		return
+	}
+	t.serverExts = exts
+	if algos, ok := exts[extServerSigAlgs]; ok {
+		t.serverSigAlgs = strings.Split(string(algos), ",")
+	}
+	if t.onExtInfo != nil {
+		t.onExtInfo(exts)
+	}
+}
+
+// serverSignatureAlgorithms returns the signature algorithms that the
+// server accepts for public key authentication, according to its
+// server-sig-algs extension, or nil if it did not send one. It waits
+// for the extension info of the server, which follows the first key
+// exchange.
+func (t *handshakeTransport) serverSignatureAlgorithms() []string {
+	<-t.extInfoReady
+	return t.serverSigAlgs
+}
+
+// serverExtensions returns the extensions the server sent after the
+// first key exchange. It returns nil on the server side, which does
+// not receive any.
+func (t *handshakeTransport) serverExtensions() map[string][]byte {
+	if t.extInfoReady == nil {
+		return nil
+	}
+	<-t.extInfoReady
+	return t.serverExts
+}
+
+// pendingPacket is a packet queued while a key exchange is in
+// progress, along with the size it should be padded to.
+type pendingPacket struct {
+	packet []byte
+	padTo  int
+}
+
+func (t *handshakeTransport) pushPacket(p []byte, padTo int) error {
 	if debugHandshake {
 		t.printPacket(p, true)
 	}
+	if w, ok := t.conn.(paddedPacketWriter); ok && padTo > 0 {
+		return w.writePaddedPacket(p, padTo)
+	}
 	return t.conn.writePacket(p)
 }
 
@@ -262,6 +418,9 @@ write:
 	for t.getWriteError() == nil {
 		var request *pendingKex
 		var sent bool
+		// remote is set if the other side started the key
+		// exchange, sending its kexInit before ours.
+		var remote bool
 
 		for request == nil || !sent {
 			var ok bool
@@ -270,6 +429,7 @@ write:
 				if !ok {
 					break write
 				}
+				remote = !sent
 			case <-t.requestKex:
 				break
 			}
@@ -298,10 +458,14 @@ write:
 		// another key change request, until we close the done
 		// channel on the pendingKex request.
 
+		firstKex := t.sessionID == nil
 		err := t.enterKeyExchange(request.otherInit)
 
 		t.mu.Lock()
 		t.writeError = err
+		if err == nil {
+			t.negotiated = t.algorithms
+		}
 		t.sentInitPacket = nil
 		t.sentInitMsg = nil
 
@@ -331,13 +495,21 @@ write:
 		// another kex while we are still busy with the last
 		// one, things will become very confusing.
 		for _, p := range t.pendingPackets {
-			t.writeError = t.pushPacket(p)
+			t.writeError = t.pushPacket(p.packet, p.padTo)
 			if t.writeError != nil {
 				break
 			}
 		}
 		t.pendingPackets = t.pendingPackets[:0]
 		t.mu.Unlock()
+
+		if err == nil && !firstKex && t.config.OnRekey != nil {
+			reason := RekeyReasonThreshold
+			if remote {
+				reason = RekeyReasonRemote
+			}
+			t.config.OnRekey(reason)
+		}
 	}
 
 	// drain startKex channel. We don't service t.requestKex
@@ -414,6 +586,7 @@ func (t *handshakeTransport) readOnePacket(first bool) ([]byte, error) {
 	}
 
 	if err != nil {
+		t.config.log(LogLevelWarn, "ssh: key exchange failed", "first", firstKex, "error", err)
 		return nil, err
 	}
 
@@ -444,14 +617,18 @@ func (t *handshakeTransport) sendKexInit() error {
 		return nil
 	}
 
+	kexAlgos := t.config.KeyExchanges
+	if len(t.hostKeys) == 0 && t.sessionID == nil {
+		kexAlgos = append(kexAlgos[:len(kexAlgos):len(kexAlgos)], extInfoClient)
+	}
 	msg := &kexInitMsg{
-		KexAlgos:                t.config.KeyExchanges,
+		KexAlgos:                kexAlgos,
 		CiphersClientServer:     t.config.Ciphers,
 		CiphersServerClient:     t.config.Ciphers,
 		MACsClientServer:        t.config.MACs,
 		MACsServerClient:        t.config.MACs,
-		CompressionClientServer: supportedCompressions,
-		CompressionServerClient: supportedCompressions,
+		CompressionClientServer: t.config.Compressions,
+		CompressionServerClient: t.config.Compressions,
 	}
 	io.ReadFull(rand.Reader, msg.Cookie[:])
 
@@ -469,7 +646,7 @@ func (t *handshakeTransport) sendKexInit() error {
 	packetCopy := make([]byte, len(packet))
 	copy(packetCopy, packet)
 
-	if err := t.pushPacket(packetCopy); err != nil {
+	if err := t.pushPacket(packetCopy, 0); err != nil {
 		return err
 	}
 
@@ -480,6 +657,12 @@ func (t *handshakeTransport) sendKexInit() error {
 }
 
 func (t *handshakeTransport) writePacket(p []byte) error {
+	return t.writePaddedPacket(p, 0)
+}
+
+// writePaddedPacket is like writePacket, but asks the underlying
+// transport to pad p to padTo bytes on the wire.
+func (t *handshakeTransport) writePaddedPacket(p []byte, padTo int) error {
 	switch p[0] {
 	case msgKexInit:
 		return errors.New("ssh: only handshakeTransport can send kexInit")
@@ -497,7 +680,7 @@ func (t *handshakeTransport) writePacket(p []byte) error {
 		// Copy the packet so the writer can reuse the buffer.
 		cp := make([]byte, len(p))
 		copy(cp, p)
-		t.pendingPackets = append(t.pendingPackets, cp)
+		t.pendingPackets = append(t.pendingPackets, pendingPacket{cp, padTo})
 		return nil
 	}
 
@@ -513,7 +696,7 @@ func (t *handshakeTransport) writePacket(p []byte) error {
 		t.requestKeyExchange()
 	}
 
-	if err := t.pushPacket(p); err != nil {
+	if err := t.pushPacket(p, padTo); err != nil {
 		t.writeError = err
 	}
 
@@ -591,8 +774,13 @@ func (t *handshakeTransport) enterKeyExchange(otherInitPacket []byte) error {
 		return err
 	}
 
-	if t.sessionID == nil {
+	firstKex := t.sessionID == nil
+	if firstKex {
 		t.sessionID = result.H
+		t.clientKexInit = dup(magics.clientKexInit)
+		t.serverKexInit = dup(magics.serverKexInit)
+		t.exporterSecret = exporterSecret(result)
+		t.exporterHash = result.Hash
 	}
 	result.SessionID = t.sessionID
 
@@ -602,12 +790,29 @@ func (t *handshakeTransport) enterKeyExchange(otherInitPacket []byte) error {
 	if err = t.conn.writePacket([]byte{msgNewKeys}); err != nil {
 		return err
 	}
+	if !isClient && firstKex && contains(clientInit.KexAlgos, extInfoClient) {
+		if err := t.conn.writePacket(serverExtInfo(t.extInfo, t.signatureAlgorithms)); err != nil {
+			return err
+		}
+	}
 	if packet, err := t.conn.readPacket(); err != nil {
 		return err
 	} else if packet[0] != msgNewKeys {
 		return unexpectedMessageError(msgNewKeys, packet[0])
 	}
 
+	msg := "ssh: key exchange completed"
+	if !firstKex {
+		msg = "ssh: rekey completed"
+	}
+	t.config.log(LogLevelInfo, msg,
+		"kex", t.algorithms.kex,
+		"hostkey", t.algorithms.hostKey,
+		"cipher_write", t.algorithms.w.Cipher,
+		"mac_write", t.algorithms.w.MAC,
+		"cipher_read", t.algorithms.r.Cipher,
+		"mac_read", t.algorithms.r.MAC)
+
 	return nil
 }
 
@@ -638,6 +843,10 @@ func (t *handshakeTransport) client(kex kexAlgorithm, algs *algorithms, magics *
 		return nil, err
 	}
 
+	if err := checkRSAKeySize(hostKey, t.minRSAKeySize); err != nil {
+		return nil, err
+	}
+
 	err = t.hostKeyCallback(t.dialAddress, t.remoteAddr, hostKey)
 	if err != nil {
 		return nil, err
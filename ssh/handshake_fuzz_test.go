@@ -0,0 +1,109 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package ssh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// fuzzConn is a net.Conn that reads the traffic of a peer from a
+// buffer and discards what is written to it.
+type fuzzConn struct {
+	r io.Reader
+}
+
+func (c *fuzzConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c *fuzzConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c *fuzzConn) Close() error                       { return nil }
+func (c *fuzzConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *fuzzConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *fuzzConn) SetDeadline(t time.Time) error      { return nil }
+func (c *fuzzConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *fuzzConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// fuzzPacket frames payload as an unencrypted packet.
+func fuzzPacket(payload []byte) []byte {
+	padding := packetSizeMultiple - (5+len(payload))%packetSizeMultiple
+	if padding < 4 {
+		padding += packetSizeMultiple
+	}
+	p := make([]byte, 5, 5+len(payload)+padding)
+	binary.BigEndian.PutUint32(p, uint32(1+len(payload)+padding))
+	p[4] = byte(padding)
+	p = append(p, payload...)
+	return append(p, make([]byte, padding)...)
+}
+
+// fuzzSeeds returns handshake traffic of a peer, valid and malformed.
+func fuzzSeeds() [][]byte {
+	const version = "SSH-2.0-fuzz\r\n"
+	config := &Config{}
+	config.SetDefaults()
+	kexInit := Marshal(&kexInitMsg{
+		KexAlgos:                config.KeyExchanges,
+		ServerHostKeyAlgos:      []string{KeyAlgoED25519, KeyAlgoRSA},
+		CiphersClientServer:     config.Ciphers,
+		CiphersServerClient:     config.Ciphers,
+		MACsClientServer:        config.MACs,
+		MACsServerClient:        config.MACs,
+		CompressionClientServer: supportedCompressions,
+		CompressionServerClient: supportedCompressions,
+	})
+	// A name-list whose length exceeds the packet.
+	hugeList := append([]byte{msgKexInit}, make([]byte, 16)...)
+	hugeList = append(hugeList, 0xff, 0xff, 0xff, 0xf0, 'a')
+
+	seeds := [][]byte{
+		[]byte(version),
+		[]byte("SSH-1.99-fuzz\r\n"),
+		append([]byte(version), fuzzPacket(kexInit)...),
+		append([]byte(version), fuzzPacket(kexInit[:40])...),
+		append([]byte(version), fuzzPacket(hugeList)...),
+		append(append([]byte(version), fuzzPacket(kexInit)...), fuzzPacket(kexInit)...),
+		append(append([]byte(version), fuzzPacket(kexInit)...), fuzzPacket([]byte{msgKexECDHInit, 0, 0, 0, 32})...),
+		append(append([]byte(version), fuzzPacket(kexInit)...), fuzzPacket([]byte{msgNewKeys})...),
+		append([]byte(version), 0xff, 0xff, 0xff, 0xff, 4),
+		append([]byte(version), 0, 0, 0, 12, 200, msgKexInit, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0),
+	}
+	return seeds
+}
+
+// FuzzHandshake feeds arbitrary bytes as the handshake traffic of the
+// peer to a client and a server, which must fail cleanly.
+func FuzzHandshake(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		f.Add(seed)
+	}
+	// Enable all the algorithms, including those the defaults leave
+	// out, so that their parsers are exercised too.
+	config := Config{
+		KeyExchanges: append(append([]string(nil), supportedKexAlgos...), kexAlgoDHGEXSHA1, kexAlgoDHGEXSHA256),
+		Ciphers:      supportedCiphers,
+		MACs:         supportedMACs,
+	}
+	serverConf := &ServerConfig{Config: config, NoClientAuth: true}
+	serverConf.KeyExchanges = supportedKexAlgos
+	serverConf.AddHostKey(testSigners["ed25519"])
+	serverConf.AddHostKey(testSigners["rsa"])
+	serverConf.AddHostKey(testSigners["ecdsa"])
+	clientConf := &ClientConfig{Config: config, HostKeyCallback: InsecureIgnoreHostKey()}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if conn, _, _, err := NewServerConn(&fuzzConn{bytes.NewReader(data)}, serverConf); err == nil {
+			conn.Close()
+		}
+		if conn, _, _, err := NewClientConn(&fuzzConn{bytes.NewReader(data)}, "fuzz", clientConf); err == nil {
+			conn.Close()
+		}
+	})
+}
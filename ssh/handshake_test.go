@@ -7,6 +7,7 @@ package ssh
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha512"
 	"errors"
 	"fmt"
 	"io"
@@ -560,3 +561,260 @@ func TestHandshakeRekeyDefault(t *testing.T) {
 		t.Errorf("got rekey after %dG write, want 64G", wgb)
 	}
 }
+
+// recordingConn records all bytes that pass through a net.Conn.
+type recordingConn struct {
+	net.Conn
+	mu            sync.Mutex
+	read, written bytes.Buffer
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mu.Lock()
+	c.read.Write(b[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.written.Write(b)
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+// firstPacketPayload returns the payload of the first unencrypted
+// binary packet following the version line in stream.
+func firstPacketPayload(t *testing.T, stream []byte) []byte {
+	i := bytes.Index(stream, []byte("\r\n"))
+	if i < 0 {
+		t.Fatalf("no version line in %q", stream)
+	}
+	stream = stream[i+2:]
+	length, rest, ok := parseUint32(stream)
+	if !ok || uint32(len(rest)) < length || length < 1 {
+		t.Fatalf("short packet")
+	}
+	padding := uint32(rest[0])
+	return rest[1 : length-padding]
+}
+
+func TestKexInitPayloads(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+	serverDone := make(chan struct{})
+	var serverConn *ServerConn
+	go func() {
+		defer close(serverDone)
+		serverConn, _, _, _ = NewServerConn(c1, serverConf)
+	}()
+
+	rc := &recordingConn{Conn: c2}
+	clientConf := &ClientConfig{
+		User:            "testuser",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+	conn, _, _, err := NewClientConn(rc, "", clientConf)
+	if err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	defer conn.Close()
+	<-serverDone
+	if serverConn == nil {
+		t.Fatal("server handshake failed")
+	}
+
+	rc.mu.Lock()
+	wantClient := dup(firstPacketPayload(t, rc.written.Bytes()))
+	wantServer := dup(firstPacketPayload(t, rc.read.Bytes()))
+	rc.mu.Unlock()
+
+	if wantClient[0] != msgKexInit || wantServer[0] != msgKexInit {
+		t.Fatalf("captured packets are not KEXINIT: %d, %d", wantClient[0], wantServer[0])
+	}
+
+	for _, c := range []Conn{conn, serverConn.Conn} {
+		client, server := c.(KexInitConn).KexInitPayloads()
+		if !bytes.Equal(client, wantClient) {
+			t.Errorf("client KEXINIT: got %x, want %x", client, wantClient)
+		}
+		if !bytes.Equal(server, wantServer) {
+			t.Errorf("server KEXINIT: got %x, want %x", server, wantServer)
+		}
+	}
+
+	// The returned slices are copies.
+	client, _ := conn.(KexInitConn).KexInitPayloads()
+	client[0] = 0
+	if client, _ = conn.(KexInitConn).KexInitPayloads(); client[0] != msgKexInit {
+		t.Errorf("KexInitPayloads returned internal buffer")
+	}
+}
+
+func TestHandshakeDHGroupsSHA512(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large DH groups in short mode")
+	}
+	for _, kex := range []string{kexAlgoDH16SHA512, kexAlgoDH18SHA512} {
+		t.Run(kex, func(t *testing.T) {
+			serverConf := testServerConfig()
+			serverConf.KeyExchanges = []string{kex}
+			clientConf := testClientConfig()
+			clientConf.KeyExchanges = []string{kexAlgoCurve25519SHA256, kex}
+
+			client, server, _, reqs := pipePair(t, serverConf, clientConf)
+			go DiscardRequests(reqs)
+
+			// The exchange hash, which becomes the session ID, is
+			// computed with SHA-512.
+			if n := len(client.SessionID()); n != sha512.Size {
+				t.Errorf("got session ID of %d bytes, want %d", n, sha512.Size)
+			}
+			if !bytes.Equal(client.SessionID(), server.SessionID()) {
+				t.Error("client and server session IDs differ")
+			}
+		})
+	}
+}
+
+// rekeyRecorder records the reasons passed to Config.OnRekey.
+type rekeyRecorder struct {
+	mu      sync.Mutex
+	reasons map[string]int
+}
+
+func (r *rekeyRecorder) onRekey(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.reasons == nil {
+		r.reasons = make(map[string]int)
+	}
+	r.reasons[reason]++
+}
+
+func (r *rekeyRecorder) get() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reasons
+}
+
+func TestRekeyDuringTransfer(t *testing.T) {
+	var serverRekeys, clientRekeys rekeyRecorder
+	serverConf := testServerConfig()
+	// The server renews the keys every 64 KiB, in the middle of
+	// the transfer.
+	serverConf.RekeyThreshold = 64 << 10
+	serverConf.OnRekey = serverRekeys.onRekey
+	clientConf := testClientConfig()
+	clientConf.OnRekey = clientRekeys.onRekey
+
+	client, _, chans, reqs := pipePair(t, serverConf, clientConf)
+	go DiscardRequests(reqs)
+	go func() {
+		for newCh := range chans {
+			ch, in, err := newCh.Accept()
+			if err != nil {
+				return
+			}
+			go DiscardRequests(in)
+			// Echo the data back, so that it crosses the
+			// key renewals in both directions.
+			go func() {
+				io.Copy(ch, ch)
+				ch.CloseWrite()
+			}()
+		}
+	}()
+
+	ch, in, err := client.OpenChannel("echo", nil)
+	if err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+	defer ch.Close()
+	go DiscardRequests(in)
+
+	// The data spans several channel windows, so that the key
+	// renewals happen while it is in flight.
+	data := make([]byte, 8<<20)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if _, err := io.Copy(ch, bytes.NewReader(data)); err != nil {
+			t.Errorf("Copy: %v", err)
+		}
+		ch.CloseWrite()
+	}()
+	var got bytes.Buffer
+	if _, err := io.Copy(&got, ch); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Fatalf("got %d bytes back, which differ from the %d bytes sent", got.Len(), len(data))
+	}
+
+	// The server started all the key renewals.
+	if _, _, err := client.SendRequest("ping", true, nil); err != nil {
+		t.Fatalf("SendRequest: %v", err)
+	}
+	srv, cli := serverRekeys.get(), clientRekeys.get()
+	if srv[RekeyReasonThreshold] < 2 || len(srv) != 1 {
+		t.Errorf("server rekeys: got %v, want several %q", srv, RekeyReasonThreshold)
+	}
+	if cli[RekeyReasonRemote] == 0 || len(cli) != 1 {
+		t.Errorf("client rekeys: got %v, want only %q", cli, RekeyReasonRemote)
+	}
+}
+
+func TestPeerSupports(t *testing.T) {
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+		ExtInfo:      map[string][]byte{"app@example.com": nil},
+	}
+	serverConf.Ciphers = []string{"aes128-ctr", "aes256-ctr"}
+	serverConf.AddHostKey(testSigners["ecdsa"])
+	clientConf := testClientConfig()
+	clientConf.Ciphers = []string{"aes256-ctr", chacha20Poly1305ID}
+	client, server, _, _ := pipePair(t, serverConf, clientConf)
+
+	for _, tt := range []struct {
+		conn       Conn
+		capability string
+		want       bool
+	}{
+		{client.Conn, "ext:" + extServerSigAlgs, true},
+		{client.Conn, "ext:app@example.com", true},
+		{client.Conn, "ext:unknown@example.com", false},
+		{client.Conn, "cipher:aes128-ctr", true},
+		{client.Conn, "cipher:" + chacha20Poly1305ID, false},
+		{client.Conn, "hostkey:" + KeyAlgoECDSA256, true},
+		{client.Conn, "hostkey:" + KeyAlgoED25519, false},
+		{client.Conn, "kex:" + extInfoClient, false},
+		{client.Conn, "compression:" + compressionNone, true},
+		{server.Conn, "cipher:" + chacha20Poly1305ID, true},
+		{server.Conn, "cipher:aes128-ctr", false},
+		{server.Conn, "kex:" + extInfoClient, true},
+		{server.Conn, "mac:hmac-sha2-256", true},
+		{server.Conn, "ext:" + extServerSigAlgs, false},
+		{client.Conn, extServerSigAlgs, false},
+		{client.Conn, "channel:session", false},
+	} {
+		side := "client"
+		if tt.conn == server.Conn {
+			side = "server"
+		}
+		if got := tt.conn.(CapabilityConn).PeerSupports(tt.capability); got != tt.want {
+			t.Errorf("%s: PeerSupports(%q) = %v, want %v", side, tt.capability, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+// The global requests of the OpenSSH host key rotation protocol, see
+// section 2.5 of PROTOCOL in the OpenSSH sources.
+const (
+	hostKeysRequestType      = "hostkeys-00@openssh.com"
+	hostKeysProveRequestType = "hostkeys-prove-00@openssh.com"
+)
+
+// A HostKeySet holds the host keys of a server, as
+// ServerConfig.HostKeySet, and can be changed while the server runs,
+// for instance to rotate them. It is safe for concurrent use.
+//
+// Each connection uses the keys that are in the set when it starts,
+// and advertises all of them to the client after authentication, with
+// the hostkeys-00@openssh.com request of OpenSSH. Clients that
+// implement the UpdateHostKeys option of OpenSSH then learn the keys,
+// which they can verify with hostkeys-prove-00@openssh.com requests,
+// and forget the keys that are no longer advertised. To rotate a key,
+// add the new key, wait for the clients to learn it, and then remove
+// the old key.
+type HostKeySet struct {
+	mu   sync.Mutex
+	keys []Signer
+}
+
+// NewHostKeySet returns a HostKeySet holding keys.
+func NewHostKeySet(keys ...Signer) *HostKeySet {
+	s := new(HostKeySet)
+	for _, k := range keys {
+		s.Add(k)
+	}
+	return s
+}
+
+// Add adds key to the set, after the keys it already has. Unlike
+// ServerConfig.AddHostKey, it keeps the keys of the same type: of
+// those, the first is used for key exchanges, and the others are only
+// advertised. Adding a key whose public key is already in the set has
+// no effect.
+func (s *HostKeySet) Add(key Signer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.indexLocked(key.PublicKey()) < 0 {
+		s.keys = append(s.keys, key)
+	}
+}
+
+// Remove removes the key whose public key is pub, and reports whether
+// it was in the set. Established connections are not affected.
+func (s *HostKeySet) Remove(pub PublicKey) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexLocked(pub)
+	if i < 0 {
+		return false
+	}
+	s.keys = append(s.keys[:i:i], s.keys[i+1:]...)
+	return true
+}
+
+// Keys returns the keys of the set, in the order they were added.
+func (s *HostKeySet) Keys() []Signer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Signer(nil), s.keys...)
+}
+
+func (s *HostKeySet) indexLocked(pub PublicKey) int {
+	blob := pub.Marshal()
+	for i, k := range s.keys {
+		if bytes.Equal(k.PublicKey().Marshal(), blob) {
+			return i
+		}
+	}
+	return -1
+}
+
+// kexHostKeys returns the first key of each type of keys, the keys used
+// for key exchanges.
+func kexHostKeys(keys []Signer) []Signer {
+	var r []Signer
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if typ := k.PublicKey().Type(); !seen[typ] {
+			seen[typ] = true
+			r = append(r, k)
+		}
+	}
+	return r
+}
+
+// hostKeysAdvertisement returns the payload of a hostkeys-00@openssh.com
+// request advertising keys.
+func hostKeysAdvertisement(keys []Signer) []byte {
+	var payload []byte
+	for _, k := range keys {
+		payload = appendString(payload, string(k.PublicKey().Marshal()))
+	}
+	return payload
+}
+
+// hostKeysProver answers the hostkeys-prove-00@openssh.com requests of
+// a connection.
+type hostKeysProver struct {
+	keys      []Signer
+	sessionID []byte
+	// kexAlgo is the host key algorithm of the first key exchange.
+	kexAlgo string
+	rand    io.Reader
+}
+
+// prove returns the response to a hostkeys-prove-00@openssh.com
+// request for the keys in payload, which must all be advertised: their
+// signatures of the session ID.
+func (p *hostKeysProver) prove(payload []byte) ([]byte, error) {
+	var response []byte
+	for len(payload) > 0 {
+		blob, rest, ok := parseString(payload)
+		if !ok {
+			return nil, errShortRead
+		}
+		payload = rest
+		var key Signer
+		for _, k := range p.keys {
+			if bytes.Equal(k.PublicKey().Marshal(), blob) {
+				key = k
+			}
+		}
+		if key == nil {
+			return nil, errors.New("ssh: hostkeys-prove request for a key that was not advertised")
+		}
+
+		data := appendString(nil, hostKeysProveRequestType)
+		data = appendString(data, string(p.sessionID))
+		data = appendString(data, string(blob))
+		var sig *Signature
+		var err error
+		if as, ok := key.(AlgorithmSigner); ok && key.PublicKey().Type() == KeyAlgoRSA {
+			// OpenSSH verifies RSA signatures with the algorithm of
+			// the key exchange, if it used an RSA key.
+			algo := SigAlgoRSASHA2512
+			switch p.kexAlgo {
+			case SigAlgoRSA, SigAlgoRSASHA2256:
+				algo = p.kexAlgo
+			}
+			sig, err = as.SignWithAlgorithm(p.rand, data, algo)
+		} else {
+			sig, err = key.Sign(p.rand, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		response = appendString(response, string(Marshal(sig)))
+	}
+	return response, nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// dialHostKeySet connects to a server using serverConf, and returns the
+// client side of the connection, the host key of the server and the
+// keys it advertised.
+func dialHostKeySet(t *testing.T, serverConf *ServerConfig) (Conn, PublicKey, []PublicKey) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+	go func() {
+		_, chans, reqs, err := NewServerConn(c1, serverConf)
+		if err != nil {
+			t.Errorf("NewServerConn: %v", err)
+			return
+		}
+		go DiscardRequests(reqs)
+		for newCh := range chans {
+			newCh.Reject(UnknownChannelType, "no channels")
+		}
+	}()
+
+	var hostKey PublicKey
+	clientConf := &ClientConfig{
+		HostKeyAlgorithms: []string{KeyAlgoECDSA256, KeyAlgoRSA},
+		HostKeyCallback: func(hostname string, remote net.Addr, key PublicKey) error {
+			hostKey = key
+			return nil
+		},
+	}
+	conn, chans, reqs, err := NewClientConn(c2, "", clientConf)
+	if err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	go func() {
+		for newCh := range chans {
+			newCh.Reject(UnknownChannelType, "no channels")
+		}
+	}()
+	req := <-reqs
+	go DiscardRequests(reqs)
+	if req.Type != hostKeysRequestType || req.WantReply {
+		t.Fatalf("got request %q, want a %s request", req.Type, hostKeysRequestType)
+	}
+	var advertised []PublicKey
+	for payload := req.Payload; len(payload) > 0; {
+		blob, rest, ok := parseString(payload)
+		if !ok {
+			t.Fatalf("malformed %s request", hostKeysRequestType)
+		}
+		key, err := ParsePublicKey(blob)
+		if err != nil {
+			t.Fatalf("ParsePublicKey: %v", err)
+		}
+		advertised = append(advertised, key)
+		payload = rest
+	}
+	return conn, hostKey, advertised
+}
+
+func checkKeys(t *testing.T, got []PublicKey, want ...Signer) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d keys, want %d", len(got), len(want))
+	}
+	for i, k := range want {
+		if !bytes.Equal(got[i].Marshal(), k.PublicKey().Marshal()) {
+			t.Errorf("key %d is a %s key, want the %s key %d", i, got[i].Type(), k.PublicKey().Type(), i)
+		}
+	}
+}
+
+func TestHostKeySet(t *testing.T) {
+	set := NewHostKeySet(testSigners["ecdsa"])
+	serverConf := &ServerConfig{NoClientAuth: true, HostKeySet: set}
+
+	// A new key is advertised, but the first key of its type is
+	// still used.
+	set.Add(testSigners["ecdsap256"])
+	set.Add(testSigners["rsa"])
+	set.Add(testSigners["rsa"])
+	conn, hostKey, advertised := dialHostKeySet(t, serverConf)
+	checkKeys(t, []PublicKey{hostKey}, testSigners["ecdsa"])
+	checkKeys(t, advertised, testSigners["ecdsa"], testSigners["ecdsap256"], testSigners["rsa"])
+
+	var payload []byte
+	for _, k := range advertised[1:] {
+		payload = appendString(payload, string(k.Marshal()))
+	}
+	ok, response, err := conn.SendRequest(hostKeysProveRequestType, true, payload)
+	if err != nil || !ok {
+		t.Fatalf("SendRequest(%s): %t, %v", hostKeysProveRequestType, ok, err)
+	}
+	for _, k := range advertised[1:] {
+		blob, rest, ok := parseString(response)
+		if !ok {
+			t.Fatalf("missing signature for the %s key", k.Type())
+		}
+		response = rest
+		sig := new(Signature)
+		if err := Unmarshal(blob, sig); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		data := appendString(nil, hostKeysProveRequestType)
+		data = appendString(data, string(conn.SessionID()))
+		data = appendString(data, string(k.Marshal()))
+		if err := k.Verify(data, sig); err != nil {
+			t.Errorf("%s signature: %v", k.Type(), err)
+		}
+		if k.Type() == KeyAlgoRSA && sig.Format != SigAlgoRSASHA2512 {
+			t.Errorf("RSA signature has format %s, want %s", sig.Format, SigAlgoRSASHA2512)
+		}
+	}
+	if len(response) > 0 {
+		t.Errorf("%d extra bytes in response", len(response))
+	}
+
+	unknown := appendString(nil, string(testPublicKeys["ed25519"].Marshal()))
+	if ok, _, err := conn.SendRequest(hostKeysProveRequestType, true, unknown); err != nil || ok {
+		t.Errorf("SendRequest(%s) for a key that was not advertised: %t, %v", hostKeysProveRequestType, ok, err)
+	}
+
+	// Removing the old key affects new connections only.
+	if !set.Remove(testPublicKeys["ecdsa"]) {
+		t.Fatal("Remove returned false")
+	}
+	if set.Remove(testPublicKeys["ecdsa"]) {
+		t.Fatal("Remove of a removed key returned true")
+	}
+	_, hostKey, advertised = dialHostKeySet(t, serverConf)
+	checkKeys(t, []PublicKey{hostKey}, testSigners["ecdsap256"])
+	checkKeys(t, advertised, testSigners["ecdsap256"], testSigners["rsa"])
+	if _, _, err := conn.SendRequest("ping", true, nil); err != nil {
+		t.Errorf("old connection: %v", err)
+	}
+}
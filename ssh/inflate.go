@@ -0,0 +1,477 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"errors"
+)
+
+// The packets compressed by OpenSSH end with a partial flush: an empty
+// block that is not byte aligned, so the next packet starts inside the
+// last byte of the previous one. compress/flate only returns what it
+// inflated once its window is full or at a stored block, which never
+// comes, so the decompressor has its own inflater (RFC 1950 and 1951).
+// It runs in a goroutine that reads the stream as the packets come,
+// and returns what it inflated whenever it needs the next packet.
+
+var (
+	errDecompressedTooLarge = errors.New("ssh: decompressed packet too large")
+	errCompressedStream     = errors.New("ssh: invalid compressed stream")
+	errDecompressorClosed   = errors.New("ssh: decompressor closed")
+)
+
+const (
+	inflateWindowSize = 1 << 15
+	// inflateChunk is how much inflated data is buffered before it
+	// is handed out, so that a small packet that inflates to a lot
+	// of data is caught early.
+	inflateChunk = 4096
+	// maxDecompressedPacket bounds what one packet may inflate to,
+	// the same as the largest packet that is accepted uncompressed.
+	maxDecompressedPacket = maxPacket
+)
+
+// decompressor decompresses the packets of one direction.
+type decompressor struct {
+	next    chan []byte
+	starved chan []byte
+	out     chan []byte
+	errc    chan error
+	done    chan struct{}
+	started bool
+
+	// The state of the inflating goroutine.
+	cur     []byte
+	bitBuf  uint32
+	bitCnt  uint
+	window  [inflateWindowSize]byte
+	written int
+	pending []byte
+}
+
+func newDecompressor() *decompressor {
+	return &decompressor{
+		next:    make(chan []byte),
+		starved: make(chan []byte),
+		out:     make(chan []byte),
+		errc:    make(chan error, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// decompress returns the data inflated from packet. After an error,
+// the decompressor must not be used anymore.
+func (d *decompressor) decompress(packet []byte) ([]byte, error) {
+	// The packet may point to an internal buffer of the cipher.
+	packet = append([]byte(nil), packet...)
+	if !d.started {
+		d.started = true
+		d.cur = packet
+		go d.run()
+	} else {
+		select {
+		case d.next <- packet:
+		case err := <-d.errc:
+			return nil, err
+		}
+	}
+
+	var result []byte
+	add := func(b []byte) error {
+		if len(result)+len(b) > maxDecompressedPacket {
+			d.close()
+			return errDecompressedTooLarge
+		}
+		result = append(result, b...)
+		return nil
+	}
+	for {
+		select {
+		case b := <-d.out:
+			if err := add(b); err != nil {
+				return nil, err
+			}
+		case b := <-d.starved:
+			if err := add(b); err != nil {
+				return nil, err
+			}
+			return result, nil
+		case err := <-d.errc:
+			return nil, err
+		}
+	}
+}
+
+// close stops the inflating goroutine.
+func (d *decompressor) close() {
+	select {
+	case <-d.done:
+	default:
+		close(d.done)
+	}
+}
+
+func (d *decompressor) run() {
+	err := d.inflate()
+	if err != errDecompressorClosed {
+		d.errc <- err
+	}
+}
+
+// readByte returns the next byte of the stream. When the packet is
+// consumed, it hands out what was inflated and waits for the next one.
+func (d *decompressor) readByte() (byte, error) {
+	if len(d.cur) == 0 {
+		select {
+		case d.starved <- d.pending:
+		case <-d.done:
+			return 0, errDecompressorClosed
+		}
+		d.pending = nil
+		select {
+		case d.cur = <-d.next:
+		case <-d.done:
+			return 0, errDecompressorClosed
+		}
+		if len(d.cur) == 0 {
+			return 0, errCompressedStream
+		}
+	}
+	b := d.cur[0]
+	d.cur = d.cur[1:]
+	return b, nil
+}
+
+// bits returns the next n bits of the stream, least significant first.
+func (d *decompressor) bits(n uint) (int, error) {
+	for d.bitCnt < n {
+		b, err := d.readByte()
+		if err != nil {
+			return 0, err
+		}
+		d.bitBuf |= uint32(b) << d.bitCnt
+		d.bitCnt += 8
+	}
+	v := d.bitBuf & (1<<n - 1)
+	d.bitBuf >>= n
+	d.bitCnt -= n
+	return int(v), nil
+}
+
+func (d *decompressor) emit(b byte) error {
+	d.window[d.written%inflateWindowSize] = b
+	d.written++
+	d.pending = append(d.pending, b)
+	if len(d.pending) >= inflateChunk {
+		select {
+		case d.out <- d.pending:
+		case <-d.done:
+			return errDecompressorClosed
+		}
+		d.pending = nil
+	}
+	return nil
+}
+
+func (d *decompressor) inflate() error {
+	cmf, err := d.bits(8)
+	if err != nil {
+		return err
+	}
+	flg, err := d.bits(8)
+	if err != nil {
+		return err
+	}
+	// Deflate with a window of at most 32kB, and no preset dictionary.
+	if cmf&0x0f != 8 || cmf>>4 > 7 || (cmf<<8|flg)%31 != 0 || flg&0x20 != 0 {
+		return errCompressedStream
+	}
+	for {
+		final, err := d.bits(1)
+		if err != nil {
+			return err
+		}
+		typ, err := d.bits(2)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case 0:
+			err = d.stored()
+		case 1:
+			err = d.codes(&fixedLitLen, &fixedDist)
+		case 2:
+			err = d.dynamic()
+		default:
+			err = errCompressedStream
+		}
+		if err != nil {
+			return err
+		}
+		if final == 1 {
+			// The stream of a connection never ends.
+			return errCompressedStream
+		}
+	}
+}
+
+func (d *decompressor) stored() error {
+	d.bitBuf, d.bitCnt = 0, 0
+	var hdr [4]byte
+	for i := range hdr {
+		b, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		hdr[i] = b
+	}
+	n := int(hdr[0]) | int(hdr[1])<<8
+	if n != ^(int(hdr[2])|int(hdr[3])<<8)&0xffff {
+		return errCompressedStream
+	}
+	for ; n > 0; n-- {
+		b, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		if err := d.emit(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// huffman is a canonical Huffman code: count[n] is the number of codes
+// of n bits, max is the length of the longest code, and symbol lists
+// the symbols ordered by code.
+type huffman struct {
+	count  [16]int
+	max    int
+	symbol []int
+}
+
+// newHuffman builds the code of the given code lengths. As in zlib and
+// compress/flate, the code must be complete, except that it may have no
+// symbols or a single one of one bit.
+func newHuffman(h *huffman, lengths []int) error {
+	h.count, h.max = [16]int{}, 0
+	for _, l := range lengths {
+		h.count[l]++
+		if l > h.max {
+			h.max = l
+		}
+	}
+	left := 1
+	for n := 1; n < 16; n++ {
+		left = left<<1 - h.count[n]
+		if left < 0 {
+			return errCompressedStream
+		}
+	}
+	if used := len(lengths) - h.count[0]; left > 0 && used > 0 && !(used == 1 && h.count[1] == 1) {
+		return errCompressedStream
+	}
+	var offs [16]int
+	for n := 1; n < 15; n++ {
+		offs[n+1] = offs[n] + h.count[n]
+	}
+	h.symbol = make([]int, len(lengths))
+	for sym, l := range lengths {
+		if l != 0 {
+			h.symbol[offs[l]] = sym
+			offs[l]++
+		}
+	}
+	return nil
+}
+
+func (d *decompressor) decode(h *huffman) (int, error) {
+	code, first, index := 0, 0, 0
+	// Bits that are not a code of an incomplete code are rejected
+	// once they are longer than its longest code.
+	for n := 1; n <= h.max; n++ {
+		b, err := d.bits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= b
+		count := h.count[n]
+		if code-first < count {
+			return h.symbol[index+code-first], nil
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	return 0, errCompressedStream
+}
+
+var (
+	lengthBase  = [29]int{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [29]uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = [30]int{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra   = [30]uint{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+
+	// The order of the code length codes in a dynamic block header.
+	codeLengthOrder = [19]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+)
+
+var fixedLitLen, fixedDist = fixedHuffman()
+
+func fixedHuffman() (litLen, dist huffman) {
+	var lengths [288]int
+	for sym := range lengths {
+		switch {
+		case sym < 144:
+			lengths[sym] = 8
+		case sym < 256:
+			lengths[sym] = 9
+		case sym < 280:
+			lengths[sym] = 7
+		default:
+			lengths[sym] = 8
+		}
+	}
+	newHuffman(&litLen, lengths[:])
+	// Distance codes 30 and 31 complete the code but never occur.
+	for sym := 0; sym < 32; sym++ {
+		lengths[sym] = 5
+	}
+	newHuffman(&dist, lengths[:32])
+	return litLen, dist
+}
+
+// codes inflates the data of a compressed block.
+func (d *decompressor) codes(litLen, dist *huffman) error {
+	for {
+		sym, err := d.decode(litLen)
+		if err != nil {
+			return err
+		}
+		if sym < 256 {
+			if err := d.emit(byte(sym)); err != nil {
+				return err
+			}
+			continue
+		}
+		if sym == 256 {
+			return nil
+		}
+		sym -= 257
+		if sym >= len(lengthBase) {
+			return errCompressedStream
+		}
+		extra, err := d.bits(lengthExtra[sym])
+		if err != nil {
+			return err
+		}
+		n := lengthBase[sym] + extra
+
+		sym, err = d.decode(dist)
+		if err != nil {
+			return err
+		}
+		if sym >= len(distBase) {
+			return errCompressedStream
+		}
+		if extra, err = d.bits(distExtra[sym]); err != nil {
+			return err
+		}
+		distance := distBase[sym] + extra
+		if distance > d.written {
+			return errCompressedStream
+		}
+		for ; n > 0; n-- {
+			b := d.window[(d.written-distance)%inflateWindowSize]
+			if err := d.emit(b); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// dynamic inflates a block compressed with the codes of its header.
+func (d *decompressor) dynamic() error {
+	nLitLen, err := d.bits(5)
+	if err != nil {
+		return err
+	}
+	nDist, err := d.bits(5)
+	if err != nil {
+		return err
+	}
+	nCode, err := d.bits(4)
+	if err != nil {
+		return err
+	}
+	nLitLen, nDist, nCode = nLitLen+257, nDist+1, nCode+4
+	if nLitLen > 286 || nDist > 30 {
+		return errCompressedStream
+	}
+
+	var lengths [286 + 30]int
+	for i := 0; i < nCode; i++ {
+		if lengths[codeLengthOrder[i]], err = d.bits(3); err != nil {
+			return err
+		}
+	}
+	var lengthCode huffman
+	if err := newHuffman(&lengthCode, lengths[:19]); err != nil {
+		return err
+	}
+	for i := range lengths[:19] {
+		lengths[i] = 0
+	}
+
+	for i := 0; i < nLitLen+nDist; {
+		sym, err := d.decode(&lengthCode)
+		if err != nil {
+			return err
+		}
+		if sym < 16 {
+			lengths[i] = sym
+			i++
+			continue
+		}
+		value, repeat := 0, 0
+		switch sym {
+		case 16:
+			if i == 0 {
+				return errCompressedStream
+			}
+			value = lengths[i-1]
+			repeat, err = d.bits(2)
+			repeat += 3
+		case 17:
+			repeat, err = d.bits(3)
+			repeat += 3
+		default:
+			repeat, err = d.bits(7)
+			repeat += 11
+		}
+		if err != nil {
+			return err
+		}
+		if i+repeat > nLitLen+nDist {
+			return errCompressedStream
+		}
+		for ; repeat > 0; repeat-- {
+			lengths[i] = value
+			i++
+		}
+	}
+	if lengths[256] == 0 {
+		return errCompressedStream
+	}
+
+	var litLen, dist huffman
+	if err := newHuffman(&litLen, lengths[:nLitLen]); err != nil {
+		return err
+	}
+	if err := newHuffman(&dist, lengths[nLitLen:nLitLen+nDist]); err != nil {
+		return err
+	}
+	return d.codes(&litLen, &dist)
+}
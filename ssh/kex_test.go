@@ -8,7 +8,9 @@ package ssh
 
 import (
 	"crypto/rand"
+	"math/big"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -63,3 +65,41 @@ func TestKexes(t *testing.T) {
 		})
 	}
 }
+
+func BenchmarkCurve25519KeyPairGenerate(b *testing.B) {
+	b.ReportAllocs()
+	var kp curve25519KeyPair
+	for i := 0; i < b.N; i++ {
+		if err := kp.generate(rand.Reader); err != nil {
+			b.Fatalf("generate: %v", err)
+		}
+	}
+}
+
+func TestDHGEXClientRejectsWeakGroups(t *testing.T) {
+	p := kexAlgoMap[kexAlgoDH14SHA1].(*dhGroup).p
+	pMinusOne := new(big.Int).Sub(p, bigOne)
+	for _, g := range []*big.Int{big.NewInt(0), bigOne, pMinusOne, p} {
+		a, b := memPipe()
+		go func() {
+			defer b.Close()
+			if _, err := b.readPacket(); err != nil {
+				return
+			}
+			b.writePacket(Marshal(&kexDHGexGroupMsg{P: p, G: g}))
+			b.readPacket()
+		}()
+		gex := kexAlgoMap[kexAlgoDHGEXSHA256]
+		if _, err := gex.Client(a, rand.Reader, &handshakeMagics{}); err == nil || !strings.Contains(err.Error(), "not safe") {
+			t.Errorf("client with the generator %v: got %v, want it rejected", g, err)
+		}
+		a.Close()
+	}
+
+	gex := &dhGEXSHA{g: big.NewInt(2), p: p}
+	for _, y := range []*big.Int{big.NewInt(0), bigOne, pMinusOne, p} {
+		if _, err := gex.diffieHellman(y, big.NewInt(12345)); err == nil {
+			t.Errorf("diffieHellman accepted the public value %v", y)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// hostKeyRSABits is the size of the RSA keys made by GenerateHostKey,
+// as for ssh-keygen.
+const hostKeyRSABits = 3072
+
+// defaultHostKeyTypes are the key types generated by GenerateHostKeys
+// if none are given.
+var defaultHostKeyTypes = []string{KeyAlgoRSA, KeyAlgoECDSA256, KeyAlgoED25519}
+
+// GenerateHostKey generates a private key of the given type, which is
+// one of KeyAlgoRSA, KeyAlgoECDSA256, KeyAlgoECDSA384, KeyAlgoECDSA521
+// and KeyAlgoED25519, and returns it as a Signer. RSA keys have 3072
+// bits. rand is the source of entropy, usually crypto/rand.Reader.
+// The key can be saved with MarshalPrivateKey.
+func GenerateHostKey(rand io.Reader, keyType string) (Signer, error) {
+	var key crypto.Signer
+	var err error
+	switch keyType {
+	case KeyAlgoRSA:
+		key, err = rsa.GenerateKey(rand, hostKeyRSABits)
+	case KeyAlgoECDSA256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand)
+	case KeyAlgoECDSA384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand)
+	case KeyAlgoECDSA521:
+		key, err = ecdsa.GenerateKey(elliptic.P521(), rand)
+	case KeyAlgoED25519:
+		_, key, err = ed25519.GenerateKey(rand)
+	default:
+		return nil, fmt.Errorf("ssh: can't generate keys of type %q", keyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewSignerFromSigner(key)
+}
+
+// GenerateHostKeys generates a host key for each of the given types
+// with GenerateHostKey. If keyTypes is empty, it generates an RSA, a
+// P-256 ECDSA and an Ed25519 key, like "ssh-keygen -A".
+func GenerateHostKeys(rand io.Reader, keyTypes []string) ([]Signer, error) {
+	if len(keyTypes) == 0 {
+		keyTypes = defaultHostKeyTypes
+	}
+	signers := make([]Signer, 0, len(keyTypes))
+	for _, keyType := range keyTypes {
+		signer, err := GenerateHostKey(rand, keyType)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+// MarshalPrivateKey returns key, with the given comment, as an
+// unencrypted PEM block in the OpenSSH private key format, which can
+// be read back with ParsePrivateKey and by OpenSSH. key is an
+// *rsa.PrivateKey, an *ecdsa.PrivateKey, an ed25519.PrivateKey or a
+// pointer to one, or a Signer for one of these keys returned by
+// GenerateHostKey, NewSignerFromKey or NewSignerFromSigner.
+func MarshalPrivateKey(key crypto.PrivateKey, comment string) (*pem.Block, error) {
+	if s, ok := key.(*wrappedSigner); ok {
+		key = s.signer
+	}
+
+	var keyType string
+	var rest []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return nil, errors.New("ssh: RSA keys with more than two primes are not supported")
+		}
+		p, q := k.Primes[0], k.Primes[1]
+		keyType = KeyAlgoRSA
+		rest = Marshal(struct {
+			N       *big.Int
+			E       *big.Int
+			D       *big.Int
+			Iqmp    *big.Int
+			P       *big.Int
+			Q       *big.Int
+			Comment string
+		}{k.N, big.NewInt(int64(k.E)), k.D, new(big.Int).ModInverse(q, p), p, q, comment})
+	case *ecdsa.PrivateKey:
+		pub, err := NewPublicKey(&k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		keyType = pub.Type()
+		rest = Marshal(struct {
+			Curve   string
+			Pub     []byte
+			D       *big.Int
+			Comment string
+		}{pub.(*ecdsaPublicKey).nistID(), elliptic.Marshal(k.Curve, k.X, k.Y), k.D, comment})
+	case *ed25519.PrivateKey:
+		return MarshalPrivateKey(*k, comment)
+	case ed25519.PrivateKey:
+		if len(k) != ed25519.PrivateKeySize {
+			return nil, errors.New("ssh: invalid Ed25519 private key length")
+		}
+		keyType = KeyAlgoED25519
+		rest = Marshal(struct {
+			Pub     []byte
+			Priv    []byte
+			Comment string
+		}{k.Public().(ed25519.PublicKey), k, comment})
+	default:
+		return nil, fmt.Errorf("ssh: can't marshal private keys of type %T", key)
+	}
+
+	pub, err := NewPublicKey(key.(crypto.Signer).Public())
+	if err != nil {
+		return nil, err
+	}
+
+	var check [4]byte
+	if _, err := io.ReadFull(rand.Reader, check[:]); err != nil {
+		return nil, err
+	}
+	block := Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+		Rest    []byte `ssh:"rest"`
+	}{binary.BigEndian.Uint32(check[:]), binary.BigEndian.Uint32(check[:]), keyType, rest})
+	// Unencrypted keys are padded to a multiple of 8 bytes, the block
+	// size of the "none" cipher.
+	for i := 1; len(block)%8 != 0; i++ {
+		block = append(block, byte(i))
+	}
+
+	w := struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{"none", "none", "", 1, pub.Marshal(), block}
+	return &pem.Block{
+		Type:  "OPENSSH PRIVATE KEY",
+		Bytes: append([]byte("openssh-key-v1\x00"), Marshal(w)...),
+	}, nil
+}
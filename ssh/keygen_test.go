@@ -0,0 +1,96 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/pem"
+	"testing"
+)
+
+func TestGenerateHostKeys(t *testing.T) {
+	signers, err := GenerateHostKeys(rand.Reader, nil)
+	if err != nil {
+		t.Fatalf("GenerateHostKeys: %v", err)
+	}
+	more, err := GenerateHostKeys(rand.Reader, []string{KeyAlgoECDSA384, KeyAlgoECDSA521})
+	if err != nil {
+		t.Fatalf("GenerateHostKeys: %v", err)
+	}
+	signers = append(signers, more...)
+
+	wantTypes := []string{KeyAlgoRSA, KeyAlgoECDSA256, KeyAlgoED25519, KeyAlgoECDSA384, KeyAlgoECDSA521}
+	if len(signers) != len(wantTypes) {
+		t.Fatalf("got %d signers, want %d", len(signers), len(wantTypes))
+	}
+	for i, signer := range signers {
+		pub := signer.PublicKey()
+		if pub.Type() != wantTypes[i] {
+			t.Errorf("signer %d has type %s, want %s", i, pub.Type(), wantTypes[i])
+			continue
+		}
+		if k, ok := pub.(CryptoPublicKey); ok && pub.Type() == KeyAlgoRSA {
+			if bits := k.CryptoPublicKey().(interface{ Size() int }).Size() * 8; bits != 3072 {
+				t.Errorf("RSA key has %d bits, want 3072", bits)
+			}
+		}
+
+		data := []byte("host key check")
+		sig, err := signer.Sign(rand.Reader, data)
+		if err != nil {
+			t.Fatalf("%s: Sign: %v", pub.Type(), err)
+		}
+		if err := pub.Verify(data, sig); err != nil {
+			t.Errorf("%s: Verify: %v", pub.Type(), err)
+		}
+
+		block, err := MarshalPrivateKey(signer, "host key")
+		if err != nil {
+			t.Fatalf("%s: MarshalPrivateKey: %v", pub.Type(), err)
+		}
+		parsed, err := ParsePrivateKey(pem.EncodeToMemory(block))
+		if err != nil {
+			t.Fatalf("%s: ParsePrivateKey: %v", pub.Type(), err)
+		}
+		if !bytes.Equal(parsed.PublicKey().Marshal(), pub.Marshal()) {
+			t.Errorf("%s: parsed key does not match the generated one", pub.Type())
+		}
+		sig, err = parsed.Sign(rand.Reader, data)
+		if err != nil {
+			t.Fatalf("%s: Sign with parsed key: %v", pub.Type(), err)
+		}
+		if err := pub.Verify(data, sig); err != nil {
+			t.Errorf("%s: Verify signature of parsed key: %v", pub.Type(), err)
+		}
+	}
+}
+
+func TestMarshalPrivateKeyRoundTrip(t *testing.T) {
+	for _, name := range []string{"rsa", "ecdsa", "ed25519"} {
+		block, err := MarshalPrivateKey(testPrivateKeys[name], "comment")
+		if err != nil {
+			t.Fatalf("%s: MarshalPrivateKey: %v", name, err)
+		}
+		raw, err := ParseRawPrivateKey(pem.EncodeToMemory(block))
+		if err != nil {
+			t.Fatalf("%s: ParseRawPrivateKey: %v", name, err)
+		}
+		signer, err := NewSignerFromKey(raw)
+		if err != nil {
+			t.Fatalf("%s: NewSignerFromKey: %v", name, err)
+		}
+		if !bytes.Equal(signer.PublicKey().Marshal(), testPublicKeys[name].Marshal()) {
+			t.Errorf("%s: parsed key does not match the marshaled one", name)
+		}
+	}
+
+	if _, err := GenerateHostKey(rand.Reader, KeyAlgoDSA); err == nil {
+		t.Error("GenerateHostKey generated a DSA key")
+	}
+	if _, err := MarshalPrivateKey(testPrivateKeys["dsa"], ""); err == nil {
+		t.Error("MarshalPrivateKey marshaled a DSA key")
+	}
+}
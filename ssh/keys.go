@@ -15,6 +15,8 @@ import (
 	"crypto/md5"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/base64"
@@ -304,6 +306,21 @@ type PublicKey interface {
 	Verify(data []byte, sig *Signature) error
 }
 
+// VerifyWithAlgorithms verifies that sig is a signature on data by key,
+// like key.Verify, but also requires the signature algorithm
+// sig.Format to be one of allowed, such as SigAlgoRSASHA2256. This can
+// be used to refuse SHA-1 based "ssh-rsa" signatures from RSA keys,
+// which key.Verify accepts.
+func VerifyWithAlgorithms(key PublicKey, data []byte, sig *Signature, allowed []string) error {
+	if sig == nil {
+		return errors.New("ssh: no signature")
+	}
+	if !contains(allowed, sig.Format) {
+		return fmt.Errorf("ssh: signature algorithm %q not allowed", sig.Format)
+	}
+	return key.Verify(data, sig)
+}
+
 // CryptoPublicKey, if implemented by a PublicKey,
 // returns the underlying crypto.PublicKey form of the key.
 type CryptoPublicKey interface {
@@ -339,6 +356,10 @@ func (r *rsaPublicKey) Type() string {
 	return "ssh-rsa"
 }
 
+// maxRSAModulusBits is the size of the largest RSA keys that are
+// parsed, as in OpenSSH. Verifying signatures of larger keys is costly.
+const maxRSAModulusBits = 16384
+
 // parseRSA parses an RSA key according to RFC 4253, section 6.6.
 func parseRSA(in []byte) (out PublicKey, rest []byte, err error) {
 	var w struct {
@@ -353,6 +374,12 @@ func parseRSA(in []byte) (out PublicKey, rest []byte, err error) {
 	if w.E.BitLen() > 24 {
 		return nil, nil, errors.New("ssh: exponent too large")
 	}
+	if w.N.Sign() <= 0 {
+		return nil, nil, errors.New("ssh: incorrect modulus")
+	}
+	if w.N.BitLen() > maxRSAModulusBits {
+		return nil, nil, errors.New("ssh: modulus too large")
+	}
 	e := w.E.Int64()
 	if e < 3 || e&1 == 0 {
 		return nil, nil, errors.New("ssh: incorrect exponent")
@@ -381,21 +408,9 @@ func (r *rsaPublicKey) Marshal() []byte {
 }
 
 func (r *rsaPublicKey) Verify(data []byte, sig *Signature) error {
-	var hash crypto.Hash
-	switch sig.Format {
-	case SigAlgoRSA:
-		hash = crypto.SHA1
-	case SigAlgoRSASHA2256:
-		hash = crypto.SHA256
-	case SigAlgoRSASHA2512:
-		hash = crypto.SHA512
-	default:
-		return fmt.Errorf("ssh: signature type %s for key type %s", sig.Format, r.Type())
-	}
-	h := hash.New()
-	h.Write(data)
-	digest := h.Sum(nil)
-	return rsa.VerifyPKCS1v15((*rsa.PublicKey)(r), hash, digest, sig.Blob)
+	// All RSA signature algorithms, including SigAlgoRSA, are
+	// implemented as SignatureAlgorithms.
+	return verifyBuiltin(r, (*rsa.PublicKey)(r), data, sig)
 }
 
 func (r *rsaPublicKey) CryptoPublicKey() crypto.PublicKey {
@@ -464,7 +479,7 @@ func (k *dsaPublicKey) Marshal() []byte {
 
 func (k *dsaPublicKey) Verify(data []byte, sig *Signature) error {
 	if sig.Format != k.Type() {
-		return fmt.Errorf("ssh: signature type %s for key type %s", sig.Format, k.Type())
+		return verifyBuiltin(k, (*dsa.PublicKey)(k), data, sig)
 	}
 	h := crypto.SHA1.New()
 	h.Write(data)
@@ -582,7 +597,7 @@ func (k ed25519PublicKey) Marshal() []byte {
 
 func (k ed25519PublicKey) Verify(b []byte, sig *Signature) error {
 	if sig.Format != k.Type() {
-		return fmt.Errorf("ssh: signature type %s for key type %s", sig.Format, k.Type())
+		return verifyBuiltin(k, ed25519.PublicKey(k), b, sig)
 	}
 	if l := len(k); l != ed25519.PublicKeySize {
 		return fmt.Errorf("ssh: invalid size %d for Ed25519 public key", l)
@@ -668,7 +683,7 @@ func (k *ecdsaPublicKey) Marshal() []byte {
 
 func (k *ecdsaPublicKey) Verify(data []byte, sig *Signature) error {
 	if sig.Format != k.Type() {
-		return fmt.Errorf("ssh: signature type %s for key type %s", sig.Format, k.Type())
+		return verifyBuiltin(k, (*ecdsa.PublicKey)(k), data, sig)
 	}
 
 	h := ecHash(k.Curve).New()
@@ -942,18 +957,114 @@ func newDSAPrivateKey(key *dsa.PrivateKey) (Signer, error) {
 type wrappedSigner struct {
 	signer crypto.Signer
 	pubKey PublicKey
+	// algorithms are the signature algorithms in addition to those
+	// of this package, see NewSignerWithAlgorithms.
+	algorithms map[string]SignatureAlgorithm
 }
 
 // NewSignerFromSigner takes any crypto.Signer implementation and
 // returns a corresponding Signer interface. This can be used, for
 // example, with keys kept in hardware modules.
+//
+// The returned Signer also implements AlgorithmSigner. Data is hashed
+// before it is passed to the crypto.Signer, and the hash function is
+// given in the opts argument of its Sign method: SHA-1, SHA-256 or
+// SHA-512 for RSA keys, depending on the signature algorithm, SHA-1
+// for DSA keys and the hash matching the curve for ECDSA keys. Ed25519
+// keys are given the unhashed data, with a zero crypto.Hash. ECDSA and
+// DSA signatures may be returned either ASN.1 encoded, as by
+// crypto/ecdsa, or as the fixed size concatenation of r and s, as by
+// PKCS #11 tokens; both are converted to the SSH encoding.
 func NewSignerFromSigner(signer crypto.Signer) (Signer, error) {
 	pubKey, err := NewPublicKey(signer.Public())
 	if err != nil {
 		return nil, err
 	}
 
-	return &wrappedSigner{signer, pubKey}, nil
+	return &wrappedSigner{signer: signer, pubKey: pubKey}, nil
+}
+
+// NewSignerWithAlgorithms is like NewSignerFromSigner, but the returned
+// AlgorithmSigner also signs with the given signature algorithms, by
+// name, that are used with the type of the key. The names must not be
+// those of algorithms of this package.
+func NewSignerWithAlgorithms(signer crypto.Signer, algorithms map[string]SignatureAlgorithm) (AlgorithmSigner, error) {
+	if err := checkSignatureAlgorithms(algorithms); err != nil {
+		return nil, err
+	}
+	pubKey, err := NewPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedSigner{signer: signer, pubKey: pubKey, algorithms: algorithms}, nil
+}
+
+// sshSignatureBlob converts a signature returned by a crypto.Signer
+// for pubKey to the encoding used in SSH signatures.
+func sshSignatureBlob(pubKey PublicKey, signature []byte) ([]byte, error) {
+	switch key := pubKey.(type) {
+	case *rsaPublicKey:
+		// Some signers strip leading zeros, but the signature must be
+		// as long as the modulus.
+		size := (key.N.BitLen() + 7) / 8
+		if len(signature) > size {
+			return nil, errors.New("ssh: signer returned an RSA signature longer than the modulus")
+		}
+		if len(signature) < size {
+			padded := make([]byte, size)
+			copy(padded[size-len(signature):], signature)
+			signature = padded
+		}
+		return signature, nil
+
+	case *ecdsaPublicKey:
+		r, s, err := parseSignerDSSSignature(signature, (key.Params().BitSize+7)/8)
+		if err != nil {
+			return nil, err
+		}
+		return Marshal(struct{ R, S *big.Int }{r, s}), nil
+
+	case *dsaPublicKey:
+		r, s, err := parseSignerDSSSignature(signature, 20)
+		if err != nil {
+			return nil, err
+		}
+		rb, sb := r.Bytes(), s.Bytes()
+		if len(rb) > 20 || len(sb) > 20 {
+			return nil, errors.New("ssh: signer returned a DSA signature that is too large")
+		}
+		blob := make([]byte, 40)
+		copy(blob[20-len(rb):20], rb)
+		copy(blob[40-len(sb):40], sb)
+		return blob, nil
+
+	case ed25519PublicKey:
+		if len(signature) != ed25519.SignatureSize {
+			return nil, errors.New("ssh: signer returned an Ed25519 signature of the wrong size")
+		}
+	}
+	return signature, nil
+}
+
+// parseSignerDSSSignature parses an ECDSA or DSA signature, which
+// crypto.Signer implementations return ASN.1 encoded, but PKCS #11
+// tokens typically return as r and s, each encoded in size bytes.
+func parseSignerDSSSignature(signature []byte, size int) (r, s *big.Int, err error) {
+	var asn1Sig struct {
+		R, S *big.Int
+	}
+	if rest, err := asn1.Unmarshal(signature, &asn1Sig); err == nil && len(rest) == 0 {
+		r, s = asn1Sig.R, asn1Sig.S
+	} else if len(signature) == 2*size {
+		r = new(big.Int).SetBytes(signature[:size])
+		s = new(big.Int).SetBytes(signature[size:])
+	} else {
+		return nil, nil, errors.New("ssh: signer returned a malformed signature")
+	}
+	if r.Sign() <= 0 || s.Sign() <= 0 {
+		return nil, nil, errors.New("ssh: signer returned a signature with non-positive values")
+	}
+	return r, s, nil
 }
 
 func (s *wrappedSigner) PublicKey() PublicKey {
@@ -967,36 +1078,34 @@ func (s *wrappedSigner) Sign(rand io.Reader, data []byte) (*Signature, error) {
 func (s *wrappedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*Signature, error) {
 	var hashFunc crypto.Hash
 
-	if _, ok := s.pubKey.(*rsaPublicKey); ok {
-		// RSA keys support a few hash functions determined by the requested signature algorithm
-		switch algorithm {
-		case "", SigAlgoRSA:
-			algorithm = SigAlgoRSA
-			hashFunc = crypto.SHA1
-		case SigAlgoRSASHA2256:
-			hashFunc = crypto.SHA256
-		case SigAlgoRSASHA2512:
-			hashFunc = crypto.SHA512
-		default:
-			return nil, fmt.Errorf("ssh: unsupported signature algorithm %s", algorithm)
-		}
-	} else {
-		// The only supported algorithm for all other key types is the same as the type of the key
-		if algorithm == "" {
-			algorithm = s.pubKey.Type()
-		} else if algorithm != s.pubKey.Type() {
-			return nil, fmt.Errorf("ssh: unsupported signature algorithm %s", algorithm)
+	if algorithm == "" {
+		algorithm = s.pubKey.Type()
+	}
+	// RSA keys, and the additional algorithms of the signer, are
+	// handled as SignatureAlgorithms.
+	if alg, ok := lookupSignatureAlgorithm(s.algorithms, algorithm, s.pubKey.Type()); ok {
+		signature, err := alg.Sign(s.signer, rand, data)
+		if err != nil {
+			return nil, err
 		}
+		return &Signature{
+			Format: algorithm,
+			Blob:   signature,
+		}, nil
+	}
+	// The default algorithm of all other key types is the same as the type of the key
+	if algorithm != s.pubKey.Type() {
+		return nil, fmt.Errorf("ssh: unsupported signature algorithm %s", algorithm)
+	}
 
-		switch key := s.pubKey.(type) {
-		case *dsaPublicKey:
-			hashFunc = crypto.SHA1
-		case *ecdsaPublicKey:
-			hashFunc = ecHash(key.Curve)
-		case ed25519PublicKey:
-		default:
-			return nil, fmt.Errorf("ssh: unsupported key type %T", key)
-		}
+	switch key := s.pubKey.(type) {
+	case *dsaPublicKey:
+		hashFunc = crypto.SHA1
+	case *ecdsaPublicKey:
+		hashFunc = ecHash(key.Curve)
+	case ed25519PublicKey:
+	default:
+		return nil, fmt.Errorf("ssh: unsupported key type %T", key)
 	}
 
 	var digest []byte
@@ -1013,31 +1122,9 @@ func (s *wrappedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm
 		return nil, err
 	}
 
-	// crypto.Signer.Sign is expected to return an ASN.1-encoded signature
-	// for ECDSA and DSA, but that's not the encoding expected by SSH, so
-	// re-encode.
-	switch s.pubKey.(type) {
-	case *ecdsaPublicKey, *dsaPublicKey:
-		type asn1Signature struct {
-			R, S *big.Int
-		}
-		asn1Sig := new(asn1Signature)
-		_, err := asn1.Unmarshal(signature, asn1Sig)
-		if err != nil {
-			return nil, err
-		}
-
-		switch s.pubKey.(type) {
-		case *ecdsaPublicKey:
-			signature = Marshal(asn1Sig)
-
-		case *dsaPublicKey:
-			signature = make([]byte, 40)
-			r := asn1Sig.R.Bytes()
-			s := asn1Sig.S.Bytes()
-			copy(signature[20-len(r):20], r)
-			copy(signature[40-len(s):40], s)
-		}
+	signature, err = sshSignatureBlob(s.pubKey, signature)
+	if err != nil {
+		return nil, err
 	}
 
 	return &Signature{
@@ -1472,3 +1559,34 @@ func FingerprintSHA256(pubKey PublicKey) string {
 	hash := base64.RawStdEncoding.EncodeToString(sha256sum[:])
 	return "SHA256:" + hash
 }
+
+// FingerprintSHA512 is like FingerprintSHA256, but uses SHA-512, as
+// printed by ssh-keygen -l -E sha512.
+func FingerprintSHA512(pubKey PublicKey) string {
+	sha512sum := sha512.Sum512(pubKey.Marshal())
+	hash := base64.RawStdEncoding.EncodeToString(sha512sum[:])
+	return "SHA512:" + hash
+}
+
+// EqualFingerprint reports whether the fingerprints a and b are equal,
+// in constant time. Unlike subtle.ConstantTimeCompare, it does not
+// return early for inputs of different lengths, so that the time it
+// takes does not reveal the length of a fingerprint either.
+func EqualFingerprint(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// redactedFingerprintLen is the number of fingerprint characters kept
+// by RedactKey. 48 bits are enough to tell keys apart in logs.
+const redactedFingerprintLen = 8
+
+// RedactKey returns a short representation of pubKey that is meant for
+// logs: its type and a prefix of its SHA-256 fingerprint, such as
+// "ssh-ed25519 SHA256:uNiVztks...". It identifies the key well enough
+// to recognize it in a list, but must not be used to verify it.
+func RedactKey(pubKey PublicKey) string {
+	fp := FingerprintSHA256(pubKey)
+	return pubKey.Type() + " " + fp[:len("SHA256:")+redactedFingerprintLen] + "..."
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package ssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/ssh/testdata"
+)
+
+// checkParsedKey checks that pub, parsed from untrusted input, can be
+// used without panicking, and that it round-trips through Marshal.
+func checkParsedKey(t *testing.T, pub PublicKey) {
+	wire := pub.Marshal()
+	again, err := ParsePublicKey(wire)
+	if err != nil {
+		t.Fatalf("ParsePublicKey of the marshaled %s key: %v", pub.Type(), err)
+	}
+	if !bytes.Equal(again.Marshal(), wire) {
+		t.Fatalf("%s key does not round-trip through Marshal", pub.Type())
+	}
+	MarshalAuthorizedKey(pub)
+	FingerprintSHA256(pub)
+	pub.Verify([]byte("data"), &Signature{Format: pub.Type(), Blob: wire})
+}
+
+func FuzzParsePublicKey(f *testing.F) {
+	for _, name := range []string{"rsa", "dsa", "ecdsa", "ed25519", "cert"} {
+		pub := testSigners[name].PublicKey()
+		f.Add(pub.Marshal())
+		f.Add(MarshalAuthorizedKey(pub))
+	}
+	for _, d := range testdata.SKData {
+		f.Add(d.PubKey)
+	}
+	f.Add(append([]byte(`from="10.0.0.1",command="echo \"x\"",no-pty `), MarshalAuthorizedKey(testPublicKeys["ed25519"])...))
+	f.Add(Marshal(struct {
+		Name string
+		E, N []byte
+	}{KeyAlgoRSA, []byte{1, 0, 1}, []byte{0x80, 0, 0, 1}}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if pub, err := ParsePublicKey(data); err == nil {
+			checkParsedKey(t, pub)
+		}
+		for rest := data; len(rest) > 0; {
+			pub, _, _, r, err := ParseAuthorizedKey(rest)
+			if err != nil {
+				break
+			}
+			checkParsedKey(t, pub)
+			rest = r
+		}
+	})
+}
+
+func FuzzParseCertificate(f *testing.F) {
+	f.Add(testSigners["cert"].PublicKey().Marshal())
+	if pub, _, _, _, err := ParseAuthorizedKey(testdata.SSHCertificates["rsa"]); err == nil {
+		f.Add(pub.Marshal())
+	}
+	newCert := func(principals int, options int, authority Signer) []byte {
+		c := &Certificate{
+			Key:      testPublicKeys["ed25519"],
+			CertType: UserCert,
+			Permissions: Permissions{
+				CriticalOptions: map[string]string{"force-command": "true"},
+				Extensions:      map[string]string{"permit-pty": ""},
+			},
+		}
+		for i := 0; i < principals; i++ {
+			c.ValidPrincipals = append(c.ValidPrincipals, fmt.Sprint("user", i))
+		}
+		for i := 0; i < options; i++ {
+			c.Extensions[fmt.Sprint("ext", i, "@example.com")] = "v"
+		}
+		if err := c.SignCert(rand.Reader, authority); err != nil {
+			f.Fatal(err)
+		}
+		return c.Marshal()
+	}
+	f.Add(newCert(1, 0, testSigners["ed25519"]))
+	f.Add(newCert(maxCertPrincipals+1, 0, testSigners["ecdsa"]))
+	f.Add(newCert(0, 100, testSigners["rsa"]))
+	// A certificate signed by a certificate.
+	f.Add(newCert(1, 0, testSigners["cert"]))
+
+	checker := &CertChecker{
+		IsUserAuthority: func(auth PublicKey) bool { return true },
+		IsHostAuthority: func(auth PublicKey, address string) bool { return true },
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pub, err := ParsePublicKey(data)
+		if err != nil {
+			return
+		}
+		cert, ok := pub.(*Certificate)
+		if !ok {
+			return
+		}
+		if len(cert.ValidPrincipals) > maxCertPrincipals {
+			t.Fatalf("parsed a certificate with %d principals", len(cert.ValidPrincipals))
+		}
+		if _, ok := cert.SignatureKey.(*Certificate); ok {
+			t.Fatal("parsed a certificate signed by a certificate")
+		}
+		checkParsedKey(t, cert)
+		checker.CheckCert("user0", cert)
+	})
+}
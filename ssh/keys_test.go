@@ -6,17 +6,20 @@ package ssh
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/dsa"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/pem"
 	"fmt"
 	"io"
+	"math/big"
 	"reflect"
 	"strings"
 	"testing"
@@ -153,6 +156,159 @@ func TestKeySignWithAlgorithmVerify(t *testing.T) {
 	}
 }
 
+// stubSigner is a crypto.Signer, like one backed by a PKCS #11 token,
+// that records the hash it is asked to use. If raw is set, ECDSA and
+// DSA signatures are returned as the concatenation of r and s.
+type stubSigner struct {
+	priv   interface{}
+	raw    bool
+	hashes []crypto.Hash
+}
+
+func (s *stubSigner) Public() crypto.PublicKey {
+	if k, ok := s.priv.(*dsa.PrivateKey); ok {
+		return &k.PublicKey
+	}
+	return s.priv.(crypto.Signer).Public()
+}
+
+func (s *stubSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.hashes = append(s.hashes, opts.HashFunc())
+	var r, ss *big.Int
+	var size int
+	switch k := s.priv.(type) {
+	case *dsa.PrivateKey:
+		var err error
+		if r, ss, err = dsa.Sign(rand, k, digest); err != nil {
+			return nil, err
+		}
+		size = 20
+	case *ecdsa.PrivateKey:
+		var err error
+		if r, ss, err = ecdsa.Sign(rand, k, digest); err != nil {
+			return nil, err
+		}
+		size = (k.Params().BitSize + 7) / 8
+	default:
+		return k.(crypto.Signer).Sign(rand, digest, opts)
+	}
+	if !s.raw {
+		return asn1.Marshal(struct{ R, S *big.Int }{r, ss})
+	}
+	sig := make([]byte, 2*size)
+	r.FillBytes(sig[:size])
+	ss.FillBytes(sig[size:])
+	return sig, nil
+}
+
+func TestNewSignerFromSignerHashes(t *testing.T) {
+	for _, tt := range []struct {
+		key       string
+		raw       bool
+		algorithm string
+		wantHash  crypto.Hash
+	}{
+		{key: "rsa", wantHash: crypto.SHA1},
+		{key: "rsa", algorithm: SigAlgoRSASHA2256, wantHash: crypto.SHA256},
+		{key: "rsa", algorithm: SigAlgoRSASHA2512, wantHash: crypto.SHA512},
+		{key: "ecdsa", wantHash: crypto.SHA256},
+		{key: "ecdsa", raw: true, wantHash: crypto.SHA256},
+		{key: "dsa", wantHash: crypto.SHA1},
+		{key: "dsa", raw: true, wantHash: crypto.SHA1},
+		{key: "ed25519", wantHash: 0},
+	} {
+		stub := &stubSigner{priv: testPrivateKeys[tt.key], raw: tt.raw}
+		signer, err := NewSignerFromSigner(stub)
+		if err != nil {
+			t.Fatalf("NewSignerFromSigner(%s): %v", tt.key, err)
+		}
+
+		data := []byte("sign me")
+		sig, err := signer.(AlgorithmSigner).SignWithAlgorithm(rand.Reader, data, tt.algorithm)
+		if err != nil {
+			t.Errorf("%s %q (raw %v): SignWithAlgorithm: %v", tt.key, tt.algorithm, tt.raw, err)
+			continue
+		}
+		if len(stub.hashes) != 1 || stub.hashes[0] != tt.wantHash {
+			t.Errorf("%s %q: signer was asked for hashes %v, want %v", tt.key, tt.algorithm, stub.hashes, tt.wantHash)
+		}
+		if tt.algorithm != "" && sig.Format != tt.algorithm {
+			t.Errorf("%s: got signature format %q, want %q", tt.key, sig.Format, tt.algorithm)
+		}
+		if err := signer.PublicKey().Verify(data, sig); err != nil {
+			t.Errorf("%s %q (raw %v): Verify: %v", tt.key, tt.algorithm, tt.raw, err)
+		}
+	}
+}
+
+func TestNewSignerFromSignerMalformed(t *testing.T) {
+	for _, key := range []string{"ecdsa", "dsa", "ed25519"} {
+		signer, err := NewSignerFromSigner(&malformedSigner{testPublicKeys[key]})
+		if err != nil {
+			t.Fatalf("NewSignerFromSigner(%s): %v", key, err)
+		}
+		if _, err := signer.Sign(rand.Reader, []byte("data")); err == nil {
+			t.Errorf("%s: Sign succeeded with a malformed signature", key)
+		}
+	}
+}
+
+// malformedSigner is a crypto.Signer that returns garbage.
+type malformedSigner struct {
+	pub PublicKey
+}
+
+func (s *malformedSigner) Public() crypto.PublicKey {
+	return s.pub.(CryptoPublicKey).CryptoPublicKey()
+}
+
+func (s *malformedSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return []byte{1, 2, 3}, nil
+}
+
+func TestVerifyWithAlgorithms(t *testing.T) {
+	data := []byte("sign me")
+	rsaSigner := testSigners["rsa"].(AlgorithmSigner)
+	sha1Sig, err := rsaSigner.SignWithAlgorithm(rand.Reader, data, SigAlgoRSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha256Sig, err := rsaSigner.SignWithAlgorithm(rand.Reader, data, SigAlgoRSASHA2256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaSig, err := testSigners["ecdsa"].Sign(rand.Reader, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modern := []string{SigAlgoRSASHA2256, SigAlgoRSASHA2512, KeyAlgoECDSA256}
+	for _, tt := range []struct {
+		key     PublicKey
+		sig     *Signature
+		allowed []string
+		ok      bool
+	}{
+		{testPublicKeys["rsa"], sha256Sig, modern, true},
+		{testPublicKeys["rsa"], sha1Sig, modern, false},
+		{testPublicKeys["rsa"], sha1Sig, []string{SigAlgoRSA}, true},
+		{testPublicKeys["ecdsa"], ecdsaSig, modern, true},
+		{testPublicKeys["ecdsa"], ecdsaSig, []string{SigAlgoRSASHA2256}, false},
+		// An allowed format still has to verify.
+		{testPublicKeys["ecdsa"], sha256Sig, modern, false},
+		{testPublicKeys["rsa"], nil, modern, false},
+	} {
+		err := VerifyWithAlgorithms(tt.key, data, tt.sig, tt.allowed)
+		if (err == nil) != tt.ok {
+			format := ""
+			if tt.sig != nil {
+				format = tt.sig.Format
+			}
+			t.Errorf("VerifyWithAlgorithms(%s, %q, %v) = %v, want ok %v", tt.key.Type(), format, tt.allowed, err, tt.ok)
+		}
+	}
+}
+
 func TestParseRSAPrivateKey(t *testing.T) {
 	key := testPrivateKeys["rsa"]
 
@@ -531,7 +687,7 @@ func TestKnownHostsParsing(t *testing.T) {
 func TestFingerprintLegacyMD5(t *testing.T) {
 	pub, _ := getTestKey()
 	fingerprint := FingerprintLegacyMD5(pub)
-	want := "fb:61:6d:1a:e3:f0:95:45:3c:a0:79:be:4a:93:63:66" // ssh-keygen -lf -E md5 rsa
+	want := "cd:36:89:38:29:08:01:c3:54:03:81:92:83:6f:63:0f" // ssh-keygen -lf -E md5 rsa
 	if fingerprint != want {
 		t.Errorf("got fingerprint %q want %q", fingerprint, want)
 	}
@@ -540,12 +696,48 @@ func TestFingerprintLegacyMD5(t *testing.T) {
 func TestFingerprintSHA256(t *testing.T) {
 	pub, _ := getTestKey()
 	fingerprint := FingerprintSHA256(pub)
-	want := "SHA256:Anr3LjZK8YVpjrxu79myrW9Hrb/wpcMNpVvTq/RcBm8" // ssh-keygen -lf rsa
+	want := "SHA256:vVSd3Z5eCy9VLyxWqeUswXL4TwQCNozDjy5ub4iYWAI" // ssh-keygen -lf rsa
 	if fingerprint != want {
 		t.Errorf("got fingerprint %q want %q", fingerprint, want)
 	}
 }
 
+func TestFingerprintSHA512(t *testing.T) {
+	pub, _ := getTestKey()
+	fingerprint := FingerprintSHA512(pub)
+	want := "SHA512:dKCShaUwy5poXP2796FFSi3hqihlOK67ULfxumzKszlzrDTFMoRQnRODYuR+muT/J3SFK3w7H2N1h3xySAy6ug" // ssh-keygen -lf -E sha512 rsa
+	if fingerprint != want {
+		t.Errorf("got fingerprint %q want %q", fingerprint, want)
+	}
+}
+
+func TestEqualFingerprint(t *testing.T) {
+	fp := FingerprintSHA256(testPublicKeys["rsa"])
+	for _, tt := range []struct {
+		a, b string
+		want bool
+	}{
+		{fp, fp, true},
+		{fp, FingerprintSHA256(testPublicKeys["ecdsa"]), false},
+		{fp, fp[:len(fp)-1], false},
+		{fp, fp + "A", false},
+		{fp, "", false},
+		{"", "", true},
+	} {
+		if got := EqualFingerprint(tt.a, tt.b); got != tt.want {
+			t.Errorf("EqualFingerprint(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestRedactKey(t *testing.T) {
+	pub, _ := getTestKey()
+	want := "ssh-rsa SHA256:vVSd3Z5e..."
+	if got := RedactKey(pub); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
 func TestInvalidKeys(t *testing.T) {
 	keyTypes := []string{
 		"RSA PRIVATE KEY",
@@ -616,3 +808,22 @@ func TestSKKeys(t *testing.T) {
 		}
 	}
 }
+
+func TestParseRSAPublicKeyBounds(t *testing.T) {
+	marshal := func(n *big.Int) []byte {
+		return Marshal(struct {
+			Name string
+			E, N *big.Int
+		}{KeyAlgoRSA, big.NewInt(65537), n})
+	}
+	if _, err := ParsePublicKey(marshal(testPublicKeys["rsa"].(CryptoPublicKey).CryptoPublicKey().(*rsa.PublicKey).N)); err != nil {
+		t.Errorf("ParsePublicKey: %v", err)
+	}
+	huge := new(big.Int).Lsh(bigOne, maxRSAModulusBits)
+	huge.Add(huge, bigOne)
+	for _, n := range []*big.Int{big.NewInt(0), big.NewInt(-65537), huge} {
+		if _, err := ParsePublicKey(marshal(n)); err == nil {
+			t.Errorf("ParsePublicKey accepted a modulus of %d bits with sign %d", n.BitLen(), n.Sign())
+		}
+	}
+}
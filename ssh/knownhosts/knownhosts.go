@@ -128,6 +128,10 @@ type hostKeyDB struct {
 	// Serialized version of revoked keys
 	revoked map[string]*KnownKey
 	lines   []keyDBLine
+
+	// ipFallback is whether the key of an unknown hostname is
+	// accepted if it matches the IP address, see NewWithIPFallback.
+	ipFallback bool
 }
 
 func newHostKeyDB() *hostKeyDB {
@@ -304,8 +308,21 @@ type KeyError struct {
 	// Want holds the accepted host keys. For each key algorithm,
 	// there can be one hostkey.  If Want is empty, the host is
 	// unknown. If Want is non-empty, there was a mismatch, which
-	// can signify a MITM attack.
+	// can signify a MITM attack. The keys are in the order of
+	// their lines in the files.
 	Want []KnownKey
+
+	// Got is the key that the host presented.
+	Got ssh.PublicKey
+}
+
+// IsHostKeyChanged reports whether the host has known keys, none of
+// which is the one it presented, as opposed to being unknown. This is
+// the case for which OpenSSH warns that the host key changed. It is
+// also the case if the host presented a key of a type for which it has
+// no known key.
+func (u *KeyError) IsHostKeyChanged() bool {
+	return len(u.Want) > 0
 }
 
 func (u *KeyError) Error() string {
@@ -331,23 +348,60 @@ func (db *hostKeyDB) check(address string, remote net.Addr, remoteKey ssh.Public
 		return &RevokedError{Revoked: *revoked}
 	}
 
-	host, port, err := net.SplitHostPort(remote.String())
+	addrs, err := candidates(address, remote)
 	if err != nil {
-		return fmt.Errorf("knownhosts: SplitHostPort(%s): %v", remote, err)
+		return err
 	}
 
-	hostToCheck := addr{host, port}
-	if address != "" {
-		// Give preference to the hostname if available.
-		host, port, err := net.SplitHostPort(address)
-		if err != nil {
-			return fmt.Errorf("knownhosts: SplitHostPort(%s): %v", address, err)
+	// Give preference to the hostname if available. As with
+	// CheckHostIP in OpenSSH, a hostname without an entry is unknown,
+	// even if the key is known for its IP address, unless ipFallback
+	// is set. The IP address is then only tried to accept the key;
+	// otherwise, the error is that of the hostname.
+	err = db.checkAddr(addrs[0], remoteKey)
+	if keyErr, ok := err.(*KeyError); ok && len(keyErr.Want) == 0 && len(addrs) > 1 && db.ipFallback {
+		if db.checkAddr(addrs[1], remoteKey) == nil {
+			return nil
 		}
+	}
+	return err
+}
 
-		hostToCheck = addr{host, port}
+// candidates returns the addresses under which the key of a host is
+// looked up, see Candidates.
+func candidates(address string, remote net.Addr) ([]addr, error) {
+	host, port, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		return nil, fmt.Errorf("knownhosts: SplitHostPort(%s): %v", remote, err)
+	}
+	ip := addr{host, port}
+	if address == "" {
+		return []addr{ip}, nil
+	}
+	a, err := splitAddress(address)
+	if err != nil {
+		return nil, err
 	}
+	if Normalize(a.String()) == Normalize(ip.String()) || net.ParseIP(a.host) != nil {
+		return []addr{a}, nil
+	}
+	return []addr{a, ip}, nil
+}
 
-	return db.checkAddr(hostToCheck, remoteKey)
+// splitAddress splits a "host:port" address. As in Normalize, the port
+// defaults to 22 if it is missing.
+func splitAddress(address string) (addr, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err == nil {
+		return addr{host, port}, nil
+	}
+	if net.ParseIP(address) != nil {
+		return addr{address, "22"}, nil
+	}
+	if host, port, err := net.SplitHostPort(address + ":22"); err == nil {
+		return addr{host, port}, nil
+	}
+	return addr{}, fmt.Errorf("knownhosts: SplitHostPort(%s): %v", address, err)
 }
 
 // checkAddr checks if we can find the given public key for the
@@ -360,20 +414,17 @@ func (db *hostKeyDB) checkAddr(a addr, remoteKey ssh.PublicKey) error {
 
 	// Algorithm => key.
 	knownKeys := map[string]KnownKey{}
+	keyErr := &KeyError{Got: remoteKey}
 	for _, l := range db.lines {
 		if l.match(a) {
 			typ := l.knownKey.Key.Type()
 			if _, ok := knownKeys[typ]; !ok {
 				knownKeys[typ] = l.knownKey
+				keyErr.Want = append(keyErr.Want, l.knownKey)
 			}
 		}
 	}
 
-	keyErr := &KeyError{}
-	for _, v := range knownKeys {
-		keyErr.Want = append(keyErr.Want, v)
-	}
-
 	// Unknown remote host.
 	if len(knownKeys) == 0 {
 		return keyErr
@@ -413,9 +464,24 @@ func (db *hostKeyDB) Read(r io.Reader, filename string) error {
 // ssh.ClientConfig.HostKeyCallback. By preference, the key check
 // operates on the hostname if available, i.e. if a server changes its
 // IP address, the host key check will still succeed, even though a
-// record of the new IP address is not available.
+// record of the new IP address is not available. A hostname without
+// any record is unknown, even if the key is known for its IP address.
 func New(files ...string) (ssh.HostKeyCallback, error) {
+	return newCallback(false, files)
+}
+
+// NewWithIPFallback is like New, but a hostname without any record is
+// checked against the records of its IP address, see Candidates. This
+// accepts a host that was only recorded under its IP address, at the
+// cost of trusting whoever answers at that address for every name
+// resolving to it.
+func NewWithIPFallback(files ...string) (ssh.HostKeyCallback, error) {
+	return newCallback(true, files)
+}
+
+func newCallback(ipFallback bool, files []string) (ssh.HostKeyCallback, error) {
 	db := newHostKeyDB()
+	db.ipFallback = ipFallback
 	for _, fn := range files {
 		f, err := os.Open(fn)
 		if err != nil {
@@ -435,6 +501,54 @@ func New(files ...string) (ssh.HostKeyCallback, error) {
 	return certChecker.CheckHostKey, nil
 }
 
+// hostKeyAlgorithmOrder is the order of the results of
+// HostKeyAlgorithms, the default preference of package ssh.
+var hostKeyAlgorithmOrder = []string{
+	ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+	ssh.KeyAlgoED25519,
+}
+
+// probeKey is a key that no known_hosts line can match.
+type probeKey struct{}
+
+func (probeKey) Type() string    { return "knownhosts-probe@golang.org" }
+func (probeKey) Marshal() []byte { return ssh.Marshal(struct{ Type string }{probeKey{}.Type()}) }
+func (probeKey) Verify(data []byte, sig *ssh.Signature) error {
+	return errors.New("knownhosts: probe key can't verify signatures")
+}
+
+// HostKeyAlgorithms returns the algorithms of the host keys that
+// callback, as returned by New, knows for the given "host:port"
+// address. It returns nil if the host is unknown.
+//
+// The result can be used as the HostKeyAlgorithms of an
+// ssh.ClientConfig, so that a server is asked for a type of key that
+// is already pinned, rather than for a different type of key that the
+// callback would reject as a mismatch. Only the key types negotiated
+// by default by package ssh are returned, and certificates signed by a
+// "@cert-authority" are not taken into account.
+func HostKeyAlgorithms(callback ssh.HostKeyCallback, address string) []string {
+	// The callback of New reports the known keys in the KeyError
+	// rejecting a key of an unknown type.
+	remote := &net.TCPAddr{IP: net.IPv4zero}
+	keyErr, ok := callback(address, remote, probeKey{}).(*KeyError)
+	if !ok || len(keyErr.Want) == 0 {
+		return nil
+	}
+	known := make(map[string]bool)
+	for _, k := range keyErr.Want {
+		known[k.Key.Type()] = true
+	}
+	var algos []string
+	for _, algo := range hostKeyAlgorithmOrder {
+		if known[algo] {
+			algos = append(algos, algo)
+		}
+	}
+	return algos
+}
+
 // Normalize normalizes an address into the form used in known_hosts
 func Normalize(address string) string {
 	host, port, err := net.SplitHostPort(address)
@@ -451,6 +565,35 @@ func Normalize(address string) string {
 	return entry
 }
 
+// NormalizeAddr returns the IP address and port of remote, such as
+// the net.Addr passed to an ssh.HostKeyCallback, in the form used in
+// known_hosts, as Normalize does for a "host:port" string.
+func NormalizeAddr(remote net.Addr) string {
+	return Normalize(remote.String())
+}
+
+// Candidates returns the entries, in the form of Normalize, under which
+// the callbacks returned by New look up the key of a host, given the
+// address and remote arguments of the callback, in order of
+// preference. These are the address, or the IP address of remote if
+// address is empty, and then, if address is a hostname, the IP address
+// of remote: "host" or "[host]:port", as the port is omitted if it is
+// 22, followed by "192.0.2.1" or "[192.0.2.1]:port". The callbacks of
+// New only use the first entry. Those of NewWithIPFallback look up the
+// key under the IP address if the hostname has no entry, and then only
+// accept it if it matches.
+func Candidates(address string, remote net.Addr) ([]string, error) {
+	addrs, err := candidates(address, remote)
+	if err != nil {
+		return nil, err
+	}
+	var entries []string
+	for _, a := range addrs {
+		entries = append(entries, Normalize(a.String()))
+	}
+	return entries, nil
+}
+
 // Line returns a line to add append to the known_hosts files.
 func Line(addresses []string, key ssh.PublicKey) string {
 	var trimmed []string
@@ -6,10 +6,17 @@ package knownhosts
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -58,6 +65,101 @@ func testDB(t *testing.T, s string) *hostKeyDB {
 	return db
 }
 
+func TestHostKeyAlgorithms(t *testing.T) {
+	rsaKeyStr := "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQC08LMbM8Zrnw+2oLUjb3epNtqT3dyjXCbyLA0bfSpgfxiinvSnMiJlSx4zkEEK0i8xwOewQ/t8jGVaKH1enlCCAoVskauiIa4pNDscM7Vbj3yzFGbaf4bVejQb00IoE9HwmiZNvgFbaxh0Gg9p/zlLqmCaBW5+GeB2wfY81nNH8Gfu6zg3h7cacpf9y9kloswaIPizqYW0npL+orUpw9++RXVM0W2Sq2Fm/lC3G7Y9e6OjLK0r9NyhzDy0UKPUX2t4Sn1UjBc+3LepnCnhsYXw9ERHB45nyM4fBMiyIOhmxsGF1svQc9GsehoIy2oX+VqkPfG5gOerLDCL1mZ6TxKF"
+	db := strings.Join([]string{
+		"server.org " + edKeyStr,
+		"server.org,192.0.2.1 " + ecKeyStr,
+		"server.org " + rsaKeyStr,
+		"other.org " + edKeyStr,
+		"@revoked revoked.org " + edKeyStr,
+	}, "\n")
+	fn := filepath.Join(t.TempDir(), "known_hosts")
+	if err := ioutil.WriteFile(fn, []byte(db), 0600); err != nil {
+		t.Fatal(err)
+	}
+	callback, err := New(fn)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	for _, tt := range []struct {
+		address string
+		want    []string
+	}{
+		{"server.org:22", []string{ssh.KeyAlgoECDSA256, ssh.KeyAlgoRSA, ssh.KeyAlgoED25519}},
+		{"192.0.2.1:22", []string{ssh.KeyAlgoECDSA256}},
+		{"other.org:22", []string{ssh.KeyAlgoED25519}},
+		{"server.org:2222", nil},
+		{"unknown.org:22", nil},
+		{"revoked.org:22", nil},
+	} {
+		if got := HostKeyAlgorithms(callback, tt.address); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("HostKeyAlgorithms(%q): got %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestHostKeyAlgorithmsHandshake(t *testing.T) {
+	ecSigner, err := ssh.GenerateHostKey(rand.Reader, ssh.KeyAlgoECDSA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSigner, err := ssh.GenerateHostKey(rand.Reader, ssh.KeyAlgoED25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConf := &ssh.ServerConfig{NoClientAuth: true}
+	serverConf.AddHostKey(ecSigner)
+	serverConf.AddHostKey(edSigner)
+
+	// Only the Ed25519 key is pinned, but the ECDSA key is preferred
+	// by default.
+	fn := filepath.Join(t.TempDir(), "known_hosts")
+	line := Line([]string{"server.org:22"}, edSigner.PublicKey())
+	if err := ioutil.WriteFile(fn, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	callback, err := New(fn)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	handshake := func(algos []string) error {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		go func() {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			ssh.NewServerConn(c, serverConf)
+		}()
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, _, _, err := ssh.NewClientConn(c, "server.org:22", &ssh.ClientConfig{
+			HostKeyCallback:   callback,
+			HostKeyAlgorithms: algos,
+		})
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	if err := handshake(nil); err == nil {
+		t.Error("handshake with the default algorithms succeeded, want a key mismatch")
+	}
+	if err := handshake(HostKeyAlgorithms(callback, "server.org:22")); err != nil {
+		t.Errorf("handshake with the known algorithms: %v", err)
+	}
+}
+
 func TestRevoked(t *testing.T) {
 	db := testDB(t, "\n\n@revoked * "+edKeyStr+"\n")
 	want := &RevokedError{
@@ -145,6 +247,40 @@ func TestSameKeyType(t *testing.T) {
 	}
 }
 
+func TestKeyErrorHostKeyChanged(t *testing.T) {
+	str := fmt.Sprintf("server.org %s\nserver.org %s\nserver.org %s", ecKeyStr, edKeyStr, alternateEdKeyStr)
+	db := testDB(t, str)
+	for _, tt := range []struct {
+		address string
+		key     ssh.PublicKey
+		changed bool
+		want    []ssh.PublicKey
+	}{
+		{"unknown.org:22", edKey, false, nil},
+		{"server.org:22", alternateEdKey, true, []ssh.PublicKey{ecKey, edKey}},
+	} {
+		err := db.check(tt.address, testAddr, tt.key)
+		ke, ok := err.(*KeyError)
+		if !ok {
+			t.Fatalf("check(%s) = %v, want a *KeyError", tt.address, err)
+		}
+		if ke.IsHostKeyChanged() != tt.changed {
+			t.Errorf("check(%s): IsHostKeyChanged = %v, want %v", tt.address, ke.IsHostKeyChanged(), tt.changed)
+		}
+		if ke.Got == nil || !bytes.Equal(ke.Got.Marshal(), tt.key.Marshal()) {
+			t.Errorf("check(%s): got presented key %v, want %v", tt.address, ke.Got, tt.key)
+		}
+		if len(ke.Want) != len(tt.want) {
+			t.Fatalf("check(%s): got known keys %v, want %d", tt.address, ke.Want, len(tt.want))
+		}
+		for i, k := range ke.Want {
+			if !bytes.Equal(k.Key.Marshal(), tt.want[i].Marshal()) || k.Line != i+1 {
+				t.Errorf("check(%s): known key %d is %v, want %s at line %d", tt.address, i, &k, tt.want[i].Type(), i+1)
+			}
+		}
+	}
+}
+
 func TestIPAddress(t *testing.T) {
 	str := fmt.Sprintf("%s %s", testAddr, edKeyStr)
 	db := testDB(t, str)
@@ -234,6 +370,7 @@ func TestWildcard(t *testing.T) {
 			Line:     1,
 			Key:      edKey,
 		}},
+		Got: ecKey,
 	}
 
 	got := db.check("server.domain:22", &net.TCPAddr{}, ecKey)
@@ -337,6 +474,96 @@ func TestNormalize(t *testing.T) {
 	}
 }
 
+func TestCandidates(t *testing.T) {
+	remote22 := &net.TCPAddr{IP: net.IP{192, 0, 2, 1}, Port: 22}
+	remote2222 := &net.TCPAddr{IP: net.IP{192, 0, 2, 1}, Port: 2222}
+	remote6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 22}
+	for _, tt := range []struct {
+		address string
+		remote  net.Addr
+		want    []string
+	}{
+		{"server.org:22", remote22, []string{"server.org", "192.0.2.1"}},
+		{"server.org", remote22, []string{"server.org", "192.0.2.1"}},
+		{"[server.org]:22", remote22, []string{"server.org", "192.0.2.1"}},
+		{"server.org:2222", remote2222, []string{"[server.org]:2222", "[192.0.2.1]:2222"}},
+		{"server.org:22", remote6, []string{"server.org", "[2001:db8::1]"}},
+		{"192.0.2.1:22", remote22, []string{"192.0.2.1"}},
+		{"192.0.2.1", remote22, []string{"192.0.2.1"}},
+		{"[2001:db8::1]:22", remote6, []string{"[2001:db8::1]"}},
+		{"2001:db8::1", remote6, []string{"[2001:db8::1]"}},
+		{"", remote2222, []string{"[192.0.2.1]:2222"}},
+	} {
+		got, err := Candidates(tt.address, tt.remote)
+		if err != nil {
+			t.Errorf("Candidates(%q, %v): %v", tt.address, tt.remote, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Candidates(%q, %v) = %q, want %q", tt.address, tt.remote, got, tt.want)
+		}
+	}
+	if got := NormalizeAddr(remote2222); got != "[192.0.2.1]:2222" {
+		t.Errorf("NormalizeAddr(%v) = %q, want %q", remote2222, got, "[192.0.2.1]:2222")
+	}
+}
+
+func TestPortEquivalence(t *testing.T) {
+	for _, line := range []string{
+		"server.org " + edKeyStr,
+		"[server.org]:22 " + edKeyStr,
+		HashHostname("server.org") + " " + edKeyStr,
+	} {
+		db := testDB(t, line)
+		for _, address := range []string{"server.org:22", "server.org", "[server.org]:22"} {
+			if err := db.check(address, testAddr, edKey); err != nil {
+				t.Errorf("%q: check(%q): %v", line, address, err)
+			}
+		}
+		if err := db.check("server.org:2222", testAddr, edKey); err == nil {
+			t.Errorf("%q: check succeeded for another port", line)
+		}
+	}
+
+	db := testDB(t, HashHostname("[server.org]:2222")+" "+edKeyStr)
+	if err := db.check("server.org:2222", testAddr, edKey); err != nil {
+		t.Errorf("check: %v", err)
+	}
+	if err := db.check("server.org:22", testAddr, edKey); err == nil {
+		t.Error("check succeeded for the default port")
+	}
+}
+
+func TestIPFallback(t *testing.T) {
+	for _, line := range []string{
+		fmt.Sprintf("%s %s", testAddr.IP, edKeyStr),
+		fmt.Sprintf("%s %s", HashHostname(testAddr.IP.String()), edKeyStr),
+	} {
+		db := testDB(t, line+"\notherhost.org "+ecKeyStr)
+		// By default, a hostname without an entry is unknown.
+		err := db.check("server.org:22", testAddr, edKey)
+		if ke, ok := err.(*KeyError); !ok || len(ke.Want) != 0 {
+			t.Errorf("%q: got %v, want a KeyError for an unknown host", line, err)
+		}
+
+		// With the fallback, it is checked against its IP address.
+		db.ipFallback = true
+		if err := db.check("server.org:22", testAddr, edKey); err != nil {
+			t.Errorf("%q: check: %v", line, err)
+		}
+		// A mismatch with the IP address still reports an unknown host.
+		err = db.check("server.org:22", testAddr, alternateEdKey)
+		if ke, ok := err.(*KeyError); !ok || len(ke.Want) != 0 {
+			t.Errorf("%q: got %v, want a KeyError for an unknown host", line, err)
+		}
+		// The key of a known hostname takes precedence.
+		err = db.check("otherhost.org:22", testAddr, edKey)
+		if ke, ok := err.(*KeyError); !ok || len(ke.Want) != 1 || !keyEq(ke.Want[0].Key, ecKey) {
+			t.Errorf("%q: got %v, want a KeyError for the hostname", line, err)
+		}
+	}
+}
+
 func TestHashedHostkeyCheck(t *testing.T) {
 	str := fmt.Sprintf("%s %s", HashHostname(testHostname), edKeyStr)
 	db := testDB(t, str)
@@ -349,8 +576,68 @@ func TestHashedHostkeyCheck(t *testing.T) {
 			Line:     1,
 			Key:      edKey,
 		}},
+		Got: alternateEdKey,
 	}
 	if got := db.check(testHostname+":22", testAddr, alternateEdKey); !reflect.DeepEqual(got, want) {
 		t.Errorf("got error %v, want %v", got, want)
 	}
 }
+
+func TestCombineHostKeyCallbacks(t *testing.T) {
+	newSigner := func() ssh.AlgorithmSigner {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer.(ssh.AlgorithmSigner)
+	}
+	ca, otherCA, host := newSigner(), newSigner(), newSigner()
+	issue := func(ca ssh.AlgorithmSigner) *ssh.Certificate {
+		cert, err := ssh.IssueHostCert(ca, host.PublicKey(), ssh.CertOptions{
+			Principals: []string{"server.org"},
+			Validity:   time.Hour,
+		})
+		if err != nil {
+			t.Fatalf("IssueHostCert: %v", err)
+		}
+		return cert
+	}
+
+	// The known_hosts file has no entry for server.org.
+	fn := filepath.Join(t.TempDir(), "known_hosts")
+	if err := ioutil.WriteFile(fn, []byte("other.org "+edKeyStr+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	knownHostsCallback, err := New(fn)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	callback := ssh.CombineHostKeyCallbacks(knownHostsCallback, ssh.NewHostCertChecker([]ssh.PublicKey{ca.PublicKey()}, nil).CheckHostKey)
+
+	if err := callback("server.org:22", testAddr, issue(ca)); err != nil {
+		t.Errorf("host certificate of the CA: %v", err)
+	}
+
+	for _, key := range []ssh.PublicKey{issue(otherCA), host.PublicKey()} {
+		err = callback("server.org:22", testAddr, key)
+		combined, ok := err.(*ssh.HostKeyCallbacksError)
+		if !ok || len(combined.Errors) != 2 {
+			t.Fatalf("%s: got %v, want a HostKeyCallbacksError with 2 errors", key.Type(), err)
+		}
+		for i, err := range combined.Errors {
+			if !strings.Contains(combined.Error(), err.Error()) {
+				t.Errorf("%s: error %q lacks error %d: %q", key.Type(), combined, i, err)
+			}
+		}
+	}
+
+	// The unknown host key is reported as such by known_hosts.
+	var keyErr *KeyError
+	if !errors.As(err, &keyErr) || len(keyErr.Want) != 0 || !bytes.Equal(keyErr.Got.Marshal(), host.PublicKey().Marshal()) {
+		t.Errorf("got error %v, want a KeyError for an unknown host", err)
+	}
+}
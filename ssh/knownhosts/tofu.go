@@ -0,0 +1,136 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package knownhosts
+
+import (
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TOFUStore stores the host keys trusted by TOFUHostKeyCallback. The
+// hosts are in the form of Normalize, such as "host" or "[host]:2222".
+type TOFUStore interface {
+	// Get returns the keys trusted for host, or none if the host
+	// is unknown.
+	Get(host string) ([]ssh.PublicKey, error)
+
+	// Add trusts key for host.
+	Add(host string, key ssh.PublicKey) error
+}
+
+// TOFUHostKeyCallback returns a host key callback that trusts the key a
+// host presents the first time, that is when store has no key for the
+// host, and adds it to store. Afterwards, it only accepts the keys of
+// store for the host. Other keys are rejected with a *KeyError whose
+// Want holds the stored keys, even if they are of another type than
+// the presented key, so the HostKeyAlgorithms of the ssh.ClientConfig
+// should prefer the stored types.
+//
+// Hosts are identified by the address passed to the callback, or by
+// the IP address of the remote side if the address is empty.
+func TOFUHostKeyCallback(store TOFUStore) ssh.HostKeyCallback {
+	var mu sync.Mutex
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		host := Normalize(hostname)
+		if hostname == "" {
+			host = NormalizeAddr(remote)
+		}
+		// Hold the lock so that concurrent connections to a new
+		// host do not trust different keys.
+		mu.Lock()
+		defer mu.Unlock()
+		keys, err := store.Get(host)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return store.Add(host, key)
+		}
+		keyErr := &KeyError{Got: key}
+		for _, k := range keys {
+			if keyEq(k, key) {
+				return nil
+			}
+			keyErr.Want = append(keyErr.Want, KnownKey{Key: k})
+		}
+		return keyErr
+	}
+}
+
+// TOFUFile is a TOFUStore that keeps the keys in the known_hosts file
+// it names, which is created on the first Add. Its hosts match the
+// lines of the file as they do for New, including hashed and wildcard
+// patterns. Keys revoked with a @revoked line are neither returned nor
+// added, and @cert-authority lines are ignored. New keys are appended
+// to the file as the lines of Line.
+type TOFUFile string
+
+func (f TOFUFile) read() (*hostKeyDB, error) {
+	db := newHostKeyDB()
+	file, err := os.Open(string(f))
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := db.Read(file, string(f)); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Get implements TOFUStore.
+func (f TOFUFile) Get(host string) ([]ssh.PublicKey, error) {
+	a, err := splitAddress(host)
+	if err != nil {
+		return nil, err
+	}
+	db, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	var keys []ssh.PublicKey
+	for _, l := range db.lines {
+		if !l.cert && l.match(a) && db.revoked[string(l.knownKey.Key.Marshal())] == nil {
+			keys = append(keys, l.knownKey.Key)
+		}
+	}
+	return keys, nil
+}
+
+// Add implements TOFUStore. It returns a *RevokedError if the file
+// revokes key.
+func (f TOFUFile) Add(host string, key ssh.PublicKey) error {
+	db, err := f.read()
+	if err != nil {
+		return err
+	}
+	if revoked := db.revoked[string(key.Marshal())]; revoked != nil {
+		return &RevokedError{Revoked: *revoked}
+	}
+
+	file, err := os.OpenFile(string(f), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	line := []byte(Line([]string{host}, key) + "\n")
+	// Do not append to a last line that lacks its line ending.
+	if fi, err := file.Stat(); err == nil && fi.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, fi.Size()-1); err == nil && last[0] != '\n' {
+			line = append([]byte("\n"), line...)
+		}
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
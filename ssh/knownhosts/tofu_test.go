@@ -0,0 +1,102 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package knownhosts
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestTOFUFile(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "known_hosts")
+	callback := TOFUHostKeyCallback(TOFUFile(fn))
+
+	// First use of the host, then of the same key.
+	for i := 0; i < 2; i++ {
+		if err := callback("server.org:22", testAddr, edKey); err != nil {
+			t.Fatalf("connection %d: %v", i, err)
+		}
+	}
+	content, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "server.org " + edKeyStr + "\n"; string(content) != want {
+		t.Errorf("got known_hosts %q, want %q", content, want)
+	}
+
+	// The file can be used with New.
+	knownHostsCallback, err := New(fn)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := knownHostsCallback("server.org:22", testAddr, edKey); err != nil {
+		t.Errorf("New: got %v for the key added by TOFUFile", err)
+	}
+
+	// Another key for the host is a mismatch.
+	for _, key := range []ssh.PublicKey{alternateEdKey, ecKey} {
+		err := callback("server.org:22", testAddr, key)
+		keyErr, ok := err.(*KeyError)
+		if !ok || !keyErr.IsHostKeyChanged() || len(keyErr.Want) != 1 || !keyEq(keyErr.Want[0].Key, edKey) || !keyEq(keyErr.Got, key) {
+			t.Errorf("%s: got %v, want a KeyError with the known key", key.Type(), err)
+		}
+	}
+
+	// Other hosts have their own keys.
+	if err := callback("server.org:2222", testAddr, ecKey); err != nil {
+		t.Errorf("other port: %v", err)
+	}
+	if err := callback("", testAddr, alternateEdKey); err != nil {
+		t.Errorf("IP address: %v", err)
+	}
+	content, _ = ioutil.ReadFile(fn)
+	if !strings.Contains(string(content), "\n[server.org]:2222 "+ecKeyStr+"\n") || !strings.HasSuffix(string(content), "\n198.41.30.196 "+alternateEdKeyStr+"\n") {
+		t.Errorf("got known_hosts %q", content)
+	}
+	if err := callback("server.org:2222", testAddr, edKey); err == nil {
+		t.Errorf("other port: accepted the key of another port")
+	}
+}
+
+func TestTOFUFileExisting(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "known_hosts")
+	db := strings.Join([]string{
+		"*.example.org " + ecKeyStr,
+		"@revoked * " + alternateEdKeyStr,
+		"@cert-authority * " + edKeyStr,
+		// The last line lacks its line ending.
+		HashHostname("hashed.org") + " " + edKeyStr,
+	}, "\n")
+	if err := ioutil.WriteFile(fn, []byte(db), 0600); err != nil {
+		t.Fatal(err)
+	}
+	callback := TOFUHostKeyCallback(TOFUFile(fn))
+
+	if err := callback("www.example.org:22", testAddr, ecKey); err != nil {
+		t.Errorf("wildcard: %v", err)
+	}
+	if err := callback("hashed.org:22", testAddr, edKey); err != nil {
+		t.Errorf("hashed: %v", err)
+	}
+	if err := callback("www.example.org:22", testAddr, edKey); err == nil {
+		t.Errorf("wildcard: accepted another key")
+	}
+	if _, ok := callback("new.org:22", testAddr, alternateEdKey).(*RevokedError); !ok {
+		t.Errorf("revoked key: want a RevokedError")
+	}
+	if err := callback("new.org:22", testAddr, edKey); err != nil {
+		t.Errorf("new host: %v", err)
+	}
+
+	content, _ := ioutil.ReadFile(fn)
+	if want := db + "\nnew.org " + edKeyStr + "\n"; string(content) != want {
+		t.Errorf("got known_hosts %q, want %q", content, want)
+	}
+}
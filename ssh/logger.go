@@ -0,0 +1,42 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+// LogLevel is the severity of an event passed to a Logger. The values
+// are those of the levels of the log/slog package, so a LogLevel can
+// be converted to a slog.Level directly.
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = -4
+	LogLevelInfo  LogLevel = 0
+	LogLevelWarn  LogLevel = 4
+	LogLevelError LogLevel = 8
+)
+
+// A Logger receives events about the key exchange, authentication
+// and disconnection of a connection, see Config.Logger. Events never
+// contain secrets such as passwords or key material.
+type Logger interface {
+	// Log records an event. The keyvals are alternating keys and
+	// values, in the form accepted by slog.Logger.Log.
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+// The LoggerFunc type is an adapter to allow the use of ordinary
+// functions as a Logger.
+type LoggerFunc func(level LogLevel, msg string, keyvals ...interface{})
+
+// Log calls f(level, msg, keyvals...).
+func (f LoggerFunc) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	f(level, msg, keyvals...)
+}
+
+// log passes an event to c.Logger, if it is set.
+func (c *Config) log(level LogLevel, msg string, keyvals ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Log(level, msg, keyvals...)
+	}
+}
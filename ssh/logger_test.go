@@ -0,0 +1,157 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type logEvent struct {
+	level   LogLevel
+	msg     string
+	keyvals []interface{}
+}
+
+// get returns the value for key, or nil.
+func (e logEvent) get(key string) interface{} {
+	for i := 0; i+1 < len(e.keyvals); i += 2 {
+		if e.keyvals[i] == key {
+			return e.keyvals[i+1]
+		}
+	}
+	return nil
+}
+
+type recordingLogger struct {
+	mu     sync.Mutex
+	events []logEvent
+}
+
+func (l *recordingLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, logEvent{level, msg, keyvals})
+}
+
+func (l *recordingLogger) find(msg string) []logEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var found []logEvent
+	for _, e := range l.events {
+		if e.msg == msg {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func TestLoggerEvents(t *testing.T) {
+	const password = "secret-password"
+	serverLog, clientLog := &recordingLogger{}, &recordingLogger{}
+
+	serverConf := &ServerConfig{
+		PasswordCallback: func(conn ConnMetadata, pass []byte) (*Permissions, error) {
+			if string(pass) == password {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+	}
+	serverConf.Logger = serverLog
+	serverConf.AddHostKey(testSigners["ecdsa"])
+
+	tries := 0
+	clientConf := &ClientConfig{
+		User: "testuser",
+		Auth: []AuthMethod{RetryableAuthMethod(PasswordCallback(func() (string, error) {
+			tries++
+			if tries == 1 {
+				return "wrong-" + password, nil
+			}
+			return password, nil
+		}), 2)},
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+	clientConf.Logger = clientLog
+
+	_, _, _, reqs := pipePair(t, serverConf, clientConf)
+	go DiscardRequests(reqs)
+
+	for _, l := range []*recordingLogger{serverLog, clientLog} {
+		kex := l.find("ssh: key exchange completed")
+		if len(kex) != 1 {
+			t.Fatalf("got %d key exchange events, want 1", len(kex))
+		}
+		if kex[0].get("kex") == nil || kex[0].get("cipher_write") == nil {
+			t.Errorf("key exchange event lacks the algorithms: %v", kex[0].keyvals)
+		}
+	}
+
+	failed := serverLog.find("ssh: authentication failed")
+	var sawPassword bool
+	for _, e := range failed {
+		if e.get("method") == "password" && e.get("user") == "testuser" {
+			sawPassword = true
+		}
+	}
+	if !sawPassword {
+		t.Errorf("no failed password authentication logged, got %v", failed)
+	}
+	if ok := serverLog.find("ssh: authentication succeeded"); len(ok) != 1 || ok[0].get("method") != "password" {
+		t.Errorf("got success events %v, want one for password", ok)
+	}
+
+	var results []string
+	for _, e := range clientLog.find("ssh: authentication attempt") {
+		results = append(results, fmt.Sprint(e.get("method"), ":", e.get("result")))
+	}
+	if got, want := strings.Join(results, ","), "none:failure,password:success"; got != want {
+		t.Errorf("got client attempts %s, want %s", got, want)
+	}
+
+	// Secrets must never be logged.
+	for _, l := range []*recordingLogger{serverLog, clientLog} {
+		l.mu.Lock()
+		for _, e := range l.events {
+			if strings.Contains(fmt.Sprint(e.msg, e.keyvals), password) {
+				t.Errorf("event %q %v contains the password", e.msg, e.keyvals)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+func TestLoggerDisconnect(t *testing.T) {
+	serverLog, clientLog := &recordingLogger{}, &recordingLogger{}
+	serverConf := &ServerConfig{
+		MaxAuthTries: 1,
+		PasswordCallback: func(conn ConnMetadata, pass []byte) (*Permissions, error) {
+			return nil, errors.New("denied")
+		},
+	}
+	serverConf.Logger = serverLog
+	serverConf.AddHostKey(testSigners["ecdsa"])
+	clientConf := &ClientConfig{
+		User:            "testuser",
+		Auth:            []AuthMethod{Password("wrong")},
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+	clientConf.Logger = clientLog
+
+	if _, _, _, _, err := Pipe(serverConf, clientConf); err == nil {
+		t.Fatal("Pipe succeeded")
+	}
+	if got := serverLog.find("ssh: sending disconnect"); len(got) != 1 {
+		t.Errorf("got %d sent disconnect events, want 1", len(got))
+	}
+	got := clientLog.find("ssh: received disconnect")
+	if len(got) != 1 || got[0].get("message") != "too many authentication failures" {
+		t.Errorf("got received disconnect events %v", got)
+	}
+}
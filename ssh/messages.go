@@ -38,7 +38,7 @@ const msgDisconnect = 1
 // disconnectMsg is the message that signals a disconnect. It is also
 // the error type returned from mux.Wait()
 type disconnectMsg struct {
-	Reason   uint32 `sshtype:"1"`
+	Reason   DisconnectReason `sshtype:"1"`
 	Message  string
 	Language string
 }
@@ -47,6 +47,65 @@ func (d *disconnectMsg) Error() string {
 	return fmt.Sprintf("ssh: disconnect, reason %d: %s", d.Reason, d.Message)
 }
 
+// DisconnectReason is an enumeration of the reason codes sent when
+// closing a connection. See RFC 4253, section 11.1.
+type DisconnectReason uint32
+
+const (
+	DisconnectHostNotAllowedToConnect DisconnectReason = iota + 1
+	DisconnectProtocolError
+	DisconnectKeyExchangeFailed
+	DisconnectReserved
+	DisconnectMACError
+	DisconnectCompressionError
+	DisconnectServiceNotAvailable
+	DisconnectProtocolVersionNotSupported
+	DisconnectHostKeyNotVerifiable
+	DisconnectConnectionLost
+	DisconnectByApplication
+	DisconnectTooManyConnections
+	DisconnectAuthCancelledByUser
+	DisconnectNoMoreAuthMethodsAvailable
+	DisconnectIllegalUserName
+)
+
+// String converts the disconnect reason to human readable form.
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectHostNotAllowedToConnect:
+		return "host not allowed to connect"
+	case DisconnectProtocolError:
+		return "protocol error"
+	case DisconnectKeyExchangeFailed:
+		return "key exchange failed"
+	case DisconnectReserved:
+		return "reserved"
+	case DisconnectMACError:
+		return "MAC error"
+	case DisconnectCompressionError:
+		return "compression error"
+	case DisconnectServiceNotAvailable:
+		return "service not available"
+	case DisconnectProtocolVersionNotSupported:
+		return "protocol version not supported"
+	case DisconnectHostKeyNotVerifiable:
+		return "host key not verifiable"
+	case DisconnectConnectionLost:
+		return "connection lost"
+	case DisconnectByApplication:
+		return "by application"
+	case DisconnectTooManyConnections:
+		return "too many connections"
+	case DisconnectAuthCancelledByUser:
+		return "auth cancelled by user"
+	case DisconnectNoMoreAuthMethodsAvailable:
+		return "no more auth methods available"
+	case DisconnectIllegalUserName:
+		return "illegal user name"
+	}
+	return fmt.Sprintf("unknown reason %d", int(r))
+}
+
 // See RFC 4253, section 7.1.
 const msgKexInit = 20
 
@@ -141,6 +200,16 @@ type serviceAcceptMsg struct {
 	Service string `sshtype:"6"`
 }
 
+// See RFC 8308, section 2.3.
+const msgExtInfo = 7
+
+// extInfoMsg holds NumExtensions pairs of extension names and values,
+// as strings, in Payload.
+type extInfoMsg struct {
+	NumExtensions uint32 `sshtype:"7"`
+	Payload       []byte `ssh:"rest"`
+}
+
 // See RFC 4252, section 5.
 const msgUserAuthRequest = 50
 
@@ -782,6 +851,8 @@ func decode(packet []byte) (interface{}, error) {
 		msg = new(serviceRequestMsg)
 	case msgServiceAccept:
 		msg = new(serviceAcceptMsg)
+	case msgExtInfo:
+		msg = new(extInfoMsg)
 	case msgKexInit:
 		msg = new(kexInitMsg)
 	case msgKexDHInit:
@@ -843,6 +914,7 @@ var packetTypeNames = map[byte]string{
 	msgDisconnect:          "disconnectMsg",
 	msgServiceRequest:      "serviceRequestMsg",
 	msgServiceAccept:       "serviceAcceptMsg",
+	msgExtInfo:             "extInfoMsg",
 	msgKexInit:             "kexInitMsg",
 	msgKexDHInit:           "kexDHInitMsg",
 	msgKexDHReply:          "kexDHReplyMsg",
@@ -0,0 +1,183 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.24
+// +build go1.24
+
+package ssh
+
+import (
+	"crypto"
+	"crypto/mlkem"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+const kexAlgoMLKEM768xCurve25519SHA256 = "mlkem768x25519-sha256"
+
+func init() {
+	// The hybrid post-quantum key exchange is preferred over all
+	// classical ones, as in OpenSSH.
+	supportedKexAlgos = append([]string{kexAlgoMLKEM768xCurve25519SHA256}, supportedKexAlgos...)
+	preferredKexAlgos = append([]string{kexAlgoMLKEM768xCurve25519SHA256}, preferredKexAlgos...)
+	kexAlgoMap[kexAlgoMLKEM768xCurve25519SHA256] = &mlkem768WithCurve25519sha256{}
+}
+
+// mlkem768WithCurve25519sha256 implements the hybrid ML-KEM-768 and
+// X25519 key agreement, as described in
+// https://datatracker.ietf.org/doc/html/draft-ietf-sshm-mlkem-hybrid-kex.
+// The client sends the concatenation of its ML-KEM encapsulation key
+// and X25519 public key, and the server replies with the concatenation
+// of an ML-KEM ciphertext and its X25519 public key. The shared secret
+// is the SHA-256 hash of both shared secrets, and is encoded as a
+// string rather than an mpint.
+type mlkem768WithCurve25519sha256 struct{}
+
+func (kex *mlkem768WithCurve25519sha256) Client(c packetConn, rand io.Reader, magics *handshakeMagics) (*kexResult, error) {
+	var kp curve25519KeyPair
+	if err := kp.generate(rand); err != nil {
+		return nil, err
+	}
+
+	seed := make([]byte, mlkem.SeedSize)
+	if _, err := io.ReadFull(rand, seed); err != nil {
+		return nil, err
+	}
+	mlkemDk, err := mlkem.NewDecapsulationKey768(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	hybridKey := append(mlkemDk.EncapsulationKey().Bytes(), kp.pub[:]...)
+	if err := c.writePacket(Marshal(&kexECDHInitMsg{hybridKey})); err != nil {
+		return nil, err
+	}
+
+	packet, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+
+	var reply kexECDHReplyMsg
+	if err = Unmarshal(packet, &reply); err != nil {
+		return nil, err
+	}
+	if len(reply.EphemeralPubKey) != mlkem.CiphertextSize768+32 {
+		return nil, errors.New("ssh: peer's mlkem768x25519 public value has wrong length")
+	}
+
+	mlkemShared, err := mlkemDk.Decapsulate(reply.EphemeralPubKey[:mlkem.CiphertextSize768])
+	if err != nil {
+		return nil, err
+	}
+
+	var servPub, ecdhShared [32]byte
+	copy(servPub[:], reply.EphemeralPubKey[mlkem.CiphertextSize768:])
+	curve25519.ScalarMult(&ecdhShared, &kp.priv, &servPub)
+	if subtle.ConstantTimeCompare(ecdhShared[:], curve25519Zeros[:]) == 1 {
+		return nil, errors.New("ssh: peer's curve25519 public value has wrong order")
+	}
+
+	K := hybridSharedSecret(mlkemShared, ecdhShared[:])
+
+	h := crypto.SHA256.New()
+	magics.write(h)
+	writeString(h, reply.HostKey)
+	writeString(h, hybridKey)
+	writeString(h, reply.EphemeralPubKey)
+	h.Write(K)
+
+	return &kexResult{
+		H:         h.Sum(nil),
+		K:         K,
+		HostKey:   reply.HostKey,
+		Signature: reply.Signature,
+		Hash:      crypto.SHA256,
+	}, nil
+}
+
+func (kex *mlkem768WithCurve25519sha256) Server(c packetConn, rand io.Reader, magics *handshakeMagics, priv Signer) (*kexResult, error) {
+	packet, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	var kexInit kexECDHInitMsg
+	if err = Unmarshal(packet, &kexInit); err != nil {
+		return nil, err
+	}
+
+	if len(kexInit.ClientPubKey) != mlkem.EncapsulationKeySize768+32 {
+		return nil, errors.New("ssh: peer's mlkem768x25519 public value has wrong length")
+	}
+
+	encapsulationKey, err := mlkem.NewEncapsulationKey768(kexInit.ClientPubKey[:mlkem.EncapsulationKeySize768])
+	if err != nil {
+		return nil, err
+	}
+	mlkemShared, ciphertext := encapsulationKey.Encapsulate()
+
+	var kp curve25519KeyPair
+	if err := kp.generate(rand); err != nil {
+		return nil, err
+	}
+
+	var clientPub, ecdhShared [32]byte
+	copy(clientPub[:], kexInit.ClientPubKey[mlkem.EncapsulationKeySize768:])
+	curve25519.ScalarMult(&ecdhShared, &kp.priv, &clientPub)
+	if subtle.ConstantTimeCompare(ecdhShared[:], curve25519Zeros[:]) == 1 {
+		return nil, errors.New("ssh: peer's curve25519 public value has wrong order")
+	}
+
+	K := hybridSharedSecret(mlkemShared, ecdhShared[:])
+	hybridReply := append(ciphertext, kp.pub[:]...)
+	hostKeyBytes := priv.PublicKey().Marshal()
+
+	h := crypto.SHA256.New()
+	magics.write(h)
+	writeString(h, hostKeyBytes)
+	writeString(h, kexInit.ClientPubKey)
+	writeString(h, hybridReply)
+	h.Write(K)
+
+	H := h.Sum(nil)
+
+	sig, err := signAndMarshal(priv, rand, H)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := kexECDHReplyMsg{
+		EphemeralPubKey: hybridReply,
+		HostKey:         hostKeyBytes,
+		Signature:       sig,
+	}
+	if err := c.writePacket(Marshal(&reply)); err != nil {
+		return nil, err
+	}
+	return &kexResult{
+		H:         H,
+		K:         K,
+		HostKey:   hostKeyBytes,
+		Signature: sig,
+		Hash:      crypto.SHA256,
+	}, nil
+}
+
+// hybridSharedSecret combines the ML-KEM and X25519 shared secrets, and
+// returns the result in the string encoding used for the exchange hash
+// and the key derivation.
+func hybridSharedSecret(mlkemShared, ecdhShared []byte) []byte {
+	h := sha256.New()
+	h.Write(mlkemShared)
+	h.Write(ecdhShared)
+	secret := h.Sum(nil)
+
+	K := make([]byte, stringLength(len(secret)))
+	marshalString(K, secret)
+	return K
+}
@@ -0,0 +1,43 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.24
+// +build go1.24
+
+package ssh
+
+import (
+	"testing"
+)
+
+func TestHandshakeMLKEM768x25519(t *testing.T) {
+	if preferredKexAlgos[0] != kexAlgoMLKEM768xCurve25519SHA256 {
+		t.Errorf("got preferred kex %q, want %q", preferredKexAlgos[0], kexAlgoMLKEM768xCurve25519SHA256)
+	}
+
+	serverConf := testServerConfig()
+	serverConf.KeyExchanges = []string{kexAlgoMLKEM768xCurve25519SHA256}
+	clientConf := testClientConfig()
+	clientConf.KeyExchanges = []string{kexAlgoMLKEM768xCurve25519SHA256}
+
+	client, server, chans, reqs := pipePair(t, serverConf, clientConf)
+	go DiscardRequests(reqs)
+	go func() {
+		for newCh := range chans {
+			newCh.Reject(Prohibited, "")
+		}
+	}()
+
+	if got := len(client.SessionID()); got != 32 {
+		t.Errorf("got session ID of %d bytes, want 32", got)
+	}
+	if string(client.SessionID()) != string(server.SessionID()) {
+		t.Error("client and server session IDs differ")
+	}
+
+	// A global request must make it across the new keys.
+	if _, _, err := client.SendRequest("test", true, nil); err != nil {
+		t.Fatalf("SendRequest: %v", err)
+	}
+}
@@ -5,7 +5,9 @@
 package ssh
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -67,6 +69,35 @@ func (c *chanList) remove(id uint32) {
 	c.Unlock()
 }
 
+// setOpen marks ch as confirmed, so it is included in Channels.
+func (c *chanList) setOpen(ch *channel) {
+	c.Lock()
+	ch.open = true
+	c.Unlock()
+}
+
+// infos returns a snapshot of the open channels, ordered by local ID.
+func (c *chanList) infos() []ChannelInfo {
+	c.Lock()
+	defer c.Unlock()
+	var r []ChannelInfo
+	for _, ch := range c.chans {
+		if ch == nil || !ch.open {
+			continue
+		}
+		r = append(r, ChannelInfo{
+			Type:          ch.chanType,
+			LocalID:       ch.localId,
+			RemoteID:      ch.remoteId,
+			Inbound:       ch.direction == channelInbound,
+			Created:       ch.created,
+			BytesReceived: atomic.LoadUint64(&ch.bytesReceived),
+			BytesSent:     atomic.LoadUint64(&ch.bytesSent),
+		})
+	}
+	return r
+}
+
 // dropAll forgets all channels it knows, returning them in a slice.
 func (c *chanList) dropAll() []*channel {
 	c.Lock()
@@ -91,8 +122,46 @@ type mux struct {
 
 	incomingChannels chan NewChannel
 
-	globalSentMu     sync.Mutex
-	globalResponses  chan interface{}
+	// openFilter, if non-nil, is called for every channel open
+	// request before it is passed to incomingChannels. If it returns
+	// an error, the channel is rejected instead.
+	openFilter func(NewChannel) error
+
+	// acceptEnv, if non-nil, decides on the "env" requests of
+	// incoming session channels, see ServerConfig.AcceptEnv.
+	acceptEnv func(name, value string) bool
+
+	// noAgentForwarding, if set, makes agent forwarding requests and
+	// channels fail in both directions, see
+	// ClientConfig.DisableAgentForwarding.
+	noAgentForwarding bool
+
+	// proveHostKeys, if non-nil, returns the response to the
+	// hostkeys-prove-00@openssh.com requests of the client, which
+	// are then not passed to incomingRequests. See HostKeySet.
+	proveHostKeys func(payload []byte) ([]byte, error)
+
+	// globalSentMu serializes sending global requests that want a
+	// reply, so that they are sent in the order of globalPending.
+	globalSentMu sync.Mutex
+
+	// globalMu protects globalPending and globalClosed.
+	globalMu sync.Mutex
+	// globalPending holds a channel for each sent global request
+	// that is awaiting its reply, in the order they were sent. The
+	// requests of canceled calls stay until their reply arrives.
+	globalPending []chan interface{}
+	globalClosed  bool
+	maxPending    int
+
+	// incomingMu protects incomingPending.
+	incomingMu sync.Mutex
+	// incomingPending holds the received global requests that want a
+	// reply and whose reply is not sent yet, in the order they
+	// arrived, as replies must be sent in that order. There are at
+	// most maxPending; further requests are answered with a failure.
+	incomingPending []*globalReply
+
 	incomingRequests chan *Request
 
 	errCond *sync.Cond
@@ -103,6 +172,11 @@ type mux struct {
 // offset.
 var globalOff uint32
 
+// Channels returns a snapshot of the open channels.
+func (m *mux) Channels() []ChannelInfo {
+	return m.chanList.infos()
+}
+
 func (m *mux) Wait() error {
 	m.errCond.L.Lock()
 	defer m.errCond.L.Unlock()
@@ -112,14 +186,66 @@ func (m *mux) Wait() error {
 	return m.err
 }
 
+// defaultMaxPendingGlobalRequests is the default of
+// Config.MaxPendingGlobalRequests.
+const defaultMaxPendingGlobalRequests = 64
+
+// muxOptions configures a mux created by newMuxWithOptions.
+type muxOptions struct {
+	// openFilter is the filter for incoming channels, see
+	// mux.openFilter.
+	openFilter func(NewChannel) error
+
+	// maxPendingGlobalRequests bounds the number of global requests
+	// awaiting a reply. If zero, defaultMaxPendingGlobalRequests is
+	// used.
+	maxPendingGlobalRequests int
+
+	// acceptEnv is the policy for "env" requests, see mux.acceptEnv.
+	acceptEnv func(name, value string) bool
+
+	// noAgentForwarding forbids agent forwarding, see
+	// mux.noAgentForwarding.
+	noAgentForwarding bool
+
+	// proveHostKeys answers hostkeys-prove-00@openssh.com requests,
+	// see mux.proveHostKeys.
+	proveHostKeys func(payload []byte) ([]byte, error)
+}
+
+// The request and channel types of OpenSSH agent forwarding.
+const (
+	agentRequestType = "auth-agent-req@openssh.com"
+	agentChannelType = "auth-agent@openssh.com"
+)
+
+// eowRequestType is the channel request with which OpenSSH signals
+// the end of writes on a session channel.
+const eowRequestType = "eow@openssh.com"
+
+var errAgentForwardingDisabled = errors.New("ssh: agent forwarding is disabled")
+
 // newMux returns a mux that runs over the given connection.
 func newMux(p packetConn) *mux {
+	return newMuxWithOptions(p, muxOptions{})
+}
+
+// newMuxWithOptions returns a mux that runs over the given
+// connection, configured by opts.
+func newMuxWithOptions(p packetConn, opts muxOptions) *mux {
 	m := &mux{
-		conn:             p,
-		incomingChannels: make(chan NewChannel, chanSize),
-		globalResponses:  make(chan interface{}, 1),
-		incomingRequests: make(chan *Request, chanSize),
-		errCond:          newCond(),
+		conn:              p,
+		openFilter:        opts.openFilter,
+		acceptEnv:         opts.acceptEnv,
+		noAgentForwarding: opts.noAgentForwarding,
+		proveHostKeys:     opts.proveHostKeys,
+		maxPending:        opts.maxPendingGlobalRequests,
+		incomingChannels:  make(chan NewChannel, chanSize),
+		incomingRequests:  make(chan *Request, chanSize),
+		errCond:           newCond(),
+	}
+	if m.maxPending <= 0 {
+		m.maxPending = defaultMaxPendingGlobalRequests
 	}
 	if debugMux {
 		m.chanList.offset = atomic.AddUint32(&globalOff, 1)
@@ -138,24 +264,53 @@ func (m *mux) sendMessage(msg interface{}) error {
 }
 
 func (m *mux) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
-	if wantReply {
-		m.globalSentMu.Lock()
-		defer m.globalSentMu.Unlock()
-	}
+	return m.SendRequestContext(context.Background(), name, wantReply, payload)
+}
+
+// ContextRequestSender is implemented by the Conn values of this
+// package, and sends global requests whose wait for the reply can be
+// canceled.
+type ContextRequestSender interface {
+	Conn
+
+	// SendRequestContext is like SendRequest, but if ctx is done
+	// before the reply arrives, it returns the error of ctx; the
+	// reply is then discarded when it arrives. It also fails if
+	// Config.MaxPendingGlobalRequests requests are already awaiting
+	// their reply, including those of canceled calls, for example
+	// because the peer never replies.
+	SendRequestContext(ctx context.Context, name string, wantReply bool, payload []byte) (bool, []byte, error)
+}
 
-	if err := m.sendMessage(globalRequestMsg{
+// errTooManyPendingRequests is returned by SendRequestContext when
+// too many global requests await a reply.
+var errTooManyPendingRequests = errors.New("ssh: too many global requests awaiting a reply")
+
+// SendRequestContext implements ContextRequestSender.
+func (m *mux) SendRequestContext(ctx context.Context, name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	req := globalRequestMsg{
 		Type:      name,
 		WantReply: wantReply,
 		Data:      payload,
-	}); err != nil {
-		return false, nil, err
 	}
-
 	if !wantReply {
-		return false, nil, nil
+		return false, nil, m.sendMessage(req)
+	}
+	if err := ctx.Err(); err != nil {
+		return false, nil, err
 	}
 
-	msg, ok := <-m.globalResponses
+	reply, err := m.sendGlobalRequest(req)
+	if err != nil {
+		return false, nil, err
+	}
+	var msg interface{}
+	var ok bool
+	select {
+	case msg, ok = <-reply:
+	case <-ctx.Done():
+		return false, nil, ctx.Err()
+	}
 	if !ok {
 		return false, nil, io.EOF
 	}
@@ -169,8 +324,116 @@ func (m *mux) SendRequest(name string, wantReply bool, payload []byte) (bool, []
 	}
 }
 
-// ackRequest must be called after processing a global request that
-// has WantReply set.
+// sendGlobalRequest sends req, which wants a reply, and returns the
+// channel that receives the reply. The channel is closed if the
+// connection is closed first.
+func (m *mux) sendGlobalRequest(req globalRequestMsg) (<-chan interface{}, error) {
+	m.globalSentMu.Lock()
+	defer m.globalSentMu.Unlock()
+
+	reply := make(chan interface{}, 1)
+	m.globalMu.Lock()
+	switch {
+	case m.globalClosed:
+		m.globalMu.Unlock()
+		return nil, io.EOF
+	case len(m.globalPending) >= m.maxPending:
+		m.globalMu.Unlock()
+		return nil, errTooManyPendingRequests
+	}
+	m.globalPending = append(m.globalPending, reply)
+	m.globalMu.Unlock()
+
+	if err := m.sendMessage(req); err != nil {
+		// No request was sent after ours, as globalSentMu is held,
+		// so it is the last one, unless the loop is gone.
+		m.globalMu.Lock()
+		if n := len(m.globalPending); n > 0 && m.globalPending[n-1] == reply {
+			m.globalPending = m.globalPending[:n-1]
+		}
+		m.globalMu.Unlock()
+		return nil, err
+	}
+	return reply, nil
+}
+
+// handleGlobalResponse passes msg, a reply to a global request, to the
+// oldest request awaiting one. Replies without a request are dropped.
+func (m *mux) handleGlobalResponse(msg interface{}) {
+	m.globalMu.Lock()
+	defer m.globalMu.Unlock()
+	if len(m.globalPending) == 0 {
+		return
+	}
+	reply := m.globalPending[0]
+	m.globalPending[0] = nil
+	m.globalPending = m.globalPending[1:]
+	reply <- msg
+}
+
+// closeGlobalResponses fails the requests awaiting a reply, and those
+// sent later.
+func (m *mux) closeGlobalResponses() {
+	m.globalMu.Lock()
+	defer m.globalMu.Unlock()
+	m.globalClosed = true
+	for _, reply := range m.globalPending {
+		close(reply)
+	}
+	m.globalPending = nil
+}
+
+// A globalReply is the reply to a received global request, see
+// mux.incomingPending.
+type globalReply struct {
+	done bool
+	ok   bool
+	data []byte
+	// failures is the number of requests after this one that were
+	// refused because too many were pending.
+	failures int
+}
+
+// queueReply adds a pending reply for a received global request. It
+// returns nil if too many are pending; a failure is then sent in turn.
+func (m *mux) queueReply() *globalReply {
+	m.incomingMu.Lock()
+	defer m.incomingMu.Unlock()
+	if n := len(m.incomingPending); n >= m.maxPending {
+		m.incomingPending[n-1].failures++
+		return nil
+	}
+	r := &globalReply{}
+	m.incomingPending = append(m.incomingPending, r)
+	return r
+}
+
+// sendReply sets the reply r, and sends the replies that are no longer
+// waiting for an earlier one. Replies after the first are ignored.
+func (m *mux) sendReply(r *globalReply, ok bool, data []byte) error {
+	m.incomingMu.Lock()
+	defer m.incomingMu.Unlock()
+	if r.done {
+		return nil
+	}
+	r.done, r.ok, r.data = true, ok, data
+	for len(m.incomingPending) > 0 && m.incomingPending[0].done {
+		head := m.incomingPending[0]
+		m.incomingPending = m.incomingPending[1:]
+		if err := m.ackRequest(head.ok, head.data); err != nil {
+			return err
+		}
+		for ; head.failures > 0; head.failures-- {
+			if err := m.ackRequest(false, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ackRequest sends the reply to a global request that has WantReply
+// set.
 func (m *mux) ackRequest(ok bool, data []byte) error {
 	if ok {
 		return m.sendMessage(globalRequestSuccessMsg{Data: data})
@@ -196,7 +459,7 @@ func (m *mux) loop() {
 
 	close(m.incomingChannels)
 	close(m.incomingRequests)
-	close(m.globalResponses)
+	m.closeGlobalResponses()
 
 	m.conn.Close()
 
@@ -254,14 +517,28 @@ func (m *mux) handleGlobalPacket(packet []byte) error {
 
 	switch msg := msg.(type) {
 	case *globalRequestMsg:
+		var reply *globalReply
+		if msg.WantReply {
+			if reply = m.queueReply(); reply == nil {
+				return nil
+			}
+		}
+		if msg.Type == hostKeysProveRequestType && m.proveHostKeys != nil {
+			response, err := m.proveHostKeys(msg.Data)
+			if reply == nil {
+				return nil
+			}
+			return m.sendReply(reply, err == nil, response)
+		}
 		m.incomingRequests <- &Request{
 			Type:      msg.Type,
 			WantReply: msg.WantReply,
 			Payload:   msg.Data,
 			mux:       m,
+			reply:     reply,
 		}
 	case *globalRequestSuccessMsg, *globalRequestFailureMsg:
-		m.globalResponses <- msg
+		m.handleGlobalResponse(msg)
 	default:
 		panic(fmt.Sprintf("not a global message %#v", msg))
 	}
@@ -290,6 +567,16 @@ func (m *mux) handleChannelOpen(packet []byte) error {
 	c.remoteId = msg.PeersID
 	c.maxRemotePayload = msg.MaxPacketSize
 	c.remoteWin.add(msg.PeersWindow)
+	if m.noAgentForwarding && msg.ChanType == agentChannelType {
+		m.chanList.remove(c.localId)
+		return c.Reject(Prohibited, errAgentForwardingDisabled.Error())
+	}
+	if m.openFilter != nil {
+		if err := m.openFilter(c); err != nil {
+			m.chanList.remove(c.localId)
+			return c.Reject(Prohibited, err.Error())
+		}
+	}
 	m.incomingChannels <- c
 	return nil
 }
@@ -303,29 +590,63 @@ func (m *mux) OpenChannel(chanType string, extra []byte) (Channel, <-chan *Reque
 	return ch, ch.incomingRequests, nil
 }
 
+// ManualWindowConn is implemented by the Conn values of this package,
+// and opens channels in manual window mode, see ManualWindowChannel.
+type ManualWindowConn interface {
+	Conn
+
+	// OpenChannelManualWindow opens a channel, like OpenChannel,
+	// granting the peer an initial window of window bytes. Further
+	// window is only granted by AdjustWindow.
+	OpenChannelManualWindow(name string, data []byte, window uint32) (ManualWindowChannel, <-chan *Request, error)
+}
+
+// OpenChannelManualWindow implements ManualWindowConn.
+func (m *mux) OpenChannelManualWindow(chanType string, extra []byte, window uint32) (ManualWindowChannel, <-chan *Request, error) {
+	ch := m.newChannel(chanType, channelOutbound, extra)
+	ch.myWindow = window
+	ch.manualWindow = true
+	if err := m.sendChannelOpen(ch); err != nil {
+		return nil, nil, err
+	}
+	return ch, ch.incomingRequests, nil
+}
+
 func (m *mux) openChannel(chanType string, extra []byte) (*channel, error) {
 	ch := m.newChannel(chanType, channelOutbound, extra)
+	if err := m.sendChannelOpen(ch); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
 
+// sendChannelOpen requests the opening of ch, and waits for the
+// answer.
+func (m *mux) sendChannelOpen(ch *channel) error {
+	if m.noAgentForwarding && ch.chanType == agentChannelType {
+		m.chanList.remove(ch.localId)
+		return errAgentForwardingDisabled
+	}
 	ch.maxIncomingPayload = channelMaxPacket
 
 	open := channelOpenMsg{
-		ChanType:         chanType,
+		ChanType:         ch.chanType,
 		PeersWindow:      ch.myWindow,
 		MaxPacketSize:    ch.maxIncomingPayload,
-		TypeSpecificData: extra,
+		TypeSpecificData: ch.extraData,
 		PeersID:          ch.localId,
 	}
 	if err := m.sendMessage(open); err != nil {
-		return nil, err
+		return err
 	}
 
 	switch msg := (<-ch.msg).(type) {
 	case *channelOpenConfirmMsg:
-		return ch, nil
+		return nil
 	case *channelOpenFailureMsg:
-		return nil, &OpenChannelError{msg.Reason, msg.Message}
+		return &OpenChannelError{msg.Reason, msg.Message}
 	default:
-		return nil, fmt.Errorf("ssh: unexpected packet in response to channel open: %T", msg)
+		return fmt.Errorf("ssh: unexpected packet in response to channel open: %T", msg)
 	}
 }
 
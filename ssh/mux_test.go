@@ -5,8 +5,15 @@
 package ssh
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io"
 	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -566,6 +573,156 @@ func TestMuxGlobalRequestUnblock(t *testing.T) {
 	}
 }
 
+func TestMuxGlobalRequestContext(t *testing.T) {
+	clientMux, serverMux := muxPair()
+	defer serverMux.Close()
+	defer clientMux.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := clientMux.SendRequestContext(ctx, "slow", true, nil); err != context.DeadlineExceeded {
+		t.Fatalf("SendRequestContext: got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The late reply to the canceled request must not be taken for
+	// the reply to the next one.
+	slow := <-serverMux.incomingRequests
+	if err := slow.Reply(false, []byte("slow")); err != nil {
+		t.Fatalf("Reply: %v", err)
+	}
+	go func() {
+		r := <-serverMux.incomingRequests
+		r.Reply(true, []byte(r.Type))
+	}()
+	ok, data, err := clientMux.SendRequestContext(context.Background(), "fast", true, nil)
+	if !ok || string(data) != "fast" || err != nil {
+		t.Errorf("SendRequestContext(\"fast\"): %v %q %v", ok, data, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := clientMux.SendRequestContext(ctx, "canceled", true, nil); err != context.Canceled {
+		t.Errorf("SendRequestContext with a canceled context: got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestMuxMaxPendingGlobalRequests(t *testing.T) {
+	a, b := memPipe()
+	clientMux := newMuxWithOptions(a, muxOptions{maxPendingGlobalRequests: 2})
+	serverMux := newMux(b)
+	defer serverMux.Close()
+	defer clientMux.Close()
+
+	// The server does not reply, so both requests stay pending.
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, _, err := clientMux.SendRequestContext(ctx, "ignored", true, nil)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("SendRequestContext: got %v, want %v", err, context.DeadlineExceeded)
+		}
+	}
+	if _, _, err := clientMux.SendRequest("extra", true, nil); err != errTooManyPendingRequests {
+		t.Fatalf("SendRequest: got %v, want %v", err, errTooManyPendingRequests)
+	}
+	// Requests without a reply are not limited.
+	if _, _, err := clientMux.SendRequest("no-reply", false, nil); err != nil {
+		t.Fatalf("SendRequest without reply: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		r := <-serverMux.incomingRequests
+		if err := r.Reply(false, nil); err != nil {
+			t.Fatalf("Reply: %v", err)
+		}
+	}
+	go func() {
+		for r := range serverMux.incomingRequests {
+			if r.WantReply {
+				r.Reply(true, nil)
+			}
+		}
+	}()
+	// The replies free the slots, but may still be in flight.
+	for i := 0; ; i++ {
+		ok, _, err := clientMux.SendRequest("after", true, nil)
+		if err == errTooManyPendingRequests && i < 100 {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		if !ok || err != nil {
+			t.Fatalf("SendRequest after the replies: %v %v", ok, err)
+		}
+		break
+	}
+}
+
+func TestMuxMaxPendingIncomingGlobalRequests(t *testing.T) {
+	a, b := memPipe()
+	clientMux := newMux(a)
+	serverMux := newMuxWithOptions(b, muxOptions{maxPendingGlobalRequests: 2})
+	defer serverMux.Close()
+	defer clientMux.Close()
+
+	type result struct {
+		name string
+		ok   bool
+		data []byte
+		err  error
+	}
+	results := make(chan result, 4)
+	send := func(name string) {
+		go func() {
+			ok, data, err := clientMux.SendRequest(name, true, nil)
+			results <- result{name, ok, data, err}
+		}()
+	}
+
+	// The server holds two requests without replying, so the next
+	// two are refused.
+	var held []*Request
+	for _, name := range []string{"first", "second"} {
+		send(name)
+		held = append(held, <-serverMux.incomingRequests)
+	}
+	send("third")
+	send("fourth")
+	for refused := 0; refused < 2; {
+		serverMux.incomingMu.Lock()
+		refused = serverMux.incomingPending[1].failures
+		serverMux.incomingMu.Unlock()
+		runtime.Gosched()
+	}
+
+	// Replying out of order still sends the replies in order.
+	for i := len(held) - 1; i >= 0; i-- {
+		if err := held[i].Reply(true, []byte(held[i].Type)); err != nil {
+			t.Fatalf("Reply: %v", err)
+		}
+	}
+	got := map[string]result{}
+	for i := 0; i < 4; i++ {
+		r := <-results
+		got[r.name] = r
+	}
+	for _, name := range []string{"first", "second"} {
+		if r := got[name]; !r.ok || string(r.data) != name || r.err != nil {
+			t.Errorf("request %q: got %v %q %v, want its own reply", name, r.ok, r.data, r.err)
+		}
+	}
+	for _, name := range []string{"third", "fourth"} {
+		if r := got[name]; r.ok || r.err != nil {
+			t.Errorf("request %q: got %v %v, want a failure", name, r.ok, r.err)
+		}
+	}
+
+	select {
+	case r := <-serverMux.incomingRequests:
+		t.Errorf("refused request %q was passed on", r.Type)
+	default:
+	}
+}
+
 func TestMuxChannelRequestUnblock(t *testing.T) {
 	a, b, connB := channelPair(t)
 	defer a.Close()
@@ -713,3 +870,502 @@ func TestDebug(t *testing.T) {
 		t.Error("transport debug switched on")
 	}
 }
+
+func TestMuxChannels(t *testing.T) {
+	client, server := muxPair()
+	defer client.Close()
+	defer server.Close()
+	go DiscardRequests(server.incomingRequests)
+	go DiscardRequests(client.incomingRequests)
+
+	accepted := make(chan Channel, 3)
+	go func() {
+		for newCh := range server.incomingChannels {
+			ch, reqs, err := newCh.Accept()
+			if err != nil {
+				t.Errorf("Accept: %v", err)
+				return
+			}
+			go DiscardRequests(reqs)
+			accepted <- ch
+		}
+	}()
+
+	start := time.Now()
+	var chans []Channel
+	for _, typ := range []string{"a", "b", "c"} {
+		ch, reqs, err := client.OpenChannel(typ, nil)
+		if err != nil {
+			t.Fatalf("OpenChannel(%q): %v", typ, err)
+		}
+		go DiscardRequests(reqs)
+		chans = append(chans, ch)
+	}
+	serverChans := []Channel{<-accepted, <-accepted, <-accepted}
+
+	if _, err := chans[1].Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(serverChans[1], buf); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+
+	infos := client.Channels()
+	if len(infos) != 3 {
+		t.Fatalf("got %d channels, want 3: %+v", len(infos), infos)
+	}
+	for i, info := range infos {
+		want := chans[i].(*channel)
+		if info.Type != want.chanType || info.LocalID != want.localId || info.RemoteID != want.remoteId {
+			t.Errorf("channel %d: got %+v, want type %q, IDs %d/%d", i, info, want.chanType, want.localId, want.remoteId)
+		}
+		if info.Inbound {
+			t.Errorf("channel %d: opened locally, but reported as inbound", i)
+		}
+		if info.Created.Before(start) {
+			t.Errorf("channel %d: created at %v, before the test started", i, info.Created)
+		}
+	}
+	if infos[1].BytesSent != 5 || infos[0].BytesSent != 0 {
+		t.Errorf("got bytes sent %d and %d, want 5 and 0", infos[1].BytesSent, infos[0].BytesSent)
+	}
+
+	serverInfos := server.Channels()
+	if len(serverInfos) != 3 {
+		t.Fatalf("got %d server channels, want 3", len(serverInfos))
+	}
+	for _, info := range serverInfos {
+		if !info.Inbound {
+			t.Errorf("server channel %+v not reported as inbound", info)
+		}
+		if info.Type == "b" && info.BytesReceived != 5 {
+			t.Errorf("server channel b received %d bytes, want 5", info.BytesReceived)
+		}
+	}
+
+	// Closing a channel removes it from the list once both sides
+	// have closed it.
+	chans[0].Close()
+	for i := 0; i < 100 && len(client.Channels()) != 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if infos := client.Channels(); len(infos) != 2 || infos[0].Type != "b" {
+		t.Errorf("after closing a, got channels %+v", infos)
+	}
+}
+
+func TestConnChannels(t *testing.T) {
+	serverConf := &ServerConfig{NoClientAuth: true}
+	serverConf.AddHostKey(testSigners["rsa"])
+	client, _, chans, reqs := pipePair(t, serverConf, testClientConfig())
+	go DiscardRequests(reqs)
+	go func() {
+		for newCh := range chans {
+			_, reqs, _ := newCh.Accept()
+			go DiscardRequests(reqs)
+		}
+	}()
+
+	if _, _, err := client.OpenChannel("session", nil); err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+	lister, ok := client.Conn.(ChannelLister)
+	if !ok {
+		t.Fatalf("%T is not a ChannelLister", client.Conn)
+	}
+	if infos := lister.Channels(); len(infos) != 1 || infos[0].Type != "session" {
+		t.Errorf("got channels %+v, want one session", infos)
+	}
+}
+
+func TestMuxManualWindow(t *testing.T) {
+	client, server := muxPair()
+	defer client.Close()
+	defer server.Close()
+
+	accepted := make(chan ManualWindowChannel, 1)
+	go func() {
+		newCh := <-server.incomingChannels
+		ch, reqs, err := newCh.(ManualWindowNewChannel).AcceptManualWindow(10)
+		if err != nil {
+			t.Errorf("AcceptManualWindow: %v", err)
+			return
+		}
+		go DiscardRequests(reqs)
+		accepted <- ch
+	}()
+	writer, reqs, err := client.OpenChannel("chan", nil)
+	if err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+	go DiscardRequests(reqs)
+	reader := <-accepted
+
+	data := []byte("0123456789abcdefghijklmno")
+	written := make(chan error, 1)
+	go func() {
+		_, err := writer.Write(data)
+		written <- err
+	}()
+
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(reader, buf[:10]); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	// Reading did not grant more window, so the writer is stuck.
+	select {
+	case err := <-written:
+		t.Fatalf("Write returned %v without a window adjustment", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := reader.AdjustWindow(uint32(len(data) - 10)); err != nil {
+		t.Fatalf("AdjustWindow: %v", err)
+	}
+	if _, err := io.ReadFull(reader, buf[10:]); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Errorf("read %q, want %q", buf, data)
+	}
+
+	if err := reader.AdjustWindow(1); err != nil {
+		t.Fatalf("AdjustWindow: %v", err)
+	}
+	if err := reader.AdjustWindow(1<<32 - 1); err == nil {
+		t.Error("AdjustWindow succeeded with an overflowing window")
+	}
+}
+
+func TestConnOpenChannelManualWindow(t *testing.T) {
+	serverConf := testServerConfig()
+	client, _, chans, reqs := pipePair(t, serverConf, testClientConfig())
+	go DiscardRequests(reqs)
+
+	written := make(chan error, 1)
+	go func() {
+		ch, chReqs, err := (<-chans).Accept()
+		if err != nil {
+			written <- err
+			return
+		}
+		go DiscardRequests(chReqs)
+		_, err = ch.Write(make([]byte, 100))
+		written <- err
+	}()
+
+	ch, chReqs, err := client.Conn.(ManualWindowConn).OpenChannelManualWindow("chan", nil, 40)
+	if err != nil {
+		t.Fatalf("OpenChannelManualWindow: %v", err)
+	}
+	go DiscardRequests(chReqs)
+
+	buf := make([]byte, 100)
+	if _, err := io.ReadFull(ch, buf[:40]); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	select {
+	case err := <-written:
+		t.Fatalf("Write returned %v without a window adjustment", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := ch.AdjustWindow(60); err != nil {
+		t.Fatalf("AdjustWindow: %v", err)
+	}
+	if _, err := io.ReadFull(ch, buf[40:]); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+}
+
+func TestOnChannelOpen(t *testing.T) {
+	var seen []string
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+		OnChannelOpen: func(conn ConnMetadata, newChan NewChannel) error {
+			seen = append(seen, conn.User()+":"+newChan.ChannelType())
+			if newChan.ChannelType() == "direct-tcpip" {
+				return errors.New("port forwarding is disabled")
+			}
+			return nil
+		},
+	}
+	serverConf.AddHostKey(testSigners["ecdsa"])
+	client, server, chans, reqs := pipePair(t, serverConf, &ClientConfig{User: "testuser", HostKeyCallback: InsecureIgnoreHostKey()})
+	go DiscardRequests(reqs)
+
+	received := make(chan string, 2)
+	go func() {
+		for newCh := range chans {
+			received <- newCh.ChannelType()
+			ch, chReqs, err := newCh.Accept()
+			if err != nil {
+				t.Errorf("Accept: %v", err)
+				return
+			}
+			go DiscardRequests(chReqs)
+			ch.Close()
+		}
+	}()
+
+	_, _, err := client.OpenChannel("direct-tcpip", nil)
+	openErr, ok := err.(*OpenChannelError)
+	if !ok || openErr.Reason != Prohibited || openErr.Message != "port forwarding is disabled" {
+		t.Fatalf("OpenChannel: got %v, want a Prohibited rejection", err)
+	}
+	ch, chReqs, err := client.OpenChannel("session", nil)
+	if err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+	go DiscardRequests(chReqs)
+	ch.Close()
+
+	if got := <-received; got != "session" {
+		t.Errorf("application received a %q channel, want only session", got)
+	}
+	if len(server.Conn.(ChannelLister).Channels()) > 1 {
+		t.Errorf("rejected channel is still listed")
+	}
+	if got, want := strings.Join(seen, ","), "testuser:direct-tcpip,testuser:session"; got != want {
+		t.Errorf("OnChannelOpen saw %s, want %s", got, want)
+	}
+}
+
+func TestAgentForwardingDisabled(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		client, server bool
+	}{
+		{"DisableAgentForwarding", true, false},
+		{"RejectAgentForwarding", false, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConf := &ServerConfig{NoClientAuth: true, RejectAgentForwarding: tt.server}
+			serverConf.AddHostKey(testSigners["ecdsa"])
+			clientConf := &ClientConfig{HostKeyCallback: InsecureIgnoreHostKey(), DisableAgentForwarding: tt.client}
+			client, server, chans, reqs := pipePair(t, serverConf, clientConf)
+			go DiscardRequests(reqs)
+			go func() {
+				for newCh := range client.HandleChannelOpen(agentChannelType) {
+					newCh.Reject(ConnectionFailed, "agent channel reached the client")
+				}
+			}()
+
+			requests := make(chan string, 10)
+			go func() {
+				for newCh := range chans {
+					if newCh.ChannelType() != "session" {
+						newCh.Reject(ConnectionFailed, "agent channel reached the server")
+						continue
+					}
+					ch, chReqs, err := newCh.Accept()
+					if err != nil {
+						t.Errorf("Accept: %v", err)
+						return
+					}
+					go func() {
+						defer ch.Close()
+						for req := range chReqs {
+							requests <- req.Type
+							req.Reply(true, nil)
+						}
+					}()
+				}
+			}()
+
+			ch, chReqs, err := client.OpenChannel("session", nil)
+			if err != nil {
+				t.Fatalf("OpenChannel: %v", err)
+			}
+			defer ch.Close()
+			go DiscardRequests(chReqs)
+
+			ok, err := ch.SendRequest(agentRequestType, true, nil)
+			if tt.client && err != errAgentForwardingDisabled {
+				t.Errorf("SendRequest: got %v, want %v", err, errAgentForwardingDisabled)
+			}
+			if tt.server && (err != nil || ok) {
+				t.Errorf("SendRequest: got %t, %v, want a refusal", ok, err)
+			}
+			if _, err := ch.SendRequest("ping", true, nil); err != nil {
+				t.Fatalf("SendRequest: %v", err)
+			}
+			if got := <-requests; got != "ping" {
+				t.Errorf("server received a %q request, want only ping", got)
+			}
+
+			// Agent channels opened by the server.
+			_, _, err = server.OpenChannel(agentChannelType, nil)
+			if tt.server && err != errAgentForwardingDisabled {
+				t.Errorf("server OpenChannel: got %v, want %v", err, errAgentForwardingDisabled)
+			}
+			if openErr, ok := err.(*OpenChannelError); tt.client && (!ok || openErr.Reason != Prohibited) {
+				t.Errorf("server OpenChannel: got %v, want a Prohibited rejection", err)
+			}
+
+			// Agent channels opened by the client.
+			_, _, err = client.OpenChannel(agentChannelType, nil)
+			if tt.client && err != errAgentForwardingDisabled {
+				t.Errorf("client OpenChannel: got %v, want %v", err, errAgentForwardingDisabled)
+			}
+			if openErr, ok := err.(*OpenChannelError); tt.server && (!ok || openErr.Reason != Prohibited) {
+				t.Errorf("client OpenChannel: got %v, want a Prohibited rejection", err)
+			}
+		})
+	}
+}
+
+func TestEndOfWrite(t *testing.T) {
+	client, _, chans, reqs := pipePair(t, testServerConfig(), testClientConfig())
+	go DiscardRequests(reqs)
+
+	requests := make(chan string, 10)
+	serverCh := make(chan Channel, 1)
+	go func() {
+		for newCh := range chans {
+			ch, chReqs, err := newCh.Accept()
+			if err != nil {
+				t.Errorf("Accept: %v", err)
+				return
+			}
+			go func() {
+				for req := range chReqs {
+					requests <- req.Type
+					req.Reply(true, nil)
+				}
+				close(requests)
+			}()
+			serverCh <- ch
+		}
+	}()
+
+	ch, chReqs, err := client.OpenChannel("session", nil)
+	if err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+	defer ch.Close()
+	go DiscardRequests(chReqs)
+	sch := <-serverCh
+
+	// CloseWrite only ends the data of the client.
+	if err := ch.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite: %v", err)
+	}
+	if _, err := ioutil.ReadAll(sch); err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if sch.(EOWChannel).EOWReceived() {
+		t.Errorf("CloseWrite sent eow@openssh.com")
+	}
+
+	// The server still writes to the client, which stops reading.
+	if _, err := sch.Write([]byte("read")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(ch, buf); err != nil || string(buf) != "read" {
+		t.Fatalf("ReadFull: got %q, %v", buf, err)
+	}
+	if err := ch.(EOWChannel).CloseRead(); err != nil {
+		t.Fatalf("CloseRead: %v", err)
+	}
+	if n, err := ch.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read after CloseRead: got %d, %v, want io.EOF", n, err)
+	}
+	// The requests are delivered in order, so the eow@openssh.com
+	// request has been handled once this one is.
+	if _, err := ch.SendRequest("sync", true, nil); err != nil {
+		t.Fatalf("SendRequest: %v", err)
+	}
+	if !sch.(EOWChannel).EOWReceived() {
+		t.Errorf("EOWReceived is false after CloseRead of the client")
+	}
+	if ch.(EOWChannel).EOWReceived() {
+		t.Errorf("EOWReceived of the client is true")
+	}
+
+	// The data written after CloseRead is discarded, without
+	// blocking the writer on the window.
+	if _, err := sch.Write(make([]byte, 4*channelWindowSize)); err != nil {
+		t.Fatalf("Write after CloseRead: %v", err)
+	}
+
+	sch.Close()
+	for req := range requests {
+		if req != "sync" {
+			t.Errorf("server received a %q request", req)
+		}
+	}
+}
+
+func TestMuxChannelDeadlines(t *testing.T) {
+	reader, writer, mux := channelPair(t)
+	defer reader.Close()
+	defer writer.Close()
+	defer mux.Close()
+
+	isTimeout := func(err error) bool {
+		netErr, ok := err.(net.Error)
+		return ok && netErr.Timeout() && errors.Is(err, os.ErrDeadlineExceeded)
+	}
+
+	// A deadline set while a Read blocks interrupts it.
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := reader.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	reader.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if err := <-readErr; !isTimeout(err) {
+		t.Fatalf("Read: got %v, want a timeout", err)
+	}
+	if _, err := reader.Read(make([]byte, 1)); !isTimeout(err) {
+		t.Fatalf("Read after the deadline: got %v, want a timeout", err)
+	}
+	if _, err := reader.Stderr().Read(make([]byte, 1)); !isTimeout(err) {
+		t.Fatalf("Read of stderr after the deadline: got %v, want a timeout", err)
+	}
+
+	// The timeouts did not consume any data.
+	reader.SetReadDeadline(time.Time{})
+	if _, err := writer.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("Read = %q, %v, want %q", buf, err, "hello")
+	}
+
+	// A Write that waits for window space times out.
+	if _, err := writer.Write(make([]byte, channelWindowSize)); err != nil {
+		t.Fatalf("could not fill window: %v", err)
+	}
+	writer.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	if n, err := writer.Write([]byte("world")); n != 0 || !isTimeout(err) {
+		t.Fatalf("Write with a full window = %d, %v, want a timeout", n, err)
+	}
+	writer.SetDeadline(time.Time{})
+
+	// Writing works again once the window space was granted.
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := writer.Write([]byte("world"))
+		writeErr <- err
+	}()
+	if _, err := io.ReadFull(reader, make([]byte, channelWindowSize)); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if err := <-writeErr; err != nil {
+		t.Fatalf("Write after the window was adjusted: %v", err)
+	}
+	if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != "world" {
+		t.Fatalf("Read = %q, %v, want %q", buf, err, "world")
+	}
+}
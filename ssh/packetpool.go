@@ -0,0 +1,52 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import "sync"
+
+// packetBufferSizes are the capacities of the packet buffers kept in
+// packetBufferPools. The largest one fits a channel data packet with
+// the default maximum payload of channelMaxPacket bytes.
+var packetBufferSizes = [...]int{1 << 9, 1 << 11, 1 << 13, channelMaxPacket + 1<<9}
+
+// packetBufferPools hold the buffers that incoming packets are copied
+// into, so that connections moving a lot of data do not allocate a new
+// buffer for every packet. Only channel data packets, whose lifetime
+// the channel controls, are returned to the pools; other packets may
+// be retained by parsed messages and are left to the garbage
+// collector.
+var packetBufferPools [len(packetBufferSizes)]sync.Pool
+
+// getPacketBuffer returns a buffer of length n, from the pools if n is
+// small enough.
+func getPacketBuffer(n int) []byte {
+	for i, size := range packetBufferSizes {
+		if n > size {
+			continue
+		}
+		if p, ok := packetBufferPools[i].Get().(*[]byte); ok {
+			return (*p)[:n]
+		}
+		return make([]byte, n, size)
+	}
+	return make([]byte, n)
+}
+
+// putPacketBuffer zeroes buf, so that no plaintext is handed to another
+// connection, and returns it to the pools. buf must have been returned
+// by getPacketBuffer and must not be used afterwards.
+func putPacketBuffer(buf []byte) {
+	for i, size := range packetBufferSizes {
+		if cap(buf) != size {
+			continue
+		}
+		buf = buf[:size]
+		for j := range buf {
+			buf[j] = 0
+		}
+		packetBufferPools[i].Put(&buf)
+		return
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+// This file defines the payloads of the standard channel types and
+// requests of RFC 4254, for servers and clients that handle them
+// directly. They are encoded and decoded with Marshal and Unmarshal:
+//
+//	var req PtyRequest
+//	if err := Unmarshal(r.Payload, &req); err != nil {
+//		...
+//	}
+
+// DirectTCPIPPayload is the extra data of a "direct-tcpip" channel
+// open request, with which a client asks the server to connect to a
+// host. See RFC 4254, section 7.2.
+type DirectTCPIPPayload struct {
+	// Addr and Port are the host and port the server should
+	// connect to.
+	Addr string
+	Port uint32
+
+	// OriginAddr and OriginPort are the address and port of the
+	// client side of the connection, as seen by the client.
+	OriginAddr string
+	OriginPort uint32
+}
+
+// ForwardedTCPIPPayload is the extra data of a "forwarded-tcpip"
+// channel open request, with which a server passes a connection to a
+// port forwarded by a "tcpip-forward" request. See RFC 4254, section
+// 7.2.
+type ForwardedTCPIPPayload struct {
+	// Addr and Port are the address and port that were connected
+	// to, those of the "tcpip-forward" request.
+	Addr string
+	Port uint32
+
+	// OriginAddr and OriginPort are the address and port of the
+	// originator of the connection.
+	OriginAddr string
+	OriginPort uint32
+}
+
+// TCPIPForwardRequest is the payload of the "tcpip-forward" and
+// "cancel-tcpip-forward" global requests, with which a client asks
+// the server to forward the connections to a port, or to stop doing
+// so. See RFC 4254, section 7.1.
+type TCPIPForwardRequest struct {
+	// BindAddr is the address to listen on. The empty string and
+	// "0.0.0.0" mean all the addresses of the server, and
+	// "localhost" its loopback addresses.
+	BindAddr string
+
+	// BindPort is the port to listen on. If it is 0, the server
+	// chooses the port and returns it in a TCPIPForwardResponse.
+	BindPort uint32
+}
+
+// TCPIPForwardResponse is the payload of the successful reply to a
+// "tcpip-forward" request whose BindPort was 0.
+type TCPIPForwardResponse struct {
+	// BindPort is the port the server listens on.
+	BindPort uint32
+}
+
+// PtyRequest is the payload of a "pty-req" session request, which
+// asks for a pseudo-terminal. See RFC 4254, section 6.2.
+type PtyRequest struct {
+	// Term is the value of the TERM environment variable, such as
+	// "xterm".
+	Term string
+
+	// Columns and Rows are the dimensions of the terminal in
+	// characters, and Width and Height in pixels. Zero dimensions
+	// are ignored.
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+
+	// Modes holds the encoded terminal modes, as described in
+	// section 8 of RFC 4254, ended by TTY_OP_END.
+	Modes string
+}
+
+// WindowChangeRequest is the payload of a "window-change" session
+// request, which reports new dimensions of the terminal. See RFC 4254,
+// section 6.7.
+type WindowChangeRequest struct {
+	// Columns and Rows are the dimensions of the terminal in
+	// characters, and Width and Height in pixels.
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+// ExitStatusRequest is the payload of an "exit-status" session
+// request, with which a server reports the exit status of the command.
+// See RFC 4254, section 6.10.
+type ExitStatusRequest struct {
+	Status uint32
+}
+
+// ExitSignalRequest is the payload of an "exit-signal" session
+// request, with which a server reports that the command was terminated
+// by a signal. See RFC 4254, section 6.10.
+type ExitSignalRequest struct {
+	// Signal is the name of the signal, without the "SIG" prefix,
+	// such as "KILL".
+	Signal string
+
+	// CoreDumped is true if the command dumped core.
+	CoreDumped bool
+
+	// Error is a textual description of the error, and Lang its
+	// language tag.
+	Error string
+	Lang  string
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPayloadsRoundTrip(t *testing.T) {
+	for _, msg := range []interface{}{
+		&DirectTCPIPPayload{Addr: "example.com", Port: 80, OriginAddr: "192.0.2.1", OriginPort: 4242},
+		&ForwardedTCPIPPayload{Addr: "0.0.0.0", Port: 8080, OriginAddr: "198.51.100.7", OriginPort: 55000},
+		&TCPIPForwardRequest{BindAddr: "localhost", BindPort: 2222},
+		&TCPIPForwardResponse{BindPort: 40000},
+		&PtyRequest{Term: "xterm", Columns: 80, Rows: 24, Width: 640, Height: 192, Modes: "\x35\x00\x00\x00\x01\x00"},
+		&WindowChangeRequest{Columns: 132, Rows: 43, Width: 1056, Height: 344},
+		&ExitStatusRequest{Status: 3},
+		&ExitSignalRequest{Signal: "KILL", CoreDumped: true, Error: "killed", Lang: "en"},
+	} {
+		got := reflect.New(reflect.TypeOf(msg).Elem()).Interface()
+		if err := Unmarshal(Marshal(msg), got); err != nil {
+			t.Errorf("Unmarshal(%T): %v", msg, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, msg) {
+			t.Errorf("got %#v, want %#v", got, msg)
+		}
+	}
+}
+
+func TestPayloadsWireFormat(t *testing.T) {
+	got := Marshal(&DirectTCPIPPayload{Addr: "h", Port: 22, OriginAddr: "o", OriginPort: 1})
+	want := []byte{
+		0, 0, 0, 1, 'h',
+		0, 0, 0, 22,
+		0, 0, 0, 1, 'o',
+		0, 0, 0, 1,
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("DirectTCPIPPayload: got %x, want %x", got, want)
+	}
+
+	got = Marshal(&ExitSignalRequest{Signal: "TERM", CoreDumped: true})
+	want = []byte{
+		0, 0, 0, 4, 'T', 'E', 'R', 'M',
+		1,
+		0, 0, 0, 0,
+		0, 0, 0, 0,
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("ExitSignalRequest: got %x, want %x", got, want)
+	}
+}
+
+func TestParsePtyRequest(t *testing.T) {
+	server, client, err := netPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	defer client.Close()
+
+	reqs := make(chan *Request, 2)
+	go func() {
+		conf := testServerConfig()
+		conn, chans, gr, err := NewServerConn(server, conf)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go DiscardRequests(gr)
+		for newCh := range chans {
+			ch, in, err := newCh.Accept()
+			if err != nil {
+				return
+			}
+			defer ch.Close()
+			for req := range in {
+				if req.WantReply {
+					req.Reply(true, nil)
+				}
+				reqs <- req
+			}
+		}
+	}()
+
+	conn, chans, gr, err := NewClientConn(client, "", &ClientConfig{
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(conn, chans, gr)
+	defer c.Close()
+	session, err := c.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.RequestPty("vt100", 24, 80, TerminalModes{ECHO: 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.WindowChange(50, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	var pty PtyRequest
+	if err := Unmarshal((<-reqs).Payload, &pty); err != nil {
+		t.Fatal(err)
+	}
+	if want := (PtyRequest{Term: "vt100", Columns: 80, Rows: 24, Width: 640, Height: 192, Modes: "\x35\x00\x00\x00\x00\x00"}); pty != want {
+		t.Errorf("got pty-req %+v, want %+v", pty, want)
+	}
+	var wc WindowChangeRequest
+	if err := Unmarshal((<-reqs).Payload, &wc); err != nil {
+		t.Fatal(err)
+	}
+	if want := (WindowChangeRequest{Columns: 100, Rows: 50, Width: 800, Height: 400}); wc != want {
+		t.Errorf("got window-change %+v, want %+v", wc, want)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Pipe connects an SSH client and server over an in-memory
+// connection, without using a network socket. It runs the handshake
+// for both sides with the given configurations, as NewClientConn and
+// NewServerConn would, and returns the resulting Client and
+// ServerConn, along with the channels of incoming channel and global
+// requests for the server. The server's channels must be serviced or
+// the connection will hang.
+//
+// Pipe is mostly useful for tests.
+func Pipe(serverConfig *ServerConfig, clientConfig *ClientConfig) (*Client, *ServerConn, <-chan NewChannel, <-chan *Request, error) {
+	c1, c2 := newMemConnPair()
+
+	type serverResult struct {
+		conn  *ServerConn
+		chans <-chan NewChannel
+		reqs  <-chan *Request
+		err   error
+	}
+	done := make(chan serverResult, 1)
+	go func() {
+		conn, chans, reqs, err := NewServerConn(c1, serverConfig)
+		if err != nil {
+			// NewServerConn does not close c1 if it rejects
+			// serverConfig before the handshake.
+			c1.Close()
+		}
+		done <- serverResult{conn, chans, reqs, err}
+	}()
+
+	conn, chans, reqs, err := NewClientConn(c2, "pipe", clientConfig)
+	if err != nil {
+		c1.Close()
+		<-done
+		return nil, nil, nil, nil, err
+	}
+	res := <-done
+	if res.err != nil {
+		conn.Close()
+		return nil, nil, nil, nil, res.err
+	}
+	return NewClient(conn, chans, reqs), res.conn, res.chans, res.reqs, nil
+}
+
+// memConn is one end of an in-memory, full duplex net.Conn. Unlike
+// net.Pipe, writes do not wait for the peer to read them, which the
+// SSH version and key exchange depend on: both sides send before they
+// read.
+type memConn struct {
+	in   *buffer
+	peer *memConn
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newMemConnPair() (a, b *memConn) {
+	a = &memConn{in: newBuffer()}
+	b = &memConn{in: newBuffer()}
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+func (c *memConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *memConn) Read(b []byte) (int, error) {
+	if c.isClosed() {
+		return 0, io.ErrClosedPipe
+	}
+	return c.in.Read(b)
+}
+
+func (c *memConn) Write(b []byte) (int, error) {
+	if c.isClosed() || c.peer.isClosed() {
+		return 0, io.ErrClosedPipe
+	}
+	// The buffer keeps a reference to the slice it is given.
+	c.peer.in.write(append([]byte(nil), b...))
+	return len(b), nil
+}
+
+// Close closes the connection. Data already written by either side
+// can still be read by the other.
+func (c *memConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return io.ErrClosedPipe
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	c.in.eof()
+	c.peer.in.eof()
+	return nil
+}
+
+func (c *memConn) LocalAddr() net.Addr  { return memAddr{} }
+func (c *memConn) RemoteAddr() net.Addr { return memAddr{} }
+
+// SetDeadline exists to satisfy the net.Conn interface but is not
+// implemented by this type. It always returns an error.
+func (c *memConn) SetDeadline(t time.Time) error {
+	return errors.New("ssh: pipe: deadline not supported")
+}
+
+// SetReadDeadline exists to satisfy the net.Conn interface but is not
+// implemented by this type. It always returns an error.
+func (c *memConn) SetReadDeadline(t time.Time) error {
+	return errors.New("ssh: pipe: deadline not supported")
+}
+
+// SetWriteDeadline exists to satisfy the net.Conn interface but is not
+// implemented by this type. It always returns an error.
+func (c *memConn) SetWriteDeadline(t time.Time) error {
+	return errors.New("ssh: pipe: deadline not supported")
+}
+
+// memAddr is the address of both ends of a memConn.
+type memAddr struct{}
+
+func (memAddr) Network() string { return "pipe" }
+func (memAddr) String() string  { return "pipe" }
@@ -0,0 +1,132 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// testServerConfig returns a ServerConfig that accepts any client and
+// uses the "ecdsa" test host key.
+func testServerConfig() *ServerConfig {
+	conf := &ServerConfig{NoClientAuth: true}
+	conf.AddHostKey(testSigners["ecdsa"])
+	return conf
+}
+
+// testClientConfig returns a ClientConfig that accepts any host key.
+func testClientConfig() *ClientConfig {
+	return &ClientConfig{HostKeyCallback: InsecureIgnoreHostKey()}
+}
+
+// pipePair connects a client and a server with Pipe, and closes both
+// when the test ends. The channels of the server must be serviced, as
+// for Pipe.
+func pipePair(t *testing.T, serverConf *ServerConfig, clientConf *ClientConfig) (*Client, *ServerConn, <-chan NewChannel, <-chan *Request) {
+	t.Helper()
+	client, server, chans, reqs, err := Pipe(serverConf, clientConf)
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server, chans, reqs
+}
+
+func TestPipe(t *testing.T) {
+	serverConf := &ServerConfig{
+		PasswordCallback: func(conn ConnMetadata, password []byte) (*Permissions, error) {
+			if conn.User() == "testuser" && string(password) == "testpw" {
+				return &Permissions{Extensions: map[string]string{"user": "ok"}}, nil
+			}
+			return nil, errors.New("denied")
+		},
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+	clientConf := &ClientConfig{
+		User:            "testuser",
+		Auth:            []AuthMethod{Password("testpw")},
+		HostKeyCallback: FixedHostKey(testSigners["rsa"].PublicKey()),
+	}
+
+	client, server, chans, reqs, err := Pipe(serverConf, clientConf)
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	defer client.Close()
+	defer server.Close()
+	go DiscardRequests(reqs)
+
+	if got := server.Permissions.Extensions["user"]; got != "ok" {
+		t.Errorf("got permissions %v, want the ones from PasswordCallback", server.Permissions)
+	}
+
+	// Echo the data of the first channel back to the client.
+	go func() {
+		newCh := <-chans
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			return
+		}
+		go DiscardRequests(chReqs)
+		io.Copy(ch, ch)
+		ch.CloseWrite()
+	}()
+
+	ch, chReqs, err := client.OpenChannel("echo", nil)
+	if err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+	go DiscardRequests(chReqs)
+
+	want := bytes.Repeat([]byte("hello pipe "), 10000)
+	go func() {
+		ch.Write(want)
+		ch.CloseWrite()
+	}()
+	got, err := ioutil.ReadAll(ch)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("echoed %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestPipeHandshakeFailure(t *testing.T) {
+	serverConf := &ServerConfig{
+		PasswordCallback: func(conn ConnMetadata, password []byte) (*Permissions, error) {
+			return nil, errors.New("denied")
+		},
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+	clientConf := &ClientConfig{
+		User:            "testuser",
+		Auth:            []AuthMethod{Password("wrong")},
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+
+	if _, _, _, _, err := Pipe(serverConf, clientConf); err == nil {
+		t.Fatal("Pipe succeeded with a rejected password")
+	}
+}
+
+func TestPipeInvalidServerConfig(t *testing.T) {
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+		Config:       Config{KeyExchanges: []string{kexAlgoDHGEXSHA256}},
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+	if _, _, _, _, err := Pipe(serverConf, &ClientConfig{HostKeyCallback: InsecureIgnoreHostKey()}); err == nil {
+		t.Fatal("Pipe succeeded with an invalid server config")
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/ed25519"
+)
+
+// ParsePuTTYPrivateKey returns a Signer from a private key file in the
+// PPK format of PuTTY and PuTTYgen, versions 2 and 3. RSA, ECDSA and
+// Ed25519 keys are supported. The passphrase is ignored if the key is
+// not encrypted. If the key is encrypted and passphrase is nil, it
+// returns a PassphraseMissingError; if the passphrase is wrong, it
+// returns x509.IncorrectPasswordError.
+func ParsePuTTYPrivateKey(data, passphrase []byte) (Signer, error) {
+	key, err := parsePuTTYPrivateKey(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return NewSignerFromKey(key)
+}
+
+// ppkReader reads the "Name: value" lines of a PPK file, which must
+// appear in a fixed order.
+type ppkReader struct {
+	lines []string
+}
+
+func (r *ppkReader) next() (string, error) {
+	if len(r.lines) == 0 {
+		return "", errors.New("ssh: truncated PuTTY private key")
+	}
+	line := strings.TrimSuffix(r.lines[0], "\r")
+	r.lines = r.lines[1:]
+	return line, nil
+}
+
+func (r *ppkReader) field(name string) (string, error) {
+	line, err := r.next()
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimPrefix(line, name+": ")
+	if value == line {
+		return "", fmt.Errorf("ssh: PuTTY private key has %q, want field %q", line, name)
+	}
+	return value, nil
+}
+
+func (r *ppkReader) uint32Field(name string) (uint32, error) {
+	value, err := r.field(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("ssh: invalid %s in PuTTY private key: %v", name, err)
+	}
+	return uint32(n), nil
+}
+
+// blob reads a field holding a number of lines, followed by those
+// lines of base64 data.
+func (r *ppkReader) blob(name string) ([]byte, error) {
+	n, err := r.uint32Field(name)
+	if err != nil {
+		return nil, err
+	}
+	if int(n) > len(r.lines) {
+		return nil, errors.New("ssh: truncated PuTTY private key")
+	}
+	var b64 strings.Builder
+	for i := uint32(0); i < n; i++ {
+		line, _ := r.next()
+		b64.WriteString(line)
+	}
+	return base64.StdEncoding.DecodeString(b64.String())
+}
+
+// ppkKeys are the keys protecting a PPK file.
+type ppkKeys struct {
+	cipherKey, iv, macKey []byte
+}
+
+// ppkV2Keys derives the keys of a version 2 file with SHA-1.
+func ppkV2Keys(passphrase []byte) ppkKeys {
+	var cipherKey []byte
+	for i := byte(0); i < 2; i++ {
+		h := sha1.New()
+		h.Write([]byte{0, 0, 0, i})
+		h.Write(passphrase)
+		cipherKey = h.Sum(cipherKey)
+	}
+	mac := sha1.New()
+	mac.Write([]byte("putty-private-key-file-mac-key"))
+	mac.Write(passphrase)
+	return ppkKeys{
+		cipherKey: cipherKey[:32],
+		iv:        make([]byte, aes.BlockSize),
+		macKey:    mac.Sum(nil),
+	}
+}
+
+// The limits of the Argon2 parameters of version 3 files, which are
+// read before the passphrase can be checked: the memory, and the
+// memory times the passes, which bounds the time taken. PuTTYgen
+// writes 8192 KiB by default, and as many passes as take about a tenth
+// of a second.
+const (
+	maxPPKArgon2Memory = 1 << 18 // KiB
+	maxPPKArgon2Work   = 1 << 22 // KiB times passes
+)
+
+// ppkV3Keys reads the Argon2 parameters of an encrypted version 3 file
+// and derives its keys.
+func ppkV3Keys(r *ppkReader, passphrase []byte) (ppkKeys, error) {
+	kdf, err := r.field("Key-Derivation")
+	if err != nil {
+		return ppkKeys{}, err
+	}
+	memory, err := r.uint32Field("Argon2-Memory")
+	if err != nil {
+		return ppkKeys{}, err
+	}
+	passes, err := r.uint32Field("Argon2-Passes")
+	if err != nil {
+		return ppkKeys{}, err
+	}
+	parallelism, err := r.uint32Field("Argon2-Parallelism")
+	if err != nil {
+		return ppkKeys{}, err
+	}
+	saltHex, err := r.field("Argon2-Salt")
+	if err != nil {
+		return ppkKeys{}, err
+	}
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return ppkKeys{}, fmt.Errorf("ssh: invalid Argon2-Salt in PuTTY private key: %v", err)
+	}
+	if passes < 1 || parallelism < 1 || parallelism > 255 {
+		return ppkKeys{}, errors.New("ssh: invalid Argon2 parameters in PuTTY private key")
+	}
+	if memory > maxPPKArgon2Memory || uint64(memory)*uint64(passes) > maxPPKArgon2Work {
+		return ppkKeys{}, errors.New("ssh: Argon2 parameters of PuTTY private key are too costly")
+	}
+
+	var k []byte
+	switch kdf {
+	case "Argon2id":
+		k = argon2.IDKey(passphrase, salt, passes, memory, uint8(parallelism), 80)
+	case "Argon2i":
+		k = argon2.Key(passphrase, salt, passes, memory, uint8(parallelism), 80)
+	default:
+		return ppkKeys{}, fmt.Errorf("ssh: unsupported PuTTY key derivation %q", kdf)
+	}
+	return ppkKeys{cipherKey: k[:32], iv: k[32:48], macKey: k[48:]}, nil
+}
+
+func parsePuTTYPrivateKey(data, passphrase []byte) (interface{}, error) {
+	r := &ppkReader{lines: strings.Split(string(data), "\n")}
+	line, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	var version int
+	var algo string
+	switch {
+	case strings.HasPrefix(line, "PuTTY-User-Key-File-2: "):
+		version, algo = 2, strings.TrimPrefix(line, "PuTTY-User-Key-File-2: ")
+	case strings.HasPrefix(line, "PuTTY-User-Key-File-3: "):
+		version, algo = 3, strings.TrimPrefix(line, "PuTTY-User-Key-File-3: ")
+	default:
+		return nil, errors.New("ssh: not a PuTTY version 2 or 3 private key")
+	}
+
+	encryption, err := r.field("Encryption")
+	if err != nil {
+		return nil, err
+	}
+	if encryption != "none" && encryption != "aes256-cbc" {
+		return nil, fmt.Errorf("ssh: unsupported PuTTY key encryption %q", encryption)
+	}
+	encrypted := encryption != "none"
+	comment, err := r.field("Comment")
+	if err != nil {
+		return nil, err
+	}
+	pubBlob, err := r.blob("Public-Lines")
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ParsePublicKey(pubBlob)
+	if err != nil {
+		return nil, err
+	}
+	if pub.Type() != algo {
+		return nil, fmt.Errorf("ssh: PuTTY private key of type %q has a %q public key", algo, pub.Type())
+	}
+	if encrypted && passphrase == nil {
+		return nil, &PassphraseMissingError{PublicKey: pub}
+	}
+	if !encrypted {
+		passphrase = nil
+	}
+
+	var keys ppkKeys
+	var newMAC func() hash.Hash
+	switch {
+	case version == 2:
+		keys, newMAC = ppkV2Keys(passphrase), sha1.New
+	case encrypted:
+		if keys, err = ppkV3Keys(r, passphrase); err != nil {
+			return nil, err
+		}
+		newMAC = sha256.New
+	default:
+		newMAC = sha256.New
+	}
+
+	privBlob, err := r.blob("Private-Lines")
+	if err != nil {
+		return nil, err
+	}
+	macHex, err := r.field("Private-MAC")
+	if err != nil {
+		return nil, err
+	}
+	wantMAC, err := hex.DecodeString(macHex)
+	if err != nil {
+		return nil, fmt.Errorf("ssh: invalid Private-MAC in PuTTY private key: %v", err)
+	}
+
+	if encrypted {
+		if len(privBlob) == 0 || len(privBlob)%aes.BlockSize != 0 {
+			return nil, errors.New("ssh: invalid encrypted PuTTY private key length, not a multiple of the block size")
+		}
+		c, err := aes.NewCipher(keys.cipherKey)
+		if err != nil {
+			return nil, err
+		}
+		cipher.NewCBCDecrypter(c, keys.iv).CryptBlocks(privBlob, privBlob)
+	}
+
+	mac := hmac.New(newMAC, keys.macKey)
+	mac.Write(Marshal(struct {
+		Algo, Encryption, Comment string
+		Pub, Priv                 []byte
+	}{algo, encryption, comment, pubBlob, privBlob}))
+	if !hmac.Equal(mac.Sum(nil), wantMAC) {
+		if encrypted {
+			return nil, x509.IncorrectPasswordError
+		}
+		return nil, errors.New("ssh: PuTTY private key MAC mismatch")
+	}
+
+	return parsePuTTYPrivateBlob(pub, privBlob)
+}
+
+// parsePuTTYPrivateBlob combines the public key of a PPK file with its
+// private blob, whose trailing padding is ignored. See appendix C of
+// the PuTTY manual.
+func parsePuTTYPrivateBlob(pub PublicKey, priv []byte) (interface{}, error) {
+	switch pub := pub.(type) {
+	case *rsaPublicKey:
+		var k struct {
+			D, P, Q, Iqmp *big.Int
+			Rest          []byte `ssh:"rest"`
+		}
+		if err := Unmarshal(priv, &k); err != nil {
+			return nil, err
+		}
+		key := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey(*pub),
+			D:         k.D,
+			Primes:    []*big.Int{k.P, k.Q},
+		}
+		if err := key.Validate(); err != nil {
+			return nil, err
+		}
+		key.Precompute()
+		return key, nil
+	case *ecdsaPublicKey:
+		var k struct {
+			D    *big.Int
+			Rest []byte `ssh:"rest"`
+		}
+		if err := Unmarshal(priv, &k); err != nil {
+			return nil, err
+		}
+		if k.D.Sign() <= 0 || k.D.Cmp(pub.Curve.Params().N) >= 0 {
+			return nil, errors.New("ssh: scalar is out of range")
+		}
+		x, y := pub.Curve.ScalarBaseMult(k.D.Bytes())
+		if x.Cmp(pub.X) != 0 || y.Cmp(pub.Y) != 0 {
+			return nil, errors.New("ssh: public key does not match private key")
+		}
+		return &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey(*pub), D: k.D}, nil
+	case ed25519PublicKey:
+		// PuTTY stores the 32-byte seed as an mpint, reading the
+		// seed as a little-endian number.
+		var k struct {
+			Seed *big.Int
+			Rest []byte `ssh:"rest"`
+		}
+		if err := Unmarshal(priv, &k); err != nil {
+			return nil, err
+		}
+		if k.Seed.Sign() < 0 || k.Seed.BitLen() > 8*ed25519.SeedSize {
+			return nil, errors.New("ssh: private key unexpected length")
+		}
+		seed := make([]byte, ed25519.SeedSize)
+		k.Seed.FillBytes(seed)
+		for i, j := 0, len(seed)-1; i < j; i, j = i+1, j-1 {
+			seed[i], seed[j] = seed[j], seed[i]
+		}
+		key := ed25519.NewKeyFromSeed(seed)
+		if !bytes.Equal(key.Public().(ed25519.PublicKey), pub) {
+			return nil, errors.New("ssh: public key does not match private key")
+		}
+		return &key, nil
+	default:
+		return nil, fmt.Errorf("ssh: unsupported PuTTY key type %q", pub.Type())
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh/testdata"
+)
+
+func TestParsePuTTYPrivateKey(t *testing.T) {
+	for _, k := range testdata.PuTTYKeys {
+		t.Run(k.Name, func(t *testing.T) {
+			signer, err := ParsePuTTYPrivateKey(k.PPKBytes, []byte(k.Passphrase))
+			if err != nil {
+				t.Fatalf("ParsePuTTYPrivateKey: %v", err)
+			}
+			want := testSigners[k.KeyName].PublicKey()
+			if !bytes.Equal(signer.PublicKey().Marshal(), want.Marshal()) {
+				t.Errorf("got public key %s, want %s", MarshalAuthorizedKey(signer.PublicKey()), MarshalAuthorizedKey(want))
+			}
+			data := []byte("sign me")
+			sig, err := signer.Sign(rand.Reader, data)
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			if err := want.Verify(data, sig); err != nil {
+				t.Errorf("Verify: %v", err)
+			}
+
+			if k.Passphrase == "" {
+				return
+			}
+			_, err = ParsePuTTYPrivateKey(k.PPKBytes, nil)
+			if missing, ok := err.(*PassphraseMissingError); !ok {
+				t.Errorf("got error %v without passphrase, want PassphraseMissingError", err)
+			} else if !bytes.Equal(missing.PublicKey.Marshal(), want.Marshal()) {
+				t.Error("PassphraseMissingError has the wrong public key")
+			}
+			if _, err := ParsePuTTYPrivateKey(k.PPKBytes, []byte("wrong")); err != x509.IncorrectPasswordError {
+				t.Errorf("got error %v with wrong passphrase, want %v", err, x509.IncorrectPasswordError)
+			}
+		})
+	}
+}
+
+func TestParsePuTTYPrivateKeyTampered(t *testing.T) {
+	for _, k := range testdata.PuTTYKeys {
+		tampered := strings.Replace(string(k.PPKBytes), "Comment: ", "Comment: x", 1)
+		if _, err := ParsePuTTYPrivateKey([]byte(tampered), []byte(k.Passphrase)); err == nil {
+			t.Errorf("%s: tampered key parsed", k.Name)
+		}
+	}
+	for _, k := range testdata.PuTTYKeys {
+		if !strings.Contains(string(k.PPKBytes), "Argon2-Memory: 8192\n") {
+			continue
+		}
+		for _, tt := range []struct{ from, to string }{
+			{"Argon2-Memory: 8192\n", "Argon2-Memory: 4294967295\n"},
+			{"Argon2-Passes: 2\n", "Argon2-Passes: 4294967295\n"},
+		} {
+			costly := strings.Replace(string(k.PPKBytes), tt.from, tt.to, 1)
+			if _, err := ParsePuTTYPrivateKey([]byte(costly), []byte(k.Passphrase)); err == nil || !strings.Contains(err.Error(), "too costly") {
+				t.Errorf("%s with %q: got error %v, want the Argon2 parameters to be refused", k.Name, tt.to, err)
+			}
+		}
+	}
+	if _, err := ParsePuTTYPrivateKey([]byte("PuTTY-User-Key-File-1: ssh-rsa\n"), nil); err == nil {
+		t.Error("version 1 key parsed")
+	}
+	if _, err := ParsePuTTYPrivateKey(testdata.PEMBytes["rsa"], nil); err == nil {
+		t.Error("PEM key parsed")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PrincipalEntry is a line of an authorized_principals file, which
+// lists the certificate principals accepted for a user, see the
+// AuthorizedPrincipalsFile option of sshd_config(5).
+type PrincipalEntry struct {
+	// Principal is matched against the ValidPrincipals of a
+	// certificate.
+	Principal string
+
+	// Options are the options of the line, in the form returned
+	// by ParseAuthorizedKey, such as "no-pty" or
+	// `command="/usr/bin/rsync --server"`. Their names are not
+	// checked.
+	Options []string
+}
+
+// ParseAuthorizedPrincipals parses an authorized_principals file. Like
+// sshd, it ignores everything from a '#' to the end of a line, and
+// takes what precedes the last whitespace of a line as its
+// comma-separated options:
+//
+//	# Members of the ops team get a shell.
+//	ops
+//	command="/usr/bin/backup",from="10.0.0.0/8",no-pty backup
+func ParseAuthorizedPrincipals(r io.Reader) ([]PrincipalEntry, error) {
+	var entries []PrincipalEntry
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		e := PrincipalEntry{Principal: line}
+		if i := strings.LastIndexAny(line, " \t"); i >= 0 {
+			e.Principal = line[i+1:]
+			options, err := parseAuthorizedOptions(strings.TrimRight(line[:i], " \t"))
+			if err != nil {
+				return nil, fmt.Errorf("ssh: line %d of authorized principals: %v", n, err)
+			}
+			e.Options = options
+		}
+		entries = append(entries, e)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// parseAuthorizedOptions splits the comma-separated options of an
+// authorized_principals line. AuthorizedKeyOptions.Marshal also uses it
+// to check its Extra options.
+func parseAuthorizedOptions(in string) ([]string, error) {
+	var options []string
+	inQuote := false
+	start := 0
+	for i := 0; i < len(in); i++ {
+		switch b := in[i]; {
+		case b == '"' && (i == 0 || in[i-1] != '\\'):
+			inQuote = !inQuote
+		case inQuote:
+		case b == ' ' || b == '\t':
+			return nil, errors.New("whitespace in options")
+		case b == ',':
+			if i == start {
+				return nil, errors.New("empty option")
+			}
+			options = append(options, in[start:i])
+			start = i + 1
+		}
+	}
+	if inQuote {
+		return nil, errors.New("unmatched quote in options")
+	}
+	if start == len(in) {
+		return nil, errors.New("empty option")
+	}
+	return append(options, in[start:]), nil
+}
+
+// MarshalAuthorizedPrincipals serializes entries in the
+// authorized_principals format, one line each. It returns an error if
+// a principal is empty or contains whitespace, or an option is not a
+// single well-formed option, or if either contains a line break or a
+// '#', which would start another line or a comment.
+func MarshalAuthorizedPrincipals(entries []PrincipalEntry) ([]byte, error) {
+	var b bytes.Buffer
+	for _, e := range entries {
+		if e.Principal == "" || strings.ContainsAny(e.Principal, " \t\r\n#") {
+			return nil, fmt.Errorf("ssh: invalid authorized principal %q", e.Principal)
+		}
+		for _, opt := range e.Options {
+			if strings.ContainsAny(opt, "\r\n#") {
+				return nil, fmt.Errorf("ssh: invalid option %q of authorized principal %q", opt, e.Principal)
+			}
+			if split, err := parseAuthorizedOptions(opt); err != nil || len(split) != 1 {
+				return nil, fmt.Errorf("ssh: malformed option %q of authorized principal %q", opt, e.Principal)
+			}
+		}
+		if len(e.Options) > 0 {
+			b.WriteString(strings.Join(e.Options, ","))
+			b.WriteByte(' ')
+		}
+		b.WriteString(e.Principal)
+		b.WriteByte('\n')
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAuthorizedPrincipals(t *testing.T) {
+	in := `# Principals of alice.
+alice
+   ops   # The ops team.
+
+command="/usr/bin/backup --dir \"/srv/a b\"",from="10.0.0.0/8,192.168.0.0/16",no-pty backup
+no-port-forwarding,restrict	 deploy@example.com` + "\r\n" + `
+zone-1 # command="ignored" x
+`
+	want := []PrincipalEntry{
+		{Principal: "alice"},
+		{Principal: "ops"},
+		{
+			Principal: "backup",
+			Options:   []string{`command="/usr/bin/backup --dir \"/srv/a b\""`, `from="10.0.0.0/8,192.168.0.0/16"`, "no-pty"},
+		},
+		{Principal: "deploy@example.com", Options: []string{"no-port-forwarding", "restrict"}},
+		{Principal: "zone-1"},
+	}
+	got, err := ParseAuthorizedPrincipals(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ParseAuthorizedPrincipals: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	out, err := MarshalAuthorizedPrincipals(got)
+	if err != nil {
+		t.Fatalf("MarshalAuthorizedPrincipals: %v", err)
+	}
+	if again, err := ParseAuthorizedPrincipals(bytes.NewReader(out)); err != nil || !reflect.DeepEqual(again, want) {
+		t.Errorf("parsing %q = %q, %v, want %q", out, again, err, want)
+	}
+}
+
+func TestParseAuthorizedPrincipalsInvalid(t *testing.T) {
+	for _, in := range []string{
+		`command="ls alice`,
+		"no-pty,,no-pty alice",
+		"no-pty, alice",
+		"no-pty restrict alice",
+	} {
+		if got, err := ParseAuthorizedPrincipals(strings.NewReader("bob\n" + in + "\n")); err == nil {
+			t.Errorf("ParseAuthorizedPrincipals(%q) = %q, want an error", in, got)
+		} else if !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("ParseAuthorizedPrincipals(%q): got error %q, want one for line 2", in, err)
+		}
+	}
+}
+
+func TestMarshalAuthorizedPrincipalsInvalid(t *testing.T) {
+	for _, e := range []PrincipalEntry{
+		{},
+		{Principal: "alice\nroot"},
+		{Principal: "alice bob"},
+		{Principal: "#alice"},
+		{Principal: "alice", Options: []string{"no-pty\nroot"}},
+		{Principal: "alice", Options: []string{`command="ls # all"`}},
+		{Principal: "alice", Options: []string{"no-pty,restrict"}},
+		{Principal: "alice", Options: []string{"no-pty restrict"}},
+		{Principal: "alice", Options: []string{`command="ls`}},
+		{Principal: "alice", Options: []string{""}},
+	} {
+		if out, err := MarshalAuthorizedPrincipals([]PrincipalEntry{{Principal: "bob"}, e}); err == nil {
+			t.Errorf("MarshalAuthorizedPrincipals(%q) = %q, want an error", e, out)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// KexAlgorithms lists the algorithms that one side of a connection
+// supports, as sent in its SSH_MSG_KEXINIT message, in its order of
+// preference. See RFC 4253, section 7.1.
+type KexAlgorithms struct {
+	KeyExchanges            []string
+	HostKeys                []string
+	CiphersClientServer     []string
+	CiphersServerClient     []string
+	MACsClientServer        []string
+	MACsServerClient        []string
+	CompressionClientServer []string
+	CompressionServerClient []string
+}
+
+// ProbeResult describes a server, as learned by Probe.
+type ProbeResult struct {
+	// ServerVersion is the version string of the server, such as
+	// "SSH-2.0-OpenSSH_9.2".
+	ServerVersion string
+
+	// Server holds the algorithms supported by the server.
+	Server KexAlgorithms
+
+	// HostKey is the host key presented by the server, of the type
+	// given by HostKeyAlgorithm.
+	HostKey PublicKey
+
+	// These are the algorithms negotiated in the key exchange.
+	KeyExchange        string
+	HostKeyAlgorithm   string
+	CipherClientServer string
+	CipherServerClient string
+	MACClientServer    string
+	MACServerClient    string
+}
+
+// Probe runs the version exchange and the first key exchange with the
+// server at the other end of conn, then disconnects without
+// authenticating, and closes conn. It returns the host key of the
+// server, which is not verified, and the algorithms it supports.
+//
+// The key exchange is made with the algorithms of config, which may be
+// nil for the defaults. Its User, Auth and HostKeyCallback fields are
+// not used. A server only presents one of its host keys in a key
+// exchange; to learn the others, probe it again with each of the
+// remaining Server.HostKeys as HostKeyAlgorithms in config.
+//
+// If ctx is done before Probe completes, the connection is closed and
+// the error of ctx is returned.
+func Probe(ctx context.Context, conn net.Conn, addr string, config *ClientConfig) (*ProbeResult, error) {
+	defer conn.Close()
+	var fullConf ClientConfig
+	if config != nil {
+		fullConf = *config
+	}
+	fullConf.SetDefaults()
+
+	var hostKey PublicKey
+	fullConf.HostKeyCallback = func(hostname string, remote net.Addr, key PublicKey) error {
+		hostKey = key
+		return nil
+	}
+	fullConf.BannerCallback = nil
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	p, err := probe(conn, addr, &fullConf)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.HostKey = hostKey
+	return p, nil
+}
+
+func probe(conn net.Conn, addr string, config *ClientConfig) (*ProbeResult, error) {
+	clientVersion := []byte(packageVersion)
+	if config.ClientVersion != "" {
+		clientVersion = []byte(config.ClientVersion)
+	}
+	serverVersion, _, err := exchangeVersionsPreamble(conn, clientVersion, config.MaxBannerLines)
+	if err != nil {
+		return nil, err
+	}
+
+	t := newClientTransport(newTransport(conn, config.Rand, true /* is client */),
+		clientVersion, serverVersion, config, addr, conn.RemoteAddr())
+	defer t.Close()
+	if err := t.waitSession(); err != nil {
+		return nil, err
+	}
+
+	var serverInit kexInitMsg
+	if err := Unmarshal(t.serverKexInit, &serverInit); err != nil {
+		return nil, err
+	}
+	algs := t.algorithms
+	if algs == nil {
+		return nil, errors.New("ssh: key exchange did not complete")
+	}
+	t.writePacket(Marshal(&disconnectMsg{
+		Reason:  DisconnectByApplication,
+		Message: "probe finished",
+	}))
+
+	return &ProbeResult{
+		ServerVersion: string(serverVersion),
+		Server: KexAlgorithms{
+			KeyExchanges:            serverInit.KexAlgos,
+			HostKeys:                serverInit.ServerHostKeyAlgos,
+			CiphersClientServer:     serverInit.CiphersClientServer,
+			CiphersServerClient:     serverInit.CiphersServerClient,
+			MACsClientServer:        serverInit.MACsClientServer,
+			MACsServerClient:        serverInit.MACsServerClient,
+			CompressionClientServer: serverInit.CompressionClientServer,
+			CompressionServerClient: serverInit.CompressionServerClient,
+		},
+		KeyExchange:        algs.kex,
+		HostKeyAlgorithm:   algs.hostKey,
+		CipherClientServer: algs.w.Cipher,
+		CipherServerClient: algs.r.Cipher,
+		MACClientServer:    algs.w.MAC,
+		MACServerClient:    algs.r.MAC,
+	}, nil
+}
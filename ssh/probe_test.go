@@ -0,0 +1,89 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	serverConf := testServerConfig()
+	serverConf.AddHostKey(testSigners["ed25519"])
+	serverConf.ServerVersion = "SSH-2.0-ProbeTest"
+	serverErr := make(chan error, 1)
+	go func() {
+		_, _, _, err := NewServerConn(c2, serverConf)
+		serverErr <- err
+	}()
+
+	clientConf := &ClientConfig{
+		HostKeyAlgorithms: []string{KeyAlgoED25519},
+	}
+	clientConf.KeyExchanges = []string{kexAlgoCurve25519SHA256}
+	res, err := Probe(context.Background(), c1, "server", clientConf)
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if res.ServerVersion != serverConf.ServerVersion {
+		t.Errorf("ServerVersion: got %q, want %q", res.ServerVersion, serverConf.ServerVersion)
+	}
+	if want := testPublicKeys["ed25519"].Marshal(); res.HostKey == nil || !bytes.Equal(res.HostKey.Marshal(), want) {
+		t.Errorf("HostKey: got %v, want the ed25519 test key", res.HostKey)
+	}
+	if res.HostKeyAlgorithm != KeyAlgoED25519 {
+		t.Errorf("HostKeyAlgorithm: got %q, want %q", res.HostKeyAlgorithm, KeyAlgoED25519)
+	}
+	if res.KeyExchange != kexAlgoCurve25519SHA256 {
+		t.Errorf("KeyExchange: got %q, want %q", res.KeyExchange, kexAlgoCurve25519SHA256)
+	}
+	if res.CipherClientServer == "" || res.CipherServerClient == "" {
+		t.Errorf("got empty ciphers: %q, %q", res.CipherClientServer, res.CipherServerClient)
+	}
+	hostKeys := map[string]bool{}
+	for _, a := range res.Server.HostKeys {
+		hostKeys[a] = true
+	}
+	if !hostKeys[KeyAlgoED25519] || !hostKeys[KeyAlgoECDSA256] {
+		t.Errorf("Server.HostKeys: got %v, want %s and %s", res.Server.HostKeys, KeyAlgoED25519, KeyAlgoECDSA256)
+	}
+	if len(res.Server.KeyExchanges) == 0 || len(res.Server.CiphersClientServer) == 0 {
+		t.Errorf("got empty server algorithms: %+v", res.Server)
+	}
+
+	select {
+	case err := <-serverErr:
+		if err == nil {
+			t.Error("server handshake succeeded after Probe")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not see the disconnect")
+	}
+}
+
+func TestProbeContext(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	// The peer never answers.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := Probe(ctx, c1, "server", nil); err != context.DeadlineExceeded {
+		t.Errorf("Probe: got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrNotConnected is returned by the methods of a ReconnectingClient
+// while it has no connection.
+var ErrNotConnected = errors.New("ssh: not connected")
+
+// ConnState is the state of the connection of a ReconnectingClient.
+type ConnState int
+
+const (
+	// StateConnecting means that a connection is being established.
+	StateConnecting ConnState = iota
+
+	// StateConnected means that the client is connected and
+	// authenticated.
+	StateConnected
+
+	// StateDisconnected means that the connection failed or could
+	// not be established. A new attempt is made after a backoff.
+	StateDisconnected
+
+	// StateClosed means that the ReconnectingClient was closed.
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// A StateChange is sent by a ReconnectingClient when the state of its
+// connection changes.
+type StateChange struct {
+	State ConnState
+
+	// Err is the reason for StateDisconnected.
+	Err error
+}
+
+// Backoff configures the delays between the connection attempts of a
+// ReconnectingClient. The delay starts at Initial, and doubles after
+// each failed attempt, up to Max. It is reset once a connection is
+// established.
+type Backoff struct {
+	// Initial is the first delay. If zero, one second is used.
+	Initial time.Duration
+
+	// Max is the maximum delay. If zero, one minute is used.
+	Max time.Duration
+}
+
+// stateChangesSize is the buffer size of the channel returned by
+// ReconnectingClient.StateChanges.
+const stateChangesSize = 16
+
+// A ReconnectingClient maintains a client connection to a server. When
+// the connection fails, it reconnects, waiting between the attempts as
+// configured by a Backoff.
+//
+// Listeners and connections made through a ReconnectingClient belong
+// to the connection that was current when they were made, and fail
+// with it; they are not moved to the next connection.
+type ReconnectingClient struct {
+	dial    func(ctx context.Context) (net.Conn, error)
+	addr    string
+	config  *ClientConfig
+	backoff Backoff
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu      sync.Mutex
+	client  *Client
+	state   ConnState
+	changes chan StateChange
+}
+
+// NewReconnectingClient returns a ReconnectingClient that connects
+// with the connections returned by dial, and runs the handshake with
+// NewClientConn, using addr and config. A nil backoff uses the default
+// delays. The first connection attempt is made immediately, in the
+// background; use StateChanges or Client to wait for it to succeed.
+func NewReconnectingClient(dial func(ctx context.Context) (net.Conn, error), addr string, config *ClientConfig, backoff *Backoff) *ReconnectingClient {
+	rc := &ReconnectingClient{
+		dial:    dial,
+		addr:    addr,
+		config:  config,
+		done:    make(chan struct{}),
+		changes: make(chan StateChange, stateChangesSize),
+	}
+	if backoff != nil {
+		rc.backoff = *backoff
+	}
+	if rc.backoff.Initial <= 0 {
+		rc.backoff.Initial = time.Second
+	}
+	if rc.backoff.Max <= 0 {
+		rc.backoff.Max = time.Minute
+	}
+	if rc.backoff.Max < rc.backoff.Initial {
+		rc.backoff.Max = rc.backoff.Initial
+	}
+	rc.ctx, rc.cancel = context.WithCancel(context.Background())
+	go rc.run()
+	return rc
+}
+
+// StateChanges returns a channel that receives the state changes of
+// the connection, and is closed after StateClosed. If the channel is
+// not read and its buffer is full, changes are dropped; State always
+// returns the current state.
+func (rc *ReconnectingClient) StateChanges() <-chan StateChange {
+	return rc.changes
+}
+
+// State returns the current state of the connection.
+func (rc *ReconnectingClient) State() ConnState {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.state
+}
+
+// Client returns the client of the current connection, or
+// ErrNotConnected.
+func (rc *ReconnectingClient) Client() (*Client, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.client == nil {
+		return nil, ErrNotConnected
+	}
+	return rc.client, nil
+}
+
+// Dial calls Dial on the client of the current connection.
+func (rc *ReconnectingClient) Dial(n, addr string) (net.Conn, error) {
+	client, err := rc.Client()
+	if err != nil {
+		return nil, err
+	}
+	return client.Dial(n, addr)
+}
+
+// Listen calls Listen on the client of the current connection.
+func (rc *ReconnectingClient) Listen(n, addr string) (net.Listener, error) {
+	client, err := rc.Client()
+	if err != nil {
+		return nil, err
+	}
+	return client.Listen(n, addr)
+}
+
+// Close closes the current connection and stops reconnecting. It
+// returns once the StateChanges channel is closed.
+func (rc *ReconnectingClient) Close() error {
+	rc.cancel()
+	<-rc.done
+	return nil
+}
+
+func (rc *ReconnectingClient) run() {
+	defer func() {
+		rc.setState(StateClosed, nil, nil)
+		close(rc.changes)
+		close(rc.done)
+	}()
+
+	delay := rc.backoff.Initial
+	for {
+		rc.setState(StateConnecting, nil, nil)
+		client, err := rc.connect()
+		if err != nil {
+			if rc.ctx.Err() != nil {
+				return
+			}
+			rc.setState(StateDisconnected, nil, err)
+			t := time.NewTimer(delay)
+			select {
+			case <-t.C:
+			case <-rc.ctx.Done():
+				t.Stop()
+				return
+			}
+			if delay *= 2; delay > rc.backoff.Max {
+				delay = rc.backoff.Max
+			}
+			continue
+		}
+
+		delay = rc.backoff.Initial
+		rc.setState(StateConnected, client, nil)
+		waitErr := make(chan error, 1)
+		go func() { waitErr <- client.Wait() }()
+		select {
+		case err = <-waitErr:
+		case <-rc.ctx.Done():
+			client.Close()
+			<-waitErr
+			return
+		}
+		rc.setState(StateDisconnected, nil, err)
+	}
+}
+
+func (rc *ReconnectingClient) connect() (*Client, error) {
+	conn, err := rc.dial(rc.ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Abort the handshake if the client is closed meanwhile.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-rc.ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	c, chans, reqs, err := NewClientConn(conn, rc.addr, rc.config)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(c, chans, reqs), nil
+}
+
+func (rc *ReconnectingClient) setState(state ConnState, client *Client, err error) {
+	rc.mu.Lock()
+	rc.state = state
+	rc.client = client
+	rc.mu.Unlock()
+	select {
+	case rc.changes <- StateChange{state, err}:
+	default:
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// restartingServer is an echo server whose connections can be dropped,
+// and which can refuse new connections, as if it was restarting.
+type restartingServer struct {
+	t      *testing.T
+	config *ServerConfig
+
+	mu    sync.Mutex
+	down  bool
+	dials int
+	conns []net.Conn
+}
+
+func (s *restartingServer) dial(ctx context.Context) (net.Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dials++
+	if s.down {
+		return nil, errors.New("connection refused")
+	}
+	c1, c2, err := netPipe()
+	if err != nil {
+		return nil, err
+	}
+	s.conns = append(s.conns, c2)
+	go s.serve(c2)
+	return c1, nil
+}
+
+func (s *restartingServer) serve(c net.Conn) {
+	conn, chans, reqs, err := NewServerConn(c, s.config)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	go DiscardRequests(reqs)
+	for newCh := range chans {
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			s.t.Errorf("Accept: %v", err)
+			return
+		}
+		go DiscardRequests(chReqs)
+		go func() {
+			io.Copy(ch, ch)
+			ch.Close()
+		}()
+	}
+}
+
+// restart drops all connections and refuses new ones until up is
+// called.
+func (s *restartingServer) restart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = true
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *restartingServer) up() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = false
+}
+
+func waitState(t *testing.T, changes <-chan StateChange, want ConnState) StateChange {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case c, ok := <-changes:
+			if !ok {
+				t.Fatalf("state changes closed while waiting for %v", want)
+			}
+			if c.State == want {
+				return c
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %v", want)
+		}
+	}
+}
+
+func checkEcho(t *testing.T, rc *ReconnectingClient) {
+	t.Helper()
+	c, err := rc.Dial("tcp", "192.0.2.1:80")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("got %q, %v, want echo", buf, err)
+	}
+}
+
+func TestReconnectingClient(t *testing.T) {
+	serverConf := testServerConfig()
+	srv := &restartingServer{t: t, config: serverConf, down: true}
+	clientConf := testClientConfig()
+
+	rc := NewReconnectingClient(srv.dial, "server", clientConf, &Backoff{Initial: 10 * time.Millisecond, Max: 20 * time.Millisecond})
+	changes := rc.StateChanges()
+
+	// The server is down at first.
+	if c := waitState(t, changes, StateDisconnected); c.Err == nil {
+		t.Error("StateDisconnected without an error")
+	}
+	if _, err := rc.Dial("tcp", "192.0.2.1:80"); err != ErrNotConnected {
+		t.Errorf("Dial while disconnected: got %v, want %v", err, ErrNotConnected)
+	}
+	if _, err := rc.Listen("tcp", "0.0.0.0:0"); err != ErrNotConnected {
+		t.Errorf("Listen while disconnected: got %v, want %v", err, ErrNotConnected)
+	}
+	srv.up()
+	waitState(t, changes, StateConnected)
+	if rc.State() != StateConnected {
+		t.Errorf("State: got %v, want %v", rc.State(), StateConnected)
+	}
+	checkEcho(t, rc)
+
+	for i := 0; i < 2; i++ {
+		srv.restart()
+		waitState(t, changes, StateDisconnected)
+		srv.up()
+		waitState(t, changes, StateConnected)
+		checkEcho(t, rc)
+	}
+
+	srv.mu.Lock()
+	dials := srv.dials
+	srv.mu.Unlock()
+	if dials < 4 {
+		t.Errorf("got %d dials, want at least 4", dials)
+	}
+
+	rc.Close()
+	waitState(t, changes, StateClosed)
+	if _, ok := <-changes; ok {
+		t.Error("state changes not closed after Close")
+	}
+	if _, err := rc.Client(); err != ErrNotConnected {
+		t.Errorf("Client after Close: got %v, want %v", err, ErrNotConnected)
+	}
+}
+
+func TestReconnectingClientBackoff(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	dial := func(ctx context.Context) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		times = append(times, time.Now())
+		return nil, errors.New("connection refused")
+	}
+	rc := NewReconnectingClient(dial, "server", testClientConfig(), &Backoff{Initial: 20 * time.Millisecond, Max: 80 * time.Millisecond})
+	for {
+		mu.Lock()
+		n := len(times)
+		mu.Unlock()
+		if n >= 6 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rc.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The delays are 20, 40, 80, 80 and 80ms.
+	for i, want := range []time.Duration{20, 40, 80, 80, 80} {
+		want *= time.Millisecond
+		if got := times[i+1].Sub(times[i]); got < want {
+			t.Errorf("delay %d: got %v, want at least %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// The legacy SCP protocol runs "scp -t" (to) on the remote host to
+// receive files, or "scp -f" (from) to send them, and exchanges
+// control lines and file contents with it over standard input and
+// output. Each file is announced by a line "C<mode> <size> <name>\n",
+// with the mode in octal, and its contents are followed by a zero
+// byte. The receiving side acknowledges each step with a zero byte, or
+// with a byte 1 (warning) or 2 (fatal error) followed by a message
+// line.
+
+// SCPSend copies size bytes read from r to the file remotePath on the
+// remote host, with the permission bits of mode, using the legacy SCP
+// protocol. It runs "scp -t remotePath" in session, which must not
+// have been started, and waits for it to exit. If remotePath is a
+// directory on the remote host, the file is created in it with the
+// name path.Base(remotePath).
+func SCPSend(session *Session, remotePath string, r io.Reader, size int64, mode os.FileMode) error {
+	name := path.Base(remotePath)
+	if strings.ContainsAny(name, "\n") {
+		return errors.New("ssh: scp: file name contains a newline")
+	}
+	stdin, stdout, err := startSCP(session, "-t", remotePath)
+	if err != nil {
+		return err
+	}
+	err = scpSend(stdin, stdout, name, r, size, mode)
+	return finishSCP(session, stdin, err)
+}
+
+// SCPReceive copies the file remotePath of the remote host to w, using
+// the legacy SCP protocol, and returns its size and permission bits.
+// It runs "scp -f remotePath" in session, which must not have been
+// started, and waits for it to exit.
+func SCPReceive(session *Session, remotePath string, w io.Writer) (size int64, mode os.FileMode, err error) {
+	stdin, stdout, err := startSCP(session, "-f", remotePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, mode, err = scpReceive(stdin, stdout, w)
+	return size, mode, finishSCP(session, stdin, err)
+}
+
+func startSCP(session *Session, flag, remotePath string) (io.WriteCloser, *bufio.Reader, error) {
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := session.Start("scp " + flag + " " + shellQuote(remotePath)); err != nil {
+		return nil, nil, err
+	}
+	return stdin, bufio.NewReader(stdout), nil
+}
+
+// finishSCP ends the input of the remote scp and waits for it to exit.
+// It returns err, the outcome of the protocol, if it is not nil, since
+// the exit status of scp is then less precise.
+func finishSCP(session *Session, stdin io.Closer, err error) error {
+	stdin.Close()
+	if waitErr := session.Wait(); err == nil {
+		err = waitErr
+	}
+	return err
+}
+
+// shellQuote quotes s for a POSIX shell, which is how the remote host
+// usually runs commands.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// scpReadAck reads an acknowledgement of the remote scp.
+func scpReadAck(in *bufio.Reader) error {
+	b, err := in.ReadByte()
+	if err == io.EOF {
+		return errors.New("ssh: scp: unexpected end of protocol")
+	}
+	if err != nil {
+		return err
+	}
+	switch b {
+	case 0:
+		return nil
+	case 1, 2:
+		return scpRemoteError(in)
+	default:
+		return fmt.Errorf("ssh: scp: unexpected response byte %d", b)
+	}
+}
+
+// scpRemoteError reads the message line following an error byte.
+func scpRemoteError(in *bufio.Reader) error {
+	msg, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return errors.New("ssh: scp failed: " + strings.TrimSuffix(msg, "\n"))
+}
+
+func scpSend(out io.Writer, in *bufio.Reader, name string, r io.Reader, size int64, mode os.FileMode) error {
+	if err := scpReadAck(in); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(out, "C%04o %d %s\n", mode.Perm(), size, name); err != nil {
+		return err
+	}
+	if err := scpReadAck(in); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(out, r, size); err != nil {
+		return err
+	}
+	if _, err := out.Write([]byte{0}); err != nil {
+		return err
+	}
+	return scpReadAck(in)
+}
+
+func scpReceive(out io.Writer, in *bufio.Reader, w io.Writer) (int64, os.FileMode, error) {
+	ack := []byte{0}
+	if _, err := out.Write(ack); err != nil {
+		return 0, 0, err
+	}
+	for {
+		b, err := in.ReadByte()
+		if err == io.EOF {
+			return 0, 0, errors.New("ssh: scp: unexpected end of protocol")
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		switch b {
+		case 1, 2:
+			return 0, 0, scpRemoteError(in)
+		case 'T':
+			// The times of the file, sent with scp -p. They
+			// are skipped.
+			if _, err := in.ReadString('\n'); err != nil {
+				return 0, 0, err
+			}
+			if _, err := out.Write(ack); err != nil {
+				return 0, 0, err
+			}
+			continue
+		case 'C':
+		default:
+			return 0, 0, fmt.Errorf("ssh: scp: unexpected control line type %q", b)
+		}
+
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return 0, 0, err
+		}
+		fields := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 3)
+		if len(fields) != 3 {
+			return 0, 0, fmt.Errorf("ssh: scp: malformed control line %q", line)
+		}
+		perm, err := strconv.ParseUint(fields[0], 8, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("ssh: scp: malformed mode in control line %q", line)
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size < 0 {
+			return 0, 0, fmt.Errorf("ssh: scp: malformed size in control line %q", line)
+		}
+		if _, err := out.Write(ack); err != nil {
+			return 0, 0, err
+		}
+		if _, err := io.CopyN(w, in, size); err != nil {
+			if err == io.EOF {
+				err = errors.New("ssh: scp: file is truncated")
+			}
+			return 0, 0, err
+		}
+		if err := scpReadAck(in); err != nil {
+			return 0, 0, err
+		}
+		if _, err := out.Write(ack); err != nil {
+			return 0, 0, err
+		}
+		return size, os.FileMode(perm) & os.ModePerm, nil
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// scpHandler returns a session handler that runs serve as the scp
+// command wantCmd, and exits with its status.
+func scpHandler(wantCmd string, serve func(rw *bufio.ReadWriter) uint32) serverType {
+	return func(ch Channel, in <-chan *Request, t *testing.T) {
+		defer ch.Close()
+		req, ok := <-in
+		if !ok {
+			return
+		}
+		var msg execMsg
+		if err := Unmarshal(req.Payload, &msg); req.Type != "exec" || err != nil || msg.Command != wantCmd {
+			t.Errorf("got request %q %q, want exec of %q", req.Type, req.Payload, wantCmd)
+			req.Reply(false, nil)
+			return
+		}
+		req.Reply(true, nil)
+		go DiscardRequests(in)
+
+		rw := bufio.NewReadWriter(bufio.NewReader(ch), bufio.NewWriter(ch))
+		status := serve(rw)
+		rw.Flush()
+		sendStatus(status, ch, t)
+	}
+}
+
+func TestSCPSend(t *testing.T) {
+	type received struct {
+		header   string
+		contents []byte
+		end      byte
+	}
+	done := make(chan received, 1)
+	conn := dial(scpHandler(`scp -t '/tmp/it'\''s'`, func(rw *bufio.ReadWriter) uint32 {
+		var r received
+		defer func() { done <- r }()
+		rw.WriteByte(0)
+		rw.Flush()
+		var err error
+		if r.header, err = rw.ReadString('\n'); err != nil {
+			return 1
+		}
+		rw.WriteByte(0)
+		rw.Flush()
+		r.contents = make([]byte, 11)
+		if _, err := io.ReadFull(rw, r.contents); err != nil {
+			return 1
+		}
+		r.end, _ = rw.ReadByte()
+		rw.WriteByte(0)
+		return 0
+	}), t)
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := SCPSend(session, "/tmp/it's", strings.NewReader("hello world and more"), 11, 0640|os.ModeSetuid); err != nil {
+		t.Fatalf("SCPSend: %v", err)
+	}
+	r := <-done
+	if want := "C0640 11 it's\n"; r.header != want {
+		t.Errorf("got control line %q, want %q", r.header, want)
+	}
+	if string(r.contents) != "hello world" || r.end != 0 {
+		t.Errorf("got contents %q followed by %d, want %q followed by 0", r.contents, r.end, "hello world")
+	}
+}
+
+func TestSCPSendError(t *testing.T) {
+	conn := dial(scpHandler(`scp -t '/nonexistent/file'`, func(rw *bufio.ReadWriter) uint32 {
+		rw.WriteString("\x01scp: /nonexistent/file: No such file or directory\n")
+		return 1
+	}), t)
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	err = SCPSend(session, "/nonexistent/file", strings.NewReader("x"), 1, 0644)
+	if err == nil || !strings.Contains(err.Error(), "No such file or directory") {
+		t.Errorf("SCPSend: got error %v, want the message of the remote scp", err)
+	}
+}
+
+func TestSCPReceive(t *testing.T) {
+	ack := func(rw *bufio.ReadWriter) bool {
+		b, err := rw.ReadByte()
+		return err == nil && b == 0
+	}
+	conn := dial(scpHandler(`scp -f '/etc/motd'`, func(rw *bufio.ReadWriter) uint32 {
+		if !ack(rw) {
+			return 1
+		}
+		rw.WriteString("T1234567890 0 1234567890 0\n")
+		rw.Flush()
+		if !ack(rw) {
+			return 1
+		}
+		rw.WriteString("C0604 13 motd\n")
+		rw.Flush()
+		if !ack(rw) {
+			return 1
+		}
+		rw.WriteString("Hello, world!\x00")
+		rw.Flush()
+		if !ack(rw) {
+			return 1
+		}
+		return 0
+	}), t)
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	var buf bytes.Buffer
+	size, mode, err := SCPReceive(session, "/etc/motd", &buf)
+	if err != nil {
+		t.Fatalf("SCPReceive: %v", err)
+	}
+	if size != 13 || mode != 0604 || buf.String() != "Hello, world!" {
+		t.Errorf("got %d bytes %q with mode %v, want 13 bytes %q with mode 0604", size, buf.String(), mode, "Hello, world!")
+	}
+}
+
+func TestSCPReceiveError(t *testing.T) {
+	for _, tt := range []struct {
+		name, reply, want string
+	}{
+		{"remote error", "\x01scp: /etc/shadow: Permission denied\n", "Permission denied"},
+		{"directory", "D0755 0 etc\n", "unexpected control line"},
+		{"bad size", "C0644 -1 shadow\n", "malformed size"},
+		{"truncated", "C0644 100 shadow\n0123456789", "truncated"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dial(scpHandler(`scp -f '/etc/shadow'`, func(rw *bufio.ReadWriter) uint32 {
+				rw.ReadByte()
+				rw.WriteString(tt.reply)
+				rw.Flush()
+				// Wait for the acknowledgement of the control line, if any.
+				rw.ReadByte()
+				return 1
+			}), t)
+			defer conn.Close()
+
+			session, err := conn.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+			_, _, err = SCPReceive(session, "/etc/shadow", ioutil.Discard)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("SCPReceive: got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
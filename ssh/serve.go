@@ -0,0 +1,234 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by Server.Serve after a call to Shutdown
+// or Close.
+var ErrServerClosed = errors.New("ssh: Server closed")
+
+// A Server accepts connections from listeners, runs the SSH handshake
+// for each of them with NewServerConn, and passes the established
+// connections to a Handler. Unlike a hand written accept loop, it can
+// be shut down gracefully.
+type Server struct {
+	// Config is the configuration used for the handshake of every
+	// connection. It must be set.
+	Config *ServerConfig
+
+	// Handler is called in its own goroutine for every connection
+	// that completed the handshake. The connection is closed when
+	// Handler returns. It must service chans and reqs, as for
+	// NewServerConn.
+	Handler func(conn *ServerConn, chans <-chan NewChannel, reqs <-chan *Request)
+
+	// HandshakeErrorCallback, if non-nil, is called with the errors
+	// of connections that failed the handshake.
+	HandshakeErrorCallback func(conn net.Conn, err error)
+
+	mu           sync.Mutex
+	listeners    map[net.Listener]struct{}
+	conns        map[net.Conn]*ServerConn // nil during the handshake
+	shuttingDown bool
+	connsDone    chan struct{} // closed when conns becomes empty while shutting down
+}
+
+// Serve accepts connections on l and serves them until l fails, or the
+// Server is shut down. Temporary errors of Accept are retried after a
+// delay growing from 5ms to 1s. Serve always returns a non-nil error;
+// after Shutdown or Close, the error is ErrServerClosed.
+func (srv *Server) Serve(l net.Listener) error {
+	if !srv.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer srv.trackListener(l, false)
+
+	var tempDelay time.Duration // how long to sleep on accept failure
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if srv.isShuttingDown() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+		if !srv.trackConn(c, nil, true) {
+			c.Close()
+			return ErrServerClosed
+		}
+		go srv.serveConn(c)
+	}
+}
+
+func (srv *Server) serveConn(c net.Conn) {
+	defer srv.trackConn(c, nil, false)
+
+	conn, chans, reqs, err := NewServerConn(c, srv.Config)
+	if err != nil {
+		if srv.HandshakeErrorCallback != nil {
+			srv.HandshakeErrorCallback(c, err)
+		}
+		return
+	}
+	defer conn.Close()
+	srv.trackConn(c, conn, true)
+	srv.Handler(conn, chans, reqs)
+}
+
+// Shutdown gracefully shuts down the server. It closes all listeners,
+// so no new connections are accepted, and then sends a disconnect
+// message to and closes every connection that has no open channels.
+// Connections still in the handshake are closed. Connections with open
+// channels are allowed to finish; they are
+// disconnected once their channels are closed. Shutdown returns when
+// all connections are gone, or with the error of ctx if it is done
+// first, in which case the remaining connections are left open.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mu.Lock()
+	srv.shuttingDown = true
+	srv.closeListenersLocked()
+	if srv.connsDone == nil {
+		srv.connsDone = make(chan struct{})
+		if len(srv.conns) == 0 {
+			close(srv.connsDone)
+		}
+	}
+	done := srv.connsDone
+	srv.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		srv.closeIdleConns()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// shutdownPollInterval is how often Shutdown looks for connections
+// that became idle.
+const shutdownPollInterval = 100 * time.Millisecond
+
+// Close immediately closes all listeners and connections of the
+// server. For a graceful shutdown, use Shutdown.
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.shuttingDown = true
+	srv.closeListenersLocked()
+	for c := range srv.conns {
+		c.Close()
+	}
+	return nil
+}
+
+func (srv *Server) closeListenersLocked() {
+	for l := range srv.listeners {
+		l.Close()
+	}
+}
+
+// closeIdleConns closes the connections in the handshake and
+// disconnects the established ones without open channels.
+func (srv *Server) closeIdleConns() {
+	srv.mu.Lock()
+	var idle []*ServerConn
+	for c, conn := range srv.conns {
+		if conn == nil {
+			c.Close()
+			continue
+		}
+		if lister, ok := conn.Conn.(ChannelLister); ok && len(lister.Channels()) > 0 {
+			continue
+		}
+		idle = append(idle, conn)
+	}
+	srv.mu.Unlock()
+
+	for _, conn := range idle {
+		if c, ok := conn.Conn.(*connection); ok {
+			c.disconnect(DisconnectByApplication, "server shutting down")
+			continue
+		}
+		conn.Close()
+	}
+}
+
+func (srv *Server) isShuttingDown() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.shuttingDown
+}
+
+// trackListener adds or removes l. It reports false if l can't be
+// added because the server is shutting down.
+func (srv *Server) trackListener(l net.Listener, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !add {
+		delete(srv.listeners, l)
+		return true
+	}
+	if srv.shuttingDown {
+		return false
+	}
+	if srv.listeners == nil {
+		srv.listeners = make(map[net.Listener]struct{})
+	}
+	srv.listeners[l] = struct{}{}
+	return true
+}
+
+// trackConn adds, updates or removes c, which is still in the handshake
+// if conn is nil. It reports false if a new connection can't be added
+// because the server is shutting down.
+func (srv *Server) trackConn(c net.Conn, conn *ServerConn, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !add {
+		delete(srv.conns, c)
+		if srv.connsDone != nil && len(srv.conns) == 0 {
+			select {
+			case <-srv.connsDone:
+			default:
+				close(srv.connsDone)
+			}
+		}
+		return true
+	}
+	if srv.shuttingDown && conn == nil {
+		return false
+	}
+	if srv.conns == nil {
+		srv.conns = make(map[net.Conn]*ServerConn)
+	}
+	srv.conns[c] = conn
+	return true
+}
@@ -0,0 +1,234 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	release := make(chan struct{})
+	config := testServerConfig()
+	srv := &Server{
+		Config: config,
+		Handler: func(conn *ServerConn, chans <-chan NewChannel, reqs <-chan *Request) {
+			go DiscardRequests(reqs)
+			for newCh := range chans {
+				ch, chReqs, err := newCh.Accept()
+				if err != nil {
+					t.Errorf("Accept: %v", err)
+					return
+				}
+				go DiscardRequests(chReqs)
+				go func() {
+					<-release
+					ch.Write([]byte("done"))
+					ch.Close()
+				}()
+			}
+		},
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(l) }()
+
+	clientConfig := testClientConfig()
+	active, err := Dial("tcp", l.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer active.Close()
+	idle, err := Dial("tcp", l.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer idle.Close()
+
+	ch, chReqs, err := active.OpenChannel("session", nil)
+	if err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+	go DiscardRequests(chReqs)
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- srv.Shutdown(context.Background()) }()
+
+	if err := <-serveErr; err != ErrServerClosed {
+		t.Errorf("Serve returned %v, want ErrServerClosed", err)
+	}
+	if c, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		c.Close()
+		t.Error("server accepted a connection while shutting down")
+	}
+
+	// The idle connection is disconnected.
+	idleErr := make(chan error, 1)
+	go func() { idleErr <- idle.Wait() }()
+	select {
+	case err := <-idleErr:
+		if _, ok := err.(*disconnectMsg); !ok {
+			t.Errorf("idle connection ended with %v, want a disconnect", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle connection was not disconnected")
+	}
+
+	// The active one is not, until its channel is done.
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown returned %v with an active session", err)
+	case <-time.After(3 * shutdownPollInterval):
+	}
+	close(release)
+	data, err := ioutil.ReadAll(ch)
+	if err != nil || string(data) != "done" {
+		t.Errorf("active session read %q, %v, want %q", data, err, "done")
+	}
+	select {
+	case err := <-shutdownErr:
+		if err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return after the session finished")
+	}
+}
+
+func TestServerShutdownContext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	config := testServerConfig()
+	srv := &Server{
+		Config: config,
+		Handler: func(conn *ServerConn, chans <-chan NewChannel, reqs <-chan *Request) {
+			go DiscardRequests(reqs)
+			for newCh := range chans {
+				ch, chReqs, _ := newCh.Accept()
+				go DiscardRequests(chReqs)
+				defer ch.Close()
+			}
+		},
+	}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	client, err := Dial("tcp", l.Addr().String(), testClientConfig())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	if _, _, err := client.OpenChannel("session", nil); err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*shutdownPollInterval)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestServerShutdownHandshake(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	config := testServerConfig()
+	srv := &Server{
+		Config: config,
+		Handler: func(conn *ServerConn, chans <-chan NewChannel, reqs <-chan *Request) {
+			t.Error("Handler called for a connection that did not complete the handshake")
+		},
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(l) }()
+
+	// A peer that never starts the handshake.
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	if _, err := readVersion(c); err != nil {
+		t.Fatalf("readVersion: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if err := <-serveErr; err != ErrServerClosed {
+		t.Errorf("Serve returned %v, want ErrServerClosed", err)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ioutil.ReadAll(c); err != nil {
+		t.Errorf("connection in the handshake was not closed: %v", err)
+	}
+}
+
+// temporaryError is a net.Error that reports itself as temporary.
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary accept error" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyListener fails the first failures calls of Accept with a
+// temporary error.
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, temporaryError{}
+	}
+	return l.Listener.Accept()
+}
+
+func TestServerTemporaryAcceptError(t *testing.T) {
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	l := &flakyListener{Listener: tl, failures: 3}
+	config := testServerConfig()
+	srv := &Server{
+		Config: config,
+		Handler: func(conn *ServerConn, chans <-chan NewChannel, reqs <-chan *Request) {
+			go DiscardRequests(reqs)
+			for newCh := range chans {
+				newCh.Reject(Prohibited, "")
+			}
+		},
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(l) }()
+	defer srv.Close()
+
+	client, err := Dial("tcp", tl.Addr().String(), testClientConfig())
+	if err != nil {
+		t.Fatalf("Dial after temporary accept errors: %v", err)
+	}
+	client.Close()
+	select {
+	case err := <-serveErr:
+		t.Fatalf("Serve returned %v after a temporary error", err)
+	default:
+	}
+}
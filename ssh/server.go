@@ -11,6 +11,7 @@ import (
 	"io"
 	"net"
 	"strings"
+	"time"
 )
 
 // The Permissions type holds fine-grained permissions that are
@@ -45,6 +46,37 @@ type Permissions struct {
 	Extensions map[string]string
 }
 
+// MergePermissions combines permissions computed from several sources,
+// such as the options of a user certificate and the policy of the
+// server. The result has the critical options and extensions of both
+// base and override, either of which may be nil. If both have an
+// extension, the value of override wins. If both have a critical
+// option with different values, MergePermissions returns an error,
+// since critical options restrict what the user may do and neither
+// restriction can be dropped silently. The result does not share its
+// maps with base or override.
+func MergePermissions(base, override *Permissions) (*Permissions, error) {
+	perms := &Permissions{
+		CriticalOptions: make(map[string]string),
+		Extensions:      make(map[string]string),
+	}
+	for _, p := range []*Permissions{base, override} {
+		if p == nil {
+			continue
+		}
+		for k, v := range p.CriticalOptions {
+			if old, ok := perms.CriticalOptions[k]; ok && old != v {
+				return nil, fmt.Errorf("ssh: conflicting values %q and %q for critical option %q", old, v, k)
+			}
+			perms.CriticalOptions[k] = v
+		}
+		for k, v := range p.Extensions {
+			perms.Extensions[k] = v
+		}
+	}
+	return perms, nil
+}
+
 type GSSAPIWithMICConfig struct {
 	// AllowLogin, must be set, is called when gssapi-with-mic
 	// authentication is selected (RFC 4462 section 3). The srcName is from the
@@ -90,6 +122,28 @@ type ServerConfig struct {
 	// Permissions.Extensions entry.
 	PublicKeyCallback func(conn ConnMetadata, key PublicKey) (*Permissions, error)
 
+	// PublicKeyAlgorithmCallback, if non-nil, is called instead of
+	// PublicKeyCallback when a client offers a public key for
+	// authentication. In addition to the key, it receives the
+	// signature algorithm the client uses with it, such as
+	// SigAlgoRSASHA2512 or KeyAlgoED25519. This makes it possible to
+	// refuse, for example, SHA-1 based "ssh-rsa" signatures. The
+	// server verifies that the signature eventually sent by the
+	// client uses this algorithm, except that RSA certificates may
+	// also be signed with SHA-2, as older OpenSSH clients do
+	// while offering "ssh-rsa". As conn holds the user name, it
+	// can also accept "ssh-rsa" only for some users, such as
+	// accounts of legacy devices.
+	PublicKeyAlgorithmCallback func(conn ConnMetadata, key PublicKey, algorithm string) (*Permissions, error)
+
+	// MinRSAKeySize is the minimum size in bits of the RSA keys
+	// accepted for public key authentication, like the
+	// RequiredRSASize option of OpenSSH. Smaller keys, and
+	// certificates of smaller keys, are rejected before any of
+	// the public key callbacks is called. If zero, 2048 is used; a
+	// negative value accepts RSA keys of any size.
+	MinRSAKeySize int
+
 	// KeyboardInteractiveCallback, if non-nil, is called when
 	// keyboard-interactive authentication is selected (RFC
 	// 4256). The client object's Challenge function should be
@@ -110,13 +164,90 @@ type ServerConfig struct {
 	// "SSH-2.0-".
 	ServerVersion string
 
+	// ClientVersionCallback, if non-nil, is called with the version
+	// identification string of the client, such as
+	// "SSH-2.0-OpenSSH_8.4", without the line ending, as soon as it
+	// is received and before the key exchange. If it returns an
+	// error, the server sends a disconnect message with the text of
+	// the error, closes the connection and returns the error from
+	// NewServerConn.
+	ClientVersionCallback func(version []byte) error
+
 	// BannerCallback, if present, is called and the return string is sent to
 	// the client after key exchange completed but before authentication.
 	BannerCallback func(conn ConnMetadata) string
 
+	// DisconnectCallback, if non-nil, is called before the server
+	// closes a connection whose authentication failed, such as when
+	// MaxAuthTries is exceeded, with the reason and message that
+	// would be sent to the client. The returned reason and message
+	// are sent instead. This makes it possible to send a legal
+	// notice, or a generic message that does not reveal why
+	// authentication failed.
+	DisconnectCallback func(conn ConnMetadata, reason DisconnectReason, message string) (DisconnectReason, string)
+
 	// GSSAPIWithMICConfig includes gssapi server and callback, which if both non-nil, is used
 	// when gssapi-with-mic authentication is selected (RFC 4462 section 3).
 	GSSAPIWithMICConfig *GSSAPIWithMICConfig
+
+	// HandshakeTimeout is the maximum amount of time for the version
+	// exchange, key exchange and authentication to complete once
+	// NewServerConn is called. If it is exceeded, the connection is
+	// closed and an error whose Timeout method returns true is
+	// returned. It does not apply to the established connection.
+	//
+	// A HandshakeTimeout of zero means no timeout.
+	HandshakeTimeout time.Duration
+
+	// OnChannelOpen, if non-nil, is called for every channel the
+	// client opens, before the channel is passed to the application
+	// on the NewChannel channel returned by NewServerConn. If it
+	// returns an error, the channel is rejected with the Prohibited
+	// reason and the text of the error, and never reaches the
+	// application. This makes it possible to implement rate limits,
+	// auditing or access control for all channels in one place.
+	// OnChannelOpen must not call Accept or Reject on newChan, and
+	// it should return quickly, as no messages of the connection are
+	// processed while it runs.
+	OnChannelOpen func(conn ConnMetadata, newChan NewChannel) error
+
+	// AcceptEnv, if non-nil, makes the server handle the "env"
+	// requests of session channels itself, like the AcceptEnv option
+	// of OpenSSH: the variables for which AcceptEnv returns true are
+	// kept, and can be retrieved with the Env method of EnvChannel;
+	// the others are dropped. The requests are then not passed to
+	// the application.
+	AcceptEnv func(name, value string) bool
+
+	// RejectAgentForwarding, if true, guarantees that the
+	// connection never forwards the agent, whatever the code using
+	// it does: the "auth-agent-req@openssh.com" requests of the
+	// client are refused without reaching the application, and
+	// "auth-agent@openssh.com" channels can be neither opened nor
+	// accepted.
+	RejectAgentForwarding bool
+
+	// HostKeySet, if non-nil, holds the host keys, which are then
+	// not added with AddHostKey. Unlike those of AddHostKey, they
+	// can be changed while the server runs, and they are advertised
+	// to the clients, see HostKeySet.
+	HostKeySet *HostKeySet
+
+	// ExtInfo holds extensions of RFC 8308, by name, that are
+	// advertised with their values in the extension info message
+	// sent to the clients that ask for one, in addition to the
+	// server-sig-algs extension of this package. An entry for
+	// server-sig-algs is ignored. As this package does not
+	// implement extensions that change the protocol, only names of
+	// the form name@domain are allowed, except for those of such
+	// extensions of OpenSSH, like ping@openssh.com.
+	ExtInfo map[string][]byte
+
+	// SignatureAlgorithms holds signature algorithms, by name, that
+	// are accepted for public key authentication in addition to those
+	// of this package, and advertised in server-sig-algs. The names
+	// must not be those of algorithms of this package.
+	SignatureAlgorithms map[string]SignatureAlgorithm
 }
 
 // AddHostKey adds a private key as a host key. If an existing host
@@ -137,6 +268,7 @@ func (s *ServerConfig) AddHostKey(key Signer) {
 // acceptable for a user.
 type cachedPubKey struct {
 	user       string
+	algo       string
 	pubKeyData []byte
 	result     error
 	perms      *Permissions
@@ -153,9 +285,9 @@ type pubKeyCache struct {
 }
 
 // get returns the result for a given user/algo/key tuple.
-func (c *pubKeyCache) get(user string, pubKeyData []byte) (cachedPubKey, bool) {
+func (c *pubKeyCache) get(user, algo string, pubKeyData []byte) (cachedPubKey, bool) {
 	for _, k := range c.keys {
-		if k.user == user && bytes.Equal(k.pubKeyData, pubKeyData) {
+		if k.user == user && k.algo == algo && bytes.Equal(k.pubKeyData, pubKeyData) {
 			return k, true
 		}
 	}
@@ -199,11 +331,21 @@ func NewServerConn(c net.Conn, config *ServerConfig) (*ServerConn, <-chan NewCha
 			return nil, nil, nil, fmt.Errorf("ssh: unsupported key exchange %s for server", kex)
 		}
 	}
+	if err := checkSignatureAlgorithms(fullConf.SignatureAlgorithms); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkExtInfo(fullConf.ExtInfo); err != nil {
+		return nil, nil, nil, err
+	}
 
 	s := &connection{
 		sshConn: sshConn{conn: c},
 	}
+	timedOut := startHandshakeTimer(c, fullConf.HandshakeTimeout)
 	perms, err := s.serverHandshake(&fullConf)
+	if timedOut() {
+		err = errHandshakeTimeout
+	}
 	if err != nil {
 		c.Close()
 		return nil, nil, nil, err
@@ -224,12 +366,18 @@ func signAndMarshal(k Signer, rand io.Reader, data []byte) ([]byte, error) {
 
 // handshake performs key exchange and user authentication.
 func (s *connection) serverHandshake(config *ServerConfig) (*Permissions, error) {
+	var advertised []Signer
+	if config.HostKeySet != nil {
+		// config is the copy made by NewServerConn.
+		advertised = config.HostKeySet.Keys()
+		config.hostKeys = kexHostKeys(advertised)
+	}
 	if len(config.hostKeys) == 0 {
 		return nil, errors.New("ssh: server has no host keys")
 	}
 
 	if !config.NoClientAuth && config.PasswordCallback == nil && config.PublicKeyCallback == nil &&
-		config.KeyboardInteractiveCallback == nil && (config.GSSAPIWithMICConfig == nil ||
+		config.PublicKeyAlgorithmCallback == nil && config.KeyboardInteractiveCallback == nil && (config.GSSAPIWithMICConfig == nil ||
 		config.GSSAPIWithMICConfig.AllowLogin == nil || config.GSSAPIWithMICConfig.Server == nil) {
 		return nil, errors.New("ssh: no authentication methods configured but NoClientAuth is also false")
 	}
@@ -246,6 +394,16 @@ func (s *connection) serverHandshake(config *ServerConfig) (*Permissions, error)
 	}
 
 	tr := newTransport(s.sshConn.conn, config.Rand, false /* not client */)
+	if config.ClientVersionCallback != nil {
+		if err := config.ClientVersionCallback(dup(s.clientVersion)); err != nil {
+			tr.writePacket(Marshal(&disconnectMsg{
+				Reason:  DisconnectProtocolVersionNotSupported,
+				Message: err.Error(),
+			}))
+			return nil, err
+		}
+	}
+	s.traffic = tr.traffic
 	s.transport = newServerTransport(tr, s.clientVersion, s.serverVersion, config)
 
 	if err := s.transport.waitSession(); err != nil {
@@ -278,17 +436,76 @@ func (s *connection) serverHandshake(config *ServerConfig) (*Permissions, error)
 	if err != nil {
 		return nil, err
 	}
-	s.mux = newMux(s.transport)
+	var openFilter func(NewChannel) error
+	if config.OnChannelOpen != nil {
+		openFilter = func(newChan NewChannel) error {
+			return config.OnChannelOpen(s, newChan)
+		}
+	}
+	var proveHostKeys func([]byte) ([]byte, error)
+	if advertised != nil {
+		prover := &hostKeysProver{
+			keys:      advertised,
+			sessionID: s.sessionID,
+			kexAlgo:   s.transport.getAlgorithms().hostKey,
+			rand:      config.Rand,
+		}
+		proveHostKeys = prover.prove
+	}
+	s.mux = newMuxWithOptions(s.transport, muxOptions{
+		openFilter:               openFilter,
+		maxPendingGlobalRequests: config.MaxPendingGlobalRequests,
+		acceptEnv:                config.AcceptEnv,
+		noAgentForwarding:        config.RejectAgentForwarding,
+		proveHostKeys:            proveHostKeys,
+	})
+	s.limitLifetime(config.MaxLifetime)
+	if advertised != nil {
+		if _, _, err := s.mux.SendRequest(hostKeysRequestType, false, hostKeysAdvertisement(advertised)); err != nil {
+			return nil, err
+		}
+	}
 	return perms, err
 }
 
-func isAcceptableAlgo(algo string) bool {
+// isAcceptableAlgo reports whether algo is a public key algorithm of
+// this package or a signature algorithm of algos.
+func isAcceptableAlgo(algos map[string]SignatureAlgorithm, algo string) bool {
 	switch algo {
-	case KeyAlgoRSA, KeyAlgoDSA, KeyAlgoECDSA256, KeyAlgoECDSA384, KeyAlgoECDSA521, KeyAlgoSKECDSA256, KeyAlgoED25519, KeyAlgoSKED25519,
+	case KeyAlgoRSA, SigAlgoRSASHA2256, SigAlgoRSASHA2512, KeyAlgoDSA, KeyAlgoECDSA256, KeyAlgoECDSA384, KeyAlgoECDSA521, KeyAlgoSKECDSA256, KeyAlgoED25519, KeyAlgoSKED25519,
 		CertAlgoRSAv01, CertAlgoDSAv01, CertAlgoECDSA256v01, CertAlgoECDSA384v01, CertAlgoECDSA521v01, CertAlgoSKECDSA256v01, CertAlgoED25519v01, CertAlgoSKED25519v01:
 		return true
 	}
-	return false
+	_, ok := builtinSignatureAlgorithms[algo]
+	if !ok {
+		_, ok = algos[algo]
+	}
+	return ok
+}
+
+// isAlgoCompatible reports whether a signature of the sigAlgo
+// algorithm may authenticate a "publickey" request for the algo
+// algorithm, whose own signatures are of the want algorithm. OpenSSH
+// 7.2 to 7.7 name ssh-rsa-cert-v01@openssh.com in their requests, but
+// sign with rsa-sha2-256 or rsa-sha2-512.
+func isAlgoCompatible(algo, want, sigAlgo string) bool {
+	if algo == CertAlgoRSAv01 && (sigAlgo == SigAlgoRSASHA2256 || sigAlgo == SigAlgoRSASHA2512) {
+		return true
+	}
+	return sigAlgo == want
+}
+
+// algoMatchesKey reports whether the public key algorithm named in a
+// "publickey" authentication request can be used with key.
+func algoMatchesKey(algos map[string]SignatureAlgorithm, algo string, key PublicKey) bool {
+	switch algo {
+	case SigAlgoRSASHA2256, SigAlgoRSASHA2512:
+		return key.Type() == KeyAlgoRSA
+	}
+	if _, ok := lookupSignatureAlgorithm(algos, algo, key.Type()); ok {
+		return true
+	}
+	return algo == key.Type()
 }
 
 func checkSourceAddress(addr net.Addr, sourceAddrs string) error {
@@ -403,14 +620,22 @@ func (s *connection) serverAuthenticate(config *ServerConfig) (*Permissions, err
 	authFailures := 0
 	var authErrs []error
 	var displayedBanner bool
+	minRSAKeySize := config.MinRSAKeySize
+	if minRSAKeySize == 0 {
+		minRSAKeySize = defaultMinRSAKeySize
+	}
 
 userAuthLoop:
 	for {
 		if authFailures >= config.MaxAuthTries && config.MaxAuthTries > 0 {
 			discMsg := &disconnectMsg{
-				Reason:  2,
+				Reason:  DisconnectProtocolError,
 				Message: "too many authentication failures",
 			}
+			if config.DisconnectCallback != nil {
+				discMsg.Reason, discMsg.Message = config.DisconnectCallback(s, discMsg.Reason, discMsg.Message)
+			}
+			config.log(LogLevelWarn, "ssh: sending disconnect", "user", s.user, "reason", discMsg.Reason, "message", discMsg.Message)
 
 			if err := s.transport.writePacket(Marshal(discMsg)); err != nil {
 				return nil, err
@@ -486,7 +711,7 @@ userAuthLoop:
 			prompter := &sshClientKeyboardInteractive{s}
 			perms, authErr = config.KeyboardInteractiveCallback(s, prompter.Challenge)
 		case "publickey":
-			if config.PublicKeyCallback == nil {
+			if config.PublicKeyCallback == nil && config.PublicKeyAlgorithmCallback == nil {
 				authErr = errors.New("ssh: publickey auth not configured")
 				break
 			}
@@ -501,7 +726,7 @@ userAuthLoop:
 				return nil, parseError(msgUserAuthRequest)
 			}
 			algo := string(algoBytes)
-			if !isAcceptableAlgo(algo) {
+			if !isAcceptableAlgo(config.SignatureAlgorithms, algo) {
 				authErr = fmt.Errorf("ssh: algorithm %q not accepted", algo)
 				break
 			}
@@ -516,11 +741,31 @@ userAuthLoop:
 				return nil, err
 			}
 
-			candidate, ok := cache.get(s.user, pubKeyData)
+			if !algoMatchesKey(config.SignatureAlgorithms, algo, pubKey) {
+				authErr = fmt.Errorf("ssh: algorithm %q does not match key type %q", algo, pubKey.Type())
+				break
+			}
+
+			// The signature algorithm is that of algo, except for
+			// certificates, whose algorithms are named differently.
+			sigAlgo := algo
+			if cert, ok := pubKey.(*Certificate); ok && algo == cert.Type() {
+				sigAlgo = certToPrivAlgo(algo)
+			}
+			candidate, ok := cache.get(s.user, algo, pubKeyData)
 			if !ok {
 				candidate.user = s.user
+				candidate.algo = algo
 				candidate.pubKeyData = pubKeyData
-				candidate.perms, candidate.result = config.PublicKeyCallback(s, pubKey)
+				sizeErr := checkRSAKeySize(pubKey, minRSAKeySize)
+				switch {
+				case sizeErr != nil:
+					candidate.result = sizeErr
+				case config.PublicKeyAlgorithmCallback != nil:
+					candidate.perms, candidate.result = config.PublicKeyAlgorithmCallback(s, pubKey, sigAlgo)
+				default:
+					candidate.perms, candidate.result = config.PublicKeyCallback(s, pubKey)
+				}
 				if candidate.result == nil && candidate.perms != nil && candidate.perms.CriticalOptions != nil && candidate.perms.CriticalOptions[sourceAddressCriticalOption] != "" {
 					candidate.result = checkSourceAddress(
 						s.RemoteAddr(),
@@ -558,13 +803,17 @@ userAuthLoop:
 				// algorithm name that corresponds to algo with
 				// sig.Format.  This is usually the same, but
 				// for certs, the names differ.
-				if !isAcceptableAlgo(sig.Format) {
+				if !isAcceptableAlgo(config.SignatureAlgorithms, sig.Format) {
 					authErr = fmt.Errorf("ssh: algorithm %q not accepted", sig.Format)
 					break
 				}
+				if !isAlgoCompatible(algo, sigAlgo, sig.Format) {
+					authErr = fmt.Errorf("ssh: signature %q not compatible with selected algorithm %q", sig.Format, algo)
+					break
+				}
 				signedData := buildDataSignedForAuth(sessionID, userAuthReq, algoBytes, pubKeyData)
 
-				if err := pubKey.Verify(signedData, sig); err != nil {
+				if err := verifySignature(config.SignatureAlgorithms, pubKey, signedData, sig); err != nil {
 					return nil, err
 				}
 
@@ -627,6 +876,11 @@ userAuthLoop:
 		if config.AuthLogCallback != nil {
 			config.AuthLogCallback(s, userAuthReq.Method, authErr)
 		}
+		if authErr == nil {
+			config.log(LogLevelInfo, "ssh: authentication succeeded", "user", s.user, "method", userAuthReq.Method)
+		} else {
+			config.log(LogLevelInfo, "ssh: authentication failed", "user", s.user, "method", userAuthReq.Method, "error", authErr)
+		}
 
 		if authErr == nil {
 			break userAuthLoop
@@ -638,7 +892,7 @@ userAuthLoop:
 		if config.PasswordCallback != nil {
 			failureMsg.Methods = append(failureMsg.Methods, "password")
 		}
-		if config.PublicKeyCallback != nil {
+		if config.PublicKeyCallback != nil || config.PublicKeyAlgorithmCallback != nil {
 			failureMsg.Methods = append(failureMsg.Methods, "publickey")
 		}
 		if config.KeyboardInteractiveCallback != nil {
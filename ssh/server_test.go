@@ -0,0 +1,138 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergePermissions(t *testing.T) {
+	cert := &Permissions{
+		CriticalOptions: map[string]string{"force-command": "/usr/bin/backup"},
+		Extensions:      map[string]string{"permit-pty": "", "permit-port-forwarding": ""},
+	}
+	policy := &Permissions{
+		CriticalOptions: map[string]string{"source-address": "10.0.0.0/8", "force-command": "/usr/bin/backup"},
+		Extensions:      map[string]string{"permit-pty": "no", "pubkey-fp": "SHA256:x"},
+	}
+	got, err := MergePermissions(cert, policy)
+	if err != nil {
+		t.Fatalf("MergePermissions: %v", err)
+	}
+	want := &Permissions{
+		CriticalOptions: map[string]string{"force-command": "/usr/bin/backup", "source-address": "10.0.0.0/8"},
+		Extensions:      map[string]string{"permit-pty": "no", "permit-port-forwarding": "", "pubkey-fp": "SHA256:x"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// The result does not share the maps of its arguments.
+	got.Extensions["permit-X11-forwarding"] = ""
+	got.CriticalOptions["verify-required"] = ""
+	if len(cert.Extensions) != 2 || len(policy.Extensions) != 2 || len(cert.CriticalOptions) != 1 || len(policy.CriticalOptions) != 2 {
+		t.Error("modifying the result changed an argument")
+	}
+
+	for _, tt := range []struct {
+		base, override *Permissions
+	}{
+		{nil, nil},
+		{&Permissions{}, nil},
+		{nil, &Permissions{}},
+	} {
+		got, err := MergePermissions(tt.base, tt.override)
+		if err != nil {
+			t.Fatalf("MergePermissions(%v, %v): %v", tt.base, tt.override, err)
+		}
+		if got.CriticalOptions == nil || got.Extensions == nil || len(got.CriticalOptions)+len(got.Extensions) != 0 {
+			t.Errorf("MergePermissions(%v, %v) = %+v, want empty maps", tt.base, tt.override, got)
+		}
+	}
+	if got, err := MergePermissions(nil, cert); err != nil || !reflect.DeepEqual(got, cert) {
+		t.Errorf("MergePermissions(nil, cert) = %+v, %v, want %+v", got, err, cert)
+	}
+}
+
+func TestMergePermissionsConflict(t *testing.T) {
+	base := &Permissions{CriticalOptions: map[string]string{"force-command": "/usr/bin/backup"}}
+	override := &Permissions{CriticalOptions: map[string]string{"force-command": "/bin/sh"}}
+	for _, args := range [][2]*Permissions{{base, override}, {override, base}} {
+		got, err := MergePermissions(args[0], args[1])
+		if err == nil {
+			t.Errorf("MergePermissions = %+v, want an error", got)
+		} else if !strings.Contains(err.Error(), "force-command") {
+			t.Errorf("got error %q, want it to name the option", err)
+		}
+	}
+}
+
+func TestClientVersionCallback(t *testing.T) {
+	errBlocked := errors.New("client version blocked")
+	for _, tt := range []struct {
+		clientVersion string
+		wantErr       bool
+	}{
+		{"SSH-2.0-Scanner_1.0", true},
+		{"SSH-2.0-Go", false},
+	} {
+		c1, c2, err := netPipe()
+		if err != nil {
+			t.Fatalf("netPipe: %v", err)
+		}
+		var seen []byte
+		serverConf := &ServerConfig{
+			NoClientAuth: true,
+			ClientVersionCallback: func(version []byte) error {
+				seen = version
+				if bytes.HasPrefix(version, []byte("SSH-2.0-Scanner")) {
+					return errBlocked
+				}
+				return nil
+			},
+		}
+		serverConf.AddHostKey(testSigners["ecdsa"])
+		serverErr := make(chan error, 1)
+		go func() {
+			conn, _, _, err := NewServerConn(c1, serverConf)
+			if err == nil {
+				conn.Close()
+			}
+			serverErr <- err
+		}()
+
+		clientConf := &ClientConfig{
+			ClientVersion:   tt.clientVersion,
+			HostKeyCallback: InsecureIgnoreHostKey(),
+		}
+		conn, _, _, clientErr := NewClientConn(c2, "", clientConf)
+		if clientErr == nil {
+			conn.Close()
+		}
+		err = <-serverErr
+		c1.Close()
+		c2.Close()
+
+		if string(seen) != tt.clientVersion {
+			t.Errorf("callback got version %q, want %q", seen, tt.clientVersion)
+		}
+		if !tt.wantErr {
+			if err != nil || clientErr != nil {
+				t.Errorf("%s: got server error %v and client error %v, want none", tt.clientVersion, err, clientErr)
+			}
+			continue
+		}
+		if err != errBlocked {
+			t.Errorf("%s: NewServerConn: got %v, want %v", tt.clientVersion, err, errBlocked)
+		}
+		if clientErr == nil || !strings.Contains(clientErr.Error(), errBlocked.Error()) {
+			t.Errorf("%s: NewClientConn: got %v, want the disconnect message of the server", tt.clientVersion, clientErr)
+		}
+	}
+}
@@ -9,12 +9,14 @@ package ssh
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"sync"
+	"time"
 )
 
 type Signal string
@@ -130,6 +132,13 @@ type Session struct {
 	Stdout io.Writer
 	Stderr io.Writer
 
+	// Each of the three streams is connected either to the field
+	// above or to the pipe returned by StdinPipe, StdoutPipe or
+	// StderrPipe, not both: once a pipe has been requested, the
+	// corresponding field is ignored. SetStdio sets all three fields
+	// at once and reports such conflicts. The streams must be set
+	// up before Start, Run, Output, CombinedOutput or Shell.
+
 	ch        Channel // the channel backing this session
 	started   bool    // true once Start, Run or Shell is invoked.
 	copyFuncs []func() error
@@ -171,21 +180,11 @@ func (s *Session) Setenv(name, value string) error {
 	}
 	ok, err := s.ch.SendRequest("env", true, Marshal(&msg))
 	if err == nil && !ok {
-		err = errors.New("ssh: setenv failed")
+		err = &RequestRejectedError{Request: "env", msg: "ssh: setenv failed"}
 	}
 	return err
 }
 
-// RFC 4254 Section 6.2.
-type ptyRequestMsg struct {
-	Term     string
-	Columns  uint32
-	Rows     uint32
-	Width    uint32
-	Height   uint32
-	Modelist string
-}
-
 // RequestPty requests the association of a pty with the session on the remote host.
 func (s *Session) RequestPty(term string, h, w int, termmodes TerminalModes) error {
 	var tm []byte
@@ -198,17 +197,17 @@ func (s *Session) RequestPty(term string, h, w int, termmodes TerminalModes) err
 		tm = append(tm, Marshal(&kv)...)
 	}
 	tm = append(tm, tty_OP_END)
-	req := ptyRequestMsg{
-		Term:     term,
-		Columns:  uint32(w),
-		Rows:     uint32(h),
-		Width:    uint32(w * 8),
-		Height:   uint32(h * 8),
-		Modelist: string(tm),
+	req := PtyRequest{
+		Term:    term,
+		Columns: uint32(w),
+		Rows:    uint32(h),
+		Width:   uint32(w * 8),
+		Height:  uint32(h * 8),
+		Modes:   string(tm),
 	}
 	ok, err := s.ch.SendRequest("pty-req", true, Marshal(&req))
 	if err == nil && !ok {
-		err = errors.New("ssh: pty-req failed")
+		err = &RequestRejectedError{Request: "pty-req", msg: "ssh: pty-req failed"}
 	}
 	return err
 }
@@ -226,22 +225,14 @@ func (s *Session) RequestSubsystem(subsystem string) error {
 	}
 	ok, err := s.ch.SendRequest("subsystem", true, Marshal(&msg))
 	if err == nil && !ok {
-		err = errors.New("ssh: subsystem request failed")
+		err = &RequestRejectedError{Request: "subsystem", msg: "ssh: subsystem request failed"}
 	}
 	return err
 }
 
-// RFC 4254 Section 6.7.
-type ptyWindowChangeMsg struct {
-	Columns uint32
-	Rows    uint32
-	Width   uint32
-	Height  uint32
-}
-
 // WindowChange informs the remote host about a terminal window dimension change to h rows and w columns.
 func (s *Session) WindowChange(h, w int) error {
-	req := ptyWindowChangeMsg{
+	req := WindowChangeRequest{
 		Columns: uint32(w),
 		Rows:    uint32(h),
 		Width:   uint32(w * 8),
@@ -267,6 +258,103 @@ func (s *Session) Signal(sig Signal) error {
 	return err
 }
 
+// ParseSignalRequest parses the "signal" request sent by
+// Session.Signal, and returns the signal. Servers can use it when
+// handling the requests of a session channel, to deliver the signal to
+// the process of the session. The signal is not checked against the
+// SIG* constants.
+func ParseSignalRequest(req *Request) (Signal, error) {
+	if req.Type != "signal" {
+		return "", fmt.Errorf("ssh: request type %q is not a signal", req.Type)
+	}
+	var msg signalMsg
+	if err := Unmarshal(req.Payload, &msg); err != nil {
+		return "", err
+	}
+	return Signal(msg.Signal), nil
+}
+
+// RFC 4254 Section 6.8.
+type xonXoffMsg struct {
+	ClientCanDo bool
+}
+
+// SetXonXoff sends an "xon-xoff" request, which tells the remote side
+// whether the local side can do flow control with the ^S and ^Q
+// characters of a pty. RFC 4254 defines the request as sent by the
+// server, when the terminal modes of the pty allow the client to take
+// over flow control; SetXonXoff sends it on the session regardless,
+// for peers that honor it from the client. No reply is requested.
+func (s *Session) SetXonXoff(canDo bool) error {
+	msg := xonXoffMsg{
+		ClientCanDo: canDo,
+	}
+	_, err := s.ch.SendRequest("xon-xoff", false, Marshal(&msg))
+	return err
+}
+
+// ParseXonXoffRequest parses an "xon-xoff" request, as sent by
+// Session.SetXonXoff, and returns whether flow control can be done by
+// the sender.
+func ParseXonXoffRequest(req *Request) (canDo bool, err error) {
+	if req.Type != "xon-xoff" {
+		return false, fmt.Errorf("ssh: request type %q is not xon-xoff", req.Type)
+	}
+	var msg xonXoffMsg
+	if err := Unmarshal(req.Payload, &msg); err != nil {
+		return false, err
+	}
+	return msg.ClientCanDo, nil
+}
+
+// RFC 4335.
+type breakMsg struct {
+	Length uint32
+}
+
+// SendBreak sends a BREAK to the remote host, as used to control serial
+// consoles, and waits for the reply. The length of the BREAK is given
+// in milliseconds; the remote host may impose its own default or
+// limits. It returns an error if the remote host does not support or
+// refuses the request. See RFC 4335.
+func (s *Session) SendBreak(lengthMs uint32) error {
+	msg := breakMsg{
+		Length: lengthMs,
+	}
+	ok, err := s.ch.SendRequest("break", true, Marshal(&msg))
+	if err == nil && !ok {
+		err = &RequestRejectedError{Request: "break", msg: "ssh: break failed"}
+	}
+	return err
+}
+
+// ParseBreakRequest parses the "break" request sent by
+// Session.SendBreak, and returns the requested length of the BREAK in
+// milliseconds. Servers that bridge serial consoles can use it when
+// handling the requests of a session channel.
+func ParseBreakRequest(req *Request) (lengthMs uint32, err error) {
+	if req.Type != "break" {
+		return 0, fmt.Errorf("ssh: request type %q is not a break", req.Type)
+	}
+	var msg breakMsg
+	if err := Unmarshal(req.Payload, &msg); err != nil {
+		return 0, err
+	}
+	return msg.Length, nil
+}
+
+// PadSmallWrites pads the session's small writes to its standard
+// input, such as keystrokes, so their size is hidden from an observer
+// of the connection. See PaddedWritesChannel for the semantics and
+// overhead of blockSize.
+func (s *Session) PadSmallWrites(blockSize int) error {
+	ch, ok := s.ch.(PaddedWritesChannel)
+	if !ok {
+		return errors.New("ssh: session channel does not support padding")
+	}
+	return ch.PadSmallWrites(blockSize)
+}
+
 // RFC 4254 Section 6.5.
 type execMsg struct {
 	Command string
@@ -285,7 +373,7 @@ func (s *Session) Start(cmd string) error {
 
 	ok, err := s.ch.SendRequest("exec", true, Marshal(&req))
 	if err == nil && !ok {
-		err = fmt.Errorf("ssh: command %v failed", cmd)
+		err = &RequestRejectedError{Request: "exec", msg: fmt.Sprintf("ssh: command %v failed", cmd)}
 	}
 	if err != nil {
 		return err
@@ -314,6 +402,49 @@ func (s *Session) Run(cmd string) error {
 	return s.Wait()
 }
 
+// RunContext is like Run, but if ctx is done before the remote command
+// exits, it terminates the command the way kill(1) escalates: it sends
+// SIGTERM, then SIGKILL if the command did not exit within gracePeriod,
+// and, as a last resort for the servers that ignore signal requests,
+// closes the session if the command still did not exit within another
+// gracePeriod. It then returns the error of ctx. A gracePeriod of zero
+// or less closes the session right away, as WaitContext does.
+func (s *Session) RunContext(ctx context.Context, cmd string, gracePeriod time.Duration) error {
+	if err := s.Start(cmd); err != nil {
+		return err
+	}
+	if gracePeriod <= 0 {
+		return s.WaitContext(ctx)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	for _, sig := range []Signal{SIGTERM, SIGKILL} {
+		if err := s.Signal(sig); err != nil {
+			break
+		}
+		timer := time.NewTimer(gracePeriod)
+		select {
+		case <-done:
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	s.ch.Close()
+	if s.stdinPipeWriter != nil {
+		s.stdinPipeWriter.Close()
+	}
+	return ctx.Err()
+}
+
 // Output runs cmd on the remote host and returns its standard output.
 func (s *Session) Output(cmd string) ([]byte, error) {
 	if s.Stdout != nil {
@@ -336,6 +467,13 @@ func (w *singleWriter) Write(p []byte) (int, error) {
 	return w.b.Write(p)
 }
 
+// bytes returns a copy of what has been written so far.
+func (w *singleWriter) bytes() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]byte(nil), w.b.Bytes()...)
+}
+
 // CombinedOutput runs cmd on the remote host and returns its combined
 // standard output and standard error.
 func (s *Session) CombinedOutput(cmd string) ([]byte, error) {
@@ -361,7 +499,7 @@ func (s *Session) Shell() error {
 
 	ok, err := s.ch.SendRequest("shell", true, nil)
 	if err == nil && !ok {
-		return errors.New("ssh: could not start shell")
+		return &RequestRejectedError{Request: "shell", msg: "ssh: could not start shell"}
 	}
 	if err != nil {
 		return err
@@ -396,11 +534,36 @@ func (s *Session) start() error {
 // *ExitMissingError is returned. If the command completes
 // unsuccessfully or is interrupted by a signal, the error is of type
 // *ExitError. Other error types may be returned for I/O problems.
+//
+// Wait returns once the server has closed the session, which it
+// normally does after sending all of the output. Output sent to
+// Stdout and Stderr is copied for the caller, but the pipes returned
+// by StdoutPipe and StderrPipe must be read until io.EOF, which can be
+// done concurrently with Wait: output that is not read holds up the
+// remote command once the window of the channel is full, and then Wait
+// never returns. Use WaitContext to bound the wait.
 func (s *Session) Wait() error {
+	return s.WaitContext(context.Background())
+}
+
+// WaitContext is like Wait, but if ctx is done before the remote
+// command exits, it closes the session and returns the error of ctx.
+// In that case, Stdout and Stderr may still be written to until the
+// server acknowledges the close.
+func (s *Session) WaitContext(ctx context.Context) error {
 	if !s.started {
 		return errors.New("ssh: session not started")
 	}
-	waitErr := <-s.exitStatus
+	var waitErr error
+	select {
+	case waitErr = <-s.exitStatus:
+	case <-ctx.Done():
+		s.ch.Close()
+		if s.stdinPipeWriter != nil {
+			s.stdinPipeWriter.Close()
+		}
+		return ctx.Err()
+	}
 
 	if s.stdinPipeWriter != nil {
 		s.stdinPipeWriter.Close()
@@ -425,12 +588,7 @@ func (s *Session) wait(reqs <-chan *Request) error {
 		case "exit-status":
 			wm.status = int(binary.BigEndian.Uint32(msg.Payload))
 		case "exit-signal":
-			var sigval struct {
-				Signal     string
-				CoreDumped bool
-				Error      string
-				Lang       string
-			}
+			var sigval ExitSignalRequest
 			if err := Unmarshal(msg.Payload, &sigval); err != nil {
 				return err
 			}
@@ -468,6 +626,23 @@ func (s *Session) wait(reqs <-chan *Request) error {
 	return &ExitError{wm}
 }
 
+// A RequestRejectedError is returned by the methods of Session that
+// send a request and wait for the reply, such as RequestPty, Setenv,
+// Start and Shell, when the server refuses the request, for example
+// because of its policy. Their other errors come from the channel or
+// the connection. The refusal, SSH_MSG_CHANNEL_FAILURE, carries no
+// reason, so the server's cause cannot be reported.
+type RequestRejectedError struct {
+	// Request is the request type, such as "pty-req" or "env".
+	Request string
+
+	msg string
+}
+
+func (e *RequestRejectedError) Error() string {
+	return e.msg
+}
+
 // ExitMissingError is returned if a session is torn down cleanly, but
 // the server sends no confirmation of the exit status.
 type ExitMissingError struct{}
@@ -536,6 +711,28 @@ func (s *sessionStdin) Close() error {
 	return s.ch.CloseWrite()
 }
 
+// SetStdio sets Stdin, Stdout and Stderr to in, out and errOut. A nil
+// value selects the default for that stream, as for the fields. It
+// returns an error, and changes nothing, if the session has already
+// started, or if a non-nil value is given for a stream whose pipe was
+// already requested with StdinPipe, StdoutPipe or StderrPipe.
+func (s *Session) SetStdio(in io.Reader, out, errOut io.Writer) error {
+	if s.started {
+		return errors.New("ssh: SetStdio after process started")
+	}
+	if in != nil && s.stdinpipe {
+		return errors.New("ssh: SetStdio: Stdin conflicts with StdinPipe")
+	}
+	if out != nil && s.stdoutpipe {
+		return errors.New("ssh: SetStdio: Stdout conflicts with StdoutPipe")
+	}
+	if errOut != nil && s.stderrpipe {
+		return errors.New("ssh: SetStdio: Stderr conflicts with StderrPipe")
+	}
+	s.Stdin, s.Stdout, s.Stderr = in, out, errOut
+	return nil
+}
+
 // StdinPipe returns a pipe that will be connected to the
 // remote command's standard input when the command starts.
 func (s *Session) StdinPipe() (io.WriteCloser, error) {
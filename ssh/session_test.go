@@ -8,13 +8,17 @@ package ssh
 
 import (
 	"bytes"
+	"context"
 	crypto_rand "crypto/rand"
 	"errors"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -157,8 +161,104 @@ func TestSessionOutput(t *testing.T) {
 	}
 }
 
+// Test that SetStdio connects the streams, and refuses to conflict
+// with pipes or to run after the session started.
+func TestSessionSetStdio(t *testing.T) {
+	conn := dial(fixedOutputHandler, t)
+	defer conn.Close()
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("Unable to request new session: %v", err)
+	}
+	defer session.Close()
+
+	if _, err := session.StderrPipe(); err != nil {
+		t.Fatalf("StderrPipe: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	if err := session.SetStdio(nil, &stdout, &stderr); err == nil {
+		t.Error("SetStdio succeeded with a conflicting StderrPipe")
+	}
+	if session.Stdout != nil {
+		t.Error("failed SetStdio changed Stdout")
+	}
+	if err := session.SetStdio(bytes.NewReader(nil), &stdout, nil); err != nil {
+		t.Fatalf("SetStdio: %v", err)
+	}
+	if _, err := session.StdoutPipe(); err == nil {
+		t.Error("StdoutPipe succeeded after SetStdio set Stdout")
+	}
+
+	if err := session.Run(""); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got, want := stdout.String(), "this-is-stdout."; got != want {
+		t.Errorf("got stdout %q, want %q", got, want)
+	}
+	if err := session.SetStdio(nil, nil, nil); err == nil {
+		t.Error("SetStdio succeeded after the session started")
+	}
+}
+
 // Test that both stdout and stderr are returned
 // via the CombinedOutput helper.
+func TestAcceptEnv(t *testing.T) {
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+		AcceptEnv: func(name, value string) bool {
+			return name == "LANG" || strings.HasPrefix(name, "LC_")
+		},
+	}
+	serverConf.AddHostKey(testSigners["ecdsa"])
+	client, _, chans, reqs := pipePair(t, serverConf, testClientConfig())
+	go DiscardRequests(reqs)
+
+	env := make(chan []string, 1)
+	go func() {
+		ch, chReqs, err := (<-chans).Accept()
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			return
+		}
+		defer ch.Close()
+		for req := range chReqs {
+			switch req.Type {
+			case "env":
+				t.Errorf("env request passed to the application")
+			case "shell":
+				env <- ch.(EnvChannel).Env()
+				req.Reply(true, nil)
+				return
+			}
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+	for _, kv := range [][2]string{{"LANG", "C"}, {"LC_ALL", "en_US.UTF-8"}, {"LANG", "C.UTF-8"}} {
+		if err := session.Setenv(kv[0], kv[1]); err != nil {
+			t.Errorf("Setenv(%q, %q): %v", kv[0], kv[1], err)
+		}
+	}
+	for _, name := range []string{"PATH", "LD_PRELOAD", "LC_X=Y"} {
+		if err := session.Setenv(name, "/tmp"); err == nil {
+			t.Errorf("Setenv(%q) succeeded, want a rejection", name)
+		}
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell: %v", err)
+	}
+	if got, want := strings.Join(<-env, " "), "LANG=C.UTF-8 LC_ALL=en_US.UTF-8"; got != want {
+		t.Errorf("got environment %q, want %q", got, want)
+	}
+}
+
 func TestSessionCombinedOutput(t *testing.T) {
 	conn := dial(fixedOutputHandler, t)
 	defer conn.Close()
@@ -681,6 +781,327 @@ func TestSessionID(t *testing.T) {
 	}
 }
 
+func TestSessionSendBreak(t *testing.T) {
+	lengths := make(chan uint32, 2)
+	conn := dial(func(ch Channel, in <-chan *Request, t *testing.T) {
+		defer ch.Close()
+		for req := range in {
+			if req.Type != "break" {
+				req.Reply(false, nil)
+				continue
+			}
+			length, err := ParseBreakRequest(req)
+			if err != nil {
+				t.Errorf("ParseBreakRequest: %v", err)
+			}
+			lengths <- length
+			// Refuse zero length breaks, to check the reply.
+			req.Reply(length > 0, nil)
+		}
+	}, t)
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+
+	if err := session.SendBreak(500); err != nil {
+		t.Fatalf("SendBreak: %v", err)
+	}
+	if got := <-lengths; got != 500 {
+		t.Errorf("server got break length %d, want 500", got)
+	}
+	if err := session.SendBreak(0); err == nil {
+		t.Error("SendBreak succeeded for a refused request")
+	}
+	if got := <-lengths; got != 0 {
+		t.Errorf("server got break length %d, want 0", got)
+	}
+
+	if _, err := ParseBreakRequest(&Request{Type: "signal"}); err == nil {
+		t.Error("ParseBreakRequest accepted a signal request")
+	}
+}
+
+func TestSessionSignalAndXonXoff(t *testing.T) {
+	signals := make(chan Signal, 1)
+	xonXoff := make(chan bool, 2)
+	conn := dial(func(ch Channel, in <-chan *Request, t *testing.T) {
+		defer ch.Close()
+		for req := range in {
+			switch req.Type {
+			case "signal":
+				sig, err := ParseSignalRequest(req)
+				if err != nil {
+					t.Errorf("ParseSignalRequest: %v", err)
+				}
+				signals <- sig
+			case "xon-xoff":
+				canDo, err := ParseXonXoffRequest(req)
+				if err != nil {
+					t.Errorf("ParseXonXoffRequest: %v", err)
+				}
+				xonXoff <- canDo
+			}
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}, t)
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+
+	if err := session.Signal(SIGUSR1); err != nil {
+		t.Fatalf("Signal: %v", err)
+	}
+	if got := <-signals; got != SIGUSR1 {
+		t.Errorf("server got signal %q, want %q", got, SIGUSR1)
+	}
+	for _, want := range []bool{true, false} {
+		if err := session.SetXonXoff(want); err != nil {
+			t.Fatalf("SetXonXoff: %v", err)
+		}
+		if got := <-xonXoff; got != want {
+			t.Errorf("server got xon-xoff %v, want %v", got, want)
+		}
+	}
+
+	if _, err := ParseSignalRequest(&Request{Type: "break"}); err == nil {
+		t.Error("ParseSignalRequest accepted a break request")
+	}
+	if _, err := ParseXonXoffRequest(&Request{Type: "signal"}); err == nil {
+		t.Error("ParseXonXoffRequest accepted a signal request")
+	}
+}
+
+func TestSessionWaitReadingLargeOutput(t *testing.T) {
+	// More than a channel window of output, so that the server
+	// can only finish if the client reads while it waits.
+	const size = 3 * channelWindowSize / 2
+	conn := dial(func(ch Channel, in <-chan *Request, t *testing.T) {
+		defer ch.Close()
+		go func() {
+			for req := range in {
+				if req.WantReply {
+					req.Reply(true, nil)
+				}
+			}
+		}()
+		if _, err := ch.Write(make([]byte, size)); err != nil {
+			t.Errorf("Write: %v", err)
+			return
+		}
+		sendStatus(0, ch, t)
+	}, t)
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe: %v", err)
+	}
+	if err := session.Start(""); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- session.Wait() }()
+	n, err := io.Copy(ioutil.Discard, stdout)
+	if err != nil || n != size {
+		t.Errorf("read %d bytes, %v, want %d", n, err, size)
+	}
+	if err := <-waitErr; err != nil {
+		t.Errorf("Wait: %v", err)
+	}
+}
+
+func TestSessionWaitContext(t *testing.T) {
+	closed := make(chan struct{})
+	conn := dial(func(ch Channel, in <-chan *Request, t *testing.T) {
+		defer close(closed)
+		defer ch.Close()
+		// Never exit, and never read: the client must give up.
+		for req := range in {
+			if req.WantReply {
+				req.Reply(true, nil)
+			}
+		}
+	}, t)
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+	if err := session.Start(""); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := session.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("WaitContext: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	// The session is closed, so the server sees the channel end,
+	// and closes it in turn.
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("session was not closed")
+	}
+	<-session.exitStatus
+}
+
+func TestClientRun(t *testing.T) {
+	conn := dial(fixedOutputHandler, t)
+	defer conn.Close()
+
+	out, err := conn.Run(context.Background(), "true")
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	const stdout = "this-is-stdout."
+	const stderr = "this-is-stderr."
+	if g := string(out); g != stdout+stderr && g != stderr+stdout {
+		t.Errorf("Run: got %q, want %q or %q", g, stdout+stderr, stderr+stdout)
+	}
+}
+
+func TestClientRunExitStatus(t *testing.T) {
+	conn := dial(func(ch Channel, in <-chan *Request, t *testing.T) {
+		defer ch.Close()
+		req := <-in
+		req.Reply(req.Type == "exec", nil)
+		io.WriteString(ch.Stderr(), "failed")
+		sendStatus(3, ch, t)
+	}, t)
+	defer conn.Close()
+
+	out, err := conn.Run(context.Background(), "false")
+	if string(out) != "failed" {
+		t.Errorf("Run: got output %q, want %q", out, "failed")
+	}
+	e, ok := err.(*ExitError)
+	if !ok {
+		t.Fatalf("Run: got error %v, want an *ExitError", err)
+	}
+	if e.ExitStatus() != 3 {
+		t.Errorf("Run: got exit status %d, want 3", e.ExitStatus())
+	}
+}
+
+func TestClientRunContext(t *testing.T) {
+	started := make(chan struct{})
+	signals := make(chan Signal, 2)
+	conn := dial(func(ch Channel, in <-chan *Request, t *testing.T) {
+		defer ch.Close()
+		defer close(signals)
+		for req := range in {
+			switch req.Type {
+			case "exec":
+				req.Reply(true, nil)
+				io.WriteString(ch, "started")
+				close(started)
+			case "signal":
+				sig, err := ParseSignalRequest(req)
+				if err != nil {
+					t.Errorf("ParseSignalRequest: %v", err)
+				}
+				signals <- sig
+				if sig == SIGTERM {
+					sendSignal(string(sig), ch, t)
+					return
+				}
+			}
+		}
+	}, t)
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	out, err := conn.Run(ctx, "sleep 60")
+	if err != context.Canceled {
+		t.Errorf("Run: got error %v, want %v", err, context.Canceled)
+	}
+	if string(out) != "started" {
+		t.Errorf("Run: got output %q, want %q", out, "started")
+	}
+	// The command exited on SIGTERM, so it was not escalated.
+	var got []Signal
+	for sig := range signals {
+		got = append(got, sig)
+	}
+	if want := []Signal{SIGTERM}; !reflect.DeepEqual(got, want) {
+		t.Errorf("server got signals %v, want %v", got, want)
+	}
+}
+
+func TestSessionPadSmallWrites(t *testing.T) {
+	conn := dial(func(ch Channel, in <-chan *Request, t *testing.T) {
+		defer ch.Close()
+		go io.Copy(ioutil.Discard, ch)
+		for req := range in {
+			if req.WantReply {
+				req.Reply(true, nil)
+			}
+		}
+	}, t)
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+	if err := session.PadSmallWrites(-1); err == nil {
+		t.Error("PadSmallWrites(-1) succeeded")
+	}
+	if err := session.PadSmallWrites(64); err != nil {
+		t.Fatalf("PadSmallWrites: %v", err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatalf("StdinPipe: %v", err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell: %v", err)
+	}
+
+	stats := conn.Conn.(TrafficCounter)
+	var sizes []uint64
+	for _, keys := range []string{"a", "ls\r", "exit 1\r", strings.Repeat("x", 64)} {
+		before := stats.TrafficStats()
+		if _, err := stdin.Write([]byte(keys)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		after := stats.TrafficStats()
+		if after.PacketsWritten != before.PacketsWritten+1 {
+			t.Fatalf("Write(%q) sent %d packets, want 1", keys, after.PacketsWritten-before.PacketsWritten)
+		}
+		sizes = append(sizes, after.BytesWritten-before.BytesWritten)
+	}
+	for i, size := range sizes {
+		if size != sizes[0] {
+			t.Errorf("write %d took %d bytes on the wire, want %d", i, size, sizes[0])
+		}
+	}
+}
+
 type noReadConn struct {
 	readSeen bool
 	net.Conn
@@ -774,3 +1195,206 @@ func TestHostKeyAlgorithms(t *testing.T) {
 		t.Fatal("succeeded connecting with unknown hostkey algorithm")
 	}
 }
+
+func TestExitChannel(t *testing.T) {
+	client, _, chans, reqs := pipePair(t, testServerConfig(), testClientConfig())
+	go DiscardRequests(reqs)
+	go func() {
+		for newCh := range chans {
+			ch, chReqs, err := newCh.Accept()
+			if err != nil {
+				t.Errorf("Accept: %v", err)
+				return
+			}
+			go DiscardRequests(chReqs)
+			// Report the signal before the status, and close the
+			// channel without sending EOF.
+			exitCh := ch.(ExitChannel)
+			if err := exitCh.SendExitSignal(SIGSEGV, true, "Segmentation fault", "en"); err != nil {
+				t.Errorf("SendExitSignal: %v", err)
+			}
+			if err := exitCh.SendExitStatus(139); err != nil {
+				t.Errorf("SendExitStatus: %v", err)
+			}
+			ch.Close()
+		}
+	}()
+
+	ch, chReqs, err := client.OpenChannel("session", nil)
+	if err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+	defer ch.Close()
+	var got []*Request
+	for req := range chReqs {
+		got = append(got, req)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d requests, want 2", len(got))
+	}
+	for _, req := range got {
+		if req.WantReply {
+			t.Errorf("%q request wants a reply", req.Type)
+		}
+	}
+
+	var sig ExitSignalRequest
+	if got[0].Type != "exit-signal" {
+		t.Errorf("got first request %q, want exit-signal", got[0].Type)
+	} else if err := Unmarshal(got[0].Payload, &sig); err != nil {
+		t.Errorf("Unmarshal: %v", err)
+	} else if want := (ExitSignalRequest{"SEGV", true, "Segmentation fault", "en"}); sig != want {
+		t.Errorf("got exit-signal %+v, want %+v", sig, want)
+	}
+	var status ExitStatusRequest
+	if got[1].Type != "exit-status" {
+		t.Errorf("got second request %q, want exit-status", got[1].Type)
+	} else if err := Unmarshal(got[1].Payload, &status); err != nil || status.Status != 139 {
+		t.Errorf("got exit-status %+v, %v, want status 139", status, err)
+	}
+}
+
+func TestExitChannelSession(t *testing.T) {
+	conn := dial(func(ch Channel, in <-chan *Request, t *testing.T) {
+		defer ch.Close()
+		req := <-in
+		req.Reply(req.Type == "shell", nil)
+		go DiscardRequests(in)
+		exitCh := ch.(ExitChannel)
+		if err := exitCh.SendExitStatus(3); err != nil {
+			t.Errorf("SendExitStatus: %v", err)
+		}
+		if err := exitCh.SendExitSignal(SIGTERM, false, "terminated", "en-GB"); err != nil {
+			t.Errorf("SendExitSignal: %v", err)
+		}
+		ch.CloseWrite()
+	}, t)
+	defer conn.Close()
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell: %v", err)
+	}
+	err = session.Wait()
+	e, ok := err.(*ExitError)
+	if !ok {
+		t.Fatalf("Wait: got %v, want an *ExitError", err)
+	}
+	if e.ExitStatus() != 3 || e.Signal() != "TERM" || e.Msg() != "terminated" || e.Lang() != "en-GB" {
+		t.Errorf("got %v, want status 3, signal TERM, message %q and language en-GB", e, "terminated")
+	}
+}
+
+func TestRequestRejectedError(t *testing.T) {
+	conn := dial(func(ch Channel, in <-chan *Request, t *testing.T) {
+		defer ch.Close()
+		for req := range in {
+			req.Reply(req.Type == "shell", nil)
+		}
+	}, t)
+	defer conn.Close()
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+
+	err = session.RequestPty("xterm", 80, 40, TerminalModes{})
+	if rejected, ok := err.(*RequestRejectedError); !ok || rejected.Request != "pty-req" {
+		t.Errorf("RequestPty: got %#v, want a RequestRejectedError for pty-req", err)
+	}
+	err = session.Setenv("LANG", "C")
+	if rejected, ok := err.(*RequestRejectedError); !ok || rejected.Request != "env" {
+		t.Errorf("Setenv: got %#v, want a RequestRejectedError for env", err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell: %v", err)
+	}
+
+	// Once the channel is closed, the errors come from the channel.
+	session.Close()
+	if err := session.RequestPty("xterm", 80, 40, TerminalModes{}); err == nil {
+		t.Errorf("RequestPty on a closed session succeeded")
+	} else if _, ok := err.(*RequestRejectedError); ok {
+		t.Errorf("RequestPty on a closed session: got %v, want a channel error", err)
+	}
+}
+
+func TestRunContextEscalation(t *testing.T) {
+	for _, tt := range []struct {
+		exitOn Signal // The signal the command exits on, if any.
+		want   []Signal
+	}{
+		{SIGTERM, []Signal{SIGTERM}},
+		{SIGKILL, []Signal{SIGTERM, SIGKILL}},
+		{"", []Signal{SIGTERM, SIGKILL}},
+	} {
+		signals := make(chan Signal, 3)
+		conn := dial(func(ch Channel, in <-chan *Request, t *testing.T) {
+			defer ch.Close()
+			defer close(signals)
+			for req := range in {
+				switch req.Type {
+				case "exec":
+					req.Reply(true, nil)
+				case "signal":
+					sig, err := ParseSignalRequest(req)
+					if err != nil {
+						t.Errorf("ParseSignalRequest: %v", err)
+					}
+					signals <- sig
+					if sig == tt.exitOn {
+						sendSignal(string(sig), ch, t)
+						return
+					}
+				}
+			}
+		}, t)
+		session, err := conn.NewSession()
+		if err != nil {
+			t.Fatalf("NewSession: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err = session.RunContext(ctx, "sleep 100", 20*time.Millisecond)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("exit on %q: RunContext = %v, want %v", tt.exitOn, err, context.DeadlineExceeded)
+		}
+		// Once the channel is closed, by the command or by
+		// RunContext, the handler returns.
+		var got []Signal
+		for sig := range signals {
+			got = append(got, sig)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("exit on %q: server got signals %v, want %v", tt.exitOn, got, tt.want)
+		}
+		conn.Close()
+	}
+}
+
+func TestRunContextExit(t *testing.T) {
+	conn := dial(func(ch Channel, in <-chan *Request, t *testing.T) {
+		defer ch.Close()
+		for req := range in {
+			if req.Type == "exec" {
+				req.Reply(true, nil)
+				sendStatus(0, ch, t)
+				return
+			}
+			req.Reply(false, nil)
+		}
+	}, t)
+	defer conn.Close()
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+	if err := session.RunContext(context.Background(), "true", time.Second); err != nil {
+		t.Errorf("RunContext: %v", err)
+	}
+}
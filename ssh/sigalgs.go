@@ -0,0 +1,124 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A SignatureAlgorithm implements a signature algorithm for the keys
+// of one key type. The RSA algorithms SigAlgoRSA, SigAlgoRSASHA2256
+// and SigAlgoRSASHA2512 are implemented this way; others, such as
+// experimental RSA-PSS formats, can be added to a server with
+// ServerConfig.SignatureAlgorithms and to a Signer with
+// NewSignerWithAlgorithms.
+type SignatureAlgorithm struct {
+	// KeyType is the type of the keys the algorithm is used with,
+	// such as KeyAlgoRSA.
+	KeyType string
+
+	// Sign signs data with signer, whose public key has type
+	// KeyType, and returns the signature blob.
+	Sign func(signer crypto.Signer, rand io.Reader, data []byte) ([]byte, error)
+
+	// Verify verifies that blob is a signature on data by key, the
+	// crypto.PublicKey of a key of type KeyType, such as an
+	// *rsa.PublicKey.
+	Verify func(key crypto.PublicKey, data, blob []byte) error
+}
+
+// builtinSignatureAlgorithms are the signature algorithms implemented
+// as SignatureAlgorithms by this package.
+var builtinSignatureAlgorithms = map[string]SignatureAlgorithm{
+	SigAlgoRSA:        rsaPKCS1v15Algorithm(crypto.SHA1),
+	SigAlgoRSASHA2256: rsaPKCS1v15Algorithm(crypto.SHA256),
+	SigAlgoRSASHA2512: rsaPKCS1v15Algorithm(crypto.SHA512),
+}
+
+// checkSignatureAlgorithms returns an error if an algorithm of algos
+// is incomplete or if its name is that of an algorithm of this
+// package.
+func checkSignatureAlgorithms(algos map[string]SignatureAlgorithm) error {
+	for name, alg := range algos {
+		if alg.KeyType == "" || alg.Sign == nil || alg.Verify == nil {
+			return fmt.Errorf("ssh: incomplete signature algorithm %s", name)
+		}
+		if name == "" || isAcceptableAlgo(nil, name) {
+			return fmt.Errorf("ssh: signature algorithm %q is already defined", name)
+		}
+	}
+	return nil
+}
+
+// lookupSignatureAlgorithm returns the signature algorithm name,
+// either one of this package or of algos, if it is used with keys of
+// keyType.
+func lookupSignatureAlgorithm(algos map[string]SignatureAlgorithm, name, keyType string) (SignatureAlgorithm, bool) {
+	alg, ok := builtinSignatureAlgorithms[name]
+	if !ok {
+		alg, ok = algos[name]
+	}
+	if !ok || alg.KeyType != keyType {
+		return SignatureAlgorithm{}, false
+	}
+	return alg, true
+}
+
+// verifyBuiltin verifies sig, whose format is not the default one of
+// key, with a signature algorithm of this package.
+func verifyBuiltin(key PublicKey, cryptoKey crypto.PublicKey, data []byte, sig *Signature) error {
+	alg, ok := lookupSignatureAlgorithm(nil, sig.Format, key.Type())
+	if !ok {
+		return fmt.Errorf("ssh: signature type %s for key type %s", sig.Format, key.Type())
+	}
+	return alg.Verify(cryptoKey, data, sig.Blob)
+}
+
+// verifySignature verifies sig by key, with the algorithm of algos
+// named by its format, if there is one, and with key.Verify
+// otherwise.
+func verifySignature(algos map[string]SignatureAlgorithm, key PublicKey, data []byte, sig *Signature) error {
+	alg, ok := algos[sig.Format]
+	if !ok || alg.KeyType != key.Type() {
+		return key.Verify(data, sig)
+	}
+	cryptoKey, ok := key.(CryptoPublicKey)
+	if !ok {
+		return fmt.Errorf("ssh: signature type %s for key type %s", sig.Format, key.Type())
+	}
+	return alg.Verify(cryptoKey.CryptoPublicKey(), data, sig.Blob)
+}
+
+func rsaPKCS1v15Algorithm(hash crypto.Hash) SignatureAlgorithm {
+	return SignatureAlgorithm{
+		KeyType: KeyAlgoRSA,
+		Sign: func(signer crypto.Signer, rand io.Reader, data []byte) ([]byte, error) {
+			pub, ok := signer.Public().(*rsa.PublicKey)
+			if !ok {
+				return nil, errors.New("ssh: not an RSA key")
+			}
+			h := hash.New()
+			h.Write(data)
+			signature, err := signer.Sign(rand, h.Sum(nil), hash)
+			if err != nil {
+				return nil, err
+			}
+			return sshSignatureBlob((*rsaPublicKey)(pub), signature)
+		},
+		Verify: func(key crypto.PublicKey, data, blob []byte) error {
+			rsaKey, ok := key.(*rsa.PublicKey)
+			if !ok {
+				return errors.New("ssh: not an RSA key")
+			}
+			h := hash.New()
+			h.Write(data)
+			return rsa.VerifyPKCS1v15(rsaKey, hash, h.Sum(nil), blob)
+		},
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+)
+
+const testSigAlgoRSAPSS = "rsa-pss-sha256@example.com"
+
+// rsaPSSAlgorithm is an experimental RSA-PSS signature algorithm.
+var rsaPSSAlgorithm = SignatureAlgorithm{
+	KeyType: KeyAlgoRSA,
+	Sign: func(signer crypto.Signer, rand io.Reader, data []byte) ([]byte, error) {
+		digest := sha256.Sum256(data)
+		return signer.Sign(rand, digest[:], &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+			Hash:       crypto.SHA256,
+		})
+	},
+	Verify: func(key crypto.PublicKey, data, blob []byte) error {
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("not an RSA key")
+		}
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPSS(rsaKey, crypto.SHA256, digest[:], blob, &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+		})
+	},
+}
+
+func TestSignatureAlgorithms(t *testing.T) {
+	algos := map[string]SignatureAlgorithm{testSigAlgoRSAPSS: rsaPSSAlgorithm}
+	signer, err := NewSignerWithAlgorithms(testPrivateKeys["rsa"].(crypto.Signer), algos)
+	if err != nil {
+		t.Fatalf("NewSignerWithAlgorithms: %v", err)
+	}
+	data := []byte("sign me")
+	sig, err := signer.SignWithAlgorithm(rand.Reader, data, testSigAlgoRSAPSS)
+	if err != nil {
+		t.Fatalf("SignWithAlgorithm: %v", err)
+	}
+	if sig.Format != testSigAlgoRSAPSS {
+		t.Errorf("got signature format %q, want %q", sig.Format, testSigAlgoRSAPSS)
+	}
+	pub := signer.PublicKey()
+	if err := verifySignature(algos, pub, data, sig); err != nil {
+		t.Errorf("verifySignature: %v", err)
+	}
+	if err := verifySignature(algos, pub, []byte("tampered"), sig); err == nil {
+		t.Error("verifySignature succeeded for tampered data")
+	}
+	// Without the algorithm, the signature is rejected.
+	if err := pub.Verify(data, sig); err == nil {
+		t.Error("Verify succeeded for an algorithm the key does not know")
+	}
+	if _, err := testSigners["rsa"].(AlgorithmSigner).SignWithAlgorithm(rand.Reader, data, testSigAlgoRSAPSS); err == nil {
+		t.Error("a signer without the algorithm signed with it")
+	}
+	// The PSS signature must not pass as a PKCS #1 v1.5 one.
+	if err := verifySignature(algos, pub, data, &Signature{Format: SigAlgoRSASHA2256, Blob: sig.Blob}); err == nil {
+		t.Error("PSS signature verified as rsa-sha2-256")
+	}
+
+	ecSigner, err := NewSignerWithAlgorithms(testPrivateKeys["ecdsa"].(crypto.Signer), algos)
+	if err != nil {
+		t.Fatalf("NewSignerWithAlgorithms: %v", err)
+	}
+	if _, err := ecSigner.SignWithAlgorithm(rand.Reader, data, testSigAlgoRSAPSS); err == nil {
+		t.Error("ECDSA key signed with an RSA signature algorithm")
+	}
+	if err := verifySignature(algos, ecSigner.PublicKey(), data, sig); err == nil {
+		t.Error("ECDSA key verified an RSA signature")
+	}
+
+	if !isAcceptableAlgo(algos, testSigAlgoRSAPSS) || !algoMatchesKey(algos, testSigAlgoRSAPSS, pub) {
+		t.Error("server does not accept the algorithm for RSA keys")
+	}
+	if isAcceptableAlgo(nil, testSigAlgoRSAPSS) {
+		t.Error("server accepts the algorithm without it being configured")
+	}
+	if algoMatchesKey(algos, testSigAlgoRSAPSS, ecSigner.PublicKey()) {
+		t.Error("server accepts the algorithm for ECDSA keys")
+	}
+
+	for _, name := range []string{KeyAlgoRSA, SigAlgoRSASHA2256, ""} {
+		if _, err := NewSignerWithAlgorithms(testPrivateKeys["rsa"].(crypto.Signer), map[string]SignatureAlgorithm{name: rsaPSSAlgorithm}); err == nil {
+			t.Errorf("algorithm named %q accepted", name)
+		}
+	}
+	if _, err := NewSignerWithAlgorithms(testPrivateKeys["rsa"].(crypto.Signer), map[string]SignatureAlgorithm{"incomplete@example.com": {KeyType: KeyAlgoRSA}}); err == nil {
+		t.Error("incomplete algorithm accepted")
+	}
+}
+
+func TestSignatureAlgorithmsAdvertised(t *testing.T) {
+	serverConfig := &ServerConfig{
+		NoClientAuth:        true,
+		SignatureAlgorithms: map[string]SignatureAlgorithm{testSigAlgoRSAPSS: rsaPSSAlgorithm},
+	}
+	serverConfig.AddHostKey(testSigners["ecdsa"])
+	client, _, _, _ := pipePair(t, serverConfig, testClientConfig())
+	if got := client.Conn.(*connection).transport.serverSignatureAlgorithms(); !contains(got, testSigAlgoRSAPSS) || !contains(got, SigAlgoRSASHA2512) {
+		t.Errorf("client received server-sig-algs %q", got)
+	}
+
+	serverConfig.SignatureAlgorithms = map[string]SignatureAlgorithm{SigAlgoRSA: rsaPSSAlgorithm}
+	if _, _, _, _, err := Pipe(serverConfig, testClientConfig()); err == nil {
+		t.Error("server accepted an algorithm replacing ssh-rsa")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"io"
+	"sync"
+)
+
+// NewCachingSigner returns a Signer that remembers the signatures made
+// by inner for the last size distinct inputs, and returns the same
+// signature again when asked to sign the same data with the same
+// algorithm, without calling inner. It is meant to avoid repeating slow
+// signing operations, such as the round trips to a hardware security
+// module, when the same authentication is retried in a loop. If inner
+// is an AlgorithmSigner, so is the returned Signer.
+//
+// WARNING: a cached signature is replayed and the rand argument of Sign
+// is ignored for cache hits. This is only acceptable where returning
+// the same signature for the same data is: it is, for deterministic
+// schemes such as RSA PKCS #1 v1.5 and Ed25519, and for data bound to a
+// single session, such as a user authentication request. Do not use a
+// caching Signer for data whose signature must be fresh. The data is
+// identified by its SHA-256 hash.
+//
+// A size less than one is treated as one.
+func NewCachingSigner(inner Signer, size int) Signer {
+	if size < 1 {
+		size = 1
+	}
+	s := &cachingSigner{
+		inner:   inner,
+		size:    size,
+		entries: make(map[signatureCacheKey]*list.Element),
+		lru:     list.New(),
+	}
+	if as, ok := inner.(AlgorithmSigner); ok {
+		return &cachingAlgorithmSigner{s, as}
+	}
+	return s
+}
+
+type signatureCacheKey struct {
+	algorithm string
+	digest    [sha256.Size]byte
+}
+
+type signatureCacheEntry struct {
+	key signatureCacheKey
+	sig *Signature
+}
+
+type cachingSigner struct {
+	inner Signer
+	size  int
+
+	mu      sync.Mutex
+	entries map[signatureCacheKey]*list.Element
+	lru     *list.List // of *signatureCacheEntry, most recent first
+}
+
+func (s *cachingSigner) PublicKey() PublicKey {
+	return s.inner.PublicKey()
+}
+
+func (s *cachingSigner) Sign(rand io.Reader, data []byte) (*Signature, error) {
+	return s.sign(data, "", func() (*Signature, error) {
+		return s.inner.Sign(rand, data)
+	})
+}
+
+func (s *cachingSigner) sign(data []byte, algorithm string, sign func() (*Signature, error)) (*Signature, error) {
+	key := signatureCacheKey{algorithm, sha256.Sum256(data)}
+	s.mu.Lock()
+	if e, ok := s.entries[key]; ok {
+		s.lru.MoveToFront(e)
+		sig := e.Value.(*signatureCacheEntry).sig
+		s.mu.Unlock()
+		return copySignature(sig), nil
+	}
+	s.mu.Unlock()
+
+	// The lock is not held while signing, which may be slow. Concurrent
+	// calls for the same data may thus both reach inner.
+	sig, err := sign()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		s.lru.MoveToFront(e)
+	} else {
+		s.entries[key] = s.lru.PushFront(&signatureCacheEntry{key, copySignature(sig)})
+		for s.lru.Len() > s.size {
+			oldest := s.lru.Remove(s.lru.Back()).(*signatureCacheEntry)
+			delete(s.entries, oldest.key)
+		}
+	}
+	return sig, nil
+}
+
+type cachingAlgorithmSigner struct {
+	*cachingSigner
+	inner AlgorithmSigner
+}
+
+func (s *cachingAlgorithmSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*Signature, error) {
+	return s.sign(data, algorithm, func() (*Signature, error) {
+		return s.inner.SignWithAlgorithm(rand, data, algorithm)
+	})
+}
+
+// copySignature returns a deep copy of sig, so that callers can't
+// modify the cached signature.
+func copySignature(sig *Signature) *Signature {
+	c := &Signature{
+		Format: sig.Format,
+		Blob:   append([]byte(nil), sig.Blob...),
+	}
+	if sig.Rest != nil {
+		c.Rest = append([]byte(nil), sig.Rest...)
+	}
+	return c
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+type countingSigner struct {
+	AlgorithmSigner
+	calls int
+}
+
+func (s *countingSigner) Sign(rand io.Reader, data []byte) (*Signature, error) {
+	s.calls++
+	return s.AlgorithmSigner.Sign(rand, data)
+}
+
+func (s *countingSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*Signature, error) {
+	s.calls++
+	return s.AlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
+
+func TestCachingSigner(t *testing.T) {
+	inner := &countingSigner{AlgorithmSigner: testSigners["rsa"].(AlgorithmSigner)}
+	s := NewCachingSigner(inner, 2)
+	as, ok := s.(AlgorithmSigner)
+	if !ok {
+		t.Fatal("caching signer of an AlgorithmSigner is not an AlgorithmSigner")
+	}
+
+	data := []byte("challenge")
+	first, err := s.Sign(rand.Reader, data)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		sig, err := s.Sign(rand.Reader, data)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		if sig.Format != first.Format || !bytes.Equal(sig.Blob, first.Blob) {
+			t.Fatalf("got signature %v, want the cached %v", sig, first)
+		}
+	}
+	if inner.calls != 1 {
+		t.Errorf("inner signer called %d times for identical inputs, want 1", inner.calls)
+	}
+	// Modifying a returned signature must not affect the cache.
+	first.Blob[0] ^= 0xff
+	if sig, _ := s.Sign(rand.Reader, data); bytes.Equal(sig.Blob, first.Blob) {
+		t.Error("cached signature was modified through a returned copy")
+	}
+
+	// Another algorithm is another input.
+	sig, err := as.SignWithAlgorithm(rand.Reader, data, SigAlgoRSASHA2256)
+	if err != nil {
+		t.Fatalf("SignWithAlgorithm: %v", err)
+	}
+	if sig.Format != SigAlgoRSASHA2256 {
+		t.Errorf("got format %q, want %q", sig.Format, SigAlgoRSASHA2256)
+	}
+	if err := s.PublicKey().Verify(data, sig); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if inner.calls != 2 {
+		t.Errorf("got %d calls, want 2", inner.calls)
+	}
+
+	// With a size of 2, the third input evicts the least recently
+	// used, which is now the SHA-1 signature of data.
+	if _, err := s.Sign(rand.Reader, []byte("other")); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, err := as.SignWithAlgorithm(rand.Reader, data, SigAlgoRSASHA2256); err != nil {
+		t.Fatalf("SignWithAlgorithm: %v", err)
+	}
+	if inner.calls != 3 {
+		t.Errorf("got %d calls, want 3", inner.calls)
+	}
+	if _, err := s.Sign(rand.Reader, data); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if inner.calls != 4 {
+		t.Errorf("got %d calls after eviction, want 4", inner.calls)
+	}
+}
+
+func TestCachingSignerNotAlgorithmSigner(t *testing.T) {
+	s := NewCachingSigner(struct{ Signer }{testSigners["ed25519"]}, 1)
+	if _, ok := s.(AlgorithmSigner); ok {
+		t.Error("caching signer of a plain Signer is an AlgorithmSigner")
+	}
+}
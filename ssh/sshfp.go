@@ -0,0 +1,387 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// SSHFP algorithm numbers and fingerprint types, from RFC 4255,
+// RFC 6594 and RFC 7479.
+const (
+	sshfpAlgoRSA     = 1
+	sshfpAlgoDSA     = 2
+	sshfpAlgoECDSA   = 3
+	sshfpAlgoED25519 = 4
+
+	sshfpTypeSHA256 = 2
+)
+
+const (
+	dnsTypeCNAME = 5
+	dnsTypeSSHFP = 44
+	dnsTypeOPT   = 41
+	dnsClassIN   = 1
+
+	dnsFlagResponse  = 1 << 15
+	dnsFlagTruncated = 1 << 9
+	dnsFlagRecurse   = 1 << 8
+	dnsFlagAD        = 1 << 5
+	dnsFlagDO        = 1 << 15
+
+	dnsRcodeNameError = 3
+)
+
+// dnsUDPSize is the size of the UDP answers the lookup accepts.
+const dnsUDPSize = 4096
+
+// sshfpTimeout bounds the lookup done by the callback of SSHFPCallback.
+const sshfpTimeout = 10 * time.Second
+
+// resolvConf is the file the name server of SSHFP lookups is read from.
+var resolvConf = "/etc/resolv.conf"
+
+type sshfpRecord struct {
+	Algorithm   uint8
+	Type        uint8
+	Fingerprint []byte
+}
+
+// SSHFPCallback returns a HostKeyCallback that verifies host keys
+// against the SSHFP records of the host name, as described in RFC 4255,
+// like the VerifyHostKeyDNS option of OpenSSH. The key is accepted if
+// the SHA-256 fingerprint of an SSHFP record for its algorithm matches
+// it; records with other fingerprint types are ignored. Certificates
+// are not supported, and host names that are IP addresses are
+// rejected.
+//
+// The records are queried over UDP, and over TCP if the UDP answer is
+// truncated, from the first name server of /etc/resolv.conf; the
+// callback fails if there is none, as on systems without that file. As
+// net.Resolver can't look up SSHFP records, only the Dial field of
+// resolver is used: the callback dials the name server with it, or
+// with a net.Dialer if resolver or its Dial field is nil.
+//
+// If requireDNSSEC is true, the answer must have the "authenticated
+// data" flag set by a validating name server, and keys that only match
+// unauthenticated records are rejected. That flag can't be trusted
+// more than the path to the name server, which should be a local
+// resolver.
+func SSHFPCallback(resolver *net.Resolver, requireDNSSEC bool) HostKeyCallback {
+	return func(hostname string, remote net.Addr, key PublicKey) error {
+		algo, ok := sshfpAlgorithm(key)
+		if !ok {
+			return fmt.Errorf("ssh: SSHFP records can't describe %s host keys", key.Type())
+		}
+		host, _, err := net.SplitHostPort(hostname)
+		if err != nil {
+			host = hostname
+		}
+		if net.ParseIP(host) != nil {
+			return fmt.Errorf("ssh: can't look up SSHFP records for IP address %s", host)
+		}
+
+		nameserver, err := systemNameserver()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), sshfpTimeout)
+		defer cancel()
+		records, authenticated, err := lookupSSHFP(ctx, resolver, nameserver, host)
+		if err != nil {
+			return err
+		}
+		if requireDNSSEC && !authenticated {
+			return fmt.Errorf("ssh: SSHFP records of %s are not authenticated by DNSSEC", host)
+		}
+
+		fp := sha256.Sum256(key.Marshal())
+		var found bool
+		for _, r := range records {
+			if r.Algorithm != algo || r.Type != sshfpTypeSHA256 {
+				continue
+			}
+			found = true
+			if subtle.ConstantTimeCompare(r.Fingerprint, fp[:]) == 1 {
+				return nil
+			}
+		}
+		if !found {
+			return fmt.Errorf("ssh: no SHA-256 SSHFP record of %s for %s host keys", host, key.Type())
+		}
+		return fmt.Errorf("ssh: host key of %s does not match its SSHFP records", host)
+	}
+}
+
+func sshfpAlgorithm(key PublicKey) (uint8, bool) {
+	switch key.Type() {
+	case KeyAlgoRSA:
+		return sshfpAlgoRSA, true
+	case KeyAlgoDSA:
+		return sshfpAlgoDSA, true
+	case KeyAlgoECDSA256, KeyAlgoECDSA384, KeyAlgoECDSA521:
+		return sshfpAlgoECDSA, true
+	case KeyAlgoED25519:
+		return sshfpAlgoED25519, true
+	}
+	return 0, false
+}
+
+// systemNameserver returns the address of the first name server of
+// resolvConf.
+func systemNameserver() (string, error) {
+	conf, err := ioutil.ReadFile(resolvConf)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(conf))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) >= 2 && f[0] == "nameserver" && net.ParseIP(f[1]) != nil {
+			return net.JoinHostPort(f[1], "53"), nil
+		}
+	}
+	return "", errors.New("ssh: no name server configured to look up SSHFP records")
+}
+
+// lookupSSHFP queries nameserver for the SSHFP records of host, and
+// reports whether the name server authenticated them.
+func lookupSSHFP(ctx context.Context, resolver *net.Resolver, nameserver, host string) ([]sshfpRecord, bool, error) {
+	dial := (&net.Dialer{}).DialContext
+	if resolver != nil && resolver.Dial != nil {
+		dial = resolver.Dial
+	}
+	host = strings.TrimSuffix(host, ".")
+	for _, network := range []string{"udp", "tcp"} {
+		var idBuf [2]byte
+		if _, err := io.ReadFull(rand.Reader, idBuf[:]); err != nil {
+			return nil, false, err
+		}
+		id := binary.BigEndian.Uint16(idBuf[:])
+		query, err := buildSSHFPQuery(id, host)
+		if err != nil {
+			return nil, false, err
+		}
+		resp, err := exchangeDNS(ctx, dial, network, nameserver, query)
+		if err != nil {
+			return nil, false, err
+		}
+		records, authenticated, truncated, err := parseSSHFPResponse(resp, id, host)
+		if err != nil || !truncated {
+			return records, authenticated, err
+		}
+	}
+	return nil, false, errors.New("ssh: truncated DNS response over TCP")
+}
+
+// exchangeDNS sends query to nameserver over network, "udp" or "tcp",
+// and returns the response.
+func exchangeDNS(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), network, nameserver string, query []byte) ([]byte, error) {
+	conn, err := dial(ctx, network, nameserver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		resp := make([]byte, dnsUDPSize)
+		n, err := conn.Read(resp)
+		if err != nil {
+			return nil, err
+		}
+		return resp[:n], nil
+	}
+
+	framed := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(framed, uint16(len(query)))
+	copy(framed[2:], query)
+	if _, err := conn.Write(framed); err != nil {
+		return nil, err
+	}
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(conn, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// buildSSHFPQuery returns a recursive query for the SSHFP records of
+// host, asking for DNSSEC validation with the AD flag and the DO flag of
+// EDNS(0), see RFC 6840 and RFC 3225.
+func buildSSHFPQuery(id uint16, host string) ([]byte, error) {
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagRecurse|dnsFlagAD)
+	binary.BigEndian.PutUint16(msg[4:], 1)  // questions
+	binary.BigEndian.PutUint16(msg[10:], 1) // additional records
+
+	if len(host) == 0 || len(host) > 253 {
+		return nil, fmt.Errorf("ssh: invalid host name %q", host)
+	}
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("ssh: invalid host name %q", host)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = appendU16(msg, dnsTypeSSHFP)
+	msg = appendU16(msg, dnsClassIN)
+
+	// The OPT record, see RFC 6891.
+	msg = append(msg, 0) // root name
+	msg = appendU16(msg, dnsTypeOPT)
+	msg = appendU16(msg, dnsUDPSize)
+	msg = append(msg, 0, 0) // extended rcode and version
+	msg = appendU16(msg, dnsFlagDO)
+	msg = appendU16(msg, 0) // no options
+	return msg, nil
+}
+
+// parseSSHFPResponse parses the response to the query with the given id
+// for host. It only returns the SSHFP records of host, or of the names
+// its CNAME records lead to. A non-existent name has no records.
+func parseSSHFPResponse(msg []byte, id uint16, host string) (records []sshfpRecord, authenticated, truncated bool, err error) {
+	errMalformed := errors.New("ssh: malformed DNS response")
+	if len(msg) < 12 {
+		return nil, false, false, errMalformed
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if binary.BigEndian.Uint16(msg[0:]) != id || flags&dnsFlagResponse == 0 {
+		return nil, false, false, errors.New("ssh: unexpected DNS response")
+	}
+	if flags&dnsFlagTruncated != 0 {
+		return nil, false, true, nil
+	}
+	authenticated = flags&dnsFlagAD != 0
+
+	if binary.BigEndian.Uint16(msg[4:]) != 1 {
+		return nil, false, false, errors.New("ssh: DNS response does not answer the query")
+	}
+	qname, off, ok := readDNSName(msg, 12)
+	if !ok || off+4 > len(msg) {
+		return nil, false, false, errMalformed
+	}
+	if !strings.EqualFold(qname, host) || binary.BigEndian.Uint16(msg[off:]) != dnsTypeSSHFP || binary.BigEndian.Uint16(msg[off+2:]) != dnsClassIN {
+		return nil, false, false, errors.New("ssh: DNS response does not answer the query")
+	}
+	off += 4
+
+	switch rcode := flags & 0xf; rcode {
+	case 0:
+	case dnsRcodeNameError:
+		return nil, authenticated, false, nil
+	default:
+		return nil, false, false, fmt.Errorf("ssh: DNS query for SSHFP records failed with rcode %d", rcode)
+	}
+
+	name := host
+	answers := binary.BigEndian.Uint16(msg[6:])
+	for i := 0; i < int(answers); i++ {
+		var owner string
+		if owner, off, ok = readDNSName(msg, off); !ok || off+10 > len(msg) {
+			return nil, false, false, errMalformed
+		}
+		typ := binary.BigEndian.Uint16(msg[off:])
+		class := binary.BigEndian.Uint16(msg[off+2:])
+		length := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+length > len(msg) {
+			return nil, false, false, errMalformed
+		}
+		data := msg[off : off+length]
+		start := off
+		off += length
+		if class != dnsClassIN || !strings.EqualFold(owner, name) {
+			continue
+		}
+		switch typ {
+		case dnsTypeCNAME:
+			// The name may be compressed against the whole message.
+			cname, end, ok := readDNSName(msg, start)
+			if !ok || end != off {
+				return nil, false, false, errMalformed
+			}
+			name = cname
+		case dnsTypeSSHFP:
+			if len(data) < 2 {
+				return nil, false, false, errMalformed
+			}
+			records = append(records, sshfpRecord{
+				Algorithm:   data[0],
+				Type:        data[1],
+				Fingerprint: data[2:],
+			})
+		}
+		// Other records, like the RRSIG records of the answer, are
+		// skipped.
+	}
+	return records, authenticated, false, nil
+}
+
+// readDNSName returns the possibly compressed name at off, without its
+// trailing dot, and the offset after it.
+func readDNSName(msg []byte, off int) (name string, next int, ok bool) {
+	var labels []string
+	next = -1
+	// Each pointer must go backwards, so that the name ends.
+	limit := off
+	for off < len(msg) {
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, true
+		case n&0xc0 == 0xc0:
+			if off+2 > len(msg) {
+				return "", 0, false
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			ptr := int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			if ptr >= limit {
+				return "", 0, false
+			}
+			off, limit = ptr, ptr
+			continue
+		case n&0xc0 != 0:
+			return "", 0, false
+		}
+		if off+1+n > len(msg) {
+			return "", 0, false
+		}
+		labels = append(labels, string(msg[off+1:off+1+n]))
+		off += 1 + n
+	}
+	return "", 0, false
+}
@@ -0,0 +1,292 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type sshfpServer struct {
+	records       []sshfpRecord
+	authenticated bool
+	rcode         uint16
+	// cname, if set, is the name the records are under, with a CNAME
+	// record leading to it.
+	cname string
+	// truncateUDP makes the UDP answers truncated.
+	truncateUDP bool
+	// otherQuestion makes the answer be for another name.
+	otherQuestion bool
+
+	queries []string // the networks and queried names
+}
+
+// resolver returns a resolver whose connections are answered by s, and
+// makes 192.0.2.53 the name server of the lookups.
+func (s *sshfpServer) resolver(t *testing.T) *net.Resolver {
+	setResolvConf(t, "search example.com\nnameserver 192.0.2.53\nnameserver 192.0.2.54\n")
+	return &net.Resolver{
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if address != "192.0.2.53:53" {
+				t.Errorf("dialed %s, want 192.0.2.53:53", address)
+			}
+			c1, c2 := net.Pipe()
+			go s.serve(t, network, c2)
+			return c1, nil
+		},
+	}
+}
+
+// setResolvConf makes the lookups read conf instead of /etc/resolv.conf.
+func setResolvConf(t *testing.T, conf string) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := resolvConf
+	resolvConf = path
+	t.Cleanup(func() { resolvConf = old })
+}
+
+// appendDNSName appends the uncompressed encoding of name.
+func appendDNSName(b []byte, name string) []byte {
+	for _, label := range strings.Split(name, ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// appendDNSRecord appends a record of name without its trailing dot.
+func appendDNSRecord(b []byte, name string, typ uint16, data []byte) []byte {
+	b = appendDNSName(b, name)
+	b = appendU16(b, typ)
+	b = appendU16(b, dnsClassIN)
+	b = appendU32(b, 60)
+	b = appendU16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+// serve answers a query on c. Over net.Pipe, each write is read at
+// once, like a UDP datagram.
+func (s *sshfpServer) serve(t *testing.T, network string, c net.Conn) {
+	defer c.Close()
+	var query []byte
+	if network == "udp" {
+		buf := make([]byte, 512)
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Errorf("reading query: %v", err)
+			return
+		}
+		query = buf[:n]
+	} else {
+		var lenBuf [2]byte
+		if _, err := io.ReadFull(c, lenBuf[:]); err != nil {
+			t.Errorf("reading query: %v", err)
+			return
+		}
+		query = make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+		if _, err := io.ReadFull(c, query); err != nil {
+			t.Errorf("reading query: %v", err)
+			return
+		}
+	}
+
+	if len(query) < 12 || binary.BigEndian.Uint16(query[4:]) != 1 {
+		t.Errorf("malformed query %x", query)
+		return
+	}
+	qname, off, ok := readDNSName(query, 12)
+	if !ok || off+4 > len(query) {
+		t.Errorf("malformed query %x", query)
+		return
+	}
+	s.queries = append(s.queries, network+" "+qname)
+	if typ := binary.BigEndian.Uint16(query[off:]); typ != dnsTypeSSHFP {
+		t.Errorf("queried type %d, want SSHFP", typ)
+	}
+	// The query must end with an OPT record with the DO flag.
+	flags := binary.BigEndian.Uint16(query[2:])
+	opt := query[off+4:]
+	if flags&dnsFlagRecurse == 0 || flags&dnsFlagAD == 0 || binary.BigEndian.Uint16(query[10:]) != 1 ||
+		len(opt) != 11 || binary.BigEndian.Uint16(opt[1:]) != dnsTypeOPT || binary.BigEndian.Uint16(opt[7:])&dnsFlagDO == 0 {
+		t.Errorf("query %x does not ask for a recursive DNSSEC answer", query)
+	}
+
+	respFlags := uint16(dnsFlagResponse|dnsFlagRecurse|1<<7) | s.rcode
+	if s.authenticated {
+		respFlags |= dnsFlagAD
+	}
+	truncated := network == "udp" && s.truncateUDP
+	if truncated {
+		respFlags |= dnsFlagTruncated
+	}
+	resp := appendU16(query[:2:2], respFlags)
+	resp = appendU16(resp, 1) // questions
+	resp = appendU16(resp, 0) // answers, set below
+	resp = appendU32(resp, 0) // authority and additional records
+	if s.otherQuestion {
+		resp = appendDNSName(resp, "other.example.com")
+	} else {
+		resp = appendDNSName(resp, qname)
+	}
+	resp = appendU16(resp, dnsTypeSSHFP)
+	resp = appendU16(resp, dnsClassIN)
+
+	var answers uint16
+	owner := qname
+	if s.cname != "" {
+		// The CNAME ends with a pointer to the "example.com" of
+		// the question name, at offset 17.
+		cname := appendDNSName(nil, strings.TrimSuffix(s.cname, ".example.com"))
+		cname = append(cname[:len(cname)-1], 0xc0, 17)
+		resp = appendDNSRecord(resp, owner, dnsTypeCNAME, cname)
+		answers++
+		owner = s.cname
+	}
+	if !truncated {
+		// A bogus RRSIG record, which must be skipped, and a
+		// record of another name, which must be ignored.
+		resp = appendDNSRecord(resp, owner, 46, []byte{1, 2, 3})
+		other := sha256.Sum256(testPublicKeys["ed25519"].Marshal())
+		resp = appendDNSRecord(resp, "attacker.example.com", dnsTypeSSHFP, append([]byte{sshfpAlgoED25519, sshfpTypeSHA256}, other[:]...))
+		answers += 2
+		for _, r := range s.records {
+			resp = appendDNSRecord(resp, owner, dnsTypeSSHFP, append([]byte{r.Algorithm, r.Type}, r.Fingerprint...))
+			answers++
+		}
+	}
+	binary.BigEndian.PutUint16(resp[6:], answers)
+	if network == "tcp" {
+		resp = append(appendU16(nil, uint16(len(resp))), resp...)
+	}
+	if _, err := c.Write(resp); err != nil {
+		t.Errorf("writing response: %v", err)
+	}
+}
+
+func TestSSHFPCallback(t *testing.T) {
+	key := testPublicKeys["ed25519"]
+	sha256FP := sha256.Sum256(key.Marshal())
+	sha1FP := sha1.Sum(key.Marshal())
+	otherFP := sha256.Sum256(testPublicKeys["rsa"].Marshal())
+	addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+
+	for _, tt := range []struct {
+		name          string
+		records       []sshfpRecord
+		authenticated bool
+		rcode         uint16
+		cname         string
+		otherQuestion bool
+		truncateUDP   bool
+		requireDNSSEC bool
+		wantErr       string
+	}{{
+		name:          "authenticated match",
+		records:       []sshfpRecord{{sshfpAlgoRSA, sshfpTypeSHA256, otherFP[:]}, {sshfpAlgoED25519, sshfpTypeSHA256, sha256FP[:]}},
+		authenticated: true,
+		requireDNSSEC: true,
+	}, {
+		name:    "unauthenticated match",
+		records: []sshfpRecord{{sshfpAlgoED25519, sshfpTypeSHA256, sha256FP[:]}},
+	}, {
+		name:    "match through CNAME",
+		records: []sshfpRecord{{sshfpAlgoED25519, sshfpTypeSHA256, sha256FP[:]}},
+		cname:   "alias.example.com",
+	}, {
+		name:        "match over TCP",
+		records:     []sshfpRecord{{sshfpAlgoED25519, sshfpTypeSHA256, sha256FP[:]}},
+		truncateUDP: true,
+	}, {
+		name:    "records of another name only",
+		wantErr: "no SHA-256 SSHFP record",
+	}, {
+		name:          "answer to another question",
+		records:       []sshfpRecord{{sshfpAlgoED25519, sshfpTypeSHA256, sha256FP[:]}},
+		otherQuestion: true,
+		wantErr:       "does not answer",
+	}, {
+		name:          "DNSSEC required",
+		records:       []sshfpRecord{{sshfpAlgoED25519, sshfpTypeSHA256, sha256FP[:]}},
+		requireDNSSEC: true,
+		wantErr:       "not authenticated",
+	}, {
+		name:          "mismatch",
+		records:       []sshfpRecord{{sshfpAlgoED25519, sshfpTypeSHA256, otherFP[:]}},
+		authenticated: true,
+		wantErr:       "does not match",
+	}, {
+		name:    "SHA-1 only",
+		records: []sshfpRecord{{sshfpAlgoED25519, 1, sha1FP[:]}},
+		wantErr: "no SHA-256 SSHFP record",
+	}, {
+		name:    "other algorithm",
+		records: []sshfpRecord{{sshfpAlgoRSA, sshfpTypeSHA256, sha256FP[:]}},
+		wantErr: "no SHA-256 SSHFP record",
+	}, {
+		name:          "non-existent name",
+		rcode:         dnsRcodeNameError,
+		authenticated: true,
+		wantErr:       "no SHA-256 SSHFP record",
+	}, {
+		name:    "server failure",
+		rcode:   2,
+		wantErr: "rcode 2",
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &sshfpServer{records: tt.records, authenticated: tt.authenticated, rcode: tt.rcode, cname: tt.cname, otherQuestion: tt.otherQuestion, truncateUDP: tt.truncateUDP}
+			err := SSHFPCallback(srv.resolver(t), tt.requireDNSSEC)("host.example.com:22", addr, key)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got %v, want success", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+			want := []string{"udp host.example.com"}
+			if tt.truncateUDP {
+				want = append(want, "tcp host.example.com")
+			}
+			if !reflect.DeepEqual(srv.queries, want) {
+				t.Errorf("got queries %q, want %q", srv.queries, want)
+			}
+		})
+	}
+}
+
+func TestSSHFPCallbackRejects(t *testing.T) {
+	srv := &sshfpServer{}
+	callback := SSHFPCallback(srv.resolver(t), false)
+	addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+
+	if err := callback("192.0.2.1:22", addr, testPublicKeys["ed25519"]); err == nil {
+		t.Error("callback accepted an IP address host name")
+	}
+	cert := &Certificate{Key: testPublicKeys["ed25519"], CertType: HostCert}
+	cert.SignCert(rand.Reader, testSigners["ecdsa"])
+	if err := callback("host.example.com:22", addr, cert); err == nil {
+		t.Error("callback accepted a certificate")
+	}
+	setResolvConf(t, "search example.com\n")
+	if err := callback("host.example.com:22", addr, testPublicKeys["ed25519"]); err == nil || !strings.Contains(err.Error(), "no name server") {
+		t.Errorf("callback without a name server: got %v", err)
+	}
+	if len(srv.queries) != 0 {
+		t.Errorf("got queries %q, want none", srv.queries)
+	}
+}
@@ -95,16 +95,7 @@ func (l *unixListener) Accept() (net.Conn, error) {
 
 // Close closes the listener.
 func (l *unixListener) Close() error {
-	// this also closes the listener.
-	l.conn.forwards.remove(&net.UnixAddr{Name: l.socketPath, Net: "unix"})
-	m := streamLocalChannelForwardMsg{
-		l.socketPath,
-	}
-	ok, _, err := l.conn.SendRequest("cancel-streamlocal-forward@openssh.com", true, Marshal(&m))
-	if err == nil && !ok {
-		err = errors.New("ssh: cancel-streamlocal-forward@openssh.com failed")
-	}
-	return err
+	return l.conn.cancelForward(&net.UnixAddr{Name: l.socketPath, Net: "unix"})
 }
 
 // Addr returns the listener's network address.
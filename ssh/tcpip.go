@@ -36,6 +36,23 @@ func (c *Client) Listen(n, addr string) (net.Listener, error) {
 	}
 }
 
+// ListenTCPWithAssignedPort is like Listen for the "tcp" network, and
+// also returns the port the server listens on. It is the port of addr,
+// or, if that is 0, the port the server assigned and sent in its reply
+// to the tcpip-forward request; a reply without a valid port is an
+// error.
+func (c *Client) ListenTCPWithAssignedPort(addr string) (net.Listener, uint32, error) {
+	laddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	l, err := c.ListenTCP(laddr)
+	if err != nil {
+		return nil, 0, err
+	}
+	return l, uint32(l.Addr().(*net.TCPAddr).Port), nil
+}
+
 // Automatic port allocation is broken with OpenSSH before 6.0. See
 // also https://bugzilla.mindrot.org/show_bug.cgi?id=2017.  In
 // particular, OpenSSH 5.9 sends a channelOpenMsg with port number 0,
@@ -84,12 +101,6 @@ func (c *Client) autoPortListenWorkaround(laddr *net.TCPAddr) (net.Listener, err
 	return nil, fmt.Errorf("ssh: listen on random port failed after %d tries: %v", tries, err)
 }
 
-// RFC 4254 7.1
-type channelForwardMsg struct {
-	addr  string
-	rport uint32
-}
-
 // handleForwards starts goroutines handling forwarded connections.
 // It's called on first use by (*Client).ListenTCP to not launch
 // goroutines until needed.
@@ -101,15 +112,27 @@ func (c *Client) handleForwards() {
 // ListenTCP requests the remote peer open a listening socket
 // on laddr. Incoming connections will be available by calling
 // Accept on the returned net.Listener.
+//
+// The address sent in the tcpip-forward request is laddr.IP in
+// textual form, or "0.0.0.0" if laddr.IP is nil. Per RFC 4254 section
+// 7.1 the server decides which interfaces are actually bound: OpenSSH,
+// for example, binds only the loopback interface unless its
+// GatewayPorts option permits otherwise, regardless of the requested
+// address. If laddr.Port is 0, the server allocates a port and the
+// returned listener's Addr reports it. laddr itself is not modified.
 func (c *Client) ListenTCP(laddr *net.TCPAddr) (net.Listener, error) {
 	c.handleForwardsOnce.Do(c.handleForwards)
-	if laddr.Port == 0 && isBrokenOpenSSHVersion(string(c.ServerVersion())) {
-		return c.autoPortListenWorkaround(laddr)
+	addr := *laddr
+	if addr.IP == nil {
+		addr.IP = net.IPv4zero
+	}
+	if addr.Port == 0 && isBrokenOpenSSHVersion(string(c.ServerVersion())) {
+		return c.autoPortListenWorkaround(&addr)
 	}
 
-	m := channelForwardMsg{
-		laddr.IP.String(),
-		uint32(laddr.Port),
+	m := TCPIPForwardRequest{
+		addr.IP.String(),
+		uint32(addr.Port),
 	}
 	// send message
 	ok, resp, err := c.SendRequest("tcpip-forward", true, Marshal(&m))
@@ -122,20 +145,21 @@ func (c *Client) ListenTCP(laddr *net.TCPAddr) (net.Listener, error) {
 
 	// If the original port was 0, then the remote side will
 	// supply a real port number in the response.
-	if laddr.Port == 0 {
-		var p struct {
-			Port uint32
-		}
+	if addr.Port == 0 {
+		var p TCPIPForwardResponse
 		if err := Unmarshal(resp, &p); err != nil {
 			return nil, err
 		}
-		laddr.Port = int(p.Port)
+		if p.BindPort == 0 || p.BindPort > 65535 {
+			return nil, fmt.Errorf("ssh: tcpip-forward response has invalid port %d", p.BindPort)
+		}
+		addr.Port = int(p.BindPort)
 	}
 
 	// Register this forward, using the port number we obtained.
-	ch := c.forwards.add(laddr)
+	ch := c.forwards.add(&addr)
 
-	return &tcpListener{laddr, c, ch}, nil
+	return &tcpListener{&addr, c, ch}, nil
 }
 
 // forwardList stores a mapping between remote
@@ -171,14 +195,6 @@ func (l *forwardList) add(addr net.Addr) chan forward {
 	return f.c
 }
 
-// See RFC 4254, section 7.2
-type forwardedTCPPayload struct {
-	Addr       string
-	Port       uint32
-	OriginAddr string
-	OriginPort uint32
-}
-
 // parseTCPAddr parses the originating address from the remote into a *net.TCPAddr.
 func parseTCPAddr(addr string, port uint32) (*net.TCPAddr, error) {
 	if port == 0 || port > 65535 {
@@ -200,7 +216,7 @@ func (l *forwardList) handleChannels(in <-chan NewChannel) {
 		)
 		switch channelType := ch.ChannelType(); channelType {
 		case "forwarded-tcpip":
-			var payload forwardedTCPPayload
+			var payload ForwardedTCPIPPayload
 			if err = Unmarshal(ch.ExtraData(), &payload); err != nil {
 				ch.Reject(ConnectionFailed, "could not parse forwarded-tcpip payload: "+err.Error())
 				continue
@@ -250,17 +266,29 @@ func (l *forwardList) handleChannels(in <-chan NewChannel) {
 }
 
 // remove removes the forward entry, and the channel feeding its
-// listener.
-func (l *forwardList) remove(addr net.Addr) {
+// listener. It reports whether there was an entry for addr.
+func (l *forwardList) remove(addr net.Addr) bool {
 	l.Lock()
 	defer l.Unlock()
 	for i, f := range l.entries {
 		if addr.Network() == f.laddr.Network() && addr.String() == f.laddr.String() {
 			l.entries = append(l.entries[:i], l.entries[i+1:]...)
 			close(f.c)
-			return
+			return true
 		}
 	}
+	return false
+}
+
+// list returns the addresses of the forward entries.
+func (l *forwardList) list() []net.Addr {
+	l.Lock()
+	defer l.Unlock()
+	addrs := make([]net.Addr, 0, len(l.entries))
+	for _, f := range l.entries {
+		addrs = append(addrs, f.laddr)
+	}
+	return addrs
 }
 
 // closeAll closes and clears all forwards.
@@ -285,6 +313,81 @@ func (l *forwardList) forward(laddr, raddr net.Addr, ch NewChannel) bool {
 	return false
 }
 
+// ForwardInfo describes a forward that the remote host listens on
+// for the client, as set up by Listen, ListenTCP or ListenUnix.
+type ForwardInfo struct {
+	// Network is "tcp" for tcpip-forward requests, and "unix" for
+	// streamlocal-forward@openssh.com requests.
+	Network string
+
+	// Addr is the address the remote host listens on, as returned by
+	// Addr of the listener.
+	Addr string
+}
+
+// Forwards returns the active forwards of the client, in the order in
+// which they were set up.
+func (c *Client) Forwards() []ForwardInfo {
+	var forwards []ForwardInfo
+	for _, addr := range c.forwards.list() {
+		forwards = append(forwards, ForwardInfo{Network: addr.Network(), Addr: addr.String()})
+	}
+	return forwards
+}
+
+// CancelForward asks the remote host to stop listening on addr, which
+// must identify an active forward as reported by Forwards. It closes
+// the listener of the forward, whose Accept then returns io.EOF.
+// N must be "tcp", "tcp4", "tcp6", or "unix".
+func (c *Client) CancelForward(n, addr string) error {
+	switch n {
+	case "tcp", "tcp4", "tcp6":
+		laddr, err := net.ResolveTCPAddr(n, addr)
+		if err != nil {
+			return err
+		}
+		if laddr.IP == nil {
+			laddr.IP = net.IPv4zero
+		}
+		return c.cancelForward(laddr)
+	case "unix":
+		return c.cancelForward(&net.UnixAddr{Name: addr, Net: "unix"})
+	default:
+		return fmt.Errorf("ssh: unsupported protocol: %s", n)
+	}
+}
+
+// cancelForward removes the forward entry of laddr and sends the
+// request that cancels it. Only the caller that removes the entry
+// sends the request, so closing a listener concurrently with
+// CancelForward cancels the forward once.
+func (c *Client) cancelForward(laddr net.Addr) error {
+	// this also closes the listener.
+	if !c.forwards.remove(laddr) {
+		return fmt.Errorf("ssh: no active forward for %s address %s", laddr.Network(), laddr)
+	}
+	var reqType string
+	var payload []byte
+	switch laddr := laddr.(type) {
+	case *net.TCPAddr:
+		reqType = "cancel-tcpip-forward"
+		payload = Marshal(&TCPIPForwardRequest{
+			laddr.IP.String(),
+			uint32(laddr.Port),
+		})
+	case *net.UnixAddr:
+		reqType = "cancel-streamlocal-forward@openssh.com"
+		payload = Marshal(&streamLocalChannelForwardMsg{laddr.Name})
+	default:
+		return fmt.Errorf("ssh: unsupported forward address %v", laddr)
+	}
+	ok, _, err := c.SendRequest(reqType, true, payload)
+	if err == nil && !ok {
+		err = errors.New("ssh: " + reqType + " failed")
+	}
+	return err
+}
+
 type tcpListener struct {
 	laddr *net.TCPAddr
 
@@ -313,18 +416,7 @@ func (l *tcpListener) Accept() (net.Conn, error) {
 
 // Close closes the listener.
 func (l *tcpListener) Close() error {
-	m := channelForwardMsg{
-		l.laddr.IP.String(),
-		uint32(l.laddr.Port),
-	}
-
-	// this also closes the listener.
-	l.conn.forwards.remove(l.laddr)
-	ok, _, err := l.conn.SendRequest("cancel-tcpip-forward", true, Marshal(&m))
-	if err == nil && !ok {
-		err = errors.New("ssh: cancel-tcpip-forward failed")
-	}
-	return err
+	return l.conn.cancelForward(l.laddr)
 }
 
 // Addr returns the listener's network address.
@@ -383,6 +475,51 @@ func (c *Client) Dial(n, addr string) (net.Conn, error) {
 	}
 }
 
+// DialWithOrigin initiates a connection to addr from the remote host,
+// like Dial, but announces originIP and originPort as the originator of
+// the connection, instead of a zero address. Servers may apply access
+// rules based on the originator. The network n must be "tcp", "tcp4" or
+// "tcp6", and originIP must be a literal IP address. The resulting
+// connection has the originator as its LocalAddr() and a zero
+// RemoteAddr().
+func (c *Client) DialWithOrigin(n, addr, originIP string, originPort uint32) (net.Conn, error) {
+	switch n {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("ssh: unsupported protocol: %s", n)
+	}
+	ip := net.ParseIP(originIP)
+	if ip == nil {
+		return nil, fmt.Errorf("ssh: invalid origin IP address %q", originIP)
+	}
+	if originPort > 65535 {
+		return nil, fmt.Errorf("ssh: invalid origin port %d", originPort)
+	}
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := c.dial(ip.String(), int(originPort), host, int(port))
+	if err != nil {
+		return nil, err
+	}
+	return &chanConn{
+		Channel: ch,
+		laddr: &net.TCPAddr{
+			IP:   ip,
+			Port: int(originPort),
+		},
+		raddr: &net.TCPAddr{
+			IP:   net.IPv4zero,
+			Port: 0,
+		},
+	}, nil
+}
+
 // DialTCP connects to the remote address raddr on the network net,
 // which must be "tcp", "tcp4", or "tcp6".  If laddr is not nil, it is used
 // as the local address for the connection.
@@ -404,20 +541,12 @@ func (c *Client) DialTCP(n string, laddr, raddr *net.TCPAddr) (net.Conn, error)
 	}, nil
 }
 
-// RFC 4254 7.2
-type channelOpenDirectMsg struct {
-	raddr string
-	rport uint32
-	laddr string
-	lport uint32
-}
-
 func (c *Client) dial(laddr string, lport int, raddr string, rport int) (Channel, error) {
-	msg := channelOpenDirectMsg{
-		raddr: raddr,
-		rport: uint32(rport),
-		laddr: laddr,
-		lport: uint32(lport),
+	msg := DirectTCPIPPayload{
+		Addr:       raddr,
+		Port:       uint32(rport),
+		OriginAddr: laddr,
+		OriginPort: uint32(lport),
 	}
 	ch, in, err := c.OpenChannel("direct-tcpip", Marshal(&msg))
 	if err != nil {
@@ -462,13 +591,21 @@ func (t *chanConn) SetDeadline(deadline time.Time) error {
 // After the deadline, the error from Read will implement net.Error
 // with Timeout() == true.
 func (t *chanConn) SetReadDeadline(deadline time.Time) error {
+	if ch, ok := t.Channel.(DeadlineChannel); ok {
+		return ch.SetReadDeadline(deadline)
+	}
 	// for compatibility with previous version,
 	// the error message contains "tcpChan"
 	return errors.New("ssh: tcpChan: deadline not supported")
 }
 
-// SetWriteDeadline exists to satisfy the net.Conn interface
-// but is not implemented by this type.  It always returns an error.
+// SetWriteDeadline sets the write deadline.
+// A zero value for t means Write will not time out.
+// After the deadline, the error from Write will implement net.Error
+// with Timeout() == true.
 func (t *chanConn) SetWriteDeadline(deadline time.Time) error {
+	if ch, ok := t.Channel.(DeadlineChannel); ok {
+		return ch.SetWriteDeadline(deadline)
+	}
 	return errors.New("ssh: tcpChan: deadline not supported")
 }
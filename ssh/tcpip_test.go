@@ -5,6 +5,9 @@
 package ssh
 
 import (
+	"io"
+	"net"
+	"reflect"
 	"testing"
 )
 
@@ -18,3 +21,297 @@ func TestAutoPortListenBroken(t *testing.T) {
 		t.Errorf("version %q marked as broken", works)
 	}
 }
+
+func TestDialWithOrigin(t *testing.T) {
+	client, _, chans, reqs := pipePair(t, testServerConfig(), testClientConfig())
+	go DiscardRequests(reqs)
+
+	type directMsg struct {
+		Raddr string
+		Rport uint32
+		Laddr string
+		Lport uint32
+	}
+	got := make(chan directMsg, 1)
+	go func() {
+		for newCh := range chans {
+			var msg directMsg
+			if err := Unmarshal(newCh.ExtraData(), &msg); err != nil {
+				t.Errorf("Unmarshal: %v", err)
+			}
+			got <- msg
+			newCh.Reject(Prohibited, "")
+		}
+	}()
+
+	if _, err := client.DialWithOrigin("tcp", "example.com:80", "192.0.2.7", 4242); err == nil {
+		t.Error("DialWithOrigin succeeded for a rejected channel")
+	}
+	want := directMsg{Raddr: "example.com", Rport: 80, Laddr: "192.0.2.7", Lport: 4242}
+	if msg := <-got; msg != want {
+		t.Errorf("got channel open %+v, want %+v", msg, want)
+	}
+
+	for _, tt := range []struct {
+		network, originIP string
+		originPort        uint32
+	}{
+		{"tcp", "not-an-ip", 22},
+		{"tcp", "192.0.2.7", 70000},
+		{"unix", "192.0.2.7", 22},
+	} {
+		if _, err := client.DialWithOrigin(tt.network, "example.com:80", tt.originIP, tt.originPort); err == nil {
+			t.Errorf("DialWithOrigin(%q, %q, %d) succeeded", tt.network, tt.originIP, tt.originPort)
+		}
+	}
+	select {
+	case msg := <-got:
+		t.Errorf("invalid origin was sent: %+v", msg)
+	default:
+	}
+}
+
+func TestListenTCPAllocatedPort(t *testing.T) {
+	client, server, chans, reqs := pipePair(t, testServerConfig(), testClientConfig())
+	go func() {
+		for newCh := range chans {
+			newCh.Reject(Prohibited, "")
+		}
+	}()
+
+	type forwardMsg struct {
+		Addr  string
+		Rport uint32
+	}
+	const allocated = 4711
+	got := make(chan forwardMsg, 2)
+	go func() {
+		for req := range reqs {
+			var msg forwardMsg
+			if err := Unmarshal(req.Payload, &msg); err != nil {
+				t.Errorf("Unmarshal: %v", err)
+			}
+			got <- msg
+			switch req.Type {
+			case "tcpip-forward":
+				req.Reply(true, Marshal(struct{ Port uint32 }{allocated}))
+			default:
+				req.Reply(true, nil)
+			}
+		}
+	}()
+
+	laddr := &net.TCPAddr{}
+	l, err := client.ListenTCP(laddr)
+	if err != nil {
+		t.Fatalf("ListenTCP: %v", err)
+	}
+	if msg, want := <-got, (forwardMsg{"0.0.0.0", 0}); msg != want {
+		t.Errorf("got tcpip-forward %+v, want %+v", msg, want)
+	}
+	if laddr.Port != 0 || laddr.IP != nil {
+		t.Errorf("ListenTCP modified its argument: %v", laddr)
+	}
+	if addr, ok := l.Addr().(*net.TCPAddr); !ok || addr.Port != allocated {
+		t.Fatalf("got listener address %v, want port %d", l.Addr(), allocated)
+	}
+
+	payload := ForwardedTCPIPPayload{
+		Addr:       "0.0.0.0",
+		Port:       allocated,
+		OriginAddr: "192.0.2.7",
+		OriginPort: 4242,
+	}
+	go func() {
+		ch, in, err := server.OpenChannel("forwarded-tcpip", Marshal(&payload))
+		if err != nil {
+			t.Errorf("OpenChannel: %v", err)
+			return
+		}
+		go DiscardRequests(in)
+		ch.Close()
+	}()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	if got, want := conn.RemoteAddr().String(), "192.0.2.7:4242"; got != want {
+		t.Errorf("got remote address %s, want %s", got, want)
+	}
+	conn.Close()
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if msg, want := <-got, (forwardMsg{"0.0.0.0", allocated}); msg != want {
+		t.Errorf("got cancel-tcpip-forward %+v, want %+v", msg, want)
+	}
+}
+
+func TestCancelForward(t *testing.T) {
+	client, _, chans, reqs := pipePair(t, testServerConfig(), testClientConfig())
+	go func() {
+		for newCh := range chans {
+			newCh.Reject(Prohibited, "")
+		}
+	}()
+	got := make(chan *Request, 10)
+	go func() {
+		for req := range reqs {
+			got <- req
+			req.Reply(true, nil)
+		}
+	}()
+
+	tcpListener, err := client.Listen("tcp", "127.0.0.1:8022")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	unixListener, err := client.Listen("unix", "/tmp/forward.sock")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	<-got
+	<-got
+
+	want := []ForwardInfo{{"tcp", "127.0.0.1:8022"}, {"unix", "/tmp/forward.sock"}}
+	if forwards := client.Forwards(); !reflect.DeepEqual(forwards, want) {
+		t.Fatalf("got forwards %v, want %v", forwards, want)
+	}
+
+	if err := client.CancelForward("tcp", "127.0.0.1:8022"); err != nil {
+		t.Fatalf("CancelForward: %v", err)
+	}
+	req := <-got
+	var msg TCPIPForwardRequest
+	if err := Unmarshal(req.Payload, &msg); err != nil || req.Type != "cancel-tcpip-forward" {
+		t.Errorf("got request %q %q, want cancel-tcpip-forward", req.Type, req.Payload)
+	}
+	if want := (TCPIPForwardRequest{"127.0.0.1", 8022}); msg != want {
+		t.Errorf("got cancel-tcpip-forward %+v, want %+v", msg, want)
+	}
+	if _, err := tcpListener.Accept(); err != io.EOF {
+		t.Errorf("Accept on a cancelled forward: got %v, want io.EOF", err)
+	}
+	if err := tcpListener.Close(); err == nil {
+		t.Error("Close of a cancelled forward succeeded")
+	}
+	if err := client.CancelForward("tcp", "127.0.0.1:8022"); err == nil {
+		t.Error("CancelForward of a cancelled forward succeeded")
+	}
+	want = want[1:]
+	if forwards := client.Forwards(); !reflect.DeepEqual(forwards, want) {
+		t.Errorf("got forwards %v, want %v", forwards, want)
+	}
+
+	// Closing the listener concurrently with CancelForward cancels
+	// the forward once.
+	errs := make(chan error, 2)
+	go func() { errs <- unixListener.Close() }()
+	go func() { errs <- client.CancelForward("unix", "/tmp/forward.sock") }()
+	if err1, err2 := <-errs, <-errs; (err1 == nil) == (err2 == nil) {
+		t.Errorf("got errors %v and %v, want exactly one to succeed", err1, err2)
+	}
+	if req := <-got; req.Type != "cancel-streamlocal-forward@openssh.com" {
+		t.Errorf("got request %q, want cancel-streamlocal-forward@openssh.com", req.Type)
+	}
+	select {
+	case req := <-got:
+		t.Errorf("got unexpected request %q", req.Type)
+	default:
+	}
+	if forwards := client.Forwards(); len(forwards) != 0 {
+		t.Errorf("got forwards %v, want none", forwards)
+	}
+}
+
+func TestNewChannelDirectTCPIP(t *testing.T) {
+	client, _, chans, reqs := pipePair(t, testServerConfig(), testClientConfig())
+	go DiscardRequests(reqs)
+
+	type result struct {
+		direct       *DirectTCPIPPayload
+		err          error
+		forwardedErr error
+	}
+	got := make(chan result, 1)
+	go func() {
+		for newCh := range chans {
+			var r result
+			tcpip := newCh.(TCPIPNewChannel)
+			r.direct, r.err = tcpip.DirectTCPIP()
+			_, r.forwardedErr = tcpip.ForwardedTCPIP()
+			got <- r
+			newCh.Reject(Prohibited, "")
+		}
+	}()
+
+	if _, err := client.DialWithOrigin("tcp", "example.com:80", "192.0.2.7", 4242); err == nil {
+		t.Error("DialWithOrigin succeeded for a rejected channel")
+	}
+	want := DirectTCPIPPayload{Addr: "example.com", Port: 80, OriginAddr: "192.0.2.7", OriginPort: 4242}
+	if r := <-got; r.err != nil {
+		t.Errorf("DirectTCPIP: %v", r.err)
+	} else if *r.direct != want {
+		t.Errorf("DirectTCPIP = %+v, want %+v", r.direct, want)
+	} else if r.forwardedErr == nil {
+		t.Error("ForwardedTCPIP succeeded for a direct-tcpip channel")
+	}
+
+	client.OpenChannel("session", nil)
+	if r := <-got; r.err == nil {
+		t.Errorf("DirectTCPIP = %+v for a session channel, want an error", r.direct)
+	}
+	client.OpenChannel("direct-tcpip", []byte{0, 0})
+	if r := <-got; r.err == nil {
+		t.Errorf("DirectTCPIP = %+v for malformed extra data, want an error", r.direct)
+	}
+}
+
+func TestListenTCPWithAssignedPort(t *testing.T) {
+	client, _, _, reqs := pipePair(t, testServerConfig(), testClientConfig())
+
+	// The server assigns port 4711, except for the requests of
+	// 127.0.0.2, which it answers without a port.
+	go func() {
+		for req := range reqs {
+			var msg TCPIPForwardRequest
+			Unmarshal(req.Payload, &msg)
+			switch {
+			case req.Type != "tcpip-forward":
+				req.Reply(true, nil)
+			case msg.BindAddr == "127.0.0.2":
+				req.Reply(true, nil)
+			case msg.BindPort == 0:
+				req.Reply(true, Marshal(&TCPIPForwardResponse{BindPort: 4711}))
+			default:
+				req.Reply(true, nil)
+			}
+		}
+	}()
+
+	for _, tt := range []struct {
+		addr string
+		port uint32
+	}{
+		{"127.0.0.1:0", 4711},
+		{"127.0.0.1:2222", 2222},
+	} {
+		l, port, err := client.ListenTCPWithAssignedPort(tt.addr)
+		if err != nil {
+			t.Fatalf("ListenTCPWithAssignedPort(%q): %v", tt.addr, err)
+		}
+		if port != tt.port || l.Addr().(*net.TCPAddr).Port != int(tt.port) {
+			t.Errorf("ListenTCPWithAssignedPort(%q): got port %d and address %v, want port %d", tt.addr, port, l.Addr(), tt.port)
+		}
+		l.Close()
+	}
+
+	if l, port, err := client.ListenTCPWithAssignedPort("127.0.0.2:0"); err == nil {
+		l.Close()
+		t.Errorf("got port %d for a reply without a port, want an error", port)
+	}
+	if _, _, err := client.ListenTCPWithAssignedPort("127.0.0.1:http:x"); err == nil {
+		t.Errorf("ListenTCPWithAssignedPort succeeded for an invalid address")
+	}
+}
@@ -447,3 +447,38 @@ func TestClientAuthAlgorithms(t *testing.T) {
 		})
 	}
 }
+
+// algorithmRecorder records the algorithms an RSA signer signs with.
+type algorithmRecorder struct {
+	ssh.AlgorithmSigner
+	algos []string
+}
+
+func (r *algorithmRecorder) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	r.algos = append(r.algos, r.PublicKey().Type())
+	return r.AlgorithmSigner.Sign(rand, data)
+}
+
+func (r *algorithmRecorder) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	r.algos = append(r.algos, algorithm)
+	return r.AlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
+
+func TestClientAuthServerSigAlgs(t *testing.T) {
+	server := newServerForConfig(t, "NoSHA1Pubkey", map[string]string{})
+	defer server.Shutdown()
+	signer := &algorithmRecorder{AlgorithmSigner: testSigners["rsa"].(ssh.AlgorithmSigner)}
+	conf := clientConfig()
+	conf.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+
+	conn, err := server.TryDial(conf)
+	if err != nil {
+		t.Fatalf("TryDial: %v", err)
+	}
+	conn.Close()
+	// The client learns from server-sig-algs that the server
+	// accepts SHA-2 signatures, and signs once, with one of them.
+	if len(signer.algos) != 1 || signer.algos[0] == ssh.SigAlgoRSA {
+		t.Errorf("client signed with %q, want one SHA-2 signature", signer.algos)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"testing"
 	"text/template"
 
@@ -64,7 +65,11 @@ AuthenticationMethods {{.AuthMethods}}
 
 var configTmpl = map[string]*template.Template{
 	"default":   template.Must(template.New("").Parse(defaultSshdConfig)),
-	"MultiAuth": template.Must(template.New("").Parse(defaultSshdConfig + multiAuthSshdConfigTail))}
+	"MultiAuth": template.Must(template.New("").Parse(defaultSshdConfig + multiAuthSshdConfigTail)),
+	// NoSHA1Pubkey refuses ssh-rsa signatures for public key
+	// authentication.
+	"NoSHA1Pubkey": template.Must(template.New("").Parse(strings.Replace(defaultSshdConfig,
+		"PubkeyAcceptedKeyTypes=*", "PubkeyAcceptedKeyTypes=-ssh-rsa", 1)))}
 
 type server struct {
 	t          *testing.T
@@ -0,0 +1,74 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// TrafficStats counts the traffic of a connection at the transport
+// level, after the version exchange. Bytes are counted as sent over
+// the network, encrypted and including the packet overhead; packets
+// include those of the key exchanges and other protocol messages.
+type TrafficStats struct {
+	BytesRead      uint64
+	BytesWritten   uint64
+	PacketsRead    uint64
+	PacketsWritten uint64
+}
+
+// TrafficCounter is implemented by the Conn values returned from
+// NewClientConn and NewServerConn.
+type TrafficCounter interface {
+	Conn
+
+	// TrafficStats returns the current traffic counters of the
+	// connection. It is cheap, and safe to call concurrently with
+	// the use of the connection.
+	TrafficStats() TrafficStats
+}
+
+// trafficCounters are the atomically updated counters behind
+// TrafficStats.
+type trafficCounters struct {
+	bytesRead      uint64
+	bytesWritten   uint64
+	packetsRead    uint64
+	packetsWritten uint64
+}
+
+func (c *connection) TrafficStats() TrafficStats {
+	return TrafficStats{
+		BytesRead:      atomic.LoadUint64(&c.traffic.bytesRead),
+		BytesWritten:   atomic.LoadUint64(&c.traffic.bytesWritten),
+		PacketsRead:    atomic.LoadUint64(&c.traffic.packetsRead),
+		PacketsWritten: atomic.LoadUint64(&c.traffic.packetsWritten),
+	}
+}
+
+// countingReader adds the number of bytes read from r to *n.
+type countingReader struct {
+	r io.Reader
+	n *uint64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddUint64(c.n, uint64(n))
+	return n, err
+}
+
+// countingWriter adds the number of bytes written to w to *n.
+type countingWriter struct {
+	w io.Writer
+	n *uint64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddUint64(c.n, uint64(n))
+	return n, err
+}
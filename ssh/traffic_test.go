@@ -0,0 +1,71 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestTrafficStats(t *testing.T) {
+	serverConf := testServerConfig()
+	client, server, chans, reqs := pipePair(t, serverConf, testClientConfig())
+	go DiscardRequests(reqs)
+
+	clientStats := client.Conn.(TrafficCounter)
+	serverStats := server.Conn.(TrafficCounter)
+	before := clientStats.TrafficStats()
+	if before.BytesWritten == 0 || before.PacketsWritten == 0 || before.PacketsRead == 0 {
+		t.Errorf("no handshake traffic counted: %+v", before)
+	}
+
+	const size = 1 << 20
+	received := make(chan int64, 1)
+	go func() {
+		ch, chReqs, err := (<-chans).Accept()
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			received <- 0
+			return
+		}
+		go DiscardRequests(chReqs)
+		n, _ := io.Copy(ioutil.Discard, ch)
+		ch.Close()
+		received <- n
+	}()
+
+	ch, chReqs, err := client.OpenChannel("chan", nil)
+	if err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+	go DiscardRequests(chReqs)
+	if _, err := ch.Write(make([]byte, size)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	ch.CloseWrite()
+	if n := <-received; n != size {
+		t.Fatalf("server received %d bytes, want %d", n, size)
+	}
+	ch.Close()
+
+	after := clientStats.TrafficStats()
+	written := after.BytesWritten - before.BytesWritten
+	// The packets hold at most channelMaxPacket bytes of data, and
+	// add less than 100 bytes of headers, padding and MAC each.
+	packets := after.PacketsWritten - before.PacketsWritten
+	if minPackets := uint64(size / channelMaxPacket); packets < minPackets {
+		t.Errorf("got %d written packets, want at least %d", packets, minPackets)
+	}
+	if written < size || written > size+100*packets {
+		t.Errorf("got %d written bytes in %d packets for %d bytes of data", written, packets, size)
+	}
+
+	// The server has read everything the client wrote before the
+	// end of the data.
+	if got := serverStats.TrafficStats(); got.BytesRead < before.BytesWritten+size || got.BytesRead > after.BytesWritten {
+		t.Errorf("server read %d bytes, client wrote %d", got.BytesRead, after.BytesWritten)
+	}
+}
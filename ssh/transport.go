@@ -8,8 +8,10 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"sync/atomic"
 )
 
 // debugTransport if set, will print packet types as they go over the
@@ -37,6 +39,15 @@ type packetConn interface {
 	Close() error
 }
 
+// paddedPacketWriter is implemented by packetConns that can pad
+// short packets so that their length on the wire does not reveal the
+// length of their payload.
+type paddedPacketWriter interface {
+	// writePaddedPacket is like writePacket, but pads packets
+	// shorter than padTo bytes.
+	writePaddedPacket(packet []byte, padTo int) error
+}
+
 // transport is the keyingTransport that implements the SSH packet
 // protocol.
 type transport struct {
@@ -48,14 +59,22 @@ type transport struct {
 	rand      io.Reader
 	isClient  bool
 	io.Closer
+
+	traffic *trafficCounters
+
+	// authenticated is set atomically once the user is
+	// authenticated, which starts delayed compression.
+	authenticated uint32
 }
 
 // packetCipher represents a combination of SSH encryption/MAC
 // protocol.  A single instance should be used for one direction only.
 type packetCipher interface {
 	// writeCipherPacket encrypts the packet and writes it to w. The
-	// contents of the packet are generally scrambled.
-	writeCipherPacket(seqnum uint32, w io.Writer, rand io.Reader, packet []byte) error
+	// contents of the packet are generally scrambled. If padTo is
+	// larger than the packet, extra random padding is added so the
+	// packet is as long on the wire as one of padTo bytes.
+	writeCipherPacket(seqnum uint32, w io.Writer, rand io.Reader, packet []byte, padTo int) error
 
 	// readCipherPacket reads and decrypts a packet of data. The
 	// returned packet may be overwritten by future calls of
@@ -70,7 +89,45 @@ type connectionState struct {
 	packetCipher
 	seqNum           uint32
 	dir              direction
-	pendingKeyChange chan packetCipher
+	pendingKeyChange chan keyChange
+
+	// compression is the compression algorithm of the keys in use.
+	// The compressor or decompressor is created once compression
+	// is active, and kept across key changes.
+	compression  string
+	compressor   *compressor
+	decompressor *decompressor
+
+	// authenticated points to the flag of the transport. It is set
+	// when reading or writing a msgUserAuthSuccess packet, as per
+	// authOnRead and authOnWrite.
+	authenticated *uint32
+	authOnRead    bool
+	authOnWrite   bool
+}
+
+// keyChange holds what a connectionState switches to on msgNewKeys.
+type keyChange struct {
+	cipher      packetCipher
+	compression string
+}
+
+// setKeys switches s to the keys of kc.
+func (s *connectionState) setKeys(kc keyChange) {
+	s.packetCipher = kc.cipher
+	s.compression = kc.compression
+	if s.compression == compressionNone {
+		s.compressor = nil
+		if s.decompressor != nil {
+			s.decompressor.close()
+			s.decompressor = nil
+		}
+	}
+}
+
+// compressing reports whether packets are compressed.
+func (s *connectionState) compressing() bool {
+	return compressionActive(s.compression, atomic.LoadUint32(s.authenticated) != 0)
 }
 
 // prepareKeyChange sets up key material for a keychange. The key changes in
@@ -81,13 +138,13 @@ func (t *transport) prepareKeyChange(algs *algorithms, kexResult *kexResult) err
 	if err != nil {
 		return err
 	}
-	t.reader.pendingKeyChange <- ciph
+	t.reader.pendingKeyChange <- keyChange{ciph, algs.r.Compression}
 
 	ciph, err = newPacketCipher(t.writer.dir, algs.w, kexResult)
 	if err != nil {
 		return err
 	}
-	t.writer.pendingKeyChange <- ciph
+	t.writer.pendingKeyChange <- keyChange{ciph, algs.w.Compression}
 
 	return nil
 }
@@ -115,6 +172,7 @@ func (t *transport) readPacket() (p []byte, err error) {
 		if err != nil {
 			break
 		}
+		atomic.AddUint64(&t.traffic.packetsRead, 1)
 		if len(p) == 0 || (p[0] != msgIgnore && p[0] != msgDebug) {
 			break
 		}
@@ -129,6 +187,17 @@ func (t *transport) readPacket() (p []byte, err error) {
 func (s *connectionState) readPacket(r *bufio.Reader) ([]byte, error) {
 	packet, err := s.packetCipher.readCipherPacket(s.seqNum, r)
 	s.seqNum++
+	if err != nil && s.decompressor != nil {
+		s.decompressor.close()
+	}
+	if err == nil && s.compressing() {
+		if s.decompressor == nil {
+			s.decompressor = newDecompressor()
+		}
+		if packet, err = s.decompressor.decompress(packet); err != nil {
+			return nil, err
+		}
+	}
 	if err == nil && len(packet) == 0 {
 		err = errors.New("ssh: zero length packet")
 	}
@@ -137,12 +206,17 @@ func (s *connectionState) readPacket(r *bufio.Reader) ([]byte, error) {
 		switch packet[0] {
 		case msgNewKeys:
 			select {
-			case cipher := <-s.pendingKeyChange:
-				s.packetCipher = cipher
+			case kc := <-s.pendingKeyChange:
+				s.setKeys(kc)
 			default:
 				return nil, errors.New("ssh: got bogus newkeys message")
 			}
 
+		case msgUserAuthSuccess:
+			if s.authOnRead {
+				atomic.StoreUint32(s.authenticated, 1)
+			}
+
 		case msgDisconnect:
 			// Transform a disconnect message into an
 			// error. Since this is lowest level at which
@@ -159,23 +233,50 @@ func (s *connectionState) readPacket(r *bufio.Reader) ([]byte, error) {
 
 	// The packet may point to an internal buffer, so copy the
 	// packet out here.
-	fresh := make([]byte, len(packet))
+	fresh := getPacketBuffer(len(packet))
 	copy(fresh, packet)
 
 	return fresh, err
 }
 
 func (t *transport) writePacket(packet []byte) error {
+	return t.writePaddedPacket(packet, 0)
+}
+
+// writePaddedPacket is like writePacket, but pads packets shorter
+// than padTo bytes so they are as long on the wire as one of padTo
+// bytes.
+func (t *transport) writePaddedPacket(packet []byte, padTo int) error {
 	if debugTransport {
 		t.printPacket(packet, true)
 	}
-	return t.writer.writePacket(t.bufWriter, t.rand, packet)
+	if err := t.writer.writePacket(t.bufWriter, t.rand, packet, padTo); err != nil {
+		return err
+	}
+	atomic.AddUint64(&t.traffic.packetsWritten, 1)
+	return nil
 }
 
-func (s *connectionState) writePacket(w *bufio.Writer, rand io.Reader, packet []byte) error {
+func (s *connectionState) writePacket(w *bufio.Writer, rand io.Reader, packet []byte, padTo int) error {
 	changeKeys := len(packet) > 0 && packet[0] == msgNewKeys
+	authSuccess := len(packet) > 0 && packet[0] == msgUserAuthSuccess
 
-	err := s.packetCipher.writeCipherPacket(s.seqNum, w, rand, packet)
+	if s.compressing() {
+		if s.compressor == nil {
+			s.compressor = newCompressor()
+		}
+		var err error
+		if packet, err = s.compressor.compress(packet); err != nil {
+			return err
+		}
+	}
+	if authSuccess && s.authOnWrite {
+		// The peer compresses what it sends once it reads this
+		// packet, so it must be set before writing it.
+		atomic.StoreUint32(s.authenticated, 1)
+	}
+
+	err := s.packetCipher.writeCipherPacket(s.seqNum, w, rand, packet, padTo)
 	if err != nil {
 		return err
 	}
@@ -185,8 +286,8 @@ func (s *connectionState) writePacket(w *bufio.Writer, rand io.Reader, packet []
 	s.seqNum++
 	if changeKeys {
 		select {
-		case cipher := <-s.pendingKeyChange:
-			s.packetCipher = cipher
+		case kc := <-s.pendingKeyChange:
+			s.setKeys(kc)
 		default:
 			panic("ssh: no key material for msgNewKeys")
 		}
@@ -195,21 +296,29 @@ func (s *connectionState) writePacket(w *bufio.Writer, rand io.Reader, packet []
 }
 
 func newTransport(rwc io.ReadWriteCloser, rand io.Reader, isClient bool) *transport {
+	traffic := new(trafficCounters)
 	t := &transport{
-		bufReader: bufio.NewReader(rwc),
-		bufWriter: bufio.NewWriter(rwc),
+		bufReader: bufio.NewReader(countingReader{rwc, &traffic.bytesRead}),
+		bufWriter: bufio.NewWriter(countingWriter{rwc, &traffic.bytesWritten}),
 		rand:      rand,
+		traffic:   traffic,
 		reader: connectionState{
 			packetCipher:     &streamPacketCipher{cipher: noneCipher{}},
-			pendingKeyChange: make(chan packetCipher, 1),
+			pendingKeyChange: make(chan keyChange, 1),
+			compression:      compressionNone,
 		},
 		writer: connectionState{
 			packetCipher:     &streamPacketCipher{cipher: noneCipher{}},
-			pendingKeyChange: make(chan packetCipher, 1),
+			pendingKeyChange: make(chan keyChange, 1),
+			compression:      compressionNone,
 		},
 		Closer: rwc,
 	}
 	t.isClient = isClient
+	t.reader.authenticated = &t.authenticated
+	t.writer.authenticated = &t.authenticated
+	t.reader.authOnRead = isClient
+	t.writer.authOnWrite = !isClient
 
 	if isClient {
 		t.reader.dir = serverKeys
@@ -284,6 +393,22 @@ const packageVersion = "SSH-2.0-Go"
 // be US ASCII, start with "SSH-2.0-", and should not include a
 // newline. exchangeVersions returns the other side's version line.
 func exchangeVersions(rw io.ReadWriter, versionLine []byte) (them []byte, err error) {
+	if err := writeVersion(rw, versionLine); err != nil {
+		return nil, err
+	}
+	return readVersion(rw)
+}
+
+// exchangeVersionsPreamble is like exchangeVersions, but reads the
+// other side's version with readVersionPreamble.
+func exchangeVersionsPreamble(rw io.ReadWriter, versionLine []byte, maxLines int) (them []byte, preamble []string, err error) {
+	if err := writeVersion(rw, versionLine); err != nil {
+		return nil, nil, err
+	}
+	return readVersionPreamble(rw, maxLines)
+}
+
+func writeVersion(w io.Writer, versionLine []byte) error {
 	// Contrary to the RFC, we do not ignore lines that don't
 	// start with "SSH-2.0-" to make the library usable with
 	// nonconforming servers.
@@ -291,15 +416,11 @@ func exchangeVersions(rw io.ReadWriter, versionLine []byte) (them []byte, err er
 		// The spec disallows non US-ASCII chars, and
 		// specifically forbids null chars.
 		if c < 32 {
-			return nil, errors.New("ssh: junk character in version line")
+			return errors.New("ssh: junk character in version line")
 		}
 	}
-	if _, err = rw.Write(append(versionLine, '\r', '\n')); err != nil {
-		return
-	}
-
-	them, err = readVersion(rw)
-	return them, err
+	_, err := w.Write(append(versionLine, '\r', '\n'))
+	return err
 }
 
 // maxVersionStringBytes is the maximum number of bytes that we'll
@@ -307,29 +428,75 @@ func exchangeVersions(rw io.ReadWriter, versionLine []byte) (them []byte, err er
 // chars
 const maxVersionStringBytes = 255
 
+// defaultMaxBannerLines is the number of lines accepted before the
+// version string if ClientConfig.MaxBannerLines is zero.
+const defaultMaxBannerLines = 32
+
 // Read version string as specified by RFC 4253, section 4.2.
 func readVersion(r io.Reader) ([]byte, error) {
-	versionString := make([]byte, 0, 64)
-	var ok bool
+	// RFC 4253 says we need to ignore all version string lines
+	// except the one containing the SSH version (provided that
+	// all the lines do not exceed 255 bytes in total).
+	for remaining := maxVersionStringBytes; ; {
+		line, n, err := readVersionLine(r, remaining)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(line, []byte("SSH-")) {
+			return line, nil
+		}
+		remaining -= n
+	}
+}
+
+// readVersionPreamble is like readVersion, but limits the length of
+// each line preceding the version string rather than their total, and
+// returns them. At most maxLines such lines are accepted, or
+// defaultMaxBannerLines if maxLines is zero, or none if maxLines is
+// negative.
+func readVersionPreamble(r io.Reader, maxLines int) ([]byte, []string, error) {
+	switch {
+	case maxLines == 0:
+		maxLines = defaultMaxBannerLines
+	case maxLines < 0:
+		maxLines = 0
+	}
+	var preamble []string
+	for {
+		line, _, err := readVersionLine(r, maxVersionStringBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		if bytes.HasPrefix(line, []byte("SSH-")) {
+			return line, preamble, nil
+		}
+		if len(preamble) == maxLines {
+			return nil, nil, fmt.Errorf("ssh: more than %d lines before the version string", maxLines)
+		}
+		preamble = append(preamble, string(line))
+	}
+}
+
+// readVersionLine reads a line of at most max bytes, including its line
+// terminator. It returns the line without the terminator, and the number
+// of bytes read.
+func readVersionLine(r io.Reader, max int) ([]byte, int, error) {
+	line := make([]byte, 0, 64)
 	var buf [1]byte
 
-	for length := 0; length < maxVersionStringBytes; length++ {
+	for length := 0; length < max; length++ {
 		_, err := io.ReadFull(r, buf[:])
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		// The RFC says that the version should be terminated with \r\n
 		// but several SSH servers actually only send a \n.
 		if buf[0] == '\n' {
-			if !bytes.HasPrefix(versionString, []byte("SSH-")) {
-				// RFC 4253 says we need to ignore all version string lines
-				// except the one containing the SSH version (provided that
-				// all the lines do not exceed 255 bytes in total).
-				versionString = versionString[:0]
-				continue
+			// There might be a '\r' on the end which we should remove.
+			if len(line) > 0 && line[len(line)-1] == '\r' {
+				line = line[:len(line)-1]
 			}
-			ok = true
-			break
+			return line, length + 1, nil
 		}
 
 		// non ASCII chars are disallowed, but we are lenient,
@@ -338,16 +505,7 @@ func readVersion(r io.Reader) ([]byte, error) {
 		// The RFC allows a comment after a space, however,
 		// all of it (version and comments) goes into the
 		// session hash.
-		versionString = append(versionString, buf[0])
-	}
-
-	if !ok {
-		return nil, errors.New("ssh: overflow reading version string")
-	}
-
-	// There might be a '\r' on the end which we should remove.
-	if len(versionString) > 0 && versionString[len(versionString)-1] == '\r' {
-		versionString = versionString[:len(versionString)-1]
+		line = append(line, buf[0])
 	}
-	return versionString, nil
+	return nil, 0, errors.New("ssh: overflow reading version string")
 }
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/binary"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -48,6 +49,63 @@ func TestReadVersionError(t *testing.T) {
 	}
 }
 
+func TestReadVersionPreamble(t *testing.T) {
+	in := "hello\r\n\r\n" + strings.Repeat("x", 250) + "\nSSH-2.0-bla\r\n"
+	version, preamble, err := readVersionPreamble(bytes.NewBufferString(in), 0)
+	if err != nil {
+		t.Fatalf("readVersionPreamble: %v", err)
+	}
+	if string(version) != "SSH-2.0-bla" {
+		t.Errorf("got version %q, want %q", version, "SSH-2.0-bla")
+	}
+	want := []string{"hello", "", strings.Repeat("x", 250)}
+	if !reflect.DeepEqual(preamble, want) {
+		t.Errorf("got preamble %q, want %q", preamble, want)
+	}
+
+	for _, tt := range []struct {
+		in       string
+		maxLines int
+		wantErr  bool
+	}{
+		{strings.Repeat("ignored\r\n", defaultMaxBannerLines) + "SSH-2.0-bla\r\n", 0, false},
+		{strings.Repeat("ignored\r\n", defaultMaxBannerLines+1) + "SSH-2.0-bla\r\n", 0, true},
+		{strings.Repeat("ignored\r\n", 100) + "SSH-2.0-bla\r\n", 100, false},
+		{"ignored\r\nSSH-2.0-bla\r\n", -1, true},
+		{"SSH-2.0-bla\r\n", -1, false},
+		{strings.Repeat("x", 256) + "\r\nSSH-2.0-bla\r\n", 0, true},
+	} {
+		_, _, err := readVersionPreamble(bytes.NewBufferString(tt.in), tt.maxLines)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("readVersionPreamble(%q, %d): got err %v, want error %t", tt.in, tt.maxLines, err, tt.wantErr)
+		}
+	}
+}
+
+// endlessPreamble is a reader that never sends the version string.
+type endlessPreamble struct {
+	n int
+}
+
+func (r *endlessPreamble) Read(p []byte) (int, error) {
+	const line = "not a version\r\n"
+	for i := range p {
+		p[i] = line[r.n%len(line)]
+		r.n++
+	}
+	return len(p), nil
+}
+
+func TestReadVersionPreambleUnbounded(t *testing.T) {
+	r := &endlessPreamble{}
+	if _, _, err := readVersionPreamble(r, 0); err == nil {
+		t.Fatal("readVersionPreamble of an endless preamble succeeded")
+	}
+	if max := (defaultMaxBannerLines + 1) * len("not a version\r\n"); r.n > max {
+		t.Errorf("read %d bytes of an endless preamble, want at most %d", r.n, max)
+	}
+}
+
 func TestExchangeVersionsBasic(t *testing.T) {
 	v := "SSH-2.0-bla"
 	buf := bytes.NewBufferString(v + "\r\n")
@@ -0,0 +1,103 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RFC 4254 Section 6.3.1.
+type x11RequestMsg struct {
+	SingleConnection bool
+	AuthProtocol     string
+	AuthCookie       string
+	ScreenNumber     uint32
+}
+
+// X11Request holds the parameters of an "x11-req" session request.
+type X11Request struct {
+	// SingleConnection is true if only one X11 connection
+	// should be forwarded.
+	SingleConnection bool
+
+	// AuthProtocol is the X11 authentication protocol, usually
+	// "MIT-MAGIC-COOKIE-1".
+	AuthProtocol string
+
+	// AuthCookie is the hex encoded authentication cookie.
+	AuthCookie string
+
+	// ScreenNumber is the X11 screen number.
+	ScreenNumber uint32
+}
+
+// RequestX11Forwarding asks the remote host to forward connections to
+// its X11 display back over the connection. The forwarded connections
+// arrive as channels of type "x11", which are obtained with
+// Client.HandleChannelOpen("x11") and should be connected to the
+// local X server. ParseX11ChannelOpen returns their originator. The
+// authentication cookie is usually a fake one, which the client
+// replaces by the real cookie of the local X server in the first
+// packet of each connection.
+func (s *Session) RequestX11Forwarding(screen uint32, singleConnection bool, authProto, authCookie string) error {
+	msg := x11RequestMsg{
+		SingleConnection: singleConnection,
+		AuthProtocol:     authProto,
+		AuthCookie:       authCookie,
+		ScreenNumber:     screen,
+	}
+	ok, err := s.ch.SendRequest("x11-req", true, Marshal(&msg))
+	if err == nil && !ok {
+		err = errors.New("ssh: x11-req failed")
+	}
+	return err
+}
+
+// ParseX11Request parses the "x11-req" request sent by
+// Session.RequestX11Forwarding. Servers that forward X11 connections
+// can use it when handling the requests of a session channel, and open
+// the channels of the forwarded connections with OpenX11Channel.
+func ParseX11Request(req *Request) (*X11Request, error) {
+	if req.Type != "x11-req" {
+		return nil, fmt.Errorf("ssh: request type %q is not an x11-req", req.Type)
+	}
+	var msg x11RequestMsg
+	if err := Unmarshal(req.Payload, &msg); err != nil {
+		return nil, err
+	}
+	r := X11Request(msg)
+	return &r, nil
+}
+
+// RFC 4254 Section 6.3.2.
+type x11ChannelOpenMsg struct {
+	OriginatorAddress string
+	OriginatorPort    uint32
+}
+
+// OpenX11Channel opens an "x11" channel over conn for an X11
+// connection the server accepted from originAddr and originPort, the
+// address of the X11 client on the server side.
+func OpenX11Channel(conn Conn, originAddr string, originPort uint32) (Channel, <-chan *Request, error) {
+	msg := x11ChannelOpenMsg{
+		OriginatorAddress: originAddr,
+		OriginatorPort:    originPort,
+	}
+	return conn.OpenChannel("x11", Marshal(&msg))
+}
+
+// ParseX11ChannelOpen returns the originator address and port of an
+// incoming "x11" channel.
+func ParseX11ChannelOpen(newCh NewChannel) (originAddr string, originPort uint32, err error) {
+	if newCh.ChannelType() != "x11" {
+		return "", 0, fmt.Errorf("ssh: channel type %q is not x11", newCh.ChannelType())
+	}
+	var msg x11ChannelOpenMsg
+	if err := Unmarshal(newCh.ExtraData(), &msg); err != nil {
+		return "", 0, err
+	}
+	return msg.OriginatorAddress, msg.OriginatorPort, nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestX11Forwarding(t *testing.T) {
+	client, server, chans, reqs := pipePair(t, testServerConfig(), testClientConfig())
+	go DiscardRequests(reqs)
+
+	got := make(chan *X11Request, 1)
+	go func() {
+		for newCh := range chans {
+			ch, in, err := newCh.Accept()
+			if err != nil {
+				t.Errorf("Accept: %v", err)
+				return
+			}
+			defer ch.Close()
+			for req := range in {
+				r, err := ParseX11Request(req)
+				if err != nil {
+					t.Errorf("ParseX11Request: %v", err)
+					req.Reply(false, nil)
+					continue
+				}
+				got <- r
+				req.Reply(true, nil)
+
+				x11, x11Reqs, err := OpenX11Channel(server, "192.0.2.1", 34567)
+				if err != nil {
+					t.Errorf("OpenX11Channel: %v", err)
+					continue
+				}
+				go DiscardRequests(x11Reqs)
+				x11.Write([]byte("xterm"))
+				x11.Close()
+			}
+		}
+	}()
+
+	x11Chans := client.HandleChannelOpen("x11")
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+	if err := session.RequestX11Forwarding(1, true, "MIT-MAGIC-COOKIE-1", "d8d7cf8e2b1a9f6bc2a4a3b0e1f2c3d4"); err != nil {
+		t.Fatalf("RequestX11Forwarding: %v", err)
+	}
+	want := X11Request{
+		SingleConnection: true,
+		AuthProtocol:     "MIT-MAGIC-COOKIE-1",
+		AuthCookie:       "d8d7cf8e2b1a9f6bc2a4a3b0e1f2c3d4",
+		ScreenNumber:     1,
+	}
+	if r := <-got; *r != want {
+		t.Errorf("got x11-req %+v, want %+v", *r, want)
+	}
+
+	newCh := <-x11Chans
+	addr, port, err := ParseX11ChannelOpen(newCh)
+	if err != nil {
+		t.Fatalf("ParseX11ChannelOpen: %v", err)
+	}
+	if addr != "192.0.2.1" || port != 34567 {
+		t.Errorf("got originator %s:%d, want 192.0.2.1:34567", addr, port)
+	}
+	ch, in, err := newCh.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	go DiscardRequests(in)
+	data, err := ioutil.ReadAll(ch)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(data) != "xterm" {
+		t.Errorf("got %q, want %q", data, "xterm")
+	}
+	ch.Close()
+
+	if _, err := ParseX11Request(&Request{Type: "pty-req"}); err == nil {
+		t.Error("ParseX11Request accepted a pty-req")
+	}
+}
@@ -8,7 +8,8 @@
 //
 // TEA is a legacy cipher and its short block size makes it vulnerable to
 // birthday bound attacks (see https://sweet32.info). It should only be used
-// where compatibility with legacy systems, not security, is the goal.
+// where compatibility with legacy systems, not security, is the goal. NewCTR
+// exists for the legacy formats that use TEA in counter mode.
 //
 // Deprecated: any new system should use AES (from crypto/aes, if necessary in
 // an AEAD mode like crypto/cipher.NewGCM) or XChaCha20-Poly1305 (from
@@ -47,6 +48,22 @@ func NewCipher(key []byte) (cipher.Block, error) {
 	return NewCipherWithRounds(key, numRounds)
 }
 
+// NewCTR returns a cipher.Stream that encrypts or decrypts with TEA,
+// using the standard number of rounds, in counter mode, as
+// crypto/cipher.NewCTR does. The key must be 16 bytes long and the
+// initial counter block iv 8 bytes long. The counter is incremented as
+// a big-endian 64-bit integer.
+func NewCTR(key, iv []byte) (cipher.Stream, error) {
+	c, err := NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != BlockSize {
+		return nil, errors.New("tea: incorrect IV size")
+	}
+	return cipher.NewCTR(c, iv), nil
+}
+
 // NewCipherWithRounds returns an instance of the TEA cipher with a given
 // number of rounds, which must be even. The key argument must be 16 bytes
 // long.
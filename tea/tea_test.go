@@ -6,6 +6,7 @@ package tea
 
 import (
 	"bytes"
+	"encoding/hex"
 	"testing"
 )
 
@@ -91,3 +92,36 @@ func TestCipherEncrypt(t *testing.T) {
 		}
 	}
 }
+
+// The vectors were computed with an independent implementation of TEA,
+// starting with a counter that wraps around.
+var ctrTest = struct {
+	iv, plaintext, ciphertext []byte
+}{
+	mustDecodeHex("fffffffffffffffe"),
+	[]byte("The quick brown fox jumps over the lazy dog"),
+	mustDecodeHex("6704c28a3c771b30571d740cde795219a83e05763ccd82f86c9fefbf5e80e157aaa4035ed0dabddba1cba2"),
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestNewCTR(t *testing.T) {
+	stream, err := NewCTR(testKey, ctrTest.iv)
+	if err != nil {
+		t.Fatalf("NewCTR: %v", err)
+	}
+	got := make([]byte, len(ctrTest.plaintext))
+	stream.XORKeyStream(got, ctrTest.plaintext)
+	if !bytes.Equal(got, ctrTest.ciphertext) {
+		t.Errorf("got %x, want %x", got, ctrTest.ciphertext)
+	}
+	if _, err := NewCTR(testKey, ctrTest.iv[:7]); err == nil {
+		t.Error("NewCTR accepted a 7 byte IV")
+	}
+}
@@ -7,7 +7,8 @@
 //
 // XTEA is a legacy cipher and its short block size makes it vulnerable to
 // birthday bound attacks (see https://sweet32.info). It should only be used
-// where compatibility with legacy systems, not security, is the goal.
+// where compatibility with legacy systems, not security, is the goal. NewCTR
+// exists for the legacy formats that use XTEA in counter mode.
 //
 // Deprecated: any new system should use AES (from crypto/aes, if necessary in
 // an AEAD mode like crypto/cipher.NewGCM) or XChaCha20-Poly1305 (from
@@ -16,7 +17,11 @@ package xtea // import "golang.org/x/crypto/xtea"
 
 // For details, see http://www.cix.co.uk/~klockstone/xtea.pdf
 
-import "strconv"
+import (
+	"crypto/cipher"
+	"errors"
+	"strconv"
+)
 
 // The XTEA block size in bytes.
 const BlockSize = 8
@@ -65,6 +70,21 @@ func (c *Cipher) Encrypt(dst, src []byte) { encryptBlock(c, dst, src) }
 // Decrypt decrypts the 8 byte buffer src using the key and stores the result in dst.
 func (c *Cipher) Decrypt(dst, src []byte) { decryptBlock(c, dst, src) }
 
+// NewCTR returns a cipher.Stream that encrypts or decrypts with XTEA
+// in counter mode, as crypto/cipher.NewCTR does, using the given 16
+// byte key and 8 byte initial counter block iv. The counter is
+// incremented as a big-endian 64-bit integer.
+func NewCTR(key, iv []byte) (cipher.Stream, error) {
+	c, err := NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != BlockSize {
+		return nil, errors.New("crypto/xtea: invalid IV size")
+	}
+	return cipher.NewCTR(c, iv), nil
+}
+
 // initCipher initializes the cipher context by creating a look up table
 // of precalculated values that are based on the key.
 func initCipher(c *Cipher, key []byte) {
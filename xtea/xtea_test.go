@@ -5,6 +5,8 @@
 package xtea
 
 import (
+	"bytes"
+	"encoding/hex"
 	"testing"
 )
 
@@ -227,3 +229,36 @@ func TestCipherDecrypt(t *testing.T) {
 		}
 	}
 }
+
+// The vectors were computed with an independent implementation of XTEA,
+// starting with a counter that wraps around.
+var ctrTest = struct {
+	iv, plaintext, ciphertext []byte
+}{
+	mustDecodeHex("fffffffffffffffe"),
+	[]byte("The quick brown fox jumps over the lazy dog"),
+	mustDecodeHex("fbc966120211f8961863e3071fcc6fbfc8b71e9620f4788861527d2253742808faa7aea576314db8a8a750"),
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestNewCTR(t *testing.T) {
+	stream, err := NewCTR(testKey, ctrTest.iv)
+	if err != nil {
+		t.Fatalf("NewCTR: %v", err)
+	}
+	got := make([]byte, len(ctrTest.plaintext))
+	stream.XORKeyStream(got, ctrTest.plaintext)
+	if !bytes.Equal(got, ctrTest.ciphertext) {
+		t.Errorf("got %x, want %x", got, ctrTest.ciphertext)
+	}
+	if _, err := NewCTR(testKey, ctrTest.iv[:7]); err == nil {
+		t.Error("NewCTR accepted a 7 byte IV")
+	}
+}